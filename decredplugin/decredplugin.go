@@ -9,9 +9,14 @@ const (
 	CmdStartVote         = "startvote"
 	CmdCastVotes         = "castvotes"
 	CmdBestBlock         = "bestblock"
+	CmdVoteDetails       = "votedetails"
+	CmdCancelVote        = "cancelvote"
+	CmdVoteResults       = "voteresults"
 	MDStreamVotes        = 13 // Votes
 	MDStreamVoteBits     = 14 // Vote bits and mask
 	MDStreamVoteSnapshot = 15 // Vote tickets and start/end parameters
+	MDStreamVoteCancel   = 16 // Vote cancellation
+	MDStreamVoteResults  = 17 // Signed final vote results
 )
 
 // CastVote is a signed vote.
@@ -144,3 +149,206 @@ func DecodeStartVoteReply(payload []byte) (*StartVoteReply, error) {
 
 	return &v, nil
 }
+
+// CancelVote instructs the plugin to cancel the started vote of the proposal
+// identified by Token before it ends.  Votes that are cast after the
+// cancellation are rejected.  Votes that were cast before are kept, they are
+// part of the anchored record history, but the outcome of a cancelled vote is
+// void.
+type CancelVote struct {
+	Token  string `json:"token"`  // Proposal ID
+	Reason string `json:"reason"` // Why the vote was cancelled
+}
+
+// EncodeCancelVote encodes CancelVote into a JSON byte slice.
+func EncodeCancelVote(cv CancelVote) ([]byte, error) {
+	b, err := json.Marshal(cv)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// DecodeCancelVote decodes a JSON byte slice into a CancelVote.
+func DecodeCancelVote(payload []byte) (*CancelVote, error) {
+	var cv CancelVote
+
+	err := json.Unmarshal(payload, &cv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cv, nil
+}
+
+// CancelVoteReply is the reply to CancelVote.  It is stored in
+// MDStreamVoteCancel.
+type CancelVoteReply struct {
+	Token       string `json:"token"`       // Proposal ID
+	Reason      string `json:"reason"`      // Why the vote was cancelled
+	BlockHeight string `json:"blockheight"` // Best block at cancellation
+	Timestamp   int64  `json:"timestamp"`   // Time of cancellation
+}
+
+// EncodeCancelVoteReply encodes CancelVoteReply into a JSON byte slice.
+func EncodeCancelVoteReply(cvr CancelVoteReply) ([]byte, error) {
+	b, err := json.Marshal(cvr)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// DecodeCancelVoteReply decodes a JSON byte slice into a CancelVoteReply.
+func DecodeCancelVoteReply(payload []byte) (*CancelVoteReply, error) {
+	var cvr CancelVoteReply
+
+	err := json.Unmarshal(payload, &cvr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cvr, nil
+}
+
+// VoteDetails requests the complete vote state of the proposal identified by
+// Token.
+type VoteDetails struct {
+	Token string `json:"token"` // Proposal ID
+}
+
+// EncodeVoteDetails encodes VoteDetails into a JSON byte slice.
+func EncodeVoteDetails(vd VoteDetails) ([]byte, error) {
+	b, err := json.Marshal(vd)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// DecodeVoteDetails decodes a JSON byte slice into a VoteDetails.
+func DecodeVoteDetails(payload []byte) (*VoteDetails, error) {
+	var vd VoteDetails
+
+	err := json.Unmarshal(payload, &vd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vd, nil
+}
+
+// VoteOptionResult is the number of votes cast for a single vote option.
+type VoteOptionResult struct {
+	Option VoteOption `json:"option"` // Vote option
+	Votes  uint64     `json:"votes"`  // Number of votes cast for option
+}
+
+// VoteDetailsReply is the reply to VoteDetails.  It contains the vote as it
+// was started, the start vote reply, the tally per vote option and all votes
+// that were cast.  Cancel is set if the vote was cancelled, the tally is void
+// in that case.
+type VoteDetailsReply struct {
+	Vote           Vote               `json:"vote"`             // Vote + options
+	StartVoteReply StartVoteReply     `json:"startvotereply"`   // Vote parameters
+	Tally          []VoteOptionResult `json:"tally"`            // Votes per option
+	CastVotes      []CastVote         `json:"castvotes"`        // All cast votes
+	Cancel         *CancelVoteReply   `json:"cancel,omitempty"` // Vote cancellation
+}
+
+// EncodeVoteDetailsReply encodes VoteDetailsReply into a JSON byte slice.
+func EncodeVoteDetailsReply(vdr VoteDetailsReply) ([]byte, error) {
+	b, err := json.Marshal(vdr)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// DecodeVoteDetailsReply decodes a JSON byte slice into a VoteDetailsReply.
+func DecodeVoteDetailsReply(payload []byte) (*VoteDetailsReply, error) {
+	var vdr VoteDetailsReply
+
+	err := json.Unmarshal(payload, &vdr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vdr, nil
+}
+
+// VoteResults requests the final results of the ended vote of the proposal
+// identified by Token.
+type VoteResults struct {
+	Token string `json:"token"` // Proposal ID
+}
+
+// EncodeVoteResults encodes VoteResults into a JSON byte slice.
+func EncodeVoteResults(vr VoteResults) ([]byte, error) {
+	b, err := json.Marshal(vr)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// DecodeVoteResults decodes a JSON byte slice into a VoteResults.
+func DecodeVoteResults(payload []byte) (*VoteResults, error) {
+	var vr VoteResults
+
+	err := json.Unmarshal(payload, &vr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vr, nil
+}
+
+// VoteResultsReply is the reply to VoteResults.  The results are computed
+// once, when they are first requested after the vote ended, and stored in
+// MDStreamVoteResults so that they are anchored along with the record.  Later
+// requests return the stored results.  Signature is the signature of
+// SignatureMessage by the politeiad identity.
+type VoteResultsReply struct {
+	Token           string             `json:"token"`           // Proposal ID
+	EndHeight       string             `json:"endheight"`       // Height of vote end
+	EligibleTickets int                `json:"eligibletickets"` // Number of eligible tickets
+	Tally           []VoteOptionResult `json:"tally"`           // Votes per option
+	CastTickets     []string           `json:"casttickets"`     // Sorted tickets that voted
+	Timestamp       int64              `json:"timestamp"`       // Time results were computed
+	Signature       string             `json:"signature"`       // Signature of SignatureMessage
+}
+
+// SignatureMessage returns the message that is signed by the politeiad
+// identity, the JSON encoding of the results without the signature.
+func (v VoteResultsReply) SignatureMessage() ([]byte, error) {
+	v.Signature = ""
+	return json.Marshal(v)
+}
+
+// EncodeVoteResultsReply encodes VoteResultsReply into a JSON byte slice.
+func EncodeVoteResultsReply(vrr VoteResultsReply) ([]byte, error) {
+	b, err := json.Marshal(vrr)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// DecodeVoteResultsReply decodes a JSON byte slice into a VoteResultsReply.
+func DecodeVoteResultsReply(payload []byte) (*VoteResultsReply, error) {
+	var vrr VoteResultsReply
+
+	err := json.Unmarshal(payload, &vrr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vrr, nil
+}
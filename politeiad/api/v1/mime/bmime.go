@@ -6,8 +6,11 @@ var (
 	// validMimeTypesList is a list of all acceptable MIME types that
 	// can be communicated between client and server.
 	validMimeTypesList = []string{
+		"application/json",
+		"application/x-yaml",
 		"image/png",
 		"image/svg+xml",
+		"text/markdown",
 		"text/plain",
 		"text/plain; charset=utf-8",
 	}
@@ -0,0 +1,83 @@
+package mime
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is the number of bytes that are considered when sniffing content.
+// It matches http.DetectContentType.
+const sniffLen = 512
+
+// TextSniffer recognizes a text based format that http.DetectContentType
+// reports as plain text.
+type TextSniffer struct {
+	MIME       string            // MIME type of the format
+	Extensions []string          // Filename extensions, including the dot
+	Match      func([]byte) bool // Content check of the head, may be nil
+}
+
+// textSniffers is the list of known text based formats.
+var textSniffers = []TextSniffer{
+	{
+		MIME:       "application/json",
+		Extensions: []string{".json"},
+		Match:      matchJSON,
+	},
+	{
+		MIME:       "application/x-yaml",
+		Extensions: []string{".yaml", ".yml"},
+	},
+	{
+		MIME:       "text/markdown",
+		Extensions: []string{".md", ".markdown"},
+	},
+}
+
+// matchJSON returns true if head starts like a JSON object or array.
+func matchJSON(head []byte) bool {
+	head = bytes.TrimLeft(head, " \t\r\n")
+	return len(head) > 0 && (head[0] == '{' || head[0] == '[')
+}
+
+// RegisterTextSniffer adds a text based format and makes its MIME type valid.
+// It is not safe for concurrent use and must be called during initialization.
+func RegisterTextSniffer(s TextSniffer) {
+	textSniffers = append(textSniffers, s)
+	if !MimeValid(s.MIME) {
+		validMimeTypesList = append(validMimeTypesList, s.MIME)
+		validMimeTypesMap[s.MIME] = struct{}{}
+	}
+}
+
+// DetectMimeType returns the MIME type of a file.  Plain text is refined to a
+// known text based format, see TextSniffer, by the extension of filename and
+// the content.  Otherwise the result is that of http.DetectContentType.
+func DetectMimeType(filename string, payload []byte) string {
+	detected := http.DetectContentType(payload)
+	if !strings.HasPrefix(detected, "text/plain") {
+		return detected
+	}
+
+	head := payload
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, s := range textSniffers {
+		for _, v := range s.Extensions {
+			if v != ext {
+				continue
+			}
+			if s.Match != nil && !s.Match(head) {
+				continue
+			}
+			return s.MIME
+		}
+	}
+
+	return detected
+}
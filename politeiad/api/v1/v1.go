@@ -28,6 +28,8 @@ const (
 	UpdateVettedMetadataRoute = "/v1/updatevettedmd/" // Update vetted metadata
 	GetUnvettedRoute          = "/v1/getunvetted/"    // Retrieve unvetted record
 	GetVettedRoute            = "/v1/getvetted/"      // Retrieve vetted record
+	AuditTrailRoute           = "/v1/audittrail/"     // Stream anchor audit trail
+	ProveAnchoredRoute        = "/v1/proveanchored/"  // Prove vetted record is anchored
 
 	// Auth required
 	InventoryRoute         = "/v1/inventory/"                  // Inventory records
@@ -55,6 +57,11 @@ const (
 	ErrorStatusDuplicateFilename             ErrorStatusT = 12
 	ErrorStatusFileNotFound                  ErrorStatusT = 13
 	ErrorStatusNoChanges                     ErrorStatusT = 14
+	ErrorStatusTooManyMDStreams              ErrorStatusT = 15
+	ErrorStatusMDTooLarge                    ErrorStatusT = 16
+	ErrorStatusRateLimited                   ErrorStatusT = 17
+	ErrorStatusFileTooLarge                  ErrorStatusT = 18
+	ErrorStatusTooManyUnvetted               ErrorStatusT = 19
 
 	// Record status codes (set and get)
 	RecordStatusInvalid           RecordStatusT = 0 // Invalid status
@@ -92,6 +99,11 @@ var (
 		ErrorStatusDuplicateFilename:             "duplicate filename",
 		ErrorStatusFileNotFound:                  "file not found",
 		ErrorStatusNoChanges:                     "no changes in record",
+		ErrorStatusTooManyMDStreams:              "too many metadata streams",
+		ErrorStatusMDTooLarge:                    "metadata too large",
+		ErrorStatusRateLimited:                   "rate limited",
+		ErrorStatusFileTooLarge:                  "file too large",
+		ErrorStatusTooManyUnvetted:               "too many unvetted records",
 	}
 
 	// RecordStatus converts record status codes to human readable text.
@@ -264,6 +276,40 @@ type GetVettedReply struct {
 	Record   Record `json:"record"`
 }
 
+// ProveAnchored requests a proof that the latest version of a vetted record
+// is anchored in the blockchain.
+type ProveAnchored struct {
+	Challenge string `json:"challenge"` // Random challenge
+	Token     string `json:"token"`     // Censorship token
+}
+
+// AnchorProof proves that a record commit is anchored in the blockchain.  It
+// can be verified without trusting the server: AnchorBranch must verify to
+// Merkle and contain Digest, DcrtimeBranch must verify to MerkleRoot and
+// contain Merkle and MerkleRoot must be committed in Transaction.  If set,
+// RecordBranch must verify to Merkle and contain RecordMerkle, which binds the
+// record files to the anchor.
+type AnchorProof struct {
+	Digest         string        `json:"digest"`         // Extended commit digest of the record
+	AnchorBranch   merkle.Branch `json:"anchorbranch"`   // Digest inclusion proof in Merkle
+	RecordMerkle   string        `json:"recordmerkle"`   // Merkle root of all files in record, may be empty
+	RecordBranch   merkle.Branch `json:"recordbranch"`   // RecordMerkle inclusion proof in Merkle
+	Merkle         string        `json:"merkle"`         // Anchor merkle root
+	DcrtimeBranch  merkle.Branch `json:"dcrtimebranch"`  // Merkle inclusion proof in MerkleRoot
+	MerkleRoot     string        `json:"merkleroot"`     // dcrtime merkle root
+	Transaction    string        `json:"transaction"`    // dcrd transaction that holds MerkleRoot
+	ChainTimestamp int64         `json:"chaintimestamp"` // Timestamp of the block
+	Signature      string        `json:"signature"`      // Server signature of Merkle, may be empty
+}
+
+// ProveAnchoredReply returns the anchor proof of a vetted record.  Proof is
+// nil if the record does not exist or its latest version has not been
+// anchored and confirmed yet.
+type ProveAnchoredReply struct {
+	Response string       `json:"response"` // Challenge response
+	Proof    *AnchorProof `json:"proof"`    // Anchor proof
+}
+
 // SetUnvettedStatus updates the status of an unvetted record.  This is used
 // to either promote a record to the public viewable repository or to censor
 // it. Additionally, metadata updates may travel along.
@@ -322,10 +368,12 @@ type UpdateVettedMetadataReply struct {
 // The IncludeFiles flag indicates if the records contain the record payload
 // as well.  This can quickly become very large and should only be used when
 // recovering the client side.
+// Censored branches are only returned if IncludeCensored is set.
 type Inventory struct {
 	Challenge string `json:"challenge"` // Random challenge
 	// XXX add IncludeMD
-	IncludeFiles bool `json:"includefiles"` // Include files in records
+	IncludeFiles    bool `json:"includefiles"`    // Include files in records
+	IncludeCensored bool `json:"includecensored"` // Include censored branches
 	// XXX add VettedStart and BranchesStart
 	VettedCount   uint `json:"vettedcount"`   // Last N vetted records
 	BranchesCount uint `json:"branchescount"` // Last N branches (censored, new etc)
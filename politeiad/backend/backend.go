@@ -8,8 +8,12 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"regexp"
+	"strings"
+	"time"
 
+	"github.com/decred/dcrtime/merkle"
 	"github.com/decred/politeia/politeiad/api/v1"
 )
 
@@ -31,6 +35,38 @@ var (
 	// locked record.
 	ErrRecordLocked = errors.New("record is locked")
 
+	// ErrFileNotPurgeable is returned when the content of a file can not be
+	// purged without rewriting history.
+	ErrFileNotPurgeable = errors.New("file not purgeable")
+
+	// ErrInvalidLabel is returned when a record label is empty or too
+	// long.
+	ErrInvalidLabel = errors.New("invalid label")
+
+	// ErrAnchorNotFound is returned when no anchor covers the requested
+	// commit.
+	ErrAnchorNotFound = errors.New("anchor not found")
+
+	// ErrRecordNotCorrupt is returned when a repair was attempted on a
+	// record that is not corrupt.
+	ErrRecordNotCorrupt = errors.New("record is not corrupt")
+
+	// ErrRecordVetted is returned when an operation that is only valid for
+	// unvetted records was attempted on a vetted record.
+	ErrRecordVetted = errors.New("record is vetted")
+
+	// ErrRateLimited is returned when a record creation or update was
+	// refused by the rate limiter.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrTooManyUnvetted is returned when a record creation was refused
+	// because the number of records pending review reached its maximum.
+	ErrTooManyUnvetted = errors.New("too many unvetted records")
+
+	// ErrManifestNotFound is returned when no record manifest was written
+	// yet.
+	ErrManifestNotFound = errors.New("manifest not found")
+
 	// Plugin names must be all lowercase letters and have a length of <20
 	PluginRE = regexp.MustCompile(`^[a-z]{1,20}$`)
 )
@@ -53,6 +89,30 @@ type File struct {
 	Payload string // base64 encoded file
 }
 
+// FileInfo describes a record file that is streamed instead of loaded, see
+// OpenRecordFile.
+type FileInfo struct {
+	Name   string // Name relative to the payload directory, e.g. images/x
+	MIME   string // MIME type
+	Digest string // SHA256 of the file content
+	Size   int64  // Size of the file content
+}
+
+// SelfTestStep is the result of a single self test step.
+type SelfTestStep struct {
+	Name     string        // Step name
+	Duration time.Duration // Duration of the step
+	Error    string        // Failure, empty if the step passed
+}
+
+// SelfTestReport is the result of a self test, see SelfTest.  Steps lists the
+// executed steps in order, the self test stops at the first failed step.
+type SelfTestReport struct {
+	Root   string         // Temporary root the self test ran in
+	Passed bool           // All steps passed
+	Steps  []SelfTestStep // Executed steps
+}
+
 type MDStatusT int
 
 const (
@@ -77,6 +137,28 @@ var (
 	}
 )
 
+// AnchorStatus describes whether the latest commit of a record is anchored.
+type AnchorStatus int
+
+const (
+	// All possible anchor status codes
+	AnchorStatusInvalid     AnchorStatus = 0 // Invalid status, this is a bug
+	AnchorStatusNotAnchored AnchorStatus = 1 // Not anchored yet
+	AnchorStatusPending     AnchorStatus = 2 // Anchored, not confirmed yet
+	AnchorStatusConfirmed   AnchorStatus = 3 // Anchored and confirmed
+)
+
+var (
+	// AnchorStatuses converts an anchor status code to a human readable
+	// string.
+	AnchorStatuses = map[AnchorStatus]string{
+		AnchorStatusInvalid:     "invalid",
+		AnchorStatusNotAnchored: "not anchored",
+		AnchorStatusPending:     "anchor pending",
+		AnchorStatusConfirmed:   "anchored",
+	}
+)
+
 // StateTransitionError indicates an invalid record status transition.
 type StateTransitionError struct {
 	From MDStatusT
@@ -88,6 +170,95 @@ func (s StateTransitionError) Error() string {
 		s.From, MDStatus[s.From], s.To, MDStatus[s.To])
 }
 
+// StatusBatchError is returned by SetUnvettedStatusBatch when at least one of
+// the status changes failed.  Errors is indexed like the status changes,
+// entries of successful changes are nil.
+type StatusBatchError struct {
+	Errors []error
+}
+
+func (s StatusBatchError) Error() string {
+	var failed int
+	for _, v := range s.Errors {
+		if v != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%v of %v status changes failed", failed,
+		len(s.Errors))
+}
+
+// ErrRebaseConflict is returned when a rebase could not be completed due to
+// conflicting files.  The rebase has been aborted when this error is
+// returned.
+type ErrRebaseConflict struct {
+	Files []string // Conflicting files
+}
+
+func (e ErrRebaseConflict) Error() string {
+	return fmt.Sprintf("rebase conflict: %v", strings.Join(e.Files, ", "))
+}
+
+// RecordCorruptError is returned when the files of a record do not match the
+// merkle root in its record metadata.
+type RecordCorruptError struct {
+	Token  string // Record token
+	Merkle string // Merkle root in the record metadata
+	Actual string // Merkle root of the files on disk
+}
+
+func (e RecordCorruptError) Error() string {
+	return fmt.Sprintf("record corrupt %v: merkle %v, files %v", e.Token,
+		e.Merkle, e.Actual)
+}
+
+// StaleBranchError is returned when a new record would be created on a record
+// branch that already exists, e.g. because a crash left it behind.  The branch
+// is not touched, it has to be inspected and removed by an operator.
+type StaleBranchError struct {
+	Token  string // Record token
+	Branch string // Existing branch
+	Head   string // Digest of the branch head
+}
+
+func (e StaleBranchError) Error() string {
+	return fmt.Sprintf("stale record branch %v at %v", e.Branch, e.Head)
+}
+
+// NoChangesError is returned when an unvetted record update would not change
+// the record.  Unchanged lists the submitted files whose content is identical
+// to the record, which tells a redundant edit apart from a malformed request.
+// Updates of vetted metadata return ErrNoChanges.
+type NoChangesError struct {
+	Token     string   // Record token
+	Unchanged []string // Submitted files that match the record
+}
+
+func (e NoChangesError) Error() string {
+	if len(e.Unchanged) == 0 {
+		return ErrNoChanges.Error()
+	}
+	return fmt.Sprintf("%v, unchanged files: %v", ErrNoChanges,
+		strings.Join(e.Unchanged, ", "))
+}
+
+// GitVersionError is returned when git is not installed or older than the
+// required version.
+type GitVersionError struct {
+	Detected string // Installed git version, empty if git failed
+	Required string // Minimum required git version
+	Err      error  // Error of git if it failed
+}
+
+func (e GitVersionError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("git %v or later is required: %v", e.Required,
+			e.Err)
+	}
+	return fmt.Sprintf("git %v or later is required, detected %v",
+		e.Required, e.Detected)
+}
+
 // RecordMetadata is the metadata of a record.
 type RecordMetadata struct {
 	Version   uint              // Iteration count of record
@@ -97,6 +268,14 @@ type RecordMetadata struct {
 	Token     []byte            // Record authentication token
 }
 
+// StatusChange describes a status transition of a single unvetted record.
+type StatusChange struct {
+	Token       []byte           // Record token
+	Status      MDStatusT        // New record status
+	MDAppend    []MetadataStream // Metadata streams to append
+	MDOverwrite []MetadataStream // Metadata streams to overwrite
+}
+
 // MetadataStream describes a single metada stream.  The ID determines how and
 // where it is stored.
 type MetadataStream struct {
@@ -125,6 +304,160 @@ type Plugin struct {
 	Settings []PluginSetting // Settings
 }
 
+// InventoryOptions are the arguments of InventoryJSON, they have the same
+// meaning as the arguments of Inventory.
+type InventoryOptions struct {
+	IncludeFiles    bool // Include file payloads
+	IncludeCensored bool // Include censored unvetted records
+}
+
+// SearchQuery describes the filter criteria of a record search.  Criteria
+// that are left at their zero value are ignored.
+type SearchQuery struct {
+	Status       []MDStatusT // Match any of the provided statuses
+	From         int64       // Match records updated at or after From
+	To           int64       // Match records updated at or before To
+	Filename     string      // Match records with a filename containing this
+	Label        string      // Match records that carry this label
+	Unvetted     bool        // Search unvetted records instead of vetted
+	IncludeFiles bool        // Return file payloads of matching records
+	Offset       uint        // Skip this many matching records
+	Limit        uint        // Return at most this many records, 0 is all
+}
+
+// AnchorInfo describes an anchor and, if available, its dcrtime confirmation.
+type AnchorInfo struct {
+	Merkle         string   // Merkle root of the anchored digests
+	Time           int64    // Anchor commit time
+	Digests        []string // Commit digests and record merkle roots
+	Confirmed      bool     // Anchor has been confirmed by dcrtime
+	ChainTimestamp int64    // Confirmation timestamp, if confirmed
+	Transaction    string   // Anchor transaction, if confirmed
+	Signature      string   // Server signature of Merkle, empty if unsigned
+}
+
+// AnchoredCommit is a commit that is covered by an anchor.
+type AnchoredCommit struct {
+	Digest  string // Extended commit digest
+	Message string // One line commit message
+}
+
+// AnchorProof proves that a record commit is anchored in the blockchain.  It
+// can be verified without trusting the server: AnchorBranch must verify to
+// Merkle, DcrtimeBranch must verify to MerkleRoot and MerkleRoot must be
+// committed in Transaction.  RecordBranch binds the record files, through
+// their merkle root, to the same anchor.  It is empty for records anchored
+// before record merkle roots were anchored.
+type AnchorProof struct {
+	Digest         string        // Extended commit digest of the record
+	AnchorBranch   merkle.Branch // Digest inclusion proof in Merkle
+	RecordMerkle   string        // Merkle root of all files in record
+	RecordBranch   merkle.Branch // RecordMerkle inclusion proof in Merkle
+	Merkle         string        // Anchor merkle root
+	DcrtimeBranch  merkle.Branch // Merkle inclusion proof in MerkleRoot
+	MerkleRoot     string        // dcrtime merkle root
+	Transaction    string        // dcrd transaction that holds MerkleRoot
+	ChainTimestamp int64         // Timestamp of the block
+	Signature      string        // Server signature of Merkle, may be empty
+}
+
+// FileProof proves that a file of a record is anchored in the blockchain.  It
+// can be verified without trusting the server: FileBranch must verify to
+// RecordMerkle, the record metadata in the commit Anchor.Digest must carry
+// RecordMerkle and Anchor must verify as an AnchorProof.
+type FileProof struct {
+	Name         string        // Filename
+	Digest       string        // SHA256 digest of the file payload
+	FileBranch   merkle.Branch // Digest inclusion proof in RecordMerkle
+	RecordMerkle string        // Merkle root of all files in record
+	Anchor       AnchorProof   // Proof that RecordMerkle is anchored
+}
+
+// RecordBundle is a self contained copy of a vetted record that can be
+// verified offline, see ExportBundle and VerifyBundle.  Anchor is nil if the
+// record commit was not anchored and confirmed when the bundle was created.
+type RecordBundle struct {
+	Record Record       // Record including file payloads
+	Anchor *AnchorProof // Proof that the record merkle root is anchored
+}
+
+// BundleCheck is the result of a single bundle verification check.
+type BundleCheck struct {
+	Name  string // Check name
+	Error string // Failure, empty if the check passed
+}
+
+// BundleVerification is the result of verifying a record bundle.  Unlike the
+// self test all checks are run, Checks lists them in order.
+type BundleVerification struct {
+	Token  string        // Record token
+	Passed bool          // All checks passed
+	Checks []BundleCheck // Executed checks
+}
+
+// ReconcileReport lists the discrepancies between the leveldb databases of the
+// backend and the repositories.  Index entries are identified by their
+// database key.
+type ReconcileReport struct {
+	OrphanLabels   []string // Tokens that carry labels but are no record
+	StaleMDIndex   []string // Index entries that do not match the repo
+	MissingMDIndex []string // Metadata streams that are not indexed
+	Repaired       bool     // Discrepancies were repaired
+}
+
+// ManifestRecord is a single record in the record manifest.
+type ManifestRecord struct {
+	Token  string // Record token
+	Merkle string // Merkle root of the record files
+}
+
+// Manifest lists every vetted record with its current merkle root, sorted by
+// token.  It is committed to the vetted repo and anchored with it, which lets
+// clients detect records that disappear between two anchored manifests.
+type Manifest struct {
+	Timestamp int64            // Last update
+	Records   []ManifestRecord // Records by token
+}
+
+// MIMEViolation is a record file whose MIME type is not allowed by the
+// current MIME policy.
+type MIMEViolation struct {
+	Token string // Record token
+	File  string // Filename
+	MIME  string // Detected MIME type
+}
+
+// AnchorHealth describes the outcome of the anchor attempts since the backend
+// was started.  Times are unix timestamps and zero if there was no such event.
+type AnchorHealth struct {
+	LastAttempt int64  // Last time an anchor was attempted
+	LastSuccess int64  // Last time an anchor was dropped
+	LastMerkle  string // Merkle root of the last dropped anchor
+	LastSkip    string // Why the last attempt did not drop an anchor
+
+	// Confirmation latency, from dropping an anchor to its chain
+	// timestamp, in seconds.  Only confirmations since startup count.
+	Confirmed        uint64 // Anchors confirmed since startup
+	LastConfirmDelay int64  // Latency of the last confirmed anchor
+	MaxConfirmDelay  int64  // Largest latency since startup
+}
+
+// Status describes the health of the backend.
+type Status struct {
+	DcrtimeHost  string       // Configured dcrtime host
+	DcrtimeError string       // Reason dcrtime is unusable, empty if healthy
+	Anchor       AnchorHealth // Anchoring health
+	AnchorPaused bool         // Anchoring is paused for maintenance
+}
+
+// RateLimiter decides whether the backend accepts another record creation or
+// update.  It is an interface so that the accounting, e.g. per user, can live
+// outside of the backend.
+type RateLimiter interface {
+	// Allow returns false when the rate was exceeded.
+	Allow() bool
+}
+
 type Backend interface {
 	// Create new record
 	New([]MetadataStream, []File) (*RecordMetadata, error)
@@ -143,12 +476,138 @@ type Backend interface {
 	// Get vetted record
 	GetVetted([]byte) (*Record, error)
 
+	// Get vetted records, nil if not found (tokens, includeFiles)
+	GetVettedBatch([][]byte, bool) ([]*Record, error)
+
+	// Get vetted record unless its latest commit digest is known (token,
+	// digest), returns false if unchanged
+	GetVettedIfChanged([]byte, string) (*Record, bool, error)
+
+	// Get record metadata streams only (token, vetted)
+	GetRecordMetadataStreams([]byte, bool) ([]MetadataStream, error)
+
+	// Check if a record was censored (token)
+	IsCensored([]byte) (bool, error)
+
+	// Rebuild the record metadata of a corrupt record (token)
+	RepairRecord([]byte) error
+
+	// Fix a stale merkle root in the record metadata (token)
+	RecalculateMerkle([]byte) (*RecordMetadata, error)
+
+	// Move an unvetted record to a fresh token, returns the new token
+	ReissueToken([]byte) ([]byte, error)
+
 	// Set unvetted record status
 	SetUnvettedStatus([]byte, MDStatusT, []MetadataStream,
 		[]MetadataStream) (*Record, error)
 
-	// Inventory retrieves various record records.
-	Inventory(uint, uint, bool) ([]Record, []Record, error)
+	// Set the status of many unvetted records with a single rebase
+	SetUnvettedStatusBatch([]StatusChange) ([]*Record, error)
+
+	// Check whether an unvetted record status can be set, nothing is
+	// written (token, status)
+	CanSetUnvettedStatus([]byte, MDStatusT) error
+
+	// Inventory retrieves various record records (vetted count, branch
+	// count, include files, include censored)
+	Inventory(uint, uint, bool, bool) ([]Record, []Record, error)
+
+	// Stream the inventory as a JSON array of records, vetted first
+	InventoryJSON(io.Writer, InventoryOptions) error
+
+	// Latest committed manifest of all vetted records
+	Manifest() (*Manifest, error)
+
+	// Vetted record files whose MIME type is no longer allowed
+	AuditMIMETypes() ([]MIMEViolation, error)
+
+	// Count vetted and unvetted records by status
+	StatusCounts() (map[MDStatusT]int, error)
+
+	// Unvetted records pending review, oldest first
+	ReviewQueue() ([]RecordMetadata, error)
+
+	// Latest commit digest of every vetted record keyed by token
+	RecordDigests() (map[string]string, error)
+
+	// Vetted records committed to since a unix timestamp
+	ModifiedSince(int64) ([]RecordMetadata, error)
+
+	// Search records by metadata and filenames
+	Search(SearchQuery) ([]Record, error)
+
+	// Find records whose metadata stream satisfies the predicate
+	FindByMetadata(uint64, func(string) bool) ([]Record, error)
+
+	// Compare the leveldb databases to the repos, optionally repair (repair)
+	ReconcileDB(bool) (*ReconcileReport, error)
+
+	// Find the anchor that covers a commit digest
+	AnchorForCommit(string) (*AnchorInfo, error)
+
+	// List the commits covered by an anchor (merkle)
+	AnchorCommits(string) ([]AnchoredCommit, error)
+
+	// List all anchors and their confirmation, newest first
+	ListAnchors() ([]AnchorInfo, error)
+
+	// Vetted record as of an anchor (token, merkle)
+	RecordAtAnchor([]byte, string) (*Record, error)
+
+	// Anchor status of the latest commit of a vetted record (token)
+	RecordAnchorStatus([]byte) (AnchorStatus, error)
+
+	// Anchor external digests, returns their merkle root (digests)
+	AnchorExternal([]string) (string, error)
+
+	// Get an external anchor and its confirmation (merkle)
+	GetExternalAnchor(string) (*AnchorInfo, error)
+
+	// Prove that an external digest is anchored (merkle, digest)
+	ProveExternal(string, string) (*AnchorProof, error)
+
+	// Stream the anchor audit trail of the vetted repo
+	AuditTrail() (io.ReadCloser, error)
+
+	// Write a consistent point in time copy of the backend (directory)
+	Snapshot(string) error
+
+	// Prove that the latest commit of a vetted record is anchored (token)
+	ProveAnchored([]byte) (*AnchorProof, error)
+
+	// Prove that a file of a vetted record is anchored
+	FileProof([]byte, string) (*FileProof, error)
+
+	// Export a vetted record and its anchor proof for offline verification
+	ExportBundle([]byte) (*RecordBundle, error)
+
+	// Irrevocably delete the content of a record file (token, filename)
+	PurgeFile([]byte, string) error
+
+	// Open a record file for streaming (token, filename, vetted)
+	OpenRecordFile([]byte, string, bool) (io.ReadCloser, *FileInfo, error)
+
+	// Replace the operational, unanchored labels of a record (token)
+	SetLabels([]byte, []string) error
+
+	// Obtain the labels of a record (token)
+	GetLabels([]byte) ([]string, error)
+
+	// Exercise create, update, vet and anchor on a throwaway backend
+	SelfTest() (*SelfTestReport, error)
+
+	// Obtain backend health status
+	Status() (*Status, error)
+
+	// Rebuild derived state after out of band repo changes
+	Reindex() error
+
+	// Stop anchoring and anchor confirmation for maintenance
+	PauseAnchoring() error
+
+	// Resume anchoring, deferred anchors are dropped right away
+	ResumeAnchoring() error
 
 	// Obtain plugin settings
 	GetPlugins() ([]Plugin, error)
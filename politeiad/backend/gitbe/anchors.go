@@ -5,14 +5,27 @@
 package gitbe
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/decred/dcrtime/api/v1"
 	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
 )
 
 // An anchor corresponds to a set of git commit hashes, along with their
@@ -43,9 +56,10 @@ type Anchor struct {
 
 // LastAnchor stores the last commit anchored in dcrtime.
 type LastAnchor struct {
-	Last   []byte // Last git digest that was anchored
-	Time   int64  // OS time when record was created
-	Merkle []byte // Merkle root that points to Anchor record, if valid
+	Last    []byte // Last git digest that was anchored
+	Covered []byte // Newest commit covered by the anchor, may be below Last
+	Time    int64  // OS time when record was created
+	Merkle  []byte // Merkle root that points to Anchor record, if valid
 }
 
 // UnconfirmedAnchor stores Merkle roots of anchors that have not been confirmed
@@ -89,12 +103,8 @@ type GitCommit struct {
 }
 
 var (
-	regexCommitHash           = regexp.MustCompile(`^commit\s+(\S+)`)
-	regexCommitDate           = regexp.MustCompile(`^Date:\s+(.+)`)
-	anchorConfirmationPattern = fmt.Sprintf(`\s*%s\s+(\S+)`, markerAnchorConfirmation)
-	regexAnchorConfirmation   = regexp.MustCompile(anchorConfirmationPattern)
-	anchorPattern             = fmt.Sprintf(`\s*%s\s+(\S+)`, markerAnchor)
-	regexAnchor               = regexp.MustCompile(anchorPattern)
+	regexCommitHash = regexp.MustCompile(`^commit\s+(\S+)`)
+	regexCommitDate = regexp.MustCompile(`^Date:\s+(.+)`)
 )
 
 const (
@@ -140,16 +150,186 @@ func extractCommit(logSlice []string) (*GitCommit, int, error) {
 	return &commit, len(commit.Message) + 4, nil
 }
 
-// Some helper functions to navigate git commit message bodies
+// Anchor commit messages
+//
+// The first line of the anchor and anchor confirmation commits in the vetted
+// repo is machine parseable.  The fields are separated by a single space:
+//
+//	Anchor <merkle> signature=<signature>
+//	Anchor confirmation <merkle> tx=<transaction> timestamp=<chain timestamp>
+//
+// <merkle> is the hex encoded merkle root of the anchor, <transaction> the
+// dcrtime transaction and <chain timestamp> the dcrtime chain timestamp in
+// seconds since the epoch.  <signature> is the hex encoded ed25519 signature
+// of the hex encoded <merkle> by the politeiad identity, see
+// VerifyAnchorMessage.  It attributes the anchor to the server, someone with
+// mere write access to the repo can't forge it.  Anchors that were dropped
+// without an identity or before signing was added carry no signature and
+// confirmations committed before the transaction and timestamp were added only
+// carry the merkle root.  The body of an anchor commit lists the anchored
+// commits, see parseAnchorCommit, followed by the merkle roots of the vetted
+// records that changed, one per line:
+//
+//	<record merkle> Record merkle <token>
+//
+// Anchoring the record merkle roots binds the files of a record to the anchor
+// without the git objects of the commit, see recordMerkleMessage.  The merkle
+// root of the anchor is the merkle root of all listed digests.  Anchors that
+// were dropped before record merkle roots were added only list commits.
+//
+// Anchor commit messages must only be created by anchorMessage.String and
+// parsed by parseAnchorMessage.
+
+const (
+	// markerRecordMerkle prefixes the anchor commit lines that carry the
+	// merkle root of a record.
+	markerRecordMerkle = "Record merkle"
+)
+
+// recordMerkleMessage returns the anchor commit message of the merkle root of
+// record id.
+func recordMerkleMessage(id string) string {
+	return markerRecordMerkle + " " + id
+}
+
+// isRecordMerkleMessage returns true if the anchor commit message belongs to a
+// record merkle root rather than to a commit.
+func isRecordMerkleMessage(message string) bool {
+	return strings.HasPrefix(message, markerRecordMerkle+" ")
+}
+
+// anchorMessage is the parsed first line of an anchor or anchor confirmation
+// commit message.
+type anchorMessage struct {
+	confirmation   bool   // Anchor confirmation
+	merkle         string // Hex encoded merkle root
+	signature      string // Anchor only, may be empty
+	transaction    string // Confirmation only, may be empty
+	chainTimestamp int64  // Confirmation only, may be 0
+}
+
+// String returns the first line of the commit message for a.
+func (a anchorMessage) String() string {
+	if !a.confirmation {
+		if a.signature == "" {
+			return markerAnchor + " " + a.merkle
+		}
+		return fmt.Sprintf("%v %v signature=%v", markerAnchor, a.merkle,
+			a.signature)
+	}
+	return fmt.Sprintf("%v %v tx=%v timestamp=%v", markerAnchorConfirmation,
+		a.merkle, a.transaction, a.chainTimestamp)
+}
+
+// parseAnchorMessage parses the first line of a commit message.  It returns
+// nil if line does not belong to an anchor or anchor confirmation commit and
+// an error if it does but is malformed.  Leading and trailing whitespace, as
+// added by git log, is ignored.
+func parseAnchorMessage(line string) (*anchorMessage, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != markerAnchor {
+		return nil, nil
+	}
+
+	var a anchorMessage
+	switch {
+	case len(fields) == 2:
+		a.merkle = fields[1]
+	case len(fields) == 3 && strings.HasPrefix(fields[2], "signature="):
+		a.merkle = fields[1]
+		a.signature = strings.TrimPrefix(fields[2], "signature=")
+		if _, err := identity.SignatureFromString(a.signature); err != nil {
+			return nil, fmt.Errorf("invalid anchor signature: %q", line)
+		}
+	case (len(fields) == 3 || len(fields) == 5) &&
+		strings.Join(fields[:2], " ") == markerAnchorConfirmation:
+		a.confirmation = true
+		a.merkle = fields[2]
+		if len(fields) == 3 {
+			// Legacy confirmation
+			break
+		}
+		if !strings.HasPrefix(fields[3], "tx=") ||
+			!strings.HasPrefix(fields[4], "timestamp=") {
+			return nil, fmt.Errorf("invalid anchor confirmation: %q",
+				line)
+		}
+		a.transaction = strings.TrimPrefix(fields[3], "tx=")
+		ts, err := strconv.ParseInt(strings.TrimPrefix(fields[4],
+			"timestamp="), 10, 64)
+		if err != nil || a.transaction == "" {
+			return nil, fmt.Errorf("invalid anchor confirmation: %q",
+				line)
+		}
+		a.chainTimestamp = ts
+	default:
+		return nil, fmt.Errorf("invalid anchor message: %q", line)
+	}
+	if !util.IsDigest(a.merkle) {
+		return nil, fmt.Errorf("invalid anchor merkle: %q", line)
+	}
+
+	return &a, nil
+}
 
-// anchorConfirmationMerkle extracts the Merkle Root from an anchor confirmation commit.
-func anchorConfirmationMerkle(commit *GitCommit) string {
-	return regexAnchorConfirmation.FindStringSubmatch(commit.Message[0])[1]
+// signAnchor returns the hex encoded signature of the hex encoded merkle root
+// of an anchor by id.
+func signAnchor(id *identity.FullIdentity, merkle string) string {
+	signature := id.SignMessage([]byte(merkle))
+	return hex.EncodeToString(signature[:])
 }
 
-// anchorConfirmationMerkle extracts the Merkle Root from an anchor commit.
-func anchorCommitMerkle(commit *GitCommit) string {
-	return regexAnchor.FindStringSubmatch(commit.Message[0])[1]
+// VerifyAnchorMessage verifies the complete commit message of an anchor commit
+// in the vetted repo, see "Anchor commit messages".  The merkle root of the
+// listed digests must match the merkle root of the first line and the latter
+// must have been signed by the politeiad identity pid.  It allows third
+// parties that clone the vetted repo to verify that an anchor, including the
+// commits and record merkle roots it covers, was dropped by the server.
+// Unsigned anchors and anchor confirmations fail verification.
+func VerifyAnchorMessage(pid identity.PublicIdentity, message string) error {
+	// Mimic the layout of a commit message in the git log
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	if len(lines) < 3 || strings.TrimSpace(lines[1]) != "" {
+		return fmt.Errorf("not an anchor: %q", lines[0])
+	}
+	digests, _, err := parseAnchorCommit(&GitCommit{
+		Message: append(lines, ""),
+	})
+	if err != nil {
+		return err
+	}
+	am, err := parseAnchorMessage(lines[0])
+	if err != nil {
+		return err
+	}
+
+	// The listed digests must add up to the anchor merkle root
+	hashes := make([]*[sha256.Size]byte, 0, len(digests))
+	for _, v := range digests {
+		if len(v) != sha256.Size {
+			return fmt.Errorf("invalid anchor digest %x", v)
+		}
+		var d [sha256.Size]byte
+		copy(d[:], v)
+		hashes = append(hashes, &d)
+	}
+	root := merkle.Root(hashes)
+	if hex.EncodeToString(root[:]) != am.merkle {
+		return fmt.Errorf("anchor merkle %v, listed digests add up to "+
+			"%x", am.merkle, root[:])
+	}
+
+	if am.signature == "" {
+		return fmt.Errorf("anchor %v is not signed", am.merkle)
+	}
+	signature, err := identity.SignatureFromString(am.signature)
+	if err != nil {
+		return err
+	}
+	if !pid.VerifyMessage([]byte(am.merkle), *signature) {
+		return fmt.Errorf("invalid anchor signature %v", am.merkle)
+	}
+	return nil
 }
 
 // parseAnchorCommit returns a list of digest bytes from an anchor GitCommit,
@@ -157,7 +337,11 @@ func anchorCommitMerkle(commit *GitCommit) string {
 func parseAnchorCommit(commit *GitCommit) ([][]byte, []string, error) {
 	// Make sure it is an anchor commit
 	firstLine := commit.Message[0]
-	if !regexAnchor.MatchString(firstLine) {
+	am, err := parseAnchorMessage(firstLine)
+	if err != nil {
+		return nil, nil, err
+	}
+	if am == nil || am.confirmation {
 		return nil, nil, fmt.Errorf("Error parsing git log. Expected an anchor commit. Instead got %q", firstLine)
 	}
 
@@ -168,7 +352,11 @@ func parseAnchorCommit(commit *GitCommit) ([][]byte, []string, error) {
 	var messages []string
 	for _, line := range commit.Message[2 : len(commit.Message)-1] {
 		// The first word is the commit hash. The rest is the one-line commit message.
-		lineParts := strings.SplitN(line, " ", 2)
+		// git log indents the message so trim it first.
+		lineParts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(lineParts) != 2 {
+			return nil, nil, fmt.Errorf("Error parsing git log. Invalid anchor line %q", line)
+		}
 		digest, err := hex.DecodeString(lineParts[0])
 		if err != nil {
 			return nil, nil, err
@@ -200,30 +388,35 @@ func (g *gitBackEnd) readAnchorRecord(key [sha256.Size]byte) (*Anchor, error) {
 		currLine = currLine + linesUsed
 
 		// Check the first line to see if the commit matches the target
-		firstLine := commit.Message[0]
+		am, err := parseAnchorMessage(commit.Message[0])
+		if err != nil {
+			return nil, err
+		}
+		if am == nil || am.merkle != keyStr {
+			continue
+		}
 		// If it is an anchor confirmation, mark the anchor as verified but
 		// keep looking for the main anchor commit
-		if regexAnchorConfirmation.MatchString(firstLine) &&
-			keyStr == anchorConfirmationMerkle(commit) {
+		if am.confirmation {
 			anchorConfirmed = AnchorVerified
-		} else if regexAnchor.MatchString(firstLine) &&
-			keyStr == anchorCommitMerkle(commit) {
-			// Found the anchor
-			digests, messages, err := parseAnchorCommit(commit)
-			if err != nil {
-				return nil, err
-			}
-			return &Anchor{
-				Type:     anchorConfirmed,
-				Time:     commit.Time,
-				Digests:  digests,
-				Messages: messages,
-			}, nil
+			continue
+		}
+
+		// Found the anchor
+		digests, messages, err := parseAnchorCommit(commit)
+		if err != nil {
+			return nil, err
 		}
+		return &Anchor{
+			Type:     anchorConfirmed,
+			Time:     commit.Time,
+			Digests:  digests,
+			Messages: messages,
+		}, nil
 	}
 
 	// Anchor wasn't found
-	return nil, fmt.Errorf("Anchor not found for key %v", key)
+	return nil, backend.ErrAnchorNotFound
 }
 
 // readLastAnchorRecord retrieves the last anchor record.
@@ -238,6 +431,7 @@ func (g *gitBackEnd) readLastAnchorRecord() (*LastAnchor, error) {
 	var found bool
 	var la LastAnchor
 	var lastAnchorCommit *GitCommit
+	var lastAnchorMerkle string
 	currLine := 0
 	for currLine < len(gitLog) {
 		commit, linesUsed, err := extractCommit(gitLog[currLine:])
@@ -248,10 +442,14 @@ func (g *gitBackEnd) readLastAnchorRecord() (*LastAnchor, error) {
 
 		// Check the first line of the commit message
 		// Make sure it is an anchor, not an anchor confirmation
-		if !regexAnchorConfirmation.MatchString(commit.Message[0]) &&
-			regexAnchor.MatchString(commit.Message[0]) {
+		am, err := parseAnchorMessage(commit.Message[0])
+		if err != nil {
+			return nil, err
+		}
+		if am != nil && !am.confirmation {
 			found = true
 			lastAnchorCommit = commit
+			lastAnchorMerkle = am.merkle
 			break
 		}
 	}
@@ -260,8 +458,7 @@ func (g *gitBackEnd) readLastAnchorRecord() (*LastAnchor, error) {
 		return &la, nil
 	}
 
-	merkleStr := anchorCommitMerkle(lastAnchorCommit)
-	merkleBytes, err := hex.DecodeString(merkleStr)
+	merkleBytes, err := hex.DecodeString(lastAnchorMerkle)
 	if err != nil {
 		return nil, err
 	}
@@ -274,6 +471,15 @@ func (g *gitBackEnd) readLastAnchorRecord() (*LastAnchor, error) {
 	}
 	la.Last = extendSHA1(hashBytes)
 
+	// The covered commits are listed newest first
+	digests, _, err := parseAnchorCommit(lastAnchorCommit)
+	if err != nil {
+		return nil, err
+	}
+	if len(digests) != 0 {
+		la.Covered = digests[0]
+	}
+
 	return &la, nil
 }
 
@@ -285,9 +491,13 @@ func (g *gitBackEnd) readUnconfirmedAnchorRecord() (*UnconfirmedAnchor, error) {
 		return nil, err
 	}
 
-	// Look for unconfirmed commits and store their Merkle roots
-	// Stop looking at the latest confirmed commit
+	// Look for unconfirmed commits and store their Merkle roots.  Anchors
+	// are not necessarily confirmed in order, an older anchor may still
+	// be waiting for confirmations while a newer one is already
+	// confirmed.  Since confirmations are always committed after their
+	// anchor we see them first and can skip the matching anchor.
 	var ua UnconfirmedAnchor
+	confirmed := make(map[string]struct{})
 	currLine := 0
 	for currLine < len(gitLog) {
 		commit, linesUsed, err := extractCommit(gitLog[currLine:])
@@ -298,13 +508,19 @@ func (g *gitBackEnd) readUnconfirmedAnchorRecord() (*UnconfirmedAnchor, error) {
 
 		// Check the first line of the commit message to see if it is an
 		// anchor confirmation or an anchor.
-		firstLine := commit.Message[0]
-		if regexAnchorConfirmation.MatchString(firstLine) {
-			// Found the latest confirmed anchor. Stop looking.
-			break
-		} else if regexAnchor.MatchString(firstLine) {
-			merkleStr := anchorCommitMerkle(commit)
-			merkleBytes, err := hex.DecodeString(merkleStr)
+		am, err := parseAnchorMessage(commit.Message[0])
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case am == nil:
+		case am.confirmation:
+			confirmed[am.merkle] = struct{}{}
+		default:
+			if _, ok := confirmed[am.merkle]; ok {
+				continue
+			}
+			merkleBytes, err := hex.DecodeString(am.merkle)
 			if err != nil {
 				return nil, err
 			}
@@ -314,3 +530,1153 @@ func (g *gitBackEnd) readUnconfirmedAnchorRecord() (*UnconfirmedAnchor, error) {
 
 	return &ua, nil
 }
+
+// pruneUnconfirmedAnchors removes the anchors from ua whose dcrtime chain
+// information is already stored in git.  That happens when a confirmation was
+// recorded out of band, e.g. by fsck while the periodic checker was waiting
+// on dcrtime.  It returns the merkle roots that were pruned.
+func (g *gitBackEnd) pruneUnconfirmedAnchors(ua *UnconfirmedAnchor) ([]string, error) {
+	var pruned []string
+	merkles := ua.Merkles[:0]
+	for _, m := range ua.Merkles {
+		merkle := hex.EncodeToString(m)
+		ci, err := g.readAnchorChainInformation(merkle)
+		if err != nil {
+			return nil, err
+		}
+		if ci != nil {
+			pruned = append(pruned, merkle)
+			continue
+		}
+		merkles = append(merkles, m)
+	}
+	ua.Merkles = merkles
+
+	return pruned, nil
+}
+
+// PruneUnconfirmedAnchors removes anchors that are already confirmed in git
+// from the unconfirmed anchor set and returns their merkle roots.  The
+// periodic anchor checker does this on every cycle, this method is intended
+// for manual cleanup.
+func (g *gitBackEnd) PruneUnconfirmedAnchors() ([]string, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	ua, err := g.readUnconfirmedAnchorRecord()
+	if err != nil {
+		return nil, err
+	}
+	pruned, err := g.pruneUnconfirmedAnchors(ua)
+	if err != nil {
+		return nil, err
+	}
+	for _, merkle := range pruned {
+		log.Infof("Pruned confirmed anchor from unconfirmed set: %v",
+			merkle)
+	}
+
+	return pruned, nil
+}
+
+// anchorConfirmation describes which parts of the confirmation of an anchor
+// are present in the vetted repo.  afterAnchorVerify writes all of them in a
+// single commit.
+type anchorConfirmation struct {
+	merkle     string
+	committed  bool // Anchor confirmation commit exists
+	chainInfo  bool // Chain information exists in the anchors directory
+	auditTrail bool // Audit trail contains the TX line
+}
+
+// complete returns true if nothing of the confirmation is missing.
+func (a anchorConfirmation) complete() bool {
+	return a.committed && a.chainInfo && a.auditTrail
+}
+
+// auditTrailTXLine returns the audit trail line that records the transaction
+// of a confirmed anchor.
+func auditTrailTXLine(merkle, transaction string) string {
+	return fmt.Sprintf("%v anchored in TX %v\n", merkle, transaction)
+}
+
+// auditTrailDelayLine returns the audit trail line that records how many
+// seconds it took to confirm an anchor.
+func auditTrailDelayLine(merkle string, delay int64) string {
+	return fmt.Sprintf("%v confirmed %vs after anchoring\n", merkle, delay)
+}
+
+// confirmationDelay returns the number of seconds between dropping the anchor
+// mr and its chain timestamp and records it in the anchor health.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) confirmationDelay(mr [sha256.Size]byte, chainTimestamp int64) (int64, error) {
+	anchor, err := g.readAnchorRecord(mr)
+	if err != nil {
+		return 0, err
+	}
+	delay := chainTimestamp - anchor.Time
+
+	g.anchorHealthMtx.Lock()
+	g.anchorHealth.Confirmed++
+	g.anchorHealth.LastConfirmDelay = delay
+	if delay > g.anchorHealth.MaxConfirmDelay {
+		g.anchorHealth.MaxConfirmDelay = delay
+	}
+	g.anchorHealthMtx.Unlock()
+
+	return delay, nil
+}
+
+// readAnchorConfirmations returns the confirmation state of all anchors in
+// the vetted repo, oldest first.  The vetted repo must sit in master.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) readAnchorConfirmations() ([]anchorConfirmation, error) {
+	gitLog, err := g.gitLog(g.vetted)
+	if err != nil {
+		return nil, err
+	}
+
+	// The log is newest first so confirmations are seen before their
+	// anchor.
+	var acs []anchorConfirmation
+	confirmed := make(map[string]struct{})
+	currLine := 0
+	for currLine < len(gitLog) {
+		commit, linesUsed, err := extractCommit(gitLog[currLine:])
+		if err != nil {
+			return nil, err
+		}
+		currLine = currLine + linesUsed
+
+		am, err := parseAnchorMessage(commit.Message[0])
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case am == nil:
+		case am.confirmation:
+			confirmed[am.merkle] = struct{}{}
+		default:
+			_, ok := confirmed[am.merkle]
+			acs = append(acs, anchorConfirmation{
+				merkle:    am.merkle,
+				committed: ok,
+			})
+		}
+	}
+
+	audit, err := ioutil.ReadFile(filepath.Join(g.vetted,
+		defaultAuditTrailFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for k := range acs {
+		ci, err := g.readAnchorChainInformation(acs[k].merkle)
+		if err != nil {
+			return nil, err
+		}
+		acs[k].chainInfo = ci != nil
+		acs[k].auditTrail = bytes.Contains(audit,
+			[]byte(acs[k].merkle+" anchored in TX "))
+	}
+
+	// Oldest first
+	for i, j := 0, len(acs)-1; i < j; i, j = i+1, j-1 {
+		acs[i], acs[j] = acs[j], acs[i]
+	}
+
+	return acs, nil
+}
+
+// incompleteAnchorConfirmations returns the merkle roots of the anchors whose
+// confirmation is not, or only partially, recorded in the vetted repo.
+func (g *gitBackEnd) incompleteAnchorConfirmations() ([]string, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	// git checkout master
+	err = g.gitCheckout(g.vetted, "master")
+	if err != nil {
+		return nil, err
+	}
+	acs, err := g.readAnchorConfirmations()
+	if err != nil {
+		return nil, err
+	}
+	var merkles []string
+	for _, ac := range acs {
+		if !ac.complete() {
+			merkles = append(merkles, ac.merkle)
+		}
+	}
+
+	return merkles, nil
+}
+
+// backfillAnchorConfirmations records the missing parts of the confirmations
+// of vrs.  The confirmation state is read again since it may have changed
+// while dcrtime was queried.  It returns the number of backfilled anchors.
+func (g *gitBackEnd) backfillAnchorConfirmations(vrs []v1.VerifyDigest) (int, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return 0, backend.ErrShutdown
+	}
+
+	// git checkout master
+	err = g.gitCheckout(g.vetted, "master")
+	if err != nil {
+		return 0, err
+	}
+	acs, err := g.readAnchorConfirmations()
+	if err != nil {
+		return 0, err
+	}
+	state := make(map[string]anchorConfirmation, len(acs))
+	for _, ac := range acs {
+		state[ac.merkle] = ac
+	}
+
+	var backfilled int
+	for _, vr := range vrs {
+		ac, ok := state[vr.Digest]
+		if !ok || ac.complete() {
+			continue
+		}
+		if vr.ChainInformation.ChainTimestamp == 0 {
+			// Not enough confirmations yet
+			log.Debugf("backfillAnchorConfirmations: not enough "+
+				"confirmations: %v", vr.Digest)
+			continue
+		}
+		mr, ok := util.ConvertDigest(vr.Digest)
+		if !ok {
+			return backfilled, fmt.Errorf("invalid digest: %v",
+				vr.Digest)
+		}
+
+		txLine := auditTrailTXLine(vr.Digest,
+			vr.ChainInformation.Transaction)
+		if !ac.auditTrail {
+			delay, err := g.confirmationDelay(mr,
+				vr.ChainInformation.ChainTimestamp)
+			if err != nil {
+				return backfilled, err
+			}
+			err = g.appendAuditTrail(g.vetted,
+				vr.ChainInformation.ChainTimestamp, mr,
+				[]string{txLine,
+					auditTrailDelayLine(vr.Digest, delay)})
+			if err != nil {
+				return backfilled, err
+			}
+		}
+		if !ac.chainInfo {
+			anchorDir := filepath.Join(g.vetted,
+				defaultAnchorsDirectory)
+			err = os.MkdirAll(anchorDir, g.dirModeOr(0774))
+			if err != nil {
+				return backfilled, err
+			}
+			ar, err := json.Marshal(vr.ChainInformation)
+			if err != nil {
+				return backfilled, err
+			}
+			err = ioutil.WriteFile(filepath.Join(anchorDir, vr.Digest),
+				ar, g.fileModeOr(0664))
+			if err != nil {
+				return backfilled, err
+			}
+		}
+
+		// Both files are added since a crash may have left them in
+		// the working tree without committing them.
+		err = g.gitAdd(g.vetted, defaultAuditTrailFile)
+		if err != nil {
+			return backfilled, err
+		}
+		err = g.gitAdd(g.vetted,
+			filepath.Join(defaultAnchorsDirectory, vr.Digest))
+		if err != nil {
+			return backfilled, err
+		}
+
+		// git commit anchor confirmation, the commit may be empty
+		// when only the commit itself was missing.
+		commitMsg := anchorMessage{
+			confirmation:   true,
+			merkle:         vr.Digest,
+			transaction:    vr.ChainInformation.Transaction,
+			chainTimestamp: vr.ChainInformation.ChainTimestamp,
+		}.String() + "\n\n" + txLine
+		_, err = g.git(g.vetted, "commit", "--allow-empty", "-m",
+			commitMsg)
+		if err != nil {
+			return backfilled, err
+		}
+		backfilled++
+
+		log.Infof("Backfilled anchor confirmation %v: commit %v "+
+			"chain information %v audit trail %v", vr.Digest,
+			!ac.committed, !ac.chainInfo, !ac.auditTrail)
+
+		if !ac.committed {
+			g.notifyAnchorConfirmed(vr, mr)
+		}
+	}
+	if backfilled == 0 {
+		return 0, nil
+	}
+
+	// git checkout master unvetted
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		return backfilled, err
+	}
+
+	// git pull --ff-only --rebase
+	return backfilled, g.gitPull(g.unvetted, true)
+}
+
+// ResyncAnchorConfirmations queries dcrtime for every anchor whose
+// confirmation is not fully recorded in the vetted repo and backfills the
+// missing confirmation commits, chain information and audit trail TX lines.
+// This repairs the repo after a crash in the middle of recording
+// confirmations.  Anchors that are completely recorded are skipped so it is
+// safe to call repeatedly.
+func (g *gitBackEnd) ResyncAnchorConfirmations() error {
+	merkles, err := g.incompleteAnchorConfirmations()
+	if err != nil {
+		return err
+	}
+	if len(merkles) == 0 {
+		log.Infof("Resync anchor confirmations: nothing to do")
+		return nil
+	}
+
+	// Query dcrtime without holding the lock
+	vrs := make([]v1.VerifyDigest, 0, len(merkles))
+	for _, merkle := range merkles {
+		vr, err := g.verifyAnchor(merkle)
+		if err != nil {
+			log.Errorf("ResyncAnchorConfirmations verify: %v", err)
+			continue
+		}
+		vrs = append(vrs, *vr)
+	}
+
+	n, err := g.backfillAnchorConfirmations(vrs)
+	if err != nil {
+		return err
+	}
+	log.Infof("Resync anchor confirmations: backfilled %v of %v", n,
+		len(merkles))
+
+	return nil
+}
+
+// readAnchorChainInformation returns the dcrtime chain information that was
+// stored when the anchor identified by merkle was confirmed.  It returns nil
+// if the anchor has not been confirmed yet.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) readAnchorChainInformation(merkle string) (*v1.ChainInformation, error) {
+	b, err := ioutil.ReadFile(filepath.Join(g.vetted,
+		defaultAnchorsDirectory, merkle))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ci v1.ChainInformation
+	err = json.Unmarshal(b, &ci)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ci, nil
+}
+
+// anchorInfo converts an anchor commit into a backend.AnchorInfo and fills
+// out the confirmation information if it is available.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) anchorInfo(commit *GitCommit) (*backend.AnchorInfo, error) {
+	digests, _, err := parseAnchorCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+	// parseAnchorCommit verified the message
+	am, err := parseAnchorMessage(commit.Message[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ai := backend.AnchorInfo{
+		Merkle:    am.merkle,
+		Time:      commit.Time,
+		Digests:   make([]string, 0, len(digests)),
+		Signature: am.signature,
+	}
+	for _, d := range digests {
+		ai.Digests = append(ai.Digests, hex.EncodeToString(d))
+	}
+
+	ci, err := g.readAnchorChainInformation(ai.Merkle)
+	if err != nil {
+		return nil, err
+	}
+	if ci != nil {
+		ai.Confirmed = true
+		ai.ChainTimestamp = ci.ChainTimestamp
+		ai.Transaction = ci.Transaction
+	}
+
+	return &ai, nil
+}
+
+// notifyAnchor hands the anchor event to the OnAnchor hook, if set.  The hook
+// is called on its own go routine so that it does not hold up the caller,
+// which is holding the lock, and a panicking hook is logged and otherwise
+// ignored.
+func (g *gitBackEnd) notifyAnchor(ai backend.AnchorInfo) {
+	if g.onAnchor == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("OnAnchor %v: %v", ai.Merkle, r)
+			}
+		}()
+		g.onAnchor(ai)
+	}()
+}
+
+// notifyAnchorConfirmed hands the confirmation of the anchor identified by mr
+// to the OnAnchor hook, if set.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) notifyAnchorConfirmed(vr v1.VerifyDigest, mr [sha256.Size]byte) {
+	if g.onAnchor == nil {
+		return
+	}
+	ai := backend.AnchorInfo{
+		Merkle:         vr.Digest,
+		Confirmed:      true,
+		ChainTimestamp: vr.ChainInformation.ChainTimestamp,
+		Transaction:    vr.ChainInformation.Transaction,
+	}
+	anchor, err := g.readAnchorRecord(mr)
+	if err != nil {
+		log.Errorf("notifyAnchorConfirmed: readAnchorRecord %v: %v",
+			vr.Digest, err)
+	} else {
+		ai.Time = anchor.Time
+		for _, d := range anchor.Digests {
+			ai.Digests = append(ai.Digests, hex.EncodeToString(d))
+		}
+	}
+	g.notifyAnchor(ai)
+}
+
+// anchorForCommit walks the vetted git log and returns the anchor that covers
+// the provided extended commit digest.  Since the log is newest first the
+// covering anchor is the last matching anchor commit seen before the commit
+// itself shows up.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) anchorForCommit(digest string) (*backend.AnchorInfo, error) {
+	gitLog, err := g.gitLog(g.vetted)
+	if err != nil {
+		return nil, err
+	}
+
+	var covering *GitCommit
+	currLine := 0
+	for currLine < len(gitLog) {
+		commit, linesUsed, err := extractCommit(gitLog[currLine:])
+		if err != nil {
+			return nil, err
+		}
+		currLine = currLine + linesUsed
+
+		hash, err := extendSHA1FromString(commit.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if hash == digest {
+			break
+		}
+
+		am, err := parseAnchorMessage(commit.Message[0])
+		if err != nil {
+			return nil, err
+		}
+		if am == nil || am.confirmation {
+			continue
+		}
+		digests, _, err := parseAnchorCommit(commit)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range digests {
+			if hex.EncodeToString(d) == digest {
+				covering = commit
+				break
+			}
+		}
+	}
+	if covering == nil {
+		return nil, backend.ErrAnchorNotFound
+	}
+
+	return g.anchorInfo(covering)
+}
+
+// AnchorForCommit returns the anchor, and its confirmation information if
+// available, that covers the provided git commit digest.  The digest may be
+// provided either as a SHA1 or as an extended SHA1 digest.
+//
+// AnchorForCommit satisfies the backend interface.
+func (g *gitBackEnd) AnchorForCommit(digest string) (*backend.AnchorInfo, error) {
+	switch len(digest) {
+	case sha1.Size * 2:
+		var err error
+		digest, err = extendSHA1FromString(digest)
+		if err != nil {
+			return nil, err
+		}
+	case sha256.Size * 2:
+		if _, err := hex.DecodeString(digest); err != nil {
+			return nil, fmt.Errorf("not hex: %v", digest)
+		}
+	default:
+		return nil, fmt.Errorf("invalid digest size: %v", digest)
+	}
+	digest = strings.ToLower(digest)
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	return g.anchorForCommit(digest)
+}
+
+// AnchorCommits returns the commits, as listed in the anchor commit message,
+// that are covered by the anchor identified by its merkle root.  It returns
+// backend.ErrAnchorNotFound if there is no such anchor.
+//
+// AnchorCommits satisfies the backend interface.
+func (g *gitBackEnd) AnchorCommits(merkle string) ([]backend.AnchoredCommit, error) {
+	key, ok := util.ConvertDigest(merkle)
+	if !ok {
+		return nil, fmt.Errorf("invalid merkle root: %v", merkle)
+	}
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	anchor, err := g.readAnchorRecord(key)
+	if err != nil {
+		return nil, err
+	}
+	acs := make([]backend.AnchoredCommit, 0, len(anchor.Digests))
+	for k, d := range anchor.Digests {
+		if isRecordMerkleMessage(anchor.Messages[k]) {
+			continue
+		}
+		acs = append(acs, backend.AnchoredCommit{
+			Digest:  hex.EncodeToString(d),
+			Message: anchor.Messages[k],
+		})
+	}
+
+	return acs, nil
+}
+
+// ListAnchors returns every anchor of the vetted repo, newest first.  The
+// confirmation information is taken from the anchors directory and, for anchors
+// that were confirmed before it existed, from their anchor confirmation commit.
+//
+// ListAnchors satisfies the backend interface.
+func (g *gitBackEnd) ListAnchors() ([]backend.AnchorInfo, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	gitLog, err := g.gitLog(g.vetted)
+	if err != nil {
+		return nil, err
+	}
+
+	// The log is newest first so confirmations precede their anchor
+	confirmations := make(map[string]*anchorMessage)
+	anchors := make([]backend.AnchorInfo, 0)
+	currLine := 0
+	for currLine < len(gitLog) {
+		commit, linesUsed, err := extractCommit(gitLog[currLine:])
+		if err != nil {
+			return nil, err
+		}
+		currLine = currLine + linesUsed
+
+		am, err := parseAnchorMessage(commit.Message[0])
+		if err != nil {
+			return nil, err
+		}
+		if am == nil {
+			continue
+		}
+		if am.confirmation {
+			confirmations[am.merkle] = am
+			continue
+		}
+
+		ai, err := g.anchorInfo(commit)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := confirmations[ai.Merkle]; ok && !ai.Confirmed {
+			ai.Confirmed = true
+			ai.ChainTimestamp = c.chainTimestamp
+			ai.Transaction = c.transaction
+		}
+		anchors = append(anchors, *ai)
+	}
+
+	return anchors, nil
+}
+
+// RecordAtAnchor returns the vetted record identified by token as it was at
+// the newest commit covered by the anchor with the provided merkle root, i.e.
+// the content whose existence the anchor proves.  It returns
+// backend.ErrRecordNotFound if the record was not vetted yet at that time.
+//
+// RecordAtAnchor satisfies the backend interface.
+func (g *gitBackEnd) RecordAtAnchor(token []byte, merkle string) (*backend.Record, error) {
+	key, ok := util.ConvertDigest(merkle)
+	if !ok {
+		return nil, fmt.Errorf("invalid merkle root: %v", merkle)
+	}
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	anchor, err := g.readAnchorRecord(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(anchor.Digests) == 0 {
+		return nil, fmt.Errorf("anchor without commits: %v", merkle)
+	}
+
+	// Digests are in git log order, newest first
+	commit := hex.EncodeToString(unextendSHA256(anchor.Digests[0]))
+	dir, err := ioutil.TempDir("", "politeia.anchor")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	id := hex.EncodeToString(token)
+	ok, err = g.gitArchive(g.vetted, commit, id, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, backend.ErrRecordNotFound
+	}
+
+	return g._getRecord(id, dir, true)
+}
+
+// lastVettedCommit returns the digest of the latest commit of the vetted
+// record identified by id.
+// This function must be called with the lock held.
+func (g *gitBackEnd) lastVettedCommit(id string) (string, error) {
+	_, err := os.Stat(filepath.Join(g.vetted, id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", backend.ErrRecordNotFound
+		}
+		return "", err
+	}
+	out, err := g.git(g.vetted, "log", "-1", "--format=%H", "--", id)
+	if err != nil {
+		return "", err
+	}
+	if len(out) == 0 {
+		return "", backend.ErrRecordNotFound
+	}
+	return out[0], nil
+}
+
+// lastVettedDigest returns the extended digest of the latest commit of the
+// vetted record identified by id.
+// This function must be called with the lock held.
+func (g *gitBackEnd) lastVettedDigest(id string) (string, error) {
+	commit, err := g.lastVettedCommit(id)
+	if err != nil {
+		return "", err
+	}
+	return extendSHA1FromString(commit)
+}
+
+// RecordAnchorStatus returns whether the latest commit of the vetted record
+// identified by token is not anchored yet, anchored but pending confirmation
+// or anchored and confirmed by dcrtime.
+//
+// RecordAnchorStatus satisfies the backend interface.
+func (g *gitBackEnd) RecordAnchorStatus(token []byte) (backend.AnchorStatus, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return backend.AnchorStatusInvalid, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return backend.AnchorStatusInvalid, backend.ErrShutdown
+	}
+
+	digest, err := g.lastVettedDigest(hex.EncodeToString(token))
+	if err != nil {
+		return backend.AnchorStatusInvalid, err
+	}
+	ai, err := g.anchorForCommit(digest)
+	switch {
+	case err == backend.ErrAnchorNotFound:
+		return backend.AnchorStatusNotAnchored, nil
+	case err != nil:
+		return backend.AnchorStatusInvalid, err
+	case ai.Confirmed:
+		return backend.AnchorStatusConfirmed, nil
+	}
+
+	return backend.AnchorStatusPending, nil
+}
+
+// ProveAnchored returns a proof that the latest commit of the vetted record
+// identified by token is anchored.  It returns backend.ErrAnchorNotFound if
+// that commit has not been anchored and confirmed yet.
+//
+// ProveAnchored satisfies the backend interface.
+func (g *gitBackEnd) ProveAnchored(token []byte) (*backend.AnchorProof, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	return g.proveAnchored(hex.EncodeToString(token))
+}
+
+// anchorBranch returns the merkle branch that proves the inclusion of digest
+// in the merkle root of digests.  All digests are hex encoded.
+func anchorBranch(digests []string, digest string) (*merkle.Branch, error) {
+	leaves := make([]*[sha256.Size]byte, 0, len(digests))
+	var leaf *[sha256.Size]byte
+	for _, v := range digests {
+		d, ok := util.ConvertDigest(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid anchor digest: %v", v)
+		}
+		leaves = append(leaves, &d)
+		if v == digest {
+			leaf = &d
+		}
+	}
+	if leaf == nil {
+		return nil, fmt.Errorf("digest not in anchor: %v", digest)
+	}
+
+	// merkle.Root sorts the leaves so the authentication path must be
+	// built from the same order.
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i][:], leaves[j][:]) < 0
+	})
+	return merkle.AuthPath(leaves, leaf), nil
+}
+
+// proveAnchored returns a proof that the latest commit of the vetted record id
+// is anchored.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) proveAnchored(id string) (*backend.AnchorProof, error) {
+	// Find the confirmed anchor that covers the latest record commit
+	digest, err := g.lastVettedDigest(id)
+	if err != nil {
+		return nil, err
+	}
+	ai, err := g.anchorForCommit(digest)
+	if err != nil {
+		return nil, err
+	}
+	ci, err := g.readAnchorChainInformation(ai.Merkle)
+	if err != nil {
+		return nil, err
+	}
+	if ci == nil {
+		return nil, backend.ErrAnchorNotFound
+	}
+
+	// Prove inclusion of the commit in the anchor
+	branch, err := anchorBranch(ai.Digests, digest)
+	if err != nil {
+		// Really can't happen
+		return nil, err
+	}
+
+	// Prove inclusion of the record merkle root, legacy anchors don't
+	// carry it
+	brm, err := loadMD(g.vetted, id)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		recordMerkle string
+		recordBranch merkle.Branch
+	)
+	for _, v := range ai.Digests {
+		if v == hex.EncodeToString(brm.Merkle[:]) {
+			recordMerkle = v
+			break
+		}
+	}
+	if recordMerkle != "" {
+		rb, err := anchorBranch(ai.Digests, recordMerkle)
+		if err != nil {
+			return nil, err
+		}
+		recordBranch = *rb
+	}
+
+	return &backend.AnchorProof{
+		Digest:         digest,
+		AnchorBranch:   *branch,
+		RecordMerkle:   recordMerkle,
+		RecordBranch:   recordBranch,
+		Merkle:         ai.Merkle,
+		DcrtimeBranch:  ci.MerklePath,
+		MerkleRoot:     ci.MerkleRoot,
+		Transaction:    ci.Transaction,
+		ChainTimestamp: ci.ChainTimestamp,
+		Signature:      ai.Signature,
+	}, nil
+}
+
+// FileProof returns a proof that the file filename of the vetted record
+// identified by token is anchored.  It proves the inclusion of the file digest
+// in the record merkle root and the inclusion of the latter in the anchor that
+// covers the latest record commit, see Anchor.RecordBranch.  It returns
+// backend.ErrAnchorNotFound if that commit has not been anchored and confirmed
+// yet or if the anchor predates anchoring record merkle roots.
+//
+// FileProof satisfies the backend interface.
+func (g *gitBackEnd) FileProof(token []byte, filename string) (*backend.FileProof, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	id := hex.EncodeToString(token)
+	brm, err := loadMD(g.vetted, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Digest all files, the record merkle root sorts them
+	ppath := filepath.Join(g.vetted, id, defaultPayloadDir)
+	files, err := payloadFiles(ppath)
+	if err != nil {
+		return nil, err
+	}
+	leaves := make([]*[sha256.Size]byte, 0, len(files))
+	var leaf *[sha256.Size]byte
+	for _, v := range files {
+		digest, err := g.payloadDigest(payloadFilename(ppath, v))
+		if err != nil {
+			return nil, err
+		}
+		var d [sha256.Size]byte
+		copy(d[:], digest)
+		leaves = append(leaves, &d)
+		if v == filename {
+			leaf = &d
+		}
+	}
+	if leaf == nil {
+		return nil, backend.ErrFileNotFound
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i][:], leaves[j][:]) < 0
+	})
+	root := merkle.Root(append([]*[sha256.Size]byte{}, leaves...))
+	if *root != brm.Merkle {
+		return nil, backend.RecordCorruptError{
+			Token:  id,
+			Merkle: hex.EncodeToString(brm.Merkle[:]),
+			Actual: hex.EncodeToString(root[:]),
+		}
+	}
+	branch := merkle.AuthPath(leaves, leaf)
+
+	ap, err := g.proveAnchored(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The record merkle root must be a leaf of the anchor, otherwise the
+	// file is not bound to it
+	if ap.RecordMerkle == "" {
+		return nil, backend.ErrAnchorNotFound
+	}
+	err = verifyBranch(ap.RecordBranch, ap.RecordMerkle, ap.Merkle)
+	if err != nil {
+		return nil, fmt.Errorf("record merkle %v: %v", ap.RecordMerkle,
+			err)
+	}
+
+	return &backend.FileProof{
+		Name:         filename,
+		Digest:       hex.EncodeToString(leaf[:]),
+		FileBranch:   *branch,
+		RecordMerkle: hex.EncodeToString(brm.Merkle[:]),
+		Anchor:       *ap,
+	}, nil
+}
+
+// auditTrail is a snapshot of the audit trail.  It only exposes the bytes
+// that existed when it was opened so that concurrent appends are not read
+// half way.
+type auditTrail struct {
+	io.Reader
+	f *os.File
+}
+
+// Close closes the underlying audit trail file.
+func (a *auditTrail) Close() error {
+	return a.f.Close()
+}
+
+// AuditTrail returns the audit trail of the vetted repo.  The lock is only
+// held while opening the file, the content is streamed by the caller.  The
+// caller must close the returned reader.
+//
+// AuditTrail satisfies the backend interface.
+func (g *gitBackEnd) AuditTrail() (io.ReadCloser, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	f, err := os.Open(filepath.Join(g.vetted, defaultAuditTrailFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Nothing was anchored yet
+			return ioutil.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &auditTrail{
+		Reader: io.LimitReader(f, fi.Size()),
+		f:      f,
+	}, nil
+}
+
+// isAnchoringPaused returns true while anchoring is paused.
+func (g *gitBackEnd) isAnchoringPaused() bool {
+	g.anchorPauseMtx.Lock()
+	defer g.anchorPauseMtx.Unlock()
+
+	return g.anchorPaused
+}
+
+// PauseAnchoring stops the anchor cron job and the periodic anchor checker
+// from doing work, e.g. during a backup or a planned dcrtime downtime.  Anchors
+// that are scheduled while paused are deferred until ResumeAnchoring.  An
+// anchor that is in flight is not interrupted.
+//
+// PauseAnchoring satisfies the backend interface.
+func (g *gitBackEnd) PauseAnchoring() error {
+	if g.isShutdown() {
+		return backend.ErrShutdown
+	}
+
+	g.anchorPauseMtx.Lock()
+	defer g.anchorPauseMtx.Unlock()
+
+	if !g.anchorPaused {
+		log.Infof("Anchoring paused")
+	}
+	g.anchorPaused = true
+
+	return nil
+}
+
+// ResumeAnchoring resumes anchoring after PauseAnchoring.  An anchor that was
+// deferred while paused is dropped right away and the anchor checker is woken
+// up.
+//
+// ResumeAnchoring satisfies the backend interface.
+func (g *gitBackEnd) ResumeAnchoring() error {
+	if g.isShutdown() {
+		return backend.ErrShutdown
+	}
+
+	g.anchorPauseMtx.Lock()
+	if !g.anchorPaused {
+		g.anchorPauseMtx.Unlock()
+		return nil
+	}
+	deferred := g.anchorDeferred
+	g.anchorPaused = false
+	g.anchorDeferred = false
+	g.anchorPauseMtx.Unlock()
+
+	log.Infof("Anchoring resumed")
+
+	if deferred {
+		go g.anchorAllReposCronJob()
+	}
+
+	// Tickle the anchor checker, it is busy if it isn't listening
+	select {
+	case g.checkAnchor <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+)
+
+func TestAnchorMessage(t *testing.T) {
+	merkle := strings.Repeat("ab", 32)
+
+	// Round trip
+	for _, am := range []anchorMessage{
+		{merkle: merkle},
+		{merkle: merkle, signature: strings.Repeat("cd", 64)},
+		{
+			confirmation:   true,
+			merkle:         merkle,
+			transaction:    expectedTestTX,
+			chainTimestamp: 1517866432,
+		},
+	} {
+		got, err := parseAnchorMessage("    " + am.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || !reflect.DeepEqual(*got, am) {
+			t.Fatalf("got %v, wanted %v", got, am)
+		}
+	}
+
+	// Legacy confirmation
+	am, err := parseAnchorMessage("Anchor confirmation " + merkle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !am.confirmation || am.merkle != merkle || am.transaction != "" {
+		t.Fatalf("unexpected legacy confirmation %v", am)
+	}
+
+	// Not an anchor
+	for _, line := range []string{
+		"",
+		"Add record " + merkle,
+		"Anchored " + merkle,
+		"Update record metadata via plugin",
+	} {
+		am, err := parseAnchorMessage(line)
+		if err != nil || am != nil {
+			t.Fatalf("%q: unexpected anchor %v %v", line, am, err)
+		}
+	}
+
+	// Malformed
+	for _, line := range []string{
+		"Anchor",
+		"Anchor xyz",
+		"Anchor " + merkle + " extra",
+		"Anchor " + merkle[1:],
+		"Anchor " + merkle + " signature=xyz",
+		"Anchor " + merkle + " signature=" + strings.Repeat("cd", 32),
+		"Anchor confirmation",
+		"Anchor confirmation xyz",
+		"Anchor confirmation " + merkle + " tx=abc",
+		"Anchor confirmation " + merkle + " tx= timestamp=1",
+		"Anchor confirmation " + merkle + " tx=abc timestamp=x",
+		"Anchor confirmation " + merkle + " timestamp=1 tx=abc",
+		"Anchor confirmation " + merkle + " tx=abc timestamp=1 x",
+		"Anchor confirmed " + merkle + " tx=abc timestamp=1",
+	} {
+		am, err := parseAnchorMessage(line)
+		if err == nil {
+			t.Fatalf("%q: expected error, got %v", line, am)
+		}
+	}
+}
+
+func TestVerifyAnchorMessage(t *testing.T) {
+	id, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A commit and a record merkle root
+	commit := sha256.Sum256([]byte("commit"))
+	record := sha256.Sum256([]byte("record"))
+	body := fmt.Sprintf("%x Add record %x\n%x %v\n", commit, record,
+		record, recordMerkleMessage(hex.EncodeToString(record[:])))
+	root := merkle.Root([]*[sha256.Size]byte{&commit, &record})
+	anchorMerkle := hex.EncodeToString(root[:])
+	message := func(am anchorMessage, body string) string {
+		return am.String() + "\n\n" + body
+	}
+	signed := anchorMessage{
+		merkle:    anchorMerkle,
+		signature: signAnchor(id, anchorMerkle),
+	}
+
+	err = VerifyAnchorMessage(id.Public, message(signed, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(body, hex.EncodeToString(commit[:]),
+		strings.Repeat("00", sha256.Size), 1)
+	for _, v := range []struct {
+		pid     identity.PublicIdentity
+		message string
+	}{
+		{other.Public, message(signed, body)},
+		{id.Public, message(anchorMessage{merkle: anchorMerkle}, body)},
+		{id.Public, message(signed, tampered)},
+		{id.Public, message(signed, fmt.Sprintf("%x Add record\n",
+			commit))},
+		{id.Public, signed.String()},
+		{id.Public, message(anchorMessage{
+			merkle:    strings.Repeat("cd", 32),
+			signature: signAnchor(id, strings.Repeat("cd", 32)),
+		}, body)},
+		{id.Public, message(anchorMessage{
+			confirmation:   true,
+			merkle:         anchorMerkle,
+			transaction:    expectedTestTX,
+			chainTimestamp: 1517866432,
+		}, body)},
+		{id.Public, "Add record " + anchorMerkle},
+	} {
+		err := VerifyAnchorMessage(v.pid, v.message)
+		if err == nil {
+			t.Fatalf("%q: expected verification to fail", v.message)
+		}
+	}
+}
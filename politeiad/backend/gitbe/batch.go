@@ -0,0 +1,217 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+// batchPublish is a record of a status batch that became vetted and has to be
+// pushed upstream.
+type batchPublish struct {
+	index  int    // Index into the status changes
+	branch string // Record branch
+	head   string // Branch head prior to the status change
+}
+
+// publishBatch chains the branches of all records in publish on top of each
+// other and pushes the result upstream with a single rebasePR.  A record that
+// can't be chained is rolled back to its original head and its error is set
+// in errs.  If rebasePR fails all chained records are rolled back.
+//
+// This function must be called with the lock held and the unvetted repo
+// sitting in master.
+func (g *gitBackEnd) publishBatch(publish []batchPublish, errs []error) error {
+	// git pull --ff-only --rebase
+	err := g.gitPull(g.unvetted, true)
+	if err != nil {
+		return err
+	}
+
+	// rollback resets the branch of p to its original head.
+	rollback := func(p batchPublish, errReturn error) error {
+		errs[p.index] = errReturn
+
+		// git checkout master
+		err := g.gitCheckout(g.unvetted, "master")
+		if err != nil {
+			return err
+		}
+		if !g.gitBranchExists(g.unvetted, p.branch) {
+			return nil
+		}
+		return g.gitBranchReset(g.unvetted, p.branch, p.head)
+	}
+
+	tip := "master"
+	chained := make([]batchPublish, 0, len(publish))
+	for _, v := range publish {
+		// git checkout records/id
+		err := g.gitCheckout(g.unvetted, v.branch)
+		if err == nil {
+			// git rebase tip
+			err = g.gitRebase(g.unvetted, tip)
+		}
+		if err != nil {
+			err = rollback(v, err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		tip = v.branch
+		chained = append(chained, v)
+	}
+	if len(chained) == 0 {
+		// git checkout master
+		return g.gitCheckout(g.unvetted, "master")
+	}
+
+	// Create and rebase PR, this also drops the tip branch
+	err = g.rebasePR(tip)
+	if err != nil {
+		// git stash
+		err2 := g.gitStash(g.unvetted)
+		if err2 != nil {
+			// We are in trouble!  Consider a panic.
+			log.Errorf("gitStash: %v", err2)
+			return err2
+		}
+		for _, v := range chained {
+			err2 = rollback(v, err)
+			if err2 != nil {
+				return err2
+			}
+		}
+		return nil
+	}
+
+	// Drop the remaining branches, they have been published with the tip
+	for _, v := range chained[:len(chained)-1] {
+		err = g.gitBranchDelete(g.unvetted, v.branch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetUnvettedStatusBatch applies the status changes to their unvetted records
+// and publishes all records that become vetted with a single rebase instead of
+// one per record.  The returned records are indexed like changes and do not
+// contain files.  A failed status change does not abort the batch, instead its
+// record is nil and a StatusBatchError that carries the error of every status
+// change is returned alongside the records.
+//
+// SetUnvettedStatusBatch satisfies the backend interface.
+func (g *gitBackEnd) SetUnvettedStatusBatch(changes []backend.StatusChange) ([]*backend.Record, error) {
+	// Lock records before the filesystem, see locks.go
+	tokens := make([][]byte, 0, len(changes))
+	for _, v := range changes {
+		tokens = append(tokens, v.Token)
+	}
+	defer g.lockRecords(tokens)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	log.Tracef("setting status of %v records", len(changes))
+
+	records := make([]*backend.Record, len(changes))
+	errs := make([]error, len(changes))
+	publish := make([]batchPublish, 0, len(changes))
+	seen := make(map[string]struct{}, len(changes))
+	for k, v := range changes {
+		id := hex.EncodeToString(v.Token)
+		if _, ok := seen[id]; ok {
+			errs[k] = fmt.Errorf("duplicate status change %v", id)
+			continue
+		}
+		seen[id] = struct{}{}
+
+		branch := recordBranch(id)
+		head, err := g.gitRevParse(g.unvetted, branch)
+		if err != nil {
+			errs[k] = backend.ErrRecordNotFound
+			continue
+		}
+
+		// Clean up after an update of this record that died half way
+		err = g.recoverUpdate(id)
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := g.setUnvettedStatus(v.Token, v.Status, v.MDAppend,
+			v.MDOverwrite, false)
+		if err != nil {
+			// git stash
+			err2 := g.gitStash(g.unvetted)
+			if err2 != nil {
+				// We are in trouble!  Consider a panic.
+				log.Errorf("gitStash: %v", err2)
+				return nil, err2
+			}
+			errs[k] = err
+		}
+
+		// git checkout master
+		err = g.gitCheckout(g.unvetted, "master")
+		if err != nil {
+			return nil, err
+		}
+
+		if errs[k] != nil {
+			continue
+		}
+		records[k] = record
+		if record.RecordMetadata.Status == backend.MDStatusVetted {
+			publish = append(publish, batchPublish{
+				index:  k,
+				branch: branch,
+				head:   head,
+			})
+		}
+	}
+
+	if len(publish) > 0 {
+		err = g.publishBatch(publish, errs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var failed bool
+	for k, v := range errs {
+		if v != nil {
+			records[k] = nil
+			failed = true
+			continue
+		}
+		g.setPending(hex.EncodeToString(records[k].RecordMetadata.Token),
+			records[k].RecordMetadata.Status)
+	}
+	if failed {
+		return records, backend.StatusBatchError{Errors: errs}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/decred/politeia/util"
+)
+
+const (
+	// defaultBlobDir is the directory, relative to the root, where large
+	// payloads are stored content-addressed outside of git.
+	defaultBlobDir = "payload-blobs"
+
+	// blobPointerPrefix starts the pointer file that is committed to git
+	// in lieu of a payload that lives in the blob store.  It is followed
+	// by the hex encoded SHA256 digest of the payload and a newline.
+	blobPointerPrefix = "politeia-blob sha256:"
+)
+
+// blobPointer returns the pointer file content for the provided digest.
+func blobPointer(digest []byte) []byte {
+	return []byte(blobPointerPrefix + hex.EncodeToString(digest) + "\n")
+}
+
+// parseBlobPointer returns the hex encoded digest if b is a blob pointer.
+func parseBlobPointer(b []byte) (string, bool) {
+	if len(b) != len(blobPointerPrefix)+64+1 ||
+		!bytes.HasPrefix(b, []byte(blobPointerPrefix)) ||
+		b[len(b)-1] != '\n' {
+		return "", false
+	}
+	digest := string(b[len(blobPointerPrefix) : len(b)-1])
+	if !util.IsDigest(digest) {
+		return "", false
+	}
+	return digest, true
+}
+
+// blobFilename returns the blob store filename of the provided hex digest.
+func (g *gitBackEnd) blobFilename(digest string) string {
+	return filepath.Join(g.root, defaultBlobDir, digest)
+}
+
+// writePayload writes a record payload to filename.  Payloads above the blob
+// threshold are stored in the blob store and only a pointer is written to
+// filename.  The remaining payloads are gzip compressed if enabled and worth
+// it, see compressPayload.  Payloads that happen to look like a blob, gzip or
+// encrypted pointer are always stored as a blob so that every pointer in git
+// is unambiguous.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) writePayload(filename string, payload, digest []byte) error {
+	_, isPointer := parseBlobPointer(payload)
+	if _, _, ok := parseGzipPointer(payload); ok {
+		isPointer = true
+	}
+	if _, _, ok := parseEncPointer(payload); ok {
+		isPointer = true
+	}
+	if !isPointer && (g.blobThreshold == 0 ||
+		int64(len(payload)) <= g.blobThreshold) {
+		if g.compress {
+			b, ok, err := compressPayload(payload, digest)
+			if err != nil {
+				return err
+			}
+			if ok {
+				payload = b
+			}
+		}
+		return ioutil.WriteFile(filename, payload, g.fileModeOr(0664))
+	}
+
+	// Blobs are immutable, only write them once.  Purged blobs are never
+	// written again, see PurgeFile.
+	blob := g.blobFilename(hex.EncodeToString(digest))
+	pt, err := g.readPurged(hex.EncodeToString(digest))
+	if err == nil && pt == nil {
+		_, err = os.Stat(blob)
+	}
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(blob), g.dirModeOr(0774))
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(blob, payload, g.fileModeOr(0664))
+	}
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, blobPointer(digest),
+		g.fileModeOr(0664))
+}
+
+// readPayload returns the record payload stored in filename, resolving blob
+// pointers, decompressing gzip pointers and decrypting encrypted pointers.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) readPayload(filename string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if digest, gz, ok := parseGzipPointer(b); ok {
+		return decompressPayload(digest, gz)
+	}
+	if digest, sealed, ok := parseEncPointer(b); ok {
+		return decryptPayload(g.encryptionKey, digest, sealed)
+	}
+	digest, ok := parseBlobPointer(b)
+	if !ok {
+		return b, nil
+	}
+
+	b, err = ioutil.ReadFile(g.blobFilename(digest))
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(util.Digest(b)) != digest {
+		return nil, fmt.Errorf("blob corrupt: %v", digest)
+	}
+	return b, nil
+}
+
+// payloadDigest returns the SHA256 digest of the record payload stored in
+// filename.  Blobs, compressed and encrypted payloads are not read, their
+// digest is taken from the pointer.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) payloadDigest(filename string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if digest, ok := parseBlobPointer(b); ok {
+		return hex.DecodeString(digest)
+	}
+	if digest, _, ok := parseGzipPointer(b); ok {
+		return hex.DecodeString(digest)
+	}
+	if digest, _, ok := parseEncPointer(b); ok {
+		return hex.DecodeString(digest)
+	}
+	return util.Digest(b), nil
+}
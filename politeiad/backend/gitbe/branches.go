@@ -0,0 +1,122 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+const (
+	// recordBranchPrefix namespaces record branches so that they can't
+	// collide with other refs such as master or tags.
+	recordBranchPrefix = "records/"
+
+	// tmpBranchSuffix marks the temporary branch that is used to push
+	// changes of a vetted record upstream.
+	tmpBranchSuffix = "_tmp"
+)
+
+// recordBranch returns the name of the branch of record id.
+func recordBranch(id string) string {
+	return recordBranchPrefix + id
+}
+
+// tmpBranch returns the name of the temporary branch of record id.
+func tmpBranch(id string) string {
+	return recordBranchPrefix + id + tmpBranchSuffix
+}
+
+// branchRecord returns the record id of branch.  It returns false if branch is
+// not a record branch.
+func branchRecord(branch string) (string, bool) {
+	if !strings.HasPrefix(branch, recordBranchPrefix) {
+		return "", false
+	}
+	id := strings.TrimPrefix(branch, recordBranchPrefix)
+	if !util.IsDigest(id) {
+		return "", false
+	}
+	return id, true
+}
+
+// recordBranches returns the record ids of all record branches in repo.
+func (g *gitBackEnd) recordBranches(repo string) ([]string, error) {
+	branches, err := g.gitBranches(repo)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(branches))
+	for _, v := range branches {
+		id, ok := branchRecord(v)
+		if !ok {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// migrateBranches renames record branches that predate the record branch
+// namespace, bare token and token_tmp branches, to their namespaced names.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) migrateBranches(repo string) error {
+	branches, err := g.gitBranches(repo)
+	if err != nil {
+		return err
+	}
+	for _, v := range branches {
+		var branch string
+		switch {
+		case util.IsDigest(v):
+			branch = recordBranch(v)
+		case strings.HasSuffix(v, tmpBranchSuffix) &&
+			util.IsDigest(strings.TrimSuffix(v, tmpBranchSuffix)):
+			branch = tmpBranch(strings.TrimSuffix(v, tmpBranchSuffix))
+		default:
+			continue
+		}
+
+		log.Infof("Migrating branch %v to %v", v, branch)
+		err = g.gitBranchRename(repo, v, branch)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staleBranchError returns a backend.StaleBranchError for the existing record
+// branch of id and logs what is known about it so that an operator can tell
+// whether it is left over from a crash.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) staleBranchError(id string) error {
+	branch := recordBranch(id)
+	e := backend.StaleBranchError{
+		Token:  id,
+		Branch: branch,
+	}
+	var head string
+	out, err := g.git(g.unvetted, "log", "-1", "--format=%H %ci %s", branch)
+	if err != nil {
+		head = err.Error()
+	} else if len(out) != 0 {
+		head = out[0]
+		e.Head = strings.SplitN(head, " ", 2)[0]
+	}
+	exists := func(repo string) bool {
+		_, err := os.Stat(filepath.Join(repo, id))
+		return err == nil
+	}
+	log.Errorf("Stale record branch %v: head %q, unvetted master %v, "+
+		"vetted %v", branch, head, exists(g.unvetted), exists(g.vetted))
+	return e
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+// verifyBranch verifies that the merkle branch b contains leaf and that it
+// authenticates root.  leaf and root are hex encoded.
+func verifyBranch(b merkle.Branch, leaf, root string) error {
+	found := false
+	for _, v := range b.Hashes {
+		if hex.EncodeToString(v[:]) == leaf {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%v not in merkle branch", leaf)
+	}
+
+	r, err := merkle.VerifyAuthPath(&b)
+	if err != nil {
+		return fmt.Errorf("invalid merkle branch: %v", err)
+	}
+	if hex.EncodeToString(r[:]) != root {
+		return fmt.Errorf("merkle branch root %x, expected %v", r[:],
+			root)
+	}
+
+	return nil
+}
+
+// VerifyAnchorProof verifies ap without trusting the server: the digest and,
+// if set, the record merkle root must be in the anchor merkle root, the anchor
+// merkle root must be in the dcrtime merkle root and the latter must have been
+// committed in a transaction.  Whether that transaction was mined can't be
+// verified offline.  Callers that verify a record must compare RecordMerkle to
+// the merkle root of its files.
+func VerifyAnchorProof(ap *backend.AnchorProof) error {
+	err := verifyBranch(ap.AnchorBranch, ap.Digest, ap.Merkle)
+	if err != nil {
+		return fmt.Errorf("anchor: %v", err)
+	}
+	if ap.RecordMerkle != "" {
+		err = verifyBranch(ap.RecordBranch, ap.RecordMerkle, ap.Merkle)
+		if err != nil {
+			return fmt.Errorf("record: %v", err)
+		}
+	}
+	err = verifyBranch(ap.DcrtimeBranch, ap.Merkle, ap.MerkleRoot)
+	if err != nil {
+		return fmt.Errorf("dcrtime: %v", err)
+	}
+	if ap.Transaction == "" {
+		return fmt.Errorf("anchor %v is not in a transaction",
+			ap.Merkle)
+	}
+	return nil
+}
+
+// bundleChecks returns the verification checks of rb in order.  Every check
+// runs, a failed check does not stop the ones that follow.
+func bundleChecks(rb *backend.RecordBundle) []backend.BundleCheck {
+	var checks []backend.BundleCheck
+	check := func(name string, err error) {
+		c := backend.BundleCheck{Name: name}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		checks = append(checks, c)
+	}
+
+	// File payloads must match their digests
+	hashes := make([]*[sha256.Size]byte, 0, len(rb.Record.Files))
+	for _, f := range rb.Record.Files {
+		payload, err := base64.StdEncoding.DecodeString(f.Payload)
+		if err != nil {
+			check("file "+f.Name, fmt.Errorf("invalid payload: %v",
+				err))
+			continue
+		}
+		digest := util.Digest(payload)
+		if hex.EncodeToString(digest) != f.Digest {
+			check("file "+f.Name, fmt.Errorf("digest %x, expected "+
+				"%v", digest, f.Digest))
+			continue
+		}
+		var d [sha256.Size]byte
+		copy(d[:], digest)
+		hashes = append(hashes, &d)
+		check("file "+f.Name, nil)
+	}
+
+	// The payloads must add up to the record merkle root
+	var err error
+	if len(hashes) != len(rb.Record.Files) {
+		err = fmt.Errorf("not all file payloads are valid")
+	} else {
+		var actual [sha256.Size]byte
+		if len(hashes) != 0 {
+			actual = *merkle.Root(hashes)
+		}
+		if actual != rb.Record.RecordMetadata.Merkle {
+			err = fmt.Errorf("merkle root %x, expected %x",
+				actual[:], rb.Record.RecordMetadata.Merkle[:])
+		}
+	}
+	check("merkle", err)
+
+	// The record commit and the record merkle root must be in the anchor
+	// and the anchor must be in the dcrtime merkle root that was committed
+	// in a transaction
+	ap := rb.Anchor
+	if ap == nil {
+		check("anchor", fmt.Errorf("bundle carries no anchor proof"))
+		return checks
+	}
+	check("anchor", verifyBranch(ap.AnchorBranch, ap.Digest, ap.Merkle))
+	recordMerkle := hex.EncodeToString(rb.Record.RecordMetadata.Merkle[:])
+	if ap.RecordMerkle != recordMerkle {
+		err = fmt.Errorf("anchored record merkle %q, expected %v",
+			ap.RecordMerkle, recordMerkle)
+	} else {
+		err = verifyBranch(ap.RecordBranch, ap.RecordMerkle, ap.Merkle)
+	}
+	check("record", err)
+	err = verifyBranch(ap.DcrtimeBranch, ap.Merkle, ap.MerkleRoot)
+	if err == nil && ap.Transaction == "" {
+		err = fmt.Errorf("anchor %v is not in a transaction", ap.Merkle)
+	}
+	check("dcrtime", err)
+
+	return checks
+}
+
+// ExportBundle returns the vetted record identified by token, including its
+// file payloads, together with the proof that it is anchored, see
+// VerifyBundle.  The anchor proof carries the dcrtime merkle path, merkle root
+// and transaction.  Anchor is nil if the latest record commit has not been
+// anchored and confirmed yet.
+//
+// ExportBundle satisfies the backend interface.
+func (g *gitBackEnd) ExportBundle(token []byte) (*backend.RecordBundle, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	r, err := g.getRecord(token, g.vetted, true)
+	if err != nil {
+		return nil, err
+	}
+	rb := backend.RecordBundle{
+		Record: *r,
+	}
+	ap, err := g.proveAnchored(hex.EncodeToString(token))
+	switch err {
+	case nil:
+		rb.Anchor = ap
+	case backend.ErrAnchorNotFound:
+	default:
+		return nil, err
+	}
+
+	return &rb, nil
+}
+
+// VerifyBundle verifies the JSON encoded backend.RecordBundle read from r
+// without touching any repo, which makes it usable for air-gapped audits.  It
+// checks every file payload against its digest, the file digests against the
+// record merkle root, the inclusion of the record merkle root in the anchor
+// and the anchor proof against the embedded dcrtime chain information.
+// Whether the transaction was mined can't be verified offline.  An error is
+// only returned if the bundle can't be decoded, failed checks are reported
+// in the returned verification.
+func VerifyBundle(r io.Reader) (*backend.BundleVerification, error) {
+	var rb backend.RecordBundle
+	err := json.NewDecoder(r).Decode(&rb)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+
+	bv := backend.BundleVerification{
+		Token:  hex.EncodeToString(rb.Record.RecordMetadata.Token),
+		Passed: true,
+		Checks: bundleChecks(&rb),
+	}
+	for _, c := range bv.Checks {
+		if c.Error != "" {
+			bv.Passed = false
+			log.Debugf("VerifyBundle %v: %v: %v", bv.Token, c.Name,
+				c.Error)
+		}
+	}
+
+	return &bv, nil
+}
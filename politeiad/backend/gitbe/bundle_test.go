@@ -0,0 +1,140 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btclog"
+	"github.com/davecgh/go-spew/spew"
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+func TestVerifyBundle(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Vet and anchor a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	err := g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := g.ExportBundle(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, proof := &exported.Record, exported.Anchor
+	if proof == nil || len(record.Files) != 1 ||
+		record.Files[0].Payload == "" {
+		t.Fatalf("unexpected bundle %v", spew.Sdump(exported))
+	}
+
+	// The fake dcrtime carries no merkle path, put the anchor in a
+	// dcrtime merkle root with another digest
+	anchor, ok := util.ConvertDigest(proof.Merkle)
+	if !ok {
+		t.Fatalf("invalid anchor merkle %v", proof.Merkle)
+	}
+	var other [sha256.Size]byte
+	copy(other[:], util.Digest([]byte("other")))
+	leaves := []*[sha256.Size]byte{&anchor, &other}
+	root := merkle.Root(append([]*[sha256.Size]byte{}, leaves...))
+	proof.MerkleRoot = hex.EncodeToString(root[:])
+	proof.DcrtimeBranch = *merkle.AuthPath(leaves, &anchor)
+
+	verify := func(rb backend.RecordBundle) *backend.BundleVerification {
+		b, err := json.Marshal(rb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bv, err := VerifyBundle(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bv
+	}
+	failed := func(bv *backend.BundleVerification) []string {
+		var names []string
+		for _, c := range bv.Checks {
+			if c.Error != "" {
+				names = append(names, c.Name)
+			}
+		}
+		return names
+	}
+
+	// Valid bundle
+	bv := verify(backend.RecordBundle{Record: *record, Anchor: proof})
+	if !bv.Passed || len(bv.Checks) != 5 {
+		t.Fatalf("unexpected verification %v", spew.Sdump(bv))
+	}
+	if bv.Token != hex.EncodeToString(rm.Token) {
+		t.Fatalf("unexpected token %v", bv.Token)
+	}
+
+	// Tampered payload
+	tampered := *record
+	tampered.Files = append([]backend.File{}, record.Files...)
+	tampered.Files[0].Payload = base64.StdEncoding.EncodeToString(
+		[]byte("this is not the file"))
+	bv = verify(backend.RecordBundle{Record: tampered, Anchor: proof})
+	if bv.Passed || strings.Join(failed(bv), ",") != "file file,merkle" {
+		t.Fatalf("unexpected verification %v", spew.Sdump(bv))
+	}
+
+	// Missing and broken anchor proofs
+	bv = verify(backend.RecordBundle{Record: *record})
+	if bv.Passed || strings.Join(failed(bv), ",") != "anchor" {
+		t.Fatalf("unexpected verification %v", spew.Sdump(bv))
+	}
+	broken := *proof
+	broken.MerkleRoot = hex.EncodeToString(other[:])
+	bv = verify(backend.RecordBundle{Record: *record, Anchor: &broken})
+	if bv.Passed || strings.Join(failed(bv), ",") != "dcrtime" {
+		t.Fatalf("unexpected verification %v", spew.Sdump(bv))
+	}
+
+	// The anchor must carry the record merkle root
+	legacy := *proof
+	legacy.RecordMerkle = ""
+	legacy.RecordBranch = merkle.Branch{}
+	bv = verify(backend.RecordBundle{Record: *record, Anchor: &legacy})
+	if bv.Passed || strings.Join(failed(bv), ",") != "record" {
+		t.Fatalf("unexpected verification %v", spew.Sdump(bv))
+	}
+
+	// Unanchored records are exported without a proof
+	rm = newTestRecord(t, g, newTestFile("file", []byte("unanchored")))
+	vetTestRecord(t, g, rm.Token)
+	exported, err = g.ExportBundle(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Anchor != nil {
+		t.Fatalf("unexpected anchor %v", spew.Sdump(exported.Anchor))
+	}
+
+	// Garbage
+	_, err = VerifyBundle(strings.NewReader("garbage"))
+	if err == nil {
+		t.Fatalf("expected invalid bundle error")
+	}
+}
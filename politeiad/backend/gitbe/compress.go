@@ -0,0 +1,102 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/decred/politeia/util"
+)
+
+const (
+	// gzipPointerPrefix starts a payload that is committed to git gzip
+	// compressed.  It is followed by the hex encoded SHA256 digest of the
+	// uncompressed payload, a newline and the gzip stream.  The file keeps
+	// its original name so that the record layout is unchanged.
+	gzipPointerPrefix = "politeia-gzip sha256:"
+
+	// gzipMinSize is the payload size in bytes below which payloads are
+	// never compressed.
+	gzipMinSize = 512
+
+	// gzipMaxRatio is the maximum compressed to uncompressed size ratio, in
+	// percent, for which a compressed payload is stored.  Payloads that do
+	// not compress that well, e.g. images, are stored as is.
+	gzipMaxRatio = 90
+)
+
+// gzipPointerLen is the length of the gzip pointer header.
+const gzipPointerLen = len(gzipPointerPrefix) + 64 + 1
+
+// parseGzipPointer returns the hex encoded digest and the gzip stream if b is
+// a compressed payload.
+func parseGzipPointer(b []byte) (string, []byte, bool) {
+	if len(b) < gzipPointerLen ||
+		!bytes.HasPrefix(b, []byte(gzipPointerPrefix)) ||
+		b[gzipPointerLen-1] != '\n' {
+		return "", nil, false
+	}
+	digest := string(b[len(gzipPointerPrefix) : gzipPointerLen-1])
+	if !util.IsDigest(digest) {
+		return "", nil, false
+	}
+	return digest, b[gzipPointerLen:], true
+}
+
+// compressPayload returns the compressed representation of payload.  It
+// returns false if payload is too small or does not compress well enough to
+// be worth it.
+func compressPayload(payload, digest []byte) ([]byte, bool, error) {
+	if len(payload) < gzipMinSize {
+		return nil, false, nil
+	}
+
+	var b bytes.Buffer
+	b.WriteString(gzipPointerPrefix + hex.EncodeToString(digest) + "\n")
+	w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, false, err
+	}
+	_, err = w.Write(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if b.Len()*100 > len(payload)*gzipMaxRatio {
+		return nil, false, nil
+	}
+	return b.Bytes(), true, nil
+}
+
+// gunzip returns the decompressed gzip stream b.
+func gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// decompressPayload returns the uncompressed payload of a gzip pointer and
+// verifies it against the digest recorded in the pointer.
+func decompressPayload(digest string, b []byte) ([]byte, error) {
+	payload, err := gunzip(b)
+	if err != nil {
+		return nil, fmt.Errorf("gzip corrupt: %v: %v", digest, err)
+	}
+	if hex.EncodeToString(util.Digest(payload)) != digest {
+		return nil, fmt.Errorf("gzip corrupt: %v", digest)
+	}
+	return payload, nil
+}
@@ -7,9 +7,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"time"
 
@@ -30,13 +32,6 @@ const (
 	decredPluginIdentity = "fullidentity"
 )
 
-var (
-	decredPluginSettings map[string]string // [key]setting
-
-	// cached values, requires lock
-	decredPluginVoteCache = make(map[string]*decredplugin.Vote) // [token]vote
-)
-
 func getDecredPlugin(testnet bool) backend.Plugin {
 	decredPlugin := backend.Plugin{
 		ID:       decredplugin.ID,
@@ -59,22 +54,35 @@ func getDecredPlugin(testnet bool) backend.Plugin {
 			})
 	}
 
-	// Initialize settings map
-	decredPluginSettings = make(map[string]string)
-	for _, v := range decredPlugin.Settings {
-		decredPluginSettings[v.Key] = v.Value
-	}
-
 	return decredPlugin
 }
 
-//SetDecredPluginSetting removes a setting if the value is "" and adds a setting otherwise.
-func setDecredPluginSetting(key, value string) {
+// setDecredPluginSetting removes a setting if the value is "" and adds a
+// setting otherwise.
+func (g *gitBackEnd) setDecredPluginSetting(key, value string) {
+	g.decredPluginMtx.Lock()
+	defer g.decredPluginMtx.Unlock()
+
 	if value == "" {
-		delete(decredPluginSettings, key)
+		delete(g.decredPluginSettings, key)
 		return
 	}
-	decredPluginSettings[key] = value
+	g.decredPluginSettings[key] = value
+}
+
+// getDecredPluginSetting returns the value of a decred plugin setting.
+func (g *gitBackEnd) getDecredPluginSetting(key string) (string, bool) {
+	g.decredPluginMtx.RLock()
+	defer g.decredPluginMtx.RUnlock()
+
+	value, ok := g.decredPluginSettings[key]
+	return value, ok
+}
+
+// dcrdataURL returns the configured dcrdata URL.
+func (g *gitBackEnd) dcrdataURL() string {
+	url, _ := g.getDecredPluginSetting("dcrdata")
+	return url
 }
 
 // verifyMessage verifies a message is properly signed.
@@ -132,27 +140,52 @@ func (g *gitBackEnd) verifyMessage(address, message, signature string) (bool, er
 	return a.EncodeAddress() == address, nil
 }
 
-func bestBlock() (*dcrdataapi.BlockDataBasic, error) {
-	url := decredPluginSettings["dcrdata"] + "api/block/best"
+// BestBlockSource provides the height of the best block of the decred chain
+// to the decred plugin, see Options.BestBlockSource.
+type BestBlockSource interface {
+	BestBlock() (uint32, error)
+}
+
+// BestBlockFunc is an adapter that allows the use of an ordinary function as a
+// BestBlockSource.
+type BestBlockFunc func() (uint32, error)
+
+// BestBlock calls f.
+func (f BestBlockFunc) BestBlock() (uint32, error) {
+	return f()
+}
+
+// bestBlock returns the height of the best block.  It queries the configured
+// best block source and falls back to dcrdata.  In test mode without a source
+// it returns testBestBlock.
+func (g *gitBackEnd) bestBlock() (uint32, error) {
+	if g.bestBlockSource != nil {
+		return g.bestBlockSource.BestBlock()
+	}
+	if g.test {
+		return testBestBlock, nil
+	}
+
+	url := g.dcrdataURL() + "api/block/best"
 	log.Debugf("connecting to %v", url)
 	r, err := http.Get(url)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer r.Body.Close()
 
 	var bdb dcrdataapi.BlockDataBasic
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&bdb); err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	return &bdb, nil
+	return bdb.Height, nil
 }
 
-func block(block uint32) (*dcrdataapi.BlockDataBasic, error) {
+func (g *gitBackEnd) block(block uint32) (*dcrdataapi.BlockDataBasic, error) {
 	h := strconv.FormatUint(uint64(block), 10)
-	url := decredPluginSettings["dcrdata"] + "api/block/" + h
+	url := g.dcrdataURL() + "api/block/" + h
 	log.Debugf("connecting to %v", url)
 	r, err := http.Get(url)
 	if err != nil {
@@ -169,8 +202,8 @@ func block(block uint32) (*dcrdataapi.BlockDataBasic, error) {
 	return &bdb, nil
 }
 
-func snapshot(hash string) ([]string, error) {
-	url := decredPluginSettings["dcrdata"] + "api/stake/pool/b/" + hash +
+func (g *gitBackEnd) snapshot(hash string) ([]string, error) {
+	url := g.dcrdataURL() + "api/stake/pool/b/" + hash +
 		"/full?sort=true"
 	log.Debugf("connecting to %v", url)
 	r, err := http.Get(url)
@@ -188,8 +221,8 @@ func snapshot(hash string) ([]string, error) {
 	return tickets, nil
 }
 
-func largestCommitmentAddress(hash string) (string, error) {
-	url := decredPluginSettings["dcrdata"] + "api/tx/" + hash
+func (g *gitBackEnd) largestCommitmentAddress(hash string) (string, error) {
+	url := g.dcrdataURL() + "api/tx/" + hash
 	log.Debugf("connecting to %v", url)
 	r, err := http.Get(url)
 	if err != nil {
@@ -232,11 +265,11 @@ func largestCommitmentAddress(hash string) (string, error) {
 }
 
 func (g *gitBackEnd) pluginBestBlock() (string, error) {
-	bb, err := bestBlock()
+	height, err := g.bestBlock()
 	if err != nil {
 		return "", err
 	}
-	return strconv.FormatUint(uint64(bb.Height), 10), nil
+	return strconv.FormatUint(uint64(height), 10), nil
 }
 
 func (g *gitBackEnd) pluginStartVote(payload string) (string, error) {
@@ -257,22 +290,22 @@ func (g *gitBackEnd) pluginStartVote(payload string) (string, error) {
 	}
 
 	// 1. Get best block
-	bb, err := bestBlock()
+	height, err := g.bestBlock()
 	if err != nil {
 		return "", fmt.Errorf("bestBlock %v", err)
 	}
-	if bb.Height < uint32(g.activeNetParams.TicketMaturity) {
+	if height < uint32(g.activeNetParams.TicketMaturity) {
 		return "", fmt.Errorf("invalid height")
 	}
 	// 2. Subtract TicketMaturity from block height to get into
 	// unforkable teritory
-	snapshotBlock, err := block(bb.Height -
+	snapshotBlock, err := g.block(height -
 		uint32(g.activeNetParams.TicketMaturity))
 	if err != nil {
 		return "", fmt.Errorf("bestBlock %v", err)
 	}
 	// 3. Get ticket pool snapshot
-	snapshot, err := snapshot(snapshotBlock.Hash)
+	snapshot, err := g.snapshot(snapshotBlock.Hash)
 	if err != nil {
 		return "", fmt.Errorf("snapshot %v", err)
 	}
@@ -314,7 +347,7 @@ func (g *gitBackEnd) pluginStartVote(payload string) (string, error) {
 // validateVote validates that vote is signed correctly.
 func (g *gitBackEnd) validateVote(token, ticket, votebit, signature string) error {
 	// Figure out addresses
-	addr, err := largestCommitmentAddress(ticket)
+	addr, err := g.largestCommitmentAddress(ticket)
 	if err != nil {
 		return err
 	}
@@ -394,7 +427,7 @@ func (g *gitBackEnd) validateVoteBit(token, bit string) error {
 		return backend.ErrShutdown
 	}
 
-	vote, ok := decredPluginVoteCache[token]
+	vote, ok := g.decredPluginVoteCache[token]
 	if ok {
 		return _validateVoteBit(*vote, b)
 	}
@@ -425,7 +458,7 @@ func (g *gitBackEnd) validateVoteBit(token, bit string) error {
 		return err
 	}
 
-	decredPluginVoteCache[token] = vote
+	g.decredPluginVoteCache[token] = vote
 
 	return _validateVoteBit(*vote, b)
 }
@@ -438,7 +471,7 @@ func (g *gitBackEnd) pluginCastVotes(payload string) (string, error) {
 	}
 
 	// XXX this should become part of some sort of context
-	fiJSON, ok := decredPluginSettings[decredPluginIdentity]
+	fiJSON, ok := g.getDecredPluginSetting(decredPluginIdentity)
 	if !ok {
 		return "", fmt.Errorf("full identity not set")
 	}
@@ -454,6 +487,7 @@ func (g *gitBackEnd) pluginCastVotes(payload string) (string, error) {
 	}
 	cbr := make([]decredplugin.CastVoteReply, len(votes))
 	dedupVotes := make(map[string]dedupVote)
+	cancelled := make(map[string]bool) // [token]cancelled
 	for k, v := range votes {
 		// Check if this is a duplicate vote
 		key := v.Token + v.Ticket
@@ -463,6 +497,24 @@ func (g *gitBackEnd) pluginCastVotes(payload string) (string, error) {
 			continue
 		}
 
+		// Reject votes on a cancelled vote
+		c, ok := cancelled[v.Token]
+		if !ok {
+			c, err = g.voteCancelled(v.Token)
+			if err != nil {
+				t := time.Now().Unix()
+				log.Errorf("pluginCastVotes: voteCancelled %v %v %v",
+					v.Token, t, err)
+				cbr[k].Error = fmt.Sprintf("internal error %v", t)
+				continue
+			}
+			cancelled[v.Token] = c
+		}
+		if c {
+			cbr[k].Error = "vote cancelled"
+			continue
+		}
+
 		// Ensure that the votebits are correct
 		err = g.validateVoteBit(v.Token, v.VoteBit)
 		if err != nil {
@@ -543,7 +595,7 @@ func (g *gitBackEnd) pluginCastVotes(payload string) (string, error) {
 		return "", err
 	}
 	id := hex.EncodeToString(random)
-	idTmp := id + "_tmp"
+	idTmp := tmpBranch(id)
 	err = g.gitNewBranch(g.unvetted, idTmp)
 	if err != nil {
 		return "", err
@@ -561,13 +613,30 @@ func (g *gitBackEnd) pluginCastVotes(payload string) (string, error) {
 	for _, v := range dedupVotes {
 		// This loop must be exited in order to close all open file
 		// handles.
+
+		// The vote may have been cancelled since it was validated
+		cvr, err := g.decredPluginVoteCancel(v.vote.Token)
+		if err != nil {
+			t := time.Now().Unix()
+			log.Errorf("pluginCastVotes: decredPluginVoteCancel "+
+				"%v %v %v", v.vote.Token, t, err)
+			cbr[v.index].Error = fmt.Sprintf("internal error %v", t)
+			cbr[v.index].Signature = ""
+			continue
+		}
+		if cvr != nil {
+			cbr[v.index].Error = "vote cancelled"
+			cbr[v.index].Signature = ""
+			continue
+		}
+
 		var f *file
 		if f, ok = files[v.vote.Token]; !ok {
 			// Lazily open files and recreate content
 			filename := mdFilename(g.unvetted, v.vote.Token,
 				decredplugin.MDStreamVotes)
 			fh, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE,
-				0666)
+				g.fileModeOr(0666))
 			if err != nil {
 				t := time.Now().Unix()
 				log.Errorf("pluginCastVotes: OpenFile %v %v %v",
@@ -692,3 +761,380 @@ func (g *gitBackEnd) pluginCastVotes(payload string) (string, error) {
 
 	return string(reply), nil
 }
+
+// decredPluginVoteCancel returns the cancellation of the vote of the vetted
+// record id.  It returns nil if the vote was not cancelled.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) decredPluginVoteCancel(id string) (*decredplugin.CancelVoteReply, error) {
+	if _, err := util.ConvertStringToken(id); err != nil {
+		return nil, fmt.Errorf("ConvertStringToken %v", err)
+	}
+	b, err := ioutil.ReadFile(mdFilename(g.vetted, id,
+		decredplugin.MDStreamVoteCancel))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cvr, err := decredplugin.DecodeCancelVoteReply(b)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeCancelVoteReply %v", err)
+	}
+	return cvr, nil
+}
+
+// voteCancelled returns true if the vote of the vetted record identified by
+// token was cancelled.
+func (g *gitBackEnd) voteCancelled(token string) (bool, error) {
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("voteCancelled unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return false, backend.ErrShutdown
+	}
+
+	cvr, err := g.decredPluginVoteCancel(token)
+	if err != nil {
+		return false, err
+	}
+	return cvr != nil, nil
+}
+
+// pluginCancelVote cancels the started vote of a vetted record before it
+// ends.  The cancellation is stored in the MDStreamVoteCancel metadata stream
+// and is therefore anchored along with the record.  Votes that were cast
+// before the cancellation are kept, later votes are rejected by
+// pluginCastVotes.
+func (g *gitBackEnd) pluginCancelVote(payload string) (string, error) {
+	log.Tracef("pluginCancelVote: %v", payload)
+	cv, err := decredplugin.DecodeCancelVote([]byte(payload))
+	if err != nil {
+		return "", fmt.Errorf("DecodeCancelVote %v", err)
+	}
+	token, err := util.ConvertStringToken(cv.Token)
+	if err != nil {
+		return "", fmt.Errorf("ConvertStringToken %v", err)
+	}
+
+	// Get best block outside of the lock
+	height, err := g.bestBlock()
+	if err != nil {
+		return "", fmt.Errorf("bestBlock %v", err)
+	}
+
+	// Lock record and filesystem across the check and the update so that
+	// the vote can't end or be cancelled in between
+	defer g.lockRecord(token)()
+	err = g.lock.Lock(LockDuration)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("pluginCancelVote unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return "", backend.ErrShutdown
+	}
+
+	// Ensure the vote is in progress
+	vdr, err := g.decredPluginVoteDetails(hex.EncodeToString(token))
+	if err != nil {
+		return "", err
+	}
+	if vdr.Cancel != nil {
+		return "", fmt.Errorf("vote already cancelled: %v", cv.Token)
+	}
+	endHeight, err := strconv.ParseUint(vdr.StartVoteReply.EndHeight,
+		10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid end height %v: %v",
+			vdr.StartVoteReply.EndHeight, err)
+	}
+	if uint64(height) >= endHeight {
+		return "", fmt.Errorf("vote has ended: %v", cv.Token)
+	}
+
+	cvr := decredplugin.CancelVoteReply{
+		Token:       cv.Token,
+		Reason:      cv.Reason,
+		BlockHeight: strconv.FormatUint(uint64(height), 10),
+		Timestamp:   time.Now().Unix(),
+	}
+	cvrb, err := decredplugin.EncodeCancelVoteReply(cvr)
+	if err != nil {
+		return "", fmt.Errorf("EncodeCancelVoteReply: %v", err)
+	}
+	err = g.updateVettedMetadataLocked(token, nil,
+		[]backend.MetadataStream{{
+			ID:      decredplugin.MDStreamVoteCancel,
+			Payload: string(cvrb),
+		}})
+	if err != nil {
+		return "", fmt.Errorf("UpdateVettedMetadata: %v", err)
+	}
+
+	log.Infof("Vote cancelled for: %v height %v reason %q", cv.Token,
+		cvr.BlockHeight, cv.Reason)
+
+	return string(cvrb), nil
+}
+
+// decredPluginVoteDetails returns the vote, start vote reply, tally and cast
+// votes of the vetted record identified by id.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) decredPluginVoteDetails(id string) (*decredplugin.VoteDetailsReply, error) {
+	_, err := os.Stat(filepath.Join(g.vetted, id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, backend.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	// Vote bits and start vote reply are written when the vote starts
+	b, err := ioutil.ReadFile(mdFilename(g.vetted, id,
+		decredplugin.MDStreamVoteBits))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("vote not started: %v", id)
+		}
+		return nil, err
+	}
+	vote, err := decredplugin.DecodeVote(b)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeVote %v", err)
+	}
+	b, err = ioutil.ReadFile(mdFilename(g.vetted, id,
+		decredplugin.MDStreamVoteSnapshot))
+	if err != nil {
+		return nil, err
+	}
+	svr, err := decredplugin.DecodeStartVoteReply(b)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeStartVoteReply %v", err)
+	}
+
+	// Cast votes are stored as a stream of JSON objects and do not exist
+	// until the first vote was cast.
+	cvs := make([]decredplugin.CastVote, 0)
+	f, err := os.Open(mdFilename(g.vetted, id, decredplugin.MDStreamVotes))
+	switch {
+	case err == nil:
+		defer f.Close()
+		d := json.NewDecoder(f)
+		for {
+			var cv decredplugin.CastVote
+			err = d.Decode(&cv)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("Decode CastVote %v", err)
+			}
+			cvs = append(cvs, cv)
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	// Tally votes
+	tally := make([]decredplugin.VoteOptionResult, len(vote.Options))
+	index := make(map[uint64]int, len(vote.Options)) // [bits]tally index
+	for k, v := range vote.Options {
+		tally[k].Option = v
+		index[v.Bits] = k
+	}
+	for _, v := range cvs {
+		bits, err := strconv.ParseUint(v.VoteBit, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vote bit %v ticket %v: %v",
+				v.VoteBit, v.Ticket, err)
+		}
+		k, ok := index[bits]
+		if !ok {
+			return nil, fmt.Errorf("unknown vote bit %v ticket %v",
+				v.VoteBit, v.Ticket)
+		}
+		tally[k].Votes++
+	}
+
+	cvr, err := g.decredPluginVoteCancel(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decredplugin.VoteDetailsReply{
+		Vote:           *vote,
+		StartVoteReply: *svr,
+		Tally:          tally,
+		CastVotes:      cvs,
+		Cancel:         cvr,
+	}, nil
+}
+
+// pluginVoteDetails returns the complete vote state of a vetted record for
+// auditing purposes.
+func (g *gitBackEnd) pluginVoteDetails(payload string) (string, error) {
+	log.Tracef("pluginVoteDetails: %v", payload)
+	vd, err := decredplugin.DecodeVoteDetails([]byte(payload))
+	if err != nil {
+		return "", fmt.Errorf("DecodeVoteDetails %v", err)
+	}
+	token, err := util.ConvertStringToken(vd.Token)
+	if err != nil {
+		return "", fmt.Errorf("ConvertStringToken %v", err)
+	}
+
+	// Lock record and filesystem
+	defer g.lockRecord(token)()
+	err = g.lock.Lock(LockDuration)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("pluginVoteDetails unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return "", backend.ErrShutdown
+	}
+
+	vdr, err := g.decredPluginVoteDetails(hex.EncodeToString(token))
+	if err != nil {
+		return "", err
+	}
+	reply, err := decredplugin.EncodeVoteDetailsReply(*vdr)
+	if err != nil {
+		return "", fmt.Errorf("EncodeVoteDetailsReply %v", err)
+	}
+
+	return string(reply), nil
+}
+
+// pluginVoteResults returns the signed final results of the ended vote of a
+// vetted record.  The results are computed and stored in the
+// MDStreamVoteResults metadata stream the first time they are requested, so
+// that they are anchored along with the record.  Later requests return the
+// stored results unchanged.  Cancelled votes have no results.
+func (g *gitBackEnd) pluginVoteResults(payload string) (string, error) {
+	log.Tracef("pluginVoteResults: %v", payload)
+	vr, err := decredplugin.DecodeVoteResults([]byte(payload))
+	if err != nil {
+		return "", fmt.Errorf("DecodeVoteResults %v", err)
+	}
+	token, err := util.ConvertStringToken(vr.Token)
+	if err != nil {
+		return "", fmt.Errorf("ConvertStringToken %v", err)
+	}
+
+	// Get best block outside of the lock
+	height, err := g.bestBlock()
+	if err != nil {
+		return "", fmt.Errorf("bestBlock %v", err)
+	}
+
+	// Lock record and filesystem across the check and the update so that
+	// concurrent requests store the results once
+	defer g.lockRecord(token)()
+	err = g.lock.Lock(LockDuration)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("pluginVoteResults unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return "", backend.ErrShutdown
+	}
+
+	// Return stored results
+	id := hex.EncodeToString(token)
+	stored, err := ioutil.ReadFile(mdFilename(g.vetted, id,
+		decredplugin.MDStreamVoteResults))
+	if err == nil {
+		return string(stored), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// Tally the ended vote
+	vdr, err := g.decredPluginVoteDetails(id)
+	if err != nil {
+		return "", err
+	}
+	if vdr.Cancel != nil {
+		return "", fmt.Errorf("vote cancelled: %v", vr.Token)
+	}
+	endHeight, err := strconv.ParseUint(vdr.StartVoteReply.EndHeight,
+		10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid end height %v: %v",
+			vdr.StartVoteReply.EndHeight, err)
+	}
+	if uint64(height) < endHeight {
+		return "", fmt.Errorf("vote has not ended: %v", vr.Token)
+	}
+
+	tickets := make([]string, 0, len(vdr.CastVotes))
+	for _, v := range vdr.CastVotes {
+		tickets = append(tickets, v.Ticket)
+	}
+	sort.Strings(tickets)
+	vrr := decredplugin.VoteResultsReply{
+		Token:           vr.Token,
+		EndHeight:       vdr.StartVoteReply.EndHeight,
+		EligibleTickets: len(vdr.StartVoteReply.EligibleTickets),
+		Tally:           vdr.Tally,
+		CastTickets:     tickets,
+		Timestamp:       time.Now().Unix(),
+	}
+
+	// Sign and store the results
+	fiJSON, ok := g.getDecredPluginSetting(decredPluginIdentity)
+	if !ok {
+		return "", fmt.Errorf("full identity not set")
+	}
+	fi, err := identity.UnmarshalFullIdentity([]byte(fiJSON))
+	if err != nil {
+		return "", err
+	}
+	msg, err := vrr.SignatureMessage()
+	if err != nil {
+		return "", err
+	}
+	signature := fi.SignMessage(msg)
+	vrr.Signature = hex.EncodeToString(signature[:])
+	vrrb, err := decredplugin.EncodeVoteResultsReply(vrr)
+	if err != nil {
+		return "", fmt.Errorf("EncodeVoteResultsReply: %v", err)
+	}
+	err = g.updateVettedMetadataLocked(token, nil,
+		[]backend.MetadataStream{{
+			ID:      decredplugin.MDStreamVoteResults,
+			Payload: string(vrrb),
+		}})
+	if err != nil {
+		return "", fmt.Errorf("UpdateVettedMetadata: %v", err)
+	}
+
+	log.Infof("Vote results stored for: %v", vr.Token)
+
+	return string(vrrb), nil
+}
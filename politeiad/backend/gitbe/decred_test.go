@@ -0,0 +1,438 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/btcsuite/btclog"
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/politeia/decredplugin"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+func TestVoteDetails(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	token := hex.EncodeToString(rm.Token)
+	vd, err := decredplugin.EncodeVoteDetails(decredplugin.VoteDetails{
+		Token: token,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unvetted records have no vote
+	_, _, err = g.Plugin(decredplugin.CmdVoteDetails, string(vd))
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	vetTestRecord(t, g, rm.Token)
+
+	// Vetted record without a vote
+	_, _, err = g.Plugin(decredplugin.CmdVoteDetails, string(vd))
+	if err == nil {
+		t.Fatalf("expected vote not started error")
+	}
+
+	// Store vote state the way the plugin does
+	vote := decredplugin.Vote{
+		Token: token,
+		Mask:  0x3,
+		Options: []decredplugin.VoteOption{
+			{Id: "no", Description: "no", Bits: 0x1},
+			{Id: "yes", Description: "yes", Bits: 0x2},
+		},
+	}
+	vb, err := decredplugin.EncodeVote(vote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := decredplugin.StartVoteReply{
+		StartBlockHeight: "100",
+		StartBlockHash:   "hash",
+		EndHeight:        "2116",
+		EligibleTickets:  []string{"t1", "t2", "t3"},
+	}
+	svrb, err := decredplugin.EncodeStartVoteReply(svr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cvs := []decredplugin.CastVote{
+		{Token: token, Ticket: "t1", VoteBit: "2", Signature: "s1"},
+		{Token: token, Ticket: "t2", VoteBit: "1", Signature: "s2"},
+		{Token: token, Ticket: "t3", VoteBit: "2", Signature: "s3"},
+	}
+	var votes []byte
+	for _, v := range cvs {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		votes = append(votes, append(b, '\n')...)
+	}
+	err = g.UpdateVettedMetadata(rm.Token, nil, []backend.MetadataStream{
+		{ID: decredplugin.MDStreamVoteBits, Payload: string(vb)},
+		{ID: decredplugin.MDStreamVoteSnapshot, Payload: string(svrb)},
+		{ID: decredplugin.MDStreamVotes, Payload: string(votes)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, reply, err := g.Plugin(decredplugin.CmdVoteDetails, string(vd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != decredplugin.CmdVoteDetails {
+		t.Fatalf("unexpected command %v", cmd)
+	}
+	vdr, err := decredplugin.DecodeVoteDetailsReply([]byte(reply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vdr.Vote.Token != token || len(vdr.Vote.Options) != 2 {
+		t.Fatalf("unexpected vote %v", vdr.Vote)
+	}
+	if vdr.StartVoteReply.EndHeight != svr.EndHeight ||
+		len(vdr.StartVoteReply.EligibleTickets) != 3 {
+		t.Fatalf("unexpected start vote reply %v", vdr.StartVoteReply)
+	}
+	if len(vdr.CastVotes) != len(cvs) {
+		t.Fatalf("unexpected cast votes %v", vdr.CastVotes)
+	}
+	for k, v := range cvs {
+		if vdr.CastVotes[k] != v {
+			t.Fatalf("cast vote %v got %v, want %v", k,
+				vdr.CastVotes[k], v)
+		}
+	}
+	if vdr.Tally[0].Option.Id != "no" || vdr.Tally[0].Votes != 1 ||
+		vdr.Tally[1].Option.Id != "yes" || vdr.Tally[1].Votes != 2 {
+		t.Fatalf("unexpected tally %v", vdr.Tally)
+	}
+}
+
+func TestBestBlockSource(t *testing.T) {
+	var height uint32 = 1234
+	g, cleanup := newTestBackEnd(t, &Options{
+		BestBlockSource: BestBlockFunc(func() (uint32, error) {
+			return height, nil
+		}),
+	})
+	defer cleanup()
+
+	// The configured source is queried
+	_, payload, err := g.Plugin(decredplugin.CmdBestBlock, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload != "1234" {
+		t.Fatalf("unexpected best block %v", payload)
+	}
+	height++
+	_, payload, err = g.Plugin(decredplugin.CmdBestBlock, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload != "1235" {
+		t.Fatalf("unexpected best block %v", payload)
+	}
+
+	// Test mode without a source is deterministic
+	g.bestBlockSource = nil
+	_, payload, err = g.Plugin(decredplugin.CmdBestBlock, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload != strconv.Itoa(testBestBlock) {
+		t.Fatalf("unexpected test best block %v", payload)
+	}
+}
+
+func TestCancelVote(t *testing.T) {
+	var height uint32 = 200
+	g, cleanup := newTestBackEnd(t, &Options{
+		BestBlockSource: BestBlockFunc(func() (uint32, error) {
+			return height, nil
+		}),
+	})
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	token := hex.EncodeToString(rm.Token)
+	cv, err := decredplugin.EncodeCancelVote(decredplugin.CancelVote{
+		Token:  token,
+		Reason: "wrong options",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No vote was started
+	_, _, err = g.Plugin(decredplugin.CmdCancelVote, string(cv))
+	if err == nil {
+		t.Fatalf("expected vote not started error")
+	}
+
+	// Store vote state the way the plugin does
+	vb, err := decredplugin.EncodeVote(decredplugin.Vote{
+		Token: token,
+		Mask:  0x3,
+		Options: []decredplugin.VoteOption{
+			{Id: "no", Description: "no", Bits: 0x1},
+			{Id: "yes", Description: "yes", Bits: 0x2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrb, err := decredplugin.EncodeStartVoteReply(
+		decredplugin.StartVoteReply{
+			StartBlockHeight: "100",
+			StartBlockHash:   "hash",
+			EndHeight:        "2116",
+			EligibleTickets:  []string{"t1", "t2"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vote, err := json.Marshal(decredplugin.CastVote{
+		Token:     token,
+		Ticket:    "t1",
+		VoteBit:   "2",
+		Signature: "s1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.UpdateVettedMetadata(rm.Token, nil, []backend.MetadataStream{
+		{ID: decredplugin.MDStreamVoteBits, Payload: string(vb)},
+		{ID: decredplugin.MDStreamVoteSnapshot, Payload: string(svrb)},
+		{ID: decredplugin.MDStreamVotes, Payload: string(vote) + "\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The vote has ended
+	height = 2116
+	_, _, err = g.Plugin(decredplugin.CmdCancelVote, string(cv))
+	if err == nil {
+		t.Fatalf("expected vote has ended error")
+	}
+
+	// Cancel the vote in progress
+	height = 200
+	cmd, reply, err := g.Plugin(decredplugin.CmdCancelVote, string(cv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != decredplugin.CmdCancelVote {
+		t.Fatalf("unexpected command %v", cmd)
+	}
+	cvr, err := decredplugin.DecodeCancelVoteReply([]byte(reply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cvr.Token != token || cvr.Reason != "wrong options" ||
+		cvr.BlockHeight != "200" {
+		t.Fatalf("unexpected cancel vote reply %v", cvr)
+	}
+	_, _, err = g.Plugin(decredplugin.CmdCancelVote, string(cv))
+	if err == nil {
+		t.Fatalf("expected vote already cancelled error")
+	}
+
+	// The cancellation is part of the vote details and the votes that
+	// were already cast are kept
+	vd, err := decredplugin.EncodeVoteDetails(decredplugin.VoteDetails{
+		Token: token,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reply, err = g.Plugin(decredplugin.CmdVoteDetails, string(vd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vdr, err := decredplugin.DecodeVoteDetailsReply([]byte(reply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vdr.Cancel == nil || *vdr.Cancel != *cvr {
+		t.Fatalf("unexpected cancellation %v", vdr.Cancel)
+	}
+	if len(vdr.CastVotes) != 1 {
+		t.Fatalf("unexpected cast votes %v", vdr.CastVotes)
+	}
+
+	// Further votes are rejected
+	cvs, err := decredplugin.EncodeCastVotes([]decredplugin.CastVote{{
+		Token:     token,
+		Ticket:    "t2",
+		VoteBit:   "1",
+		Signature: "s2",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reply, err = g.Plugin(decredplugin.CmdCastVotes, string(cvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cvrs, err := decredplugin.DecodeCastVoteReplies([]byte(reply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cvrs) != 1 || cvrs[0].Error != "vote cancelled" ||
+		cvrs[0].Signature != "" {
+		t.Fatalf("unexpected cast vote replies %v", cvrs)
+	}
+}
+
+func TestVoteResults(t *testing.T) {
+	var height uint32 = 200
+	g, cleanup := newTestBackEnd(t, &Options{
+		BestBlockSource: BestBlockFunc(func() (uint32, error) {
+			return height, nil
+		}),
+	})
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	token := hex.EncodeToString(rm.Token)
+
+	// Store vote state the way the plugin does
+	vb, err := decredplugin.EncodeVote(decredplugin.Vote{
+		Token: token,
+		Mask:  0x3,
+		Options: []decredplugin.VoteOption{
+			{Id: "no", Description: "no", Bits: 0x1},
+			{Id: "yes", Description: "yes", Bits: 0x2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrb, err := decredplugin.EncodeStartVoteReply(
+		decredplugin.StartVoteReply{
+			StartBlockHeight: "100",
+			StartBlockHash:   "hash",
+			EndHeight:        "2116",
+			EligibleTickets:  []string{"t1", "t2", "t3"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var votes []byte
+	for _, v := range []decredplugin.CastVote{
+		{Token: token, Ticket: "t3", VoteBit: "2", Signature: "s3"},
+		{Token: token, Ticket: "t1", VoteBit: "1", Signature: "s1"},
+	} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		votes = append(votes, append(b, '\n')...)
+	}
+	err = g.UpdateVettedMetadata(rm.Token, nil, []backend.MetadataStream{
+		{ID: decredplugin.MDStreamVoteBits, Payload: string(vb)},
+		{ID: decredplugin.MDStreamVoteSnapshot, Payload: string(svrb)},
+		{ID: decredplugin.MDStreamVotes, Payload: string(votes)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vr, err := decredplugin.EncodeVoteResults(decredplugin.VoteResults{
+		Token: token,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The vote has not ended
+	_, _, err = g.Plugin(decredplugin.CmdVoteResults, string(vr))
+	if err == nil {
+		t.Fatalf("expected vote has not ended error")
+	}
+
+	height = 2116
+	cmd, reply, err := g.Plugin(decredplugin.CmdVoteResults, string(vr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != decredplugin.CmdVoteResults {
+		t.Fatalf("unexpected command %v", cmd)
+	}
+	vrr, err := decredplugin.DecodeVoteResultsReply([]byte(reply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vrr.Token != token || vrr.EndHeight != "2116" ||
+		vrr.EligibleTickets != 3 {
+		t.Fatalf("unexpected vote results %v", vrr)
+	}
+	if len(vrr.CastTickets) != 2 || vrr.CastTickets[0] != "t1" ||
+		vrr.CastTickets[1] != "t3" {
+		t.Fatalf("unexpected cast tickets %v", vrr.CastTickets)
+	}
+	if vrr.Tally[0].Votes != 1 || vrr.Tally[1].Votes != 1 {
+		t.Fatalf("unexpected tally %v", vrr.Tally)
+	}
+
+	// The results are signed by the politeiad identity
+	fiJSON, _ := g.getDecredPluginSetting(decredPluginIdentity)
+	fi, err := identity.UnmarshalFullIdentity([]byte(fiJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := vrr.SignatureMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := identity.SignatureFromString(vrr.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Public.VerifyMessage(msg, *signature) {
+		t.Fatalf("invalid vote results signature")
+	}
+
+	// Later requests return the stored results
+	height = 3000
+	_, reply2, err := g.Plugin(decredplugin.CmdVoteResults, string(vr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply2 != reply {
+		t.Fatalf("results changed %v, want %v", reply2, reply)
+	}
+	md, err := g.GetRecordMetadataStreams(rm.Token, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, v := range md {
+		if v.ID == decredplugin.MDStreamVoteResults {
+			found = v.Payload == reply
+		}
+	}
+	if !found {
+		t.Fatalf("vote results not stored")
+	}
+}
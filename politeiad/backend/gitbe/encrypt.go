@@ -0,0 +1,143 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/decred/politeia/util"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// encPointerPrefix starts a payload of an unvetted record that is
+	// committed to git encrypted.  It is followed by the hex encoded
+	// SHA256 digest of the plaintext payload, a newline, the nonce and the
+	// secretbox sealed payload.  The file keeps its original name so that
+	// the record layout is unchanged.
+	encPointerPrefix = "politeia-enc sha256:"
+
+	// encNonceSize is the size of the secretbox nonce.
+	encNonceSize = 24
+
+	// EncryptionKeySize is the size of the key that encrypts unvetted
+	// payloads.
+	EncryptionKeySize = 32
+)
+
+// encPointerLen is the length of the encrypted pointer header.
+const encPointerLen = len(encPointerPrefix) + 64 + 1
+
+// parseEncPointer returns the hex encoded digest and the sealed payload,
+// including the nonce, if b is an encrypted payload.
+func parseEncPointer(b []byte) (string, []byte, bool) {
+	if len(b) < encPointerLen+encNonceSize+secretbox.Overhead ||
+		!bytes.HasPrefix(b, []byte(encPointerPrefix)) ||
+		b[encPointerLen-1] != '\n' {
+		return "", nil, false
+	}
+	digest := string(b[len(encPointerPrefix) : encPointerLen-1])
+	if !util.IsDigest(digest) {
+		return "", nil, false
+	}
+	return digest, b[encPointerLen:], true
+}
+
+// encryptPayload returns the encrypted representation of payload.
+func encryptPayload(key *[EncryptionKeySize]byte, payload, digest []byte) ([]byte, error) {
+	var nonce [encNonceSize]byte
+	n, err := util.Random(encNonceSize)
+	if err != nil {
+		return nil, err
+	}
+	copy(nonce[:], n)
+
+	b := make([]byte, 0, encPointerLen+encNonceSize+len(payload)+
+		secretbox.Overhead)
+	b = append(b, encPointerPrefix+hex.EncodeToString(digest)+"\n"...)
+	b = append(b, nonce[:]...)
+	return secretbox.Seal(b, payload, &nonce, key), nil
+}
+
+// decryptPayload returns the plaintext payload of an encrypted pointer and
+// verifies it against the digest recorded in the pointer.
+func decryptPayload(key *[EncryptionKeySize]byte, digest string, sealed []byte) ([]byte, error) {
+	if key == nil {
+		return nil, fmt.Errorf("payload encrypted but no key: %v", digest)
+	}
+	var nonce [encNonceSize]byte
+	copy(nonce[:], sealed)
+	payload, ok := secretbox.Open(nil, sealed[encNonceSize:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("decrypt payload: %v", digest)
+	}
+	if hex.EncodeToString(util.Digest(payload)) != digest {
+		return nil, fmt.Errorf("encrypted payload corrupt: %v", digest)
+	}
+	return payload, nil
+}
+
+// writeUnvettedPayload writes a payload of an unvetted record to filename.
+// When an encryption key is configured the payload is encrypted and always
+// committed to git, the blob store and compression only apply once the record
+// is vetted.  Otherwise it is identical to writePayload.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) writeUnvettedPayload(filename string, payload, digest []byte) error {
+	if g.encryptionKey == nil {
+		return g.writePayload(filename, payload, digest)
+	}
+	b, err := encryptPayload(g.encryptionKey, payload, digest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, g.fileModeOr(0664))
+}
+
+// decryptRecord replaces the encrypted payloads of path/id with their
+// plaintext and stages them.  Vetted content is public so it must never be
+// published encrypted.  The caller commits.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) decryptRecord(path, id string) error {
+	ppath := filepath.Join(path, id, defaultPayloadDir)
+	files, err := payloadFiles(ppath)
+	if err != nil {
+		return err
+	}
+	for _, v := range files {
+		filename := payloadFilename(ppath, v)
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		digest, sealed, ok := parseEncPointer(b)
+		if !ok {
+			continue
+		}
+		payload, err := decryptPayload(g.encryptionKey, digest, sealed)
+		if err != nil {
+			return err
+		}
+		d, err := hex.DecodeString(digest)
+		if err != nil {
+			return err
+		}
+		err = g.writePayload(filename, payload, d)
+		if err != nil {
+			return err
+		}
+		err = g.gitAdd(path, filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/decred/dcrtime/api/v1"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+const (
+	// defaultExternalAnchorDir is the directory, relative to the root,
+	// where anchors of external digests are recorded.  They are kept out
+	// of the repositories since they are not part of any record.
+	defaultExternalAnchorDir = "external-anchors"
+)
+
+// ExternalAnchor records a set of external digests that were anchored through
+// AnchorExternal.  It is stored as JSON and indexed by Merkle.
+type ExternalAnchor struct {
+	Merkle  string   // Merkle root of Digests, lookup key
+	Digests []string // External digests
+	Time    int64    // Time digests were submitted to dcrtime
+
+	// ChainInformation is the dcrtime confirmation of Merkle.  It is nil
+	// until the anchor has been confirmed.
+	ChainInformation *v1.ChainInformation
+}
+
+// externalAnchorFilename returns the filename of the external anchor
+// identified by merkle.
+func (g *gitBackEnd) externalAnchorFilename(merkle string) string {
+	return filepath.Join(g.root, defaultExternalAnchorDir, merkle)
+}
+
+// writeExternalAnchor stores an external anchor record.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) writeExternalAnchor(ea *ExternalAnchor) error {
+	b, err := json.Marshal(ea)
+	if err != nil {
+		return err
+	}
+	filename := g.externalAnchorFilename(ea.Merkle)
+	err = os.MkdirAll(filepath.Dir(filename), g.dirModeOr(0774))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, g.fileModeOr(0664))
+}
+
+// readExternalAnchor returns the external anchor identified by merkle.  It
+// returns backend.ErrAnchorNotFound if there is no such anchor.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) readExternalAnchor(merkle string) (*ExternalAnchor, error) {
+	b, err := ioutil.ReadFile(g.externalAnchorFilename(merkle))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, backend.ErrAnchorNotFound
+		}
+		return nil, err
+	}
+	var ea ExternalAnchor
+	err = json.Unmarshal(b, &ea)
+	if err != nil {
+		return nil, err
+	}
+	return &ea, nil
+}
+
+// unconfirmedExternalAnchors returns the merkle roots of all external anchors
+// that have not been confirmed yet.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) unconfirmedExternalAnchors() ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(g.root,
+		defaultExternalAnchorDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var merkles []string
+	for _, v := range files {
+		if !util.IsDigest(v.Name()) {
+			continue
+		}
+		ea, err := g.readExternalAnchor(v.Name())
+		if err != nil {
+			return nil, err
+		}
+		if ea.ChainInformation == nil {
+			merkles = append(merkles, ea.Merkle)
+		}
+	}
+	return merkles, nil
+}
+
+// AnchorExternal submits externally supplied SHA256 digests to dcrtime right
+// away and returns the merkle root that identifies them.  The digests are not
+// committed to the repositories; they are recorded in the external anchor
+// directory and their dcrtime confirmation is picked up by the periodic
+// anchor checker.  dcrtime batches all digests it receives so they end up in
+// the same transaction as the record anchors of the same period.
+//
+// AnchorExternal satisfies the backend interface.
+func (g *gitBackEnd) AnchorExternal(digests []string) (string, error) {
+	if len(digests) == 0 {
+		return "", fmt.Errorf("no digests")
+	}
+	hashes := make([]*[sha256.Size]byte, 0, len(digests)+1)
+	for _, v := range digests {
+		d, ok := util.ConvertDigest(v)
+		if !ok {
+			return "", fmt.Errorf("invalid digest: %v", v)
+		}
+		hashes = append(hashes, &d)
+	}
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return "", backend.ErrShutdown
+	}
+
+	// merkle.Root sorts its input so use a copy.  The root is anchored as
+	// well since it is the lookup key, see anchorRepo.
+	key := merkle.Root(append([]*[sha256.Size]byte{}, hashes...))
+	ea := ExternalAnchor{
+		Merkle:  hex.EncodeToString(key[:]),
+		Digests: digests,
+		Time:    time.Now().Unix(),
+	}
+	_, err = g.readExternalAnchor(ea.Merkle)
+	switch err {
+	case nil:
+		return "", fmt.Errorf("digests already anchored: %v", ea.Merkle)
+	case backend.ErrAnchorNotFound:
+	default:
+		return "", err
+	}
+
+	log.Infof("Anchoring %v external digests: %v", len(digests),
+		ea.Merkle)
+	err = g.anchor(append(hashes, key))
+	if err != nil {
+		return "", fmt.Errorf("anchor: %v", err)
+	}
+	err = g.writeExternalAnchor(&ea)
+	if err != nil {
+		return "", err
+	}
+
+	return ea.Merkle, nil
+}
+
+// externalAnchorChecker asks dcrtime about all unconfirmed external anchors
+// and records the confirmations.  dcrtime is not called with the lock held.
+func (g *gitBackEnd) externalAnchorChecker() error {
+	merkles, err := g.unconfirmedExternalAnchorsLock()
+	if err != nil {
+		return fmt.Errorf("externalAnchorChecker read: %v", err)
+	}
+	if len(merkles) == 0 {
+		return nil
+	}
+
+	vrs := make([]v1.VerifyDigest, 0, len(merkles))
+	for _, merkle := range merkles {
+		vr, err := g.verifyAnchor(merkle)
+		if err != nil {
+			log.Errorf("externalAnchorChecker verify: %v", err)
+			continue
+		}
+		vrs = append(vrs, *vr)
+	}
+
+	return g.afterExternalAnchorVerify(vrs)
+}
+
+// unconfirmedExternalAnchorsLock is the locked version of
+// unconfirmedExternalAnchors.
+//
+// This function must be called WITHOUT the lock held.
+func (g *gitBackEnd) unconfirmedExternalAnchorsLock() ([]string, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	return g.unconfirmedExternalAnchors()
+}
+
+// afterExternalAnchorVerify records the dcrtime confirmations of external
+// anchors.
+//
+// This function must be called WITHOUT the lock held.
+func (g *gitBackEnd) afterExternalAnchorVerify(vrs []v1.VerifyDigest) error {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("afterExternalAnchorVerify unlock error: %v",
+				err)
+		}
+	}()
+
+	for _, vr := range vrs {
+		if vr.ChainInformation.ChainTimestamp == 0 {
+			// Not enough confirmations yet
+			continue
+		}
+
+		ea, err := g.readExternalAnchor(vr.Digest)
+		if err != nil {
+			return err
+		}
+		if ea.ChainInformation != nil {
+			continue
+		}
+		ci := vr.ChainInformation
+		ea.ChainInformation = &ci
+		err = g.writeExternalAnchor(ea)
+		if err != nil {
+			return err
+		}
+
+		// Mark test anchors as confirmed by dcrtime
+		if g.test {
+			g.testAnchors[vr.Digest] = true
+		}
+
+		log.Infof("External anchor confirmed: %v %v", vr.Digest,
+			ci.Transaction)
+	}
+
+	return nil
+}
+
+// GetExternalAnchor returns the external anchor identified by merkle and, if
+// available, its dcrtime confirmation.  It returns backend.ErrAnchorNotFound
+// if there is no such anchor.
+//
+// GetExternalAnchor satisfies the backend interface.
+func (g *gitBackEnd) GetExternalAnchor(merkle string) (*backend.AnchorInfo, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	ea, err := g.readExternalAnchor(merkle)
+	if err != nil {
+		return nil, err
+	}
+	ai := backend.AnchorInfo{
+		Merkle:  ea.Merkle,
+		Time:    ea.Time,
+		Digests: ea.Digests,
+	}
+	if ea.ChainInformation != nil {
+		ai.Confirmed = true
+		ai.ChainTimestamp = ea.ChainInformation.ChainTimestamp
+		ai.Transaction = ea.ChainInformation.Transaction
+	}
+
+	return &ai, nil
+}
+
+// ProveExternal returns a proof that the external digest, that was anchored
+// through AnchorExternal under merkle, is anchored.  The proof has the same
+// shape as a record anchor proof and is verified with VerifyAnchorProof.  It
+// returns backend.ErrAnchorNotFound if the anchor does not exist or has not
+// been confirmed yet.
+//
+// ProveExternal satisfies the backend interface.
+func (g *gitBackEnd) ProveExternal(merkle, digest string) (*backend.AnchorProof, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	ea, err := g.readExternalAnchor(merkle)
+	if err != nil {
+		return nil, err
+	}
+	ci := ea.ChainInformation
+	if ci == nil {
+		return nil, backend.ErrAnchorNotFound
+	}
+	branch, err := anchorBranch(ea.Digests, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend.AnchorProof{
+		Digest:         digest,
+		AnchorBranch:   *branch,
+		Merkle:         ea.Merkle,
+		DcrtimeBranch:  ci.MerklePath,
+		MerkleRoot:     ci.MerkleRoot,
+		Transaction:    ci.Transaction,
+		ChainTimestamp: ci.ChainTimestamp,
+	}, nil
+}
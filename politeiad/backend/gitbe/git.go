@@ -1,18 +1,50 @@
 package gitbe
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/decred/politeia/politeiad/backend"
 )
 
+// defaultGitTimeout is the maximum duration of a single git invocation.
+const defaultGitTimeout = 3 * time.Minute
+
+// gitNoPromptEnv is added to the environment of every git invocation.  It
+// makes git fail instead of prompting for credentials, which would block
+// forever when running without a terminal.
+var gitNoPromptEnv = []string{
+	"GIT_TERMINAL_PROMPT=0",
+	"GIT_ASKPASS=true",
+	"SSH_ASKPASS=true",
+}
+
+// GitTimeoutError is returned when a git invocation did not complete within
+// the configured timeout.  The git process is killed when that happens.
+type GitTimeoutError struct {
+	Args    []string      // git arguments
+	Timeout time.Duration // Timeout that was exceeded
+}
+
+// Error satisfies the error interface.
+func (e GitTimeoutError) Error() string {
+	return fmt.Sprintf("git %v: timeout after %v", strings.Join(e.Args, " "),
+		e.Timeout)
+}
+
 // gitError contains all the components of a git invocation.
 type gitError struct {
 	cmd    []string
@@ -22,9 +54,34 @@ type gitError struct {
 	stderr []string
 }
 
-// Error satisfies the error interface.
+// gitErrorMaxOutput is the maximum number of bytes of git output that are
+// included in a gitError message.
+const gitErrorMaxOutput = 2048
+
+// Error satisfies the error interface.  The git output is appended to the
+// error in order to make it possible to figure out why git failed.  Errors
+// go to stderr but some commands, e.g. rebase, report conflicts on stdout so
+// both are included.
 func (e gitError) Error() string {
-	return e.err.Error()
+	output := make([]string, 0, len(e.stderr)+len(e.stdout))
+	for _, lines := range [][]string{e.stderr, e.stdout} {
+		for _, v := range lines {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			output = append(output, v)
+		}
+	}
+	if len(output) == 0 {
+		return e.err.Error()
+	}
+
+	out := strings.Join(output, "; ")
+	if len(out) > gitErrorMaxOutput {
+		out = out[:gitErrorMaxOutput] + "..."
+	}
+	return e.err.Error() + ": " + out
 }
 
 // log pretty prints a gitError.
@@ -50,6 +107,22 @@ func (e gitError) log() {
 // git excutes the git command using the provided arguments.  If the path
 // argument is set it'll be copied to the GIT_DIR environment variable.
 func (g *gitBackEnd) git(path string, args ...string) ([]string, error) {
+	out, err := g.gitRaw(path, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, 0, 128)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, nil
+}
+
+// gitRaw is identical to git but returns stdout verbatim.  Use it when the
+// output is file content that must not be split into lines.
+func (g *gitBackEnd) gitRaw(path string, args ...string) ([]byte, error) {
 	if len(args) == 0 {
 		return nil, fmt.Errorf("git requires arguments")
 	}
@@ -67,7 +140,16 @@ func (g *gitBackEnd) git(path string, args ...string) ([]string, error) {
 		defer func() { ge.log() }()
 	}
 
-	cmd := exec.Command(g.gitPath, args...)
+	// Kill git if it hangs, e.g. on a credential prompt.
+	timeout := g.gitTimeout
+	if timeout == 0 {
+		timeout = defaultGitTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, g.gitPath, args...)
+	ge.env = gitNoPromptEnv
+	cmd.Env = append(os.Environ(), gitNoPromptEnv...)
 
 	// Determine if we need to set GIT_DIR
 	if path != "" {
@@ -87,10 +169,6 @@ func (g *gitBackEnd) git(path string, args ...string) ([]string, error) {
 
 	// Finish up cmd.
 	err = cmd.Wait()
-	if err != nil {
-		ge.err = fmt.Errorf("cmd.Wait: %v", err)
-		return nil, ge
-	}
 
 	scanner := bufio.NewScanner(bytes.NewReader(stdout.Bytes()))
 	for scanner.Scan() {
@@ -102,7 +180,72 @@ func (g *gitBackEnd) git(path string, args ...string) ([]string, error) {
 		ge.stderr = append(ge.stderr, scanner.Text())
 	}
 
-	return ge.stdout, nil
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Errorf("git %v: timeout after %v", strings.Join(args, " "),
+			timeout)
+		return nil, GitTimeoutError{
+			Args:    args,
+			Timeout: timeout,
+		}
+	}
+	if err != nil {
+		ge.err = fmt.Errorf("cmd.Wait: %v", err)
+		// Some git commands fail as part of normal operation, e.g.
+		// git diff --exit-code, so don't log this as an error.
+		log.Debugf("git %v: %v", strings.Join(args, " "), ge)
+		return nil, ge
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// gitArchive extracts dir, as of commit, from the repo at path into dst.  It
+// returns false if dir does not exist at commit.
+func (g *gitBackEnd) gitArchive(path, commit, dir, dst string) (bool, error) {
+	out, err := g.git(path, "ls-tree", "--name-only", commit, "--", dir)
+	if err != nil {
+		return false, err
+	}
+	if len(out) == 0 {
+		return false, nil
+	}
+	b, err := g.gitRaw(path, "archive", "--format=tar", commit, "--", dir)
+	if err != nil {
+		return false, err
+	}
+
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return false, err
+		}
+		name := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(name, filepath.Clean(dst)+
+			string(filepath.Separator)) {
+			return false, fmt.Errorf("invalid archive path: %v",
+				hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(name, 0700)
+		case tar.TypeReg, tar.TypeRegA:
+			var f []byte
+			f, err = ioutil.ReadAll(tr)
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(name), 0700)
+			}
+			if err == nil {
+				err = ioutil.WriteFile(name, f, 0600)
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
 }
 
 // gitVersion returns the version of git.
@@ -119,6 +262,68 @@ func (g *gitBackEnd) gitVersion() (string, error) {
 	return out[0], nil
 }
 
+// parseGitVersion returns the major, minor and patch numbers of a git version,
+// e.g. 2.17.1.  The output of git version, e.g. "git version 2.17.1.windows.2"
+// is accepted as well.  A missing patch number is 0.
+func parseGitVersion(version string) ([3]int, error) {
+	var v [3]int
+	f := strings.Fields(version)
+	if len(f) >= 3 && f[0] == "git" && f[1] == "version" {
+		version = f[2]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return v, fmt.Errorf("invalid git version: %v", version)
+	}
+	for k := range v {
+		if k >= len(parts) {
+			break
+		}
+		n, err := strconv.Atoi(parts[k])
+		if err != nil || n < 0 {
+			if k == 2 {
+				// e.g. 2.17.rc1
+				break
+			}
+			return v, fmt.Errorf("invalid git version: %v", version)
+		}
+		v[k] = n
+	}
+	return v, nil
+}
+
+// gitCheckVersion verifies that git is installed and at least version
+// required.
+func (g *gitBackEnd) gitCheckVersion(required string) (string, error) {
+	rv, err := parseGitVersion(required)
+	if err != nil {
+		return "", err
+	}
+	version, err := g.gitVersion()
+	if err != nil {
+		return "", backend.GitVersionError{
+			Required: required,
+			Err:      err,
+		}
+	}
+	v, err := parseGitVersion(version)
+	if err != nil {
+		return "", err
+	}
+	for k := range v {
+		if v[k] > rv[k] {
+			break
+		}
+		if v[k] < rv[k] {
+			return "", backend.GitVersionError{
+				Detected: version,
+				Required: required,
+			}
+		}
+	}
+	return version, nil
+}
+
 func (g *gitBackEnd) gitHasChanges(path string) (rv bool) {
 	if _, err := g.git(path, "diff", "--exit-code"); err != nil {
 		rv = true
@@ -132,6 +337,25 @@ func (g *gitBackEnd) gitDiff(path string) ([]string, error) {
 	return g.git(path, "diff")
 }
 
+// gitStatus returns the NUL separated porcelain status of the files in
+// pathspec, including all untracked files.
+func (g *gitBackEnd) gitStatus(path, pathspec string) ([]byte, error) {
+	return g.gitRaw(path, "status", "--porcelain", "-z",
+		"--untracked-files=all", "--", pathspec)
+}
+
+// gitResetHard discards all staged and unstaged changes to tracked files.
+func (g *gitBackEnd) gitResetHard(path string) error {
+	_, err := g.git(path, "reset", "--hard")
+	return err
+}
+
+// gitClean removes all untracked files and directories.
+func (g *gitBackEnd) gitClean(path string) error {
+	_, err := g.git(path, "clean", "-f", "-d")
+	return err
+}
+
 func (g *gitBackEnd) gitStash(path string) error {
 	_, err := g.git(path, "stash")
 	return err
@@ -148,6 +372,7 @@ func (g *gitBackEnd) gitAdd(path, filename string) error {
 }
 
 func (g *gitBackEnd) gitCommit(path, message string) error {
+	g.testHook(testPointCommit)
 	_, err := g.git(path, "commit", "-m", message)
 	return err
 }
@@ -157,11 +382,42 @@ func (g *gitBackEnd) gitCheckout(path, branch string) error {
 	return err
 }
 
+// gitShow returns the content of filename as of the provided ref without
+// checking it out.
+func (g *gitBackEnd) gitShow(path, ref, filename string) ([]string, error) {
+	return g.git(path, "show", ref+":"+filename)
+}
+
 func (g *gitBackEnd) gitBranchDelete(path, branch string) error {
 	_, err := g.git(path, "branch", "-D", branch)
 	return err
 }
 
+// gitBranchReset points local branch at commit.  branch must not be checked
+// out.
+func (g *gitBackEnd) gitBranchReset(path, branch, commit string) error {
+	_, err := g.git(path, "branch", "-f", branch, commit)
+	return err
+}
+
+// gitRevParse returns the commit digest ref points at.
+func (g *gitBackEnd) gitRevParse(path, ref string) (string, error) {
+	out, err := g.git(path, "rev-parse", "--verify", "--quiet", ref)
+	if err != nil {
+		return "", err
+	}
+	if len(out) == 0 {
+		return "", fmt.Errorf("invalid git output")
+	}
+	return strings.TrimSpace(out[0]), nil
+}
+
+// gitBranchRename renames local branch from to to.
+func (g *gitBackEnd) gitBranchRename(path, from, to string) error {
+	_, err := g.git(path, "branch", "-m", from, to)
+	return err
+}
+
 func (g *gitBackEnd) gitBranches(path string) ([]string, error) {
 	branches, err := g.git(path, "branch")
 	if err != nil {
@@ -176,6 +432,13 @@ func (g *gitBackEnd) gitBranches(path string) ([]string, error) {
 	return b, nil
 }
 
+// gitBranchExists returns true if the provided local branch exists.
+func (g *gitBackEnd) gitBranchExists(path, branch string) bool {
+	_, err := g.git(path, "rev-parse", "--verify", "--quiet",
+		"refs/heads/"+branch)
+	return err == nil
+}
+
 func (g *gitBackEnd) gitBranchNow(path string) (string, error) {
 	branches, err := g.git(path, "branch")
 	if err != nil {
@@ -205,9 +468,56 @@ func (g *gitBackEnd) gitPull(path string, fastForward bool) error {
 	return nil
 }
 
+// gitRebaseInProgress returns true if the repo is stuck in the middle of a
+// rebase.
+func (g *gitBackEnd) gitRebaseInProgress(path string) bool {
+	for _, v := range []string{"rebase-merge", "rebase-apply"} {
+		_, err := os.Stat(filepath.Join(path, ".git", v))
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// gitConflicts returns the list of unmerged files.
+func (g *gitBackEnd) gitConflicts(path string) ([]string, error) {
+	return g.git(path, "diff", "--name-only", "--diff-filter=U")
+}
+
+func (g *gitBackEnd) gitRebaseAbort(path string) error {
+	_, err := g.git(path, "rebase", "--abort")
+	return err
+}
+
+// gitRebase rebases the current branch onto branch.  If the rebase stops due
+// to a conflict it is aborted in order to leave the repo in a clean state and
+// a backend.ErrRebaseConflict that contains the conflicting files is
+// returned.
 func (g *gitBackEnd) gitRebase(path, branch string) error {
 	_, err := g.git(path, "rebase", branch)
-	return err
+	if err == nil {
+		return nil
+	}
+	if !g.gitRebaseInProgress(path) {
+		return err
+	}
+
+	files, err2 := g.gitConflicts(path)
+	if err2 != nil {
+		log.Errorf("gitConflicts %v: %v", path, err2)
+	}
+	err2 = g.gitRebaseAbort(path)
+	if err2 != nil {
+		// We are in trouble!  Consider a panic.
+		log.Errorf("gitRebaseAbort %v: %v", path, err2)
+		return err2
+	}
+	if len(files) == 0 {
+		return err
+	}
+
+	return backend.ErrRebaseConflict{Files: files}
 }
 
 func (g *gitBackEnd) gitPush(path, remote, branch string, upstream bool) error {
@@ -274,6 +584,113 @@ func (g *gitBackEnd) gitFsck(path string) ([]string, error) {
 	return out, nil
 }
 
+// gitVerifyObjects is a stronger check than gitFsck for disk level
+// corruption.  Every pack is checked with git verify-pack and every object,
+// loose or packed, is inflated and hashed to ensure it still matches its
+// name.  The error lists the packs and objects that failed.
+func (g *gitBackEnd) gitVerifyObjects(path string) error {
+	var failed []string
+
+	// git verify-pack
+	idxs, err := filepath.Glob(filepath.Join(path, ".git", "objects",
+		"pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, idx := range idxs {
+		_, err := g.git(path, "verify-pack", idx)
+		if err != nil {
+			failed = append(failed, "pack "+filepath.Base(idx))
+		}
+	}
+
+	// git cat-file --batch-all-objects --batch
+	//
+	// The output holds every object in the repo so it is streamed rather
+	// than buffered and, since its duration grows with the repo, git runs
+	// without the command timeout.
+	cmd := exec.Command(g.gitPath, "cat-file", "--batch-all-objects",
+		"--batch")
+	cmd.Dir = path
+	cmd.Env = append(os.Environ(), gitNoPromptEnv...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("inflate objects: %v", err)
+	}
+	corrupt, err := verifyObjectStream(bufio.NewReader(stdout))
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("inflate objects: %v", err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("inflate objects: %v: %v", err,
+			strings.TrimSpace(stderr.String()))
+	}
+	failed = append(failed, corrupt...)
+
+	if len(failed) != 0 {
+		return fmt.Errorf("corrupt git objects in %v: %v",
+			filepath.Base(path), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// verifyObjectStream reads git cat-file --batch output from r and returns the
+// objects that are missing or whose content does not hash to their name.  It
+// errors if the output is malformed or truncated.
+func verifyObjectStream(r *bufio.Reader) ([]string, error) {
+	var failed []string
+	for {
+		// Header is <sha> <type> <size>, or <sha> missing
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return failed, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("truncated cat-file header: %q",
+				line)
+		}
+		header := strings.Fields(line)
+		switch {
+		case len(header) == 2 && header[1] == "missing":
+			failed = append(failed, header[0])
+			continue
+		case len(header) != 3:
+			return nil, fmt.Errorf("unexpected cat-file header: %q",
+				line)
+		}
+		size, err := strconv.ParseInt(header[2], 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("unexpected cat-file header: %q",
+				line)
+		}
+
+		h := sha1.New()
+		fmt.Fprintf(h, "%v %v\x00", header[1], size)
+		_, err = io.CopyN(h, r, size)
+		if err != nil {
+			return nil, fmt.Errorf("truncated object %v: %v",
+				header[0], err)
+		}
+		b, err := r.ReadByte()
+		if err != nil || b != '\n' {
+			return nil, fmt.Errorf("unterminated object %v", header[0])
+		}
+		if hex.EncodeToString(h.Sum(nil)) != header[0] {
+			failed = append(failed, header[0])
+		}
+	}
+}
+
 // gitConfig sets a config value for the provided repo.
 func (g *gitBackEnd) gitConfig(path, name, value string) error {
 	_, err := g.git(path, "config", name, value)
@@ -321,41 +738,65 @@ func (g *gitBackEnd) gitInit(path string) (string, error) {
 	return out[0], nil
 }
 
-// gitInitRepo initializes a directory as a git repo.  This functions exits
-// without an error if the directory is already a git repo.  The git repo is
-// initialized with a .gitignore file so that a) have a master and b) always
-// ignore the lock file.
+// gitInitRepo initializes a directory as a git repo.  The git repo is
+// initialized with a .gitignore file so that a) have a master, b) always
+// ignore the lock file and c) deltaCommits always has a base commit.  A
+// directory that is already a git repo is left alone unless it has no
+// commits, e.g. because init was interrupted, then the initial commit is
+// created.
 func (g *gitBackEnd) gitInitRepo(path string, repoConfig map[string]string) error {
 	_, err := os.Stat(filepath.Join(path, ".git"))
-	// This test is unreadable but correct.
-	if !os.IsNotExist(err) {
+	switch {
+	case os.IsNotExist(err):
+		// Containing directory
+		log.Infof("Initializing git repo: %v", path)
+		err = os.MkdirAll(path, 0755)
+		if err != nil {
+			return err
+		}
+
+		// Initialize git repo
+		_, err = g.gitInit(path)
+		if err != nil {
+			return err
+		}
+
+		// Apply repo config
+		for k, v := range repoConfig {
+			err = g.gitConfig(path, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	case err != nil:
 		return err
 	}
 
-	// Containing directory
-	log.Infof("Initializing git repo: %v", path)
-	err = os.MkdirAll(path, 0755)
+	return g.gitInitialCommit(path)
+}
+
+// gitHasCommits returns true if the repo at path has at least one commit.
+func (g *gitBackEnd) gitHasCommits(path string) (bool, error) {
+	out, err := g.git(path, "rev-list", "-n", "1", "--all")
 	if err != nil {
-		return err
+		return false, err
 	}
+	return len(out) != 0, nil
+}
 
-	// Initialize git repo
-	_, err = g.gitInit(path)
+// gitInitialCommit commits a .gitignore with the lock file name to the repo
+// at path if it has no commits yet.  This makes the repo ready to go and we'll
+// always use this as the initial commit.
+func (g *gitBackEnd) gitInitialCommit(path string) error {
+	ok, err := g.gitHasCommits(path)
 	if err != nil {
 		return err
 	}
-
-	// Apply repo config
-	for k, v := range repoConfig {
-		err = g.gitConfig(path, k, v)
-		if err != nil {
-			return err
-		}
+	if ok {
+		return nil
 	}
 
-	// Add .gitignore with the lock file name.
-	// This makes the repo ready to go and we'll always use this as the
-	// initial commit.
+	log.Infof("Creating initial commit: %v", path)
 	err = ioutil.WriteFile(filepath.Join(path, ".gitignore"),
 		[]byte(LockFilename+"\n"), 0664)
 	if err != nil {
@@ -4,15 +4,20 @@ import (
 	"bufio"
 	"bytes"
 	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/btcsuite/btclog"
+	"github.com/decred/politeia/politeiad/backend"
 )
 
 type testWriter struct {
@@ -46,6 +51,64 @@ func TestVersion(t *testing.T) {
 	}
 }
 
+func TestParseGitVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    [3]int
+		valid   bool
+	}{
+		{"2.17.1", [3]int{2, 17, 1}, true},
+		{"2.6", [3]int{2, 6, 0}, true},
+		{"git version 2.20.1", [3]int{2, 20, 1}, true},
+		{"git version 2.17.1.windows.2", [3]int{2, 17, 1}, true},
+		{"git version 2.21.0 (Apple Git-122)", [3]int{2, 21, 0}, true},
+		{"2.17.rc1", [3]int{2, 17, 0}, true},
+		{"2", [3]int{}, false},
+		{"two.17", [3]int{}, false},
+		{"", [3]int{}, false},
+	}
+	for _, test := range tests {
+		v, err := parseGitVersion(test.version)
+		if (err == nil) != test.valid {
+			t.Fatalf("%q: unexpected error %v", test.version, err)
+		}
+		if test.valid && v != test.want {
+			t.Fatalf("%q: got %v, want %v", test.version, v,
+				test.want)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	g := newGitBackEnd()
+	defer os.RemoveAll(g.root)
+
+	_, err := g.gitCheckVersion("1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = g.gitCheckVersion("999.0.0")
+	gve, ok := err.(backend.GitVersionError)
+	if !ok {
+		t.Fatalf("expected GitVersionError, got %v", err)
+	}
+	if gve.Detected == "" || gve.Required != "999.0.0" {
+		t.Fatalf("unexpected error %+v", gve)
+	}
+
+	// Missing git
+	g.gitPath = filepath.Join(g.root, "nogit")
+	_, err = g.gitCheckVersion("1.0")
+	gve, ok = err.(backend.GitVersionError)
+	if !ok {
+		t.Fatalf("expected GitVersionError, got %v", err)
+	}
+	if gve.Detected != "" || gve.Err == nil {
+		t.Fatalf("unexpected error %+v", gve)
+	}
+}
+
 func TestInit(t *testing.T) {
 	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
 	UseLogger(log)
@@ -75,6 +138,83 @@ func TestLog(t *testing.T) {
 	}
 }
 
+func TestInitRepo(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+	g := newGitBackEnd()
+	defer os.RemoveAll(g.root)
+
+	// Interrupt init before the initial commit
+	_, err := g.gitInit(g.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range defaultRepoConfig {
+		err = g.gitConfig(g.root, k, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ok, err := g.gitHasCommits(g.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("empty repo has commits")
+	}
+
+	// Init completes the repo, once
+	for i := 0; i < 2; i++ {
+		err = g.gitInitRepo(g.root, defaultRepoConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := g.git(g.root, "rev-list", "--all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 {
+		t.Fatalf("expected a single initial commit, got %v", len(out))
+	}
+
+	// The initial commit is the base of the first anchor
+	digests, _, _, err := g.deltaCommits(g.root, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 1 {
+		t.Fatalf("expected 1 digest, got %v", len(digests))
+	}
+
+	// An anchor above master leaves an empty range, which is an error
+	err = ioutil.WriteFile(filepath.Join(g.root, "testfile"),
+		[]byte("this is a file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitAdd(g.root, "testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitCommit(g.root, "Add testfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := g.gitLastDigest(g.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.git(g.root, "reset", "--hard", "HEAD~1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = g.deltaCommits(g.root, extendSHA1(top), 0)
+	if err == nil || err == errNothingToDo {
+		t.Fatalf("expected empty range error, got %v", err)
+	}
+}
+
 func TestFsck(t *testing.T) {
 	// Test git fsck, we build on top of that with a dcrtime fsck
 	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
@@ -276,3 +416,240 @@ func TestFsck(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestVerifyObjects(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+	g := newGitBackEnd()
+	defer os.RemoveAll(g.root)
+
+	_, err := g.gitInit(g.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// commit adds a file with the provided content and returns the
+	// hash of its blob.
+	commit := func(name, content string) string {
+		t.Helper()
+		err := ioutil.WriteFile(filepath.Join(g.root, name),
+			[]byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.gitAdd(g.root, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.gitCommit(g.root, "Add "+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := g.git(g.root, "rev-parse", "HEAD:"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out[0]
+	}
+
+	// Packed objects
+	commit("packed", "this is packed\n")
+	_, err = g.git(g.root, "gc", "--quiet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitVerifyObjects(g.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Loose objects
+	blobHash := commit("loose", "this is loose\n")
+	err = g.gitVerifyObjects(g.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the loose blob with valid zlib data that does not match
+	// its name.
+	var bc bytes.Buffer
+	w, err := zlib.NewWriterLevel(&bc, 1) // git uses zlib level 1
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("blob 14\x00this is LOOSE\n"))
+	w.Close()
+	blobObjectFilename := filepath.Join(g.root, ".git", "objects",
+		blobHash[:2], blobHash[2:])
+	err = os.Chmod(blobObjectFilename, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(blobObjectFilename, bc.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitVerifyObjects(g.root)
+	if err == nil || !strings.Contains(err.Error(), blobHash) {
+		t.Fatalf("expected corrupt object %v, got %v", blobHash, err)
+	}
+}
+
+func TestVerifyObjectStream(t *testing.T) {
+	blob := "this is a blob\n"
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %v\x00%v", len(blob), blob)
+	hash := hex.EncodeToString(h.Sum(nil))
+	object := fmt.Sprintf("%v blob %v\n%v\n", hash, len(blob), blob)
+
+	tests := []struct {
+		name   string
+		output string
+		failed []string
+		fail   bool
+	}{
+		{"empty", "", nil, false},
+		{"valid", object, nil, false},
+		{"missing", object + "abcd missing\n", []string{"abcd"}, false},
+		{"corrupt", strings.Replace(object, "this", "This", 1),
+			[]string{hash}, false},
+		{"empty header", "\n", nil, true},
+		{"short header", hash + " blob\n", nil, true},
+		{"invalid size", hash + " blob -1\n", nil, true},
+		{"truncated header", hash, nil, true},
+		{"truncated object", object[:len(object)-4], nil, true},
+		{"unterminated object", object[:len(object)-1] + "x", nil,
+			true},
+	}
+	for _, test := range tests {
+		failed, err := verifyObjectStream(bufio.NewReader(
+			strings.NewReader(test.output)))
+		if test.fail {
+			if err == nil {
+				t.Fatalf("%v: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(failed, test.failed) {
+			t.Fatalf("%v: got %v, wanted %v", test.name, failed,
+				test.failed)
+		}
+	}
+}
+
+func TestRebaseConflict(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+	g := newGitBackEnd()
+	defer os.RemoveAll(g.root)
+
+	err := g.gitInitRepo(g.root, defaultRepoConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// commitFile writes content to testfile and commits it.
+	tf := filepath.Join(g.root, "testfile")
+	commitFile := func(content string) {
+		err := ioutil.WriteFile(tf, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.gitAdd(g.root, tf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.gitCommit(g.root, "Update testfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Create diverging histories on master and branch
+	commitFile("base\n")
+	err = g.gitNewBranch(g.root, "branch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile("branch\n")
+	err = g.gitCheckout(g.root, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile("master\n")
+
+	// Rebase must fail with a conflict on testfile
+	err = g.gitRebase(g.root, "branch")
+	rc, ok := err.(backend.ErrRebaseConflict)
+	if !ok {
+		t.Fatalf("expected rebase conflict, got %v", err)
+	}
+	if len(rc.Files) != 1 || rc.Files[0] != "testfile" {
+		t.Fatalf("unexpected conflicting files: %v", rc.Files)
+	}
+
+	// Repo must not be stuck in the rebase
+	if g.gitRebaseInProgress(g.root) {
+		t.Fatalf("rebase still in progress")
+	}
+	branch, err := g.gitBranchNow(g.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch != "master" {
+		t.Fatalf("unexpected branch: %v", branch)
+	}
+}
+
+func TestGitTimeout(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+	g := newGitBackEnd()
+	defer os.RemoveAll(g.root)
+
+	// Use sleep as a stand-in for a hanging git
+	g.gitPath = "sleep"
+	g.gitTimeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err := g.git(g.root, "10")
+	if _, ok := err.(GitTimeoutError); !ok {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("git was not killed")
+	}
+}
+
+func TestGitNoPrompt(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+	g := newGitBackEnd()
+	defer os.RemoveAll(g.root)
+
+	// Use env as a stand-in for git to dump its environment
+	os.Setenv("GIT_TERMINAL_PROMPT", "1")
+	defer os.Unsetenv("GIT_TERMINAL_PROMPT")
+	g.gitPath = "env"
+	out, err := g.git(g.root, "-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := strings.Split(strings.Join(out, "\n"), "\x00")
+	for _, want := range gitNoPromptEnv {
+		var found bool
+		name := strings.SplitN(want, "=", 2)[0] + "="
+		for _, v := range env {
+			if !strings.HasPrefix(v, name) {
+				continue
+			}
+			// The last entry wins
+			found = v == want
+		}
+		if !found {
+			t.Fatalf("%v not set", want)
+		}
+	}
+}
@@ -14,8 +14,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -51,14 +53,42 @@ const (
 	// defaultRecordMetadataFilename is the filename of record record.
 	defaultRecordMetadataFilename = "recordmetadata.json"
 
+	// defaultMIMEFilename is the filename of the declared MIME types of
+	// the record files that differ from the detected type, see
+	// writeMIMETypes.
+	defaultMIMEFilename = "mimetypes.json"
+
 	// defaultMDFilenameSuffix is the filename suffic for the user provided
 	// metadata record.  The metadata record shall be string encoded.
 	defaultMDFilenameSuffix = ".metadata.txt"
 
+	// defaultFsckCheckpointPrefix is prepended to the repo name to form the
+	// filename, relative to the root, of the fsck checkpoint.
+	defaultFsckCheckpointPrefix = "fsck-checkpoint-"
+
+	// defaultReissuedDirectory is the directory, relative to the root,
+	// where the tombstones of reissued tokens are stored.  They are indexed
+	// by the old token.
+	defaultReissuedDirectory = "reissued"
+
+	// defaultUpdateJournalDirectory is the directory, relative to the
+	// root, where in flight record updates are journaled.  They are
+	// indexed by token.
+	defaultUpdateJournalDirectory = "updates"
+
+	// defaultPurgedDirectory is the directory, relative to the root, where
+	// the tombstones of purged blobs are stored.  They are indexed by the
+	// payload digest.
+	defaultPurgedDirectory = "purged"
+
 	// defaultAuditTrailFile is the filename where a human readable audit
 	// trail is kept.
 	defaultAuditTrailFile = "anchor_audit_trail.txt"
 
+	// defaultManifestFile is the filename, relative to the vetted root,
+	// of the record manifest.
+	defaultManifestFile = "manifest.json"
+
 	// defaultAnchorsDirectory is the directory where anchors are stored.
 	// They are indexed by TX.
 	defaultAnchorsDirectory = "anchors"
@@ -70,17 +100,37 @@ const (
 	// Seconds Minutes Hours Days Months DayOfWeek
 	anchorSchedule = "0 58 * * * *" // At 58 minutes every hour
 
+	// defaultAnchorPollInterval is how often unconfirmed anchors are
+	// checked with dcrtime.
+	defaultAnchorPollInterval = 5 * time.Minute
+
+	// defaultMinGitVersion is the oldest git version that supports every
+	// git feature the backend relies on, cat-file --batch-all-objects
+	// being the most recent one.
+	defaultMinGitVersion = "2.6.0"
+
+	// minAnchorPollInterval is the smallest anchor poll interval that is
+	// accepted in order to not hammer dcrtime.
+	minAnchorPollInterval = 10 * time.Second
+
+	// newTokenRetries is the number of attempts to create an unused
+	// censorship token.
+	newTokenRetries = 5
+
 	// expectedTestTX is a fake TX used by unit tests.
 	expectedTestTX = "TESTTX"
 
+	// testBestBlock is the fake best block height used by unit tests.
+	testBestBlock = 300000
+
 	// markerAnchor is used in commit messages to determine
 	// where an anchor has been committed.  This value is
-	// parsed and therefore must be a const.
+	// parsed and therefore must be a const, see anchorMessage.
 	markerAnchor = "Anchor"
 
 	// markerAnchorConfirmation is used in commit messages to determine
 	// where an anchor confirmation has been committed.  This value is
-	// parsed and therefore must be a const.
+	// parsed and therefore must be a const, see anchorMessage.
 	markerAnchorConfirmation = "Anchor confirmation"
 )
 
@@ -94,36 +144,220 @@ var (
 	}
 
 	errNothingToDo = errors.New("nothing to do")
+
+	// payloadDirs is the whitelist of subdirectories that may be used to
+	// group files within the payload directory of a record.
+	payloadDirs = map[string]bool{
+		"docs":   true,
+		"images": true,
+	}
 )
 
 // file is an internal representation of a file that resides in memory.
 type file struct {
 	name    string // Basename of the file
+	mime    string // Declared MIME type
 	digest  []byte // SHA256 of payload
 	payload []byte // Actual file payload
 }
 
+// Options contains the optional gitBackEnd settings.  The zero value of each
+// option selects its default.
+type Options struct {
+	// HTTPClient is used for all dcrtime calls.  It defaults to a client
+	// with a DefaultDcrtimeTimeout timeout.
+	HTTPClient *http.Client
+
+	// BlobThreshold is the payload size in bytes above which files are
+	// stored in the blob store instead of git.  Zero disables the blob
+	// store.
+	BlobThreshold int64
+
+	// OnAnchor is called when an anchor is dropped and again when it is
+	// confirmed.  It runs on its own go routine, outside of the lock, and
+	// has no bearing on the anchor process.
+	OnAnchor func(ai backend.AnchorInfo)
+
+	// SkipStartupFsck skips the dcrtime fsck of the vetted repository that
+	// is otherwise run by New.  Operators are expected to run it on a
+	// schedule instead.
+	SkipStartupFsck bool
+
+	// FullFsck ignores the fsck checkpoint and verifies all anchored
+	// commits.  By default fsck only verifies the commits that were
+	// anchored after the last fully verified anchor.
+	FullFsck bool
+
+	// AsyncStartupFsck runs the startup fsck on its own go routine so that
+	// New returns without waiting for the dcrtime verification.  The
+	// filesystem lock is only taken while recording anchor confirmations.
+	AsyncStartupFsck bool
+
+	// CompressPayloads gzip compresses file payloads that are committed
+	// to git.  Small payloads and payloads that do not compress well are
+	// stored as is.  Digests are always computed over the uncompressed
+	// payload.  Records are read correctly regardless of this setting.
+	CompressPayloads bool
+
+	// MaxMDStreams is the maximum number of metadata streams of a record.
+	// Zero disables the limit.
+	MaxMDStreams int
+
+	// MaxFileSize is the maximum decoded size in bytes of a single file.
+	// Oversized payloads are rejected before they are decoded.  Zero
+	// disables the limit.
+	MaxFileSize int64
+
+	// MaxUnvetted is the maximum number of records pending review,
+	// MDStatusUnvetted and MDStatusIterationUnvetted.  Record creations
+	// beyond it return backend.ErrTooManyUnvetted.  Zero disables the
+	// limit.
+	MaxUnvetted int
+
+	// MaxMDSize is the maximum total size in bytes of all metadata streams
+	// of a record, including the streams written by plugins.  Zero
+	// disables the limit.
+	MaxMDSize int64
+
+	// GitTimeout is the maximum duration of a single git invocation.  It
+	// defaults to 3 minutes.
+	GitTimeout time.Duration
+
+	// FileMode is the permission of files written by the backend, e.g.
+	// 0640.  When unset every file keeps its historic mode.  The process
+	// umask still applies.  Note that git recreates tracked files on
+	// checkout according to its own rules, the mode is only retained by
+	// the blob store and by files that are never checked out again.
+	FileMode os.FileMode
+
+	// DirMode is the permission of directories created by the backend,
+	// e.g. 0750.  The same caveats as for FileMode apply.
+	DirMode os.FileMode
+
+	// VerifyObjects additionally inflates and hashes every git object of
+	// both repositories during the startup git fsck.  This catches packfile
+	// and disk level corruption that git fsck may miss at the cost of a
+	// slower startup.
+	VerifyObjects bool
+
+	// RateLimiter is consulted by every record creation and unvetted
+	// record update once the request has been validated.  Refused calls
+	// return backend.ErrRateLimited.  Nil disables rate limiting.
+	RateLimiter backend.RateLimiter
+
+	// BestBlockSource provides the best block height to the decred plugin,
+	// e.g. from the operator's own dcrd.  Nil queries the dcrdata plugin
+	// setting.
+	BestBlockSource BestBlockSource
+
+	// EncryptionKey encrypts the file payloads of unvetted records at rest.
+	// Payloads are decrypted when a record is vetted since vetted content
+	// is public, the git history of a vetted record retains the encrypted
+	// revisions.  Digests are always computed over the plaintext.  Nil
+	// stores unvetted payloads in plaintext.
+	EncryptionKey *[EncryptionKeySize]byte
+
+	// MinAnchorAge is the minimum age of a commit before it is anchored.
+	// Younger commits, and the commits above them, are left for the next
+	// anchor so that records that are quickly corrected are anchored
+	// once.  Zero anchors all commits.
+	MinAnchorAge time.Duration
+
+	// AnchorPollInterval is how often unconfirmed anchors are checked with
+	// dcrtime.  It defaults to 5 minutes and may not be smaller than 10
+	// seconds.
+	AnchorPollInterval time.Duration
+
+	// TokenNamespace, when not empty, makes New derive the token of a
+	// record from the namespace and the sorted digests of its files
+	// instead of picking a random one.  Creating a record whose content
+	// already exists in the namespace returns the existing record.  This
+	// allows idempotent ingestion, e.g. when mirroring another instance.
+	TokenNamespace string
+
+	// MinGitVersion is the oldest git version that is accepted, e.g.
+	// 2.17.1.  It defaults to 2.6.0.  An older git makes New fail with a
+	// backend.GitVersionError.
+	MinGitVersion string
+
+	// IndexedMDStreams are the metadata stream IDs that FindByMetadata
+	// serves from the metadata index instead of scanning every vetted
+	// record.  Nil indexes the decred plugin vote streams, an empty slice
+	// disables the index.
+	IndexedMDStreams []uint64
+
+	// test puts the backend in test mode before New starts its go
+	// routines, see SelfTest.
+	test bool
+}
+
 // gitBackEnd is a git based backend context that satisfies the backend
 // interface.
 type gitBackEnd struct {
-	lock            *lockfile.LockFile // Global lock
-	db              *leveldb.DB        // Database
-	cron            *cron.Cron         // Scheduler for periodic tasks
-	activeNetParams *chaincfg.Params   // indicator if we are running on testnet
-	shutdown        bool               // Backend is shutdown
-	root            string             // Root directory
-	unvetted        string             // Unvettend content
-	vetted          string             // Vetted, public, visible content
-	dcrtimeHost     string             // Dcrtimed directory
-	gitPath         string             // Path to git
-	gitTrace        bool               // Enable git tracing
-	test            bool               // Set during UT
-	exit            chan struct{}      // Close channel
-	checkAnchor     chan struct{}      // Work notification
-	plugins         []backend.Plugin   // Plugins
+	lock            *lockfile.LockFile  // Global lock
+	recordLocks     recordLocks         // Per record locks, see locks.go
+	db              *leveldb.DB         // Labels database, see labels.go
+	mdIndex         *leveldb.DB         // Metadata index, see mdindex.go
+	cron            *cron.Cron          // Scheduler for periodic tasks
+	activeNetParams *chaincfg.Params    // indicator if we are running on testnet
+	shutdown        bool                // Backend is shutdown, see isShutdown
+	root            string              // Root directory
+	unvetted        string              // Unvettend content
+	vetted          string              // Vetted, public, visible content
+	dcrtimeHost     string              // Dcrtimed directory
+	httpClient      *http.Client        // Client used for dcrtime calls
+	blobThreshold   int64               // Store larger payloads as blobs
+	compress        bool                // Gzip payloads committed to git
+	maxMDStreams    int                 // Metadata streams per record limit
+	maxMDSize       int64               // Metadata size per record limit
+	maxFileSize     int64               // Decoded file size limit
+	maxUnvetted     int                 // Records pending review limit
+	pending         map[string]struct{} // Records pending review
+	gitPath         string              // Path to git
+	gitTrace        bool                // Enable git tracing
+	gitTimeout      time.Duration       // Timeout of a git invocation
+	minGitVersion   string              // Oldest accepted git version
+	tokenNamespace  string              // Derive tokens from content if set
+	anchorPoll      time.Duration       // Anchor confirmation poll interval
+	minAnchorAge    time.Duration       // Anchor commits at least this old
+	fullFsck        bool                // Ignore the fsck checkpoint
+	verifyObjects   bool                // Verify all git objects on startup
+	fileMode        os.FileMode         // Mode of new files, 0 is default
+	dirMode         os.FileMode         // Mode of new directories, 0 is default
+	test            bool                // Set during UT
+	exit            chan struct{}       // Close channel
+	checkAnchor     chan struct{}       // Work notification
+	plugins         []backend.Plugin    // Plugins
+
+	indexedMD map[uint64]struct{} // Indexed metadata streams
+
+	onAnchor      func(backend.AnchorInfo) // Anchor event hook, may be nil
+	rateLimiter   backend.RateLimiter      // Record creation limiter, may be nil
+	encryptionKey *[EncryptionKeySize]byte // Unvetted payload key, may be nil
+	identity      *identity.FullIdentity   // Signs anchors, may be nil
+
+	// anchor health, see Status
+	anchorHealthMtx sync.Mutex           // Anchor health lock
+	anchorHealth    backend.AnchorHealth // Outcome of the anchor attempts
+
+	// shutdown is written with both the lock and shutdownMtx held so that
+	// it can be read holding either.
+	shutdownMtx sync.Mutex // Shutdown lock for readers without the lock
+
+	// anchor pause, see PauseAnchoring
+	anchorPauseMtx sync.Mutex // Anchor pause lock
+	anchorPaused   bool       // Anchoring is paused
+	anchorDeferred bool       // An anchor was scheduled while paused
+
+	// decred plugin state
+	decredPluginMtx       sync.RWMutex                  // Settings lock
+	decredPluginSettings  map[string]string             // [key]setting
+	decredPluginVoteCache map[string]*decredplugin.Vote // [token]vote, requires lock
+	bestBlockSource       BestBlockSource               // Best block height, may be nil
 
 	// The following items are used for testing only
-	testAnchors map[string]bool // [digest]anchored
+	testAnchors  map[string]bool // [digest]anchored
+	testHookFunc func(testPoint) // Called at test points, may be nil
 }
 
 // extendSHA1 appends 0 to make a SHA1 the size of a SHA256 digest.
@@ -164,53 +398,178 @@ func extendSHA1FromString(s string) (string, error) {
 	return hex.EncodeToString(d), nil
 }
 
-// newUniqueID returns a new unique record ID.  The function will hold the
-// unvettedLock if successful.  The callee is responsible for releasing the
-// lock.
+// tokenInUse returns true if a record with the provided id exists in either
+// repo or as an unvetted branch, or if the id was reissued.
 //
-// This function must be called without holding the unvetted lock.
-func (g *gitBackEnd) newUniqueID() (uint64, error) {
-	err := g.lock.Lock(LockDuration)
+// This function must be called with the lock held.
+func (g *gitBackEnd) tokenInUse(id string) (bool, error) {
+	for _, path := range []string{g.vetted, g.unvetted,
+		filepath.Join(g.root, defaultReissuedDirectory)} {
+		_, err := os.Stat(filepath.Join(path, id))
+		if err == nil {
+			return true, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return g.gitBranchExists(g.unvetted, recordBranch(id)), nil
+}
+
+// newToken returns a random censorship token that is not in use.  Collisions
+// are astronomically unlikely but this keeps New from clobbering an existing
+// record regardless.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) newToken() ([]byte, error) {
+	for i := 0; i < newTokenRetries; i++ {
+		token, err := util.Random(pd.TokenSize)
+		if err != nil {
+			return nil, err
+		}
+		inUse, err := g.tokenInUse(hex.EncodeToString(token))
+		if err != nil {
+			return nil, err
+		}
+		if !inUse {
+			return token, nil
+		}
+		log.Warnf("newToken: token collision %x", token)
+	}
+	return nil, fmt.Errorf("could not create unique token")
+}
+
+// contentToken returns the deterministic censorship token of a record with
+// the provided files in namespace.  It is the SHA256 digest of the namespace,
+// a zero byte and the sorted file digests.  Filenames and metadata are not
+// part of the token.
+func contentToken(namespace string, fa []file) []byte {
+	digests := make([]string, 0, len(fa))
+	for _, v := range fa {
+		digests = append(digests, string(v.digest))
+	}
+	sort.Strings(digests)
+
+	h := sha256.New()
+	h.Write([]byte(namespace))
+	h.Write([]byte{0})
+	for _, v := range digests {
+		h.Write([]byte(v))
+	}
+	return h.Sum(nil)[:pd.TokenSize]
+}
+
+// existingRecord returns the record metadata of id if it is a vetted or an
+// unvetted record and nil if it does not exist.  A reissued id is an error
+// since its record lives under a different token.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) existingRecord(id string) (*backend.RecordMetadata, error) {
+	_, err := os.Stat(filepath.Join(g.root, defaultReissuedDirectory, id))
+	if err == nil {
+		return nil, fmt.Errorf("token reissued: %v", id)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, err = os.Stat(filepath.Join(g.vetted, id))
+	if err == nil {
+		return loadMD(g.vetted, id)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if !g.gitBranchExists(g.unvetted, recordBranch(id)) {
+		return nil, nil
+	}
+	err = g.checkoutUnvetted(id)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer func() {
+		// git checkout master
+		err := g.gitCheckout(g.unvetted, "master")
+		if err != nil {
+			log.Errorf("could not switch to master: %v", err)
+		}
+	}()
+	brm, err := loadMD(g.unvetted, id)
+	if err == backend.ErrRecordNotFound {
+		// The branch holds no record
+		return nil, g.staleBranchError(id)
+	}
+	return brm, err
+}
+
+// validFilename returns true if name is a sanitized filename.  A filename may
+// be prefixed by one of the payloadDirs, separated by a forward slash, e.g.
+// images/logo.png.  Deeper nesting and path traversal are rejected.
+func validFilename(name string) bool {
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 1:
+		// A file may not shadow a payload directory
+		if payloadDirs[name] {
+			return false
+		}
+	case 2:
+		if !payloadDirs[parts[0]] {
+			return false
+		}
+	default:
+		return false
 	}
 
-	// Get Dirs.
-	files, err := ioutil.ReadDir(g.unvetted)
+	base := parts[len(parts)-1]
+	return base != "." && base != ".." && filepath.Base(base) == base &&
+		norma.Sanitize(base) == base
+}
+
+// payloadFiles returns the names of all files in the payload directory ppath.
+// Files that reside in one of the payloadDirs are named relative to ppath
+// using a forward slash, see validFilename.
+func payloadFiles(ppath string) ([]string, error) {
+	fi, err := ioutil.ReadDir(ppath)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	// Find biggest record ID
-	var last uint64
-	for _, file := range files {
-		// This check ignores lockFilename as well
-		if !file.IsDir() {
+	names := make([]string, 0, len(fi))
+	for _, v := range fi {
+		if !v.IsDir() {
+			names = append(names, v.Name())
 			continue
 		}
-		p, err := strconv.ParseUint(file.Name(), 10, 64)
+		if !payloadDirs[v.Name()] {
+			return nil, fmt.Errorf("record corrupt: unexpected "+
+				"directory %v", filepath.Join(ppath, v.Name()))
+		}
+		sub, err := ioutil.ReadDir(filepath.Join(ppath, v.Name()))
 		if err != nil {
-			continue
+			return nil, err
 		}
-		if p > last {
-			last = p
+		for _, vv := range sub {
+			if vv.IsDir() {
+				return nil, fmt.Errorf("record corrupt: "+
+					"unexpected directory %v", filepath.Join(ppath,
+					v.Name(), vv.Name()))
+			}
+			names = append(names, v.Name()+"/"+vv.Name())
 		}
 	}
-	id := last + 1
 
-	// Create directory
-	err = os.MkdirAll(filepath.Join(g.unvetted, strconv.FormatUint(id, 10)),
-		0774)
-	if err != nil {
-		return 0, err
-	}
+	return names, nil
+}
 
-	return id, nil
+// payloadFilename returns the on disk filename of the payload file name that
+// resides in ppath.
+func payloadFilename(ppath, name string) string {
+	return filepath.Join(ppath, filepath.FromSlash(name))
 }
 
 // verifyContent verifies that all provided backend.MetadataStream and
 // backend.File are sane and returns a cooked array of the files.
-func verifyContent(metadata []backend.MetadataStream, files []backend.File, filesDel []string) ([]file, error) {
+func verifyContent(metadata []backend.MetadataStream, files []backend.File, filesDel []string, maxFileSize int64) ([]file, error) {
 	// Make sure all metadata is within maxima.
 	for _, v := range metadata {
 		if v.ID > pd.MetadataStreamsMax-1 {
@@ -237,9 +596,10 @@ func verifyContent(metadata []backend.MetadataStream, files []backend.File, file
 		}
 	}
 
-	// Prevent paths
+	// Prevent paths outside of the payload directories and unsanitized
+	// filenames
 	for i := range files {
-		if filepath.Base(files[i].Name) != files[i].Name {
+		if !validFilename(files[i].Name) {
 			return nil, backend.ContentVerificationError{
 				ErrorCode: pd.ErrorStatusInvalidFilename,
 				ErrorContext: []string{
@@ -249,7 +609,7 @@ func verifyContent(metadata []backend.MetadataStream, files []backend.File, file
 		}
 	}
 	for _, v := range filesDel {
-		if filepath.Base(v) != v {
+		if !validFilename(v) {
 			return nil, backend.ContentVerificationError{
 				ErrorCode: pd.ErrorStatusInvalidFilename,
 				ErrorContext: []string{
@@ -296,15 +656,6 @@ func verifyContent(metadata []backend.MetadataStream, files []backend.File, file
 
 	fa := make([]file, 0, len(files))
 	for i := range files {
-		if norma.Sanitize(files[i].Name) != files[i].Name {
-			return nil, backend.ContentVerificationError{
-				ErrorCode: pd.ErrorStatusInvalidFilename,
-				ErrorContext: []string{
-					files[i].Name,
-				},
-			}
-		}
-
 		// Validate digest
 		d, ok := util.ConvertDigest(files[i].Digest)
 		if !ok {
@@ -319,6 +670,20 @@ func verifyContent(metadata []backend.MetadataStream, files []backend.File, file
 		// Setup cooked file.
 		f := file{
 			name: files[i].Name,
+			mime: files[i].MIME,
+		}
+
+		// Reject oversized payloads before decoding them so that the
+		// decoded buffer is never allocated.  A payload of maxFileSize
+		// bytes encodes to exactly EncodedLen(maxFileSize) characters.
+		if maxFileSize != 0 && int64(len(files[i].Payload)) >
+			int64(base64.StdEncoding.EncodedLen(int(maxFileSize))) {
+			return nil, backend.ContentVerificationError{
+				ErrorCode: pd.ErrorStatusFileTooLarge,
+				ErrorContext: []string{
+					files[i].Name,
+				},
+			}
 		}
 
 		// Decode base64 payload
@@ -333,6 +698,15 @@ func verifyContent(metadata []backend.MetadataStream, files []backend.File, file
 			}
 		}
 
+		if maxFileSize != 0 && int64(len(f.payload)) > maxFileSize {
+			return nil, backend.ContentVerificationError{
+				ErrorCode: pd.ErrorStatusFileTooLarge,
+				ErrorContext: []string{
+					files[i].Name,
+				},
+			}
+		}
+
 		// Calculate payload digest
 		dp := util.Digest(f.payload)
 		if !bytes.Equal(d[:], dp) {
@@ -345,8 +719,13 @@ func verifyContent(metadata []backend.MetadataStream, files []backend.File, file
 		}
 		f.digest = dp
 
-		// Verify MIME
+		// Verify MIME, text based formats that are detected as plain
+		// text may also be declared as their own type
 		detectedMIMEType := http.DetectContentType(f.payload)
+		if detectedMIMEType != files[i].MIME {
+			detectedMIMEType = mime.DetectMimeType(files[i].Name,
+				f.payload)
+		}
 		if detectedMIMEType != files[i].MIME {
 			return nil, backend.ContentVerificationError{
 				ErrorCode: pd.ErrorStatusInvalidMIMEType,
@@ -372,31 +751,117 @@ func verifyContent(metadata []backend.MetadataStream, files []backend.File, file
 	return fa, nil
 }
 
+// loadMIMETypes loads the declared MIME types of the files of record path/id
+// that differ from the type http.DetectContentType reports.  The map is empty
+// if there are none.
+func loadMIMETypes(path, id string) (map[string]string, error) {
+	mt := make(map[string]string)
+	b, err := ioutil.ReadFile(filepath.Join(path, id, defaultMIMEFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return mt, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, &mt)
+	if err != nil {
+		return nil, err
+	}
+	return mt, nil
+}
+
+// declareMIMETypes records the declared MIME types of fa in mt.  Only types
+// that differ from the type http.DetectContentType reports are kept, e.g. a
+// JSON file declared as application/json, see verifyContent.
+func declareMIMETypes(mt map[string]string, fa []file) {
+	for _, v := range fa {
+		if v.mime == http.DetectContentType(v.payload) {
+			delete(mt, v.name)
+			continue
+		}
+		mt[v.name] = v.mime
+	}
+}
+
+// writeMIMETypes writes mt as the declared MIME types of record path/id and
+// stages the change.  The file is removed once mt is empty.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) writeMIMETypes(path, id string, mt map[string]string) error {
+	filename := filepath.Join(path, id, defaultMIMEFilename)
+	if len(mt) == 0 {
+		_, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// git rm id/mimetypes.json
+		return g.gitRm(path, filepath.Join(id, defaultMIMEFilename))
+	}
+
+	b, err := json.Marshal(mt)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filename, b, g.fileModeOr(0664))
+	if err != nil {
+		return err
+	}
+	// git add id/mimetypes.json
+	return g.gitAdd(path, filename)
+}
+
 // loadRecord loads an entire record of disk.  It returns an array of
-// backend.File that is completely filled out.
+// backend.File that is completely filled out.  Files carry the MIME type they
+// were declared with.
 //
 // This function must be called with the lock held.
-func loadRecord(path, id string) ([]backend.File, error) {
+func (g *gitBackEnd) loadRecord(path, id string) ([]backend.File, error) {
 	// Get dir.
 	recordDir := filepath.Join(path, id, defaultPayloadDir)
-	files, err := ioutil.ReadDir(recordDir)
+	names, err := payloadFiles(recordDir)
+	if err != nil {
+		return nil, err
+	}
+	mt, err := loadMIMETypes(path, id)
 	if err != nil {
 		return nil, err
 	}
 
-	bf := make([]backend.File, 0, len(files))
+	bf := make([]backend.File, 0, len(names))
 	// Load all files
-	for _, file := range files {
-		fn := filepath.Join(recordDir, file.Name())
-		if file.IsDir() {
-			return nil, fmt.Errorf("record corrupt: %v", path)
+	for _, name := range names {
+		filename := payloadFilename(recordDir, name)
+		pt, err := g.purgedPayload(filename)
+		if err != nil {
+			return nil, err
 		}
-
-		f := backend.File{Name: file.Name()}
-		f.MIME, f.Digest, f.Payload, err = util.LoadFile(fn)
+		if pt != nil {
+			// Content was purged, see PurgeFile
+			f := backend.File{
+				Name:   name,
+				MIME:   pt.MIME,
+				Digest: pt.Digest,
+			}
+			if v, ok := mt[name]; ok {
+				f.MIME = v
+			}
+			bf = append(bf, f)
+			continue
+		}
+		b, err := g.readPayload(filename)
+		if err != nil {
+			return nil, err
+		}
+		f := backend.File{Name: name}
+		f.MIME, f.Digest, f.Payload, err = util.EncodeFile(b)
 		if err != nil {
 			return nil, err
 		}
+		if v, ok := mt[name]; ok {
+			f.MIME = v
+		}
 		bf = append(bf, f)
 	}
 
@@ -429,11 +894,15 @@ func loadMDStreams(path, id string) ([]backend.MetadataStream, error) {
 			continue
 		}
 
-		// Fish out metadata stream ID from filename
+		// Fish out metadata stream ID from filename.  Skip stray files
+		// so that they don't make the entire record unreadable.
 		ids := strings.TrimSuffix(v.Name(), defaultMDFilenameSuffix)
 		mdid, err := strconv.ParseUint(ids, 10, 64)
 		if err != nil {
-			return nil, err
+			log.Warnf("loadMDStreams: skipping invalid metadata "+
+				"filename %v: %v", filepath.Join(dir, v.Name()),
+				err)
+			continue
 		}
 
 		// Load metadata stream
@@ -475,11 +944,28 @@ func loadMD(path, id string) (*backend.RecordMetadata, error) {
 	return &brm, nil
 }
 
+// fileModeOr returns the configured file mode or def if none was configured.
+func (g *gitBackEnd) fileModeOr(def os.FileMode) os.FileMode {
+	if g.fileMode == 0 {
+		return def
+	}
+	return g.fileMode
+}
+
+// dirModeOr returns the configured directory mode or def if none was
+// configured.
+func (g *gitBackEnd) dirModeOr(def os.FileMode) os.FileMode {
+	if g.dirMode == 0 {
+		return def
+	}
+	return g.dirMode
+}
+
 // createMD stores a RecordMetadata to the provided path/id.  This may be
 // unvetted/id or vetted/id.
 //
 // This function should be called with the lock held.
-func createMD(path, id string, status backend.MDStatusT, version uint, hashes []*[sha256.Size]byte, token []byte) (*backend.RecordMetadata, error) {
+func (g *gitBackEnd) createMD(path, id string, status backend.MDStatusT, version uint, hashes []*[sha256.Size]byte, token []byte) (*backend.RecordMetadata, error) {
 	// Create record metadata
 	brm := backend.RecordMetadata{
 		Version:   version,
@@ -489,7 +975,7 @@ func createMD(path, id string, status backend.MDStatusT, version uint, hashes []
 		Token:     token,
 	}
 
-	err := updateMD(path, id, &brm)
+	err := g.updateMD(path, id, &brm)
 	if err != nil {
 		return nil, err
 	}
@@ -500,10 +986,11 @@ func createMD(path, id string, status backend.MDStatusT, version uint, hashes []
 // updateMD updates the RecordMetadata status to the provided path/id.
 //
 // This function should be called with the lock held.
-func updateMD(path, id string, brm *backend.RecordMetadata) error {
+func (g *gitBackEnd) updateMD(path, id string, brm *backend.RecordMetadata) error {
 	// Store metadata record.
 	filename := filepath.Join(path, id, defaultRecordMetadataFilename)
-	f, err := os.Create(filename)
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC,
+		g.fileModeOr(0666))
 	if err != nil {
 		return err
 	}
@@ -529,19 +1016,26 @@ func (g *gitBackEnd) commitMD(path, id, msg string) error {
 }
 
 // deltaCommits returns sha1 extended digests and one line commit messages to
-// the caller.  If lastAnchor is empty then the range is from the dawn of time
-// until now.  If lastAnchor is a valid hash the range is from lastAnchor up
-// until no.
+// the caller.  If lastAnchor is empty then the range is from the initial
+// commit, which gitInitRepo always creates, until now.  If lastAnchor is a
+// valid hash the range is from lastAnchor until now.  Anchor commits are never
+// part of the range.  If cutoff is not zero the commits above the newest
+// commit that was made at or before cutoff are left for a later anchor, the
+// range always ends in a single cut so that the next anchor can pick up where
+// this one stopped.  errNothingToDo is returned if lastAnchor is the latest
+// commit or if the range only holds commits that are not anchored.  An empty
+// range means that the last anchor is not an ancestor of the latest commit and
+// is an error.
 //
 // This function should be called with the lock held.
-func (g *gitBackEnd) deltaCommits(path string, lastAnchor []byte) ([]*[sha256.Size]byte, []string, []string, error) {
+func (g *gitBackEnd) deltaCommits(path string, lastAnchor []byte, cutoff int64) ([]*[sha256.Size]byte, []string, []string, error) {
 	// Sanity
 	if !(len(lastAnchor) == 0 || len(lastAnchor) == sha256.Size) {
 		return nil, nil, nil, fmt.Errorf("invalid digest size")
 	}
 
-	// Minimal git arguments
-	args := []string{"log", "--pretty=oneline"}
+	// Minimal git arguments, "<digest> <commit time> <commit message>"
+	args := []string{"log", "--pretty=format:%H %ct %s"}
 
 	// Determine digest range
 	latestCommit, err := g.gitLastDigest(path)
@@ -564,26 +1058,49 @@ func (g *gitBackEnd) deltaCommits(path string, lastAnchor []byte) ([]*[sha256.Si
 		return nil, nil, nil, err
 	}
 	if len(out) == 0 {
-		return nil, nil, nil, fmt.Errorf("invalid git output")
+		// The last anchor is not in the history, e.g. master was
+		// reset below it
+		return nil, nil, nil, fmt.Errorf("invalid git output: no "+
+			"commits after %x", lastAnchor)
 	}
 
 	// Generate return data
 	digests := make([]*[sha256.Size]byte, 0, len(out))
 	commitMessages := make([]string, 0, len(out))
+	deferred := 0
 	for _, line := range out {
-		// Returned data is "<digest> <commit message>"
-		ds := strings.SplitN(line, " ", 2)
-		if len(ds) == 0 {
+		// Returned data is "<digest> <commit time> <commit message>"
+		ds := strings.SplitN(line, " ", 3)
+		if len(ds) != 3 {
 			return nil, nil, nil, fmt.Errorf("invalid log")
 		}
+		hash, commitTime, message := ds[0], ds[1], ds[2]
 
-		// Ignore anchor confirmation commits
-		if regexAnchorConfirmation.MatchString(ds[1]) {
+		// Ignore anchor and anchor confirmation commits
+		am, err := parseAnchorMessage(message)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if am != nil {
 			continue
 		}
 
+		// Leave young commits for a later anchor until the first
+		// commit that is old enough
+		if cutoff != 0 && len(digests) == 0 {
+			ct, err := strconv.ParseInt(commitTime, 10, 64)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid "+
+					"commit time: %v", commitTime)
+			}
+			if ct > cutoff {
+				deferred++
+				continue
+			}
+		}
+
 		// Validate returned digest
-		sha1Digest, err := hex.DecodeString(ds[0])
+		sha1Digest, err := hex.DecodeString(hash)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -596,9 +1113,13 @@ func (g *gitBackEnd) deltaCommits(path string, lastAnchor []byte) ([]*[sha256.Si
 
 		// Fill out return values
 		digests = append(digests, &sha256Digest)
-		commitMessages = append(commitMessages, ds[1])
+		commitMessages = append(commitMessages, message)
 	}
 
+	if deferred != 0 {
+		log.Infof("Deferring %v commits younger than %v to the next "+
+			"anchor", deferred, time.Unix(cutoff, 0))
+	}
 	if len(digests) == 0 {
 		return nil, nil, nil, errNothingToDo
 	}
@@ -619,6 +1140,8 @@ func (g *gitBackEnd) deltaCommits(path string, lastAnchor []byte) ([]*[sha256.Si
 // This function should be called with the lock held.
 // TODO: the physical write to dcrtime needs to come out of the lock.
 func (g *gitBackEnd) anchor(digests []*[sha256.Size]byte) error {
+	g.testHook(testPointDcrtime)
+
 	// Anchor all digests
 	if g.test {
 		// We always append the anchorKey as the last element
@@ -627,13 +1150,13 @@ func (g *gitBackEnd) anchor(digests []*[sha256.Size]byte) error {
 		return nil
 	}
 
-	return util.Timestamp(g.dcrtimeHost, digests)
+	return util.TimestampWithClient(g.httpClient, g.dcrtimeHost, digests)
 }
 
 // appendAuditTrail adds a record to the audit trail.
 func (g *gitBackEnd) appendAuditTrail(path string, ts int64, merkle [sha256.Size]byte, lines []string) error {
 	f, err := os.OpenFile(filepath.Join(path, defaultAuditTrailFile),
-		os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+		os.O_RDWR|os.O_CREATE|os.O_APPEND, g.fileModeOr(0644))
 	if err != nil {
 		return err
 	}
@@ -647,6 +1170,46 @@ func (g *gitBackEnd) appendAuditTrail(path string, ts int64, merkle [sha256.Size
 	return nil
 }
 
+// changedRecordMerkles returns the merkle roots of the vetted records that
+// changed since commit start, all records if start is empty or unknown, along
+// with their anchor commit messages.  Records are in token order.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) changedRecordMerkles(start []byte) ([]*[sha256.Size]byte, []string, error) {
+	var (
+		ids []string
+		ok  bool
+	)
+	if len(start) != 0 {
+		ids, ok = g.changedVettedIDs(hex.EncodeToString(
+			unextendSHA256(start)))
+	}
+	if !ok {
+		var err error
+		ids, err = g.vettedIDs()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	sort.Strings(ids)
+
+	digests := make([]*[sha256.Size]byte, 0, len(ids))
+	messages := make([]string, 0, len(ids))
+	for _, id := range ids {
+		brm, err := loadMD(g.vetted, id)
+		if err == backend.ErrRecordNotFound {
+			// Record is gone
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		d := brm.Merkle
+		digests = append(digests, &d)
+		messages = append(messages, recordMerkleMessage(id))
+	}
+	return digests, messages, nil
+}
+
 // anchorRepo drops an anchor for an individual repo.
 // It prints the basename during its actions.
 //
@@ -667,6 +1230,17 @@ func (g *gitBackEnd) anchorRepo(path string) (*[sha256.Size]byte, error) {
 		return nil, fmt.Errorf("anchor fsck master %v: %v", repo, err)
 	}
 
+	// Commit the manifest first so that it is anchored along with the
+	// records it lists.  With MinAnchorAge set the manifest commit is
+	// always too young and is left for a later anchor, Manifest only
+	// returns anchored manifests.
+	if path == g.vetted {
+		err = g.updateManifest()
+		if err != nil {
+			return nil, fmt.Errorf("update manifest: %v", err)
+		}
+	}
+
 	// Check for unanchored commits
 	last, err := g.readLastAnchorRecord()
 	if err != nil {
@@ -674,8 +1248,19 @@ func (g *gitBackEnd) anchorRepo(path string) (*[sha256.Size]byte, error) {
 			err)
 	}
 
+	// Continue after the newest commit that was anchored, commits that
+	// were deferred by the last anchor sit below it.
+	start := last.Last
+	if len(last.Covered) != 0 {
+		start = last.Covered
+	}
+	var cutoff int64
+	if g.minAnchorAge != 0 {
+		cutoff = time.Now().Add(-g.minAnchorAge).Unix()
+	}
+
 	// Fill out unvetted digests
-	digests, messages, _, err := g.deltaCommits(path, last.Last)
+	digests, messages, _, err := g.deltaCommits(path, start, cutoff)
 	if err != nil {
 		if err == errNothingToDo {
 			return nil, err
@@ -689,6 +1274,18 @@ func (g *gitBackEnd) anchorRepo(path string) (*[sha256.Size]byte, error) {
 			len(digests), len(messages))
 	}
 
+	// Anchor the merkle roots of the records that changed along with the
+	// commits, see "Anchor commit messages".  They are listed after the
+	// commits so that the newest commit remains first.
+	if path == g.vetted {
+		rd, rm, err := g.changedRecordMerkles(start)
+		if err != nil {
+			return nil, fmt.Errorf("record merkle roots: %v", err)
+		}
+		digests = append(digests, rd...)
+		messages = append(messages, rm...)
+	}
+
 	// Create commit message BEFORE calling anchor.  anchor calls
 	// merkle.Root which in turn sorts the digests and that is fine but not
 	// what we want to display to the user.
@@ -720,9 +1317,15 @@ func (g *gitBackEnd) anchorRepo(path string) (*[sha256.Size]byte, error) {
 		return nil, fmt.Errorf("anchor: %v", err)
 	}
 
-	// Prefix commitMessage with merkle root
-	commitMessage = fmt.Sprintf("%v %x\n\n%v", markerAnchor, *anchorKey,
-		commitMessage)
+	// Prefix commitMessage with merkle root and attribute the anchor to
+	// the server
+	am := anchorMessage{
+		merkle: hex.EncodeToString(anchorKey[:]),
+	}
+	if g.identity != nil {
+		am.signature = signAnchor(g.identity, am.merkle)
+	}
+	commitMessage = am.String() + "\n\n" + commitMessage
 
 	// Commit merkle root as an anchor and append included commits to audit
 	// trail
@@ -741,17 +1344,52 @@ func (g *gitBackEnd) anchorRepo(path string) (*[sha256.Size]byte, error) {
 		return nil, fmt.Errorf("gitCommit: %v", err)
 	}
 
+	// Notify hook
+	ai := backend.AnchorInfo{
+		Merkle:    am.merkle,
+		Time:      anchorRecord.Time,
+		Digests:   make([]string, 0, len(anchorRecord.Digests)),
+		Signature: am.signature,
+	}
+	for _, d := range anchorRecord.Digests {
+		ai.Digests = append(ai.Digests, hex.EncodeToString(d))
+	}
+	g.notifyAnchor(ai)
+
 	return anchorKey, nil
 }
 
 // anchor verifies if there are new commits in all repos and if that is the
-// case it drops and anchor in dcrtime for each of them.
+// case it drops and anchor in dcrtime for each of them.  The outcome is
+// recorded for Status.
 func (g *gitBackEnd) anchorAllRepos() error {
 	log.Infof("Dropping anchor")
+	attempt := time.Now().Unix()
+	mr, err := g.dropAnchor()
+	g.setAnchorHealth(attempt, mr, err)
+	if err != nil {
+		if err == errNothingToDo {
+			log.Infof("Anchoring %v: nothing to do", g.vetted)
+			return nil
+		}
+		return err
+	}
+
+	log.Infof("Dropping anchor complete: %x", *mr)
+
+	return nil
+}
+
+// dropAnchor does the work for anchorAllRepos.  It returns the merkle root of
+// the anchor or errNothingToDo if there were no new commits.
+func (g *gitBackEnd) dropAnchor() (*[sha256.Size]byte, error) {
+	t := newOpTimer("anchorRepo")
+	defer t.done()
+
 	// Lock filesystem
 	err := g.lock.Lock(LockDuration)
 	if err != nil {
-		return fmt.Errorf("anchorAllRepos lock error: %v", err)
+		return nil, fmt.Errorf("anchorAllRepos lock error: %v", err)
 	}
 	defer func() {
 		err := g.lock.Unlock()
@@ -759,8 +1397,11 @@ func (g *gitBackEnd) anchorAllRepos() error {
 			log.Errorf("anchorAllRepos unlock error: %v", err)
 		}
 	}()
+	t.lockAcquired()
+	g.testHook(testPointLocked)
 	if g.shutdown {
-		return fmt.Errorf("anchorAllRepos: %v", backend.ErrShutdown)
+		return nil, fmt.Errorf("anchorAllRepos: %v",
+			backend.ErrShutdown)
 	}
 
 	//  Anchor vetted
@@ -768,10 +1409,9 @@ func (g *gitBackEnd) anchorAllRepos() error {
 	mr, err := g.anchorRepo(g.vetted)
 	if err != nil {
 		if err == errNothingToDo {
-			log.Infof("Anchoring %v: nothing to do", g.vetted)
-			return nil
+			return nil, err
 		}
-		return fmt.Errorf("anchor repo %v: %v", g.vetted, err)
+		return nil, fmt.Errorf("anchor repo %v: %v", g.vetted, err)
 	}
 
 	// Sync vetted to unvetted
@@ -779,12 +1419,29 @@ func (g *gitBackEnd) anchorAllRepos() error {
 	// git pull --ff-only --rebase
 	err = g.gitPull(g.unvetted, true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Infof("Dropping anchor complete: %x", *mr)
+	return mr, nil
+}
 
-	return nil
+// setAnchorHealth records the outcome of the anchor attempt that started at
+// attempt.
+func (g *gitBackEnd) setAnchorHealth(attempt int64, mr *[sha256.Size]byte, err error) {
+	g.anchorHealthMtx.Lock()
+	defer g.anchorHealthMtx.Unlock()
+
+	g.anchorHealth.LastAttempt = attempt
+	switch {
+	case err == errNothingToDo:
+		g.anchorHealth.LastSkip = "nothing to anchor"
+	case err != nil:
+		g.anchorHealth.LastSkip = err.Error()
+	default:
+		g.anchorHealth.LastSkip = ""
+		g.anchorHealth.LastSuccess = time.Now().Unix()
+		g.anchorHealth.LastMerkle = hex.EncodeToString(mr[:])
+	}
 }
 
 // periodicAnchorChecker must be run as a go routine.  It sits around and
@@ -798,12 +1455,16 @@ func (g *gitBackEnd) periodicAnchorChecker() {
 		case <-g.exit:
 			return
 		case <-g.checkAnchor:
-		case <-time.After(5 * time.Minute):
+		case <-time.After(g.anchorPoll):
 		}
 
-		if g.shutdown {
+		if g.isShutdown() {
 			return
 		}
+		if g.isAnchoringPaused() {
+			log.Debugf("periodicAnchorChecker: anchoring paused")
+			continue
+		}
 
 		// Do lengthy work, this may have to be its own go routine
 		err := g.anchorChecker()
@@ -811,6 +1472,10 @@ func (g *gitBackEnd) periodicAnchorChecker() {
 			// Not much we can do past logging
 			log.Errorf("periodicAnchorChecker: %v", err)
 		}
+		err = g.externalAnchorChecker()
+		if err != nil {
+			log.Errorf("periodicAnchorChecker: %v", err)
+		}
 	}
 }
 
@@ -821,6 +1486,13 @@ func (g *gitBackEnd) anchorChecker() error {
 	if err != nil {
 		return fmt.Errorf("anchorChecker read: %v", err)
 	}
+	pruned, err := g.pruneUnconfirmedAnchors(ua)
+	if err != nil {
+		return fmt.Errorf("anchorChecker prune: %v", err)
+	}
+	for _, merkle := range pruned {
+		log.Debugf("anchorChecker: already confirmed: %v", merkle)
+	}
 
 	// Check for work
 	if len(ua.Merkles) == 0 {
@@ -873,9 +1545,23 @@ func (g *gitBackEnd) afterAnchorVerify(vrs []v1.VerifyDigest) error {
 	for _, vr := range vrs {
 		if vr.ChainInformation.ChainTimestamp == 0 {
 			// dcrtime returns 0 when there are not enough
-			// confirmations yet.
-			return fmt.Errorf("not enough confirmations: %v",
+			// confirmations yet.  Leave it in the unconfirmed set
+			// so that it is picked up during the next go-round.
+			log.Debugf("afterAnchorVerify: not enough "+
+				"confirmations: %v", vr.Digest)
+			continue
+		}
+
+		// Skip anchors that were confirmed out of band since they
+		// were read, e.g. by fsck.
+		ci, err := g.readAnchorChainInformation(vr.Digest)
+		if err != nil {
+			return err
+		}
+		if ci != nil {
+			log.Debugf("afterAnchorVerify: already confirmed: %v",
 				vr.Digest)
+			continue
 		}
 
 		// Use the audit trail as the file to be committed
@@ -883,10 +1569,17 @@ func (g *gitBackEnd) afterAnchorVerify(vrs []v1.VerifyDigest) error {
 		if !ok {
 			return fmt.Errorf("invalid digest: %v", vr.Digest)
 		}
-		txLine := fmt.Sprintf("%v anchored in TX %v\n", vr.Digest,
+		txLine := auditTrailTXLine(vr.Digest,
 			vr.ChainInformation.Transaction)
+		delay, err := g.confirmationDelay(mr,
+			vr.ChainInformation.ChainTimestamp)
+		if err != nil {
+			return err
+		}
+		log.Infof("Anchor %v confirmed after %vs", vr.Digest, delay)
 		err = g.appendAuditTrail(g.vetted,
-			vr.ChainInformation.ChainTimestamp, mr, []string{txLine})
+			vr.ChainInformation.ChainTimestamp, mr,
+			[]string{txLine, auditTrailDelayLine(vr.Digest, delay)})
 		if err != nil {
 			return err
 		}
@@ -901,7 +1594,7 @@ func (g *gitBackEnd) afterAnchorVerify(vrs []v1.VerifyDigest) error {
 		// In Vetted in the record directory add a file called anchor
 		// that points to the TX id.
 		anchorDir := filepath.Join(g.vetted, defaultAnchorsDirectory)
-		err = os.MkdirAll(anchorDir, 0774)
+		err = os.MkdirAll(anchorDir, g.dirModeOr(0774))
 		if err != nil {
 			return err
 		}
@@ -910,7 +1603,7 @@ func (g *gitBackEnd) afterAnchorVerify(vrs []v1.VerifyDigest) error {
 			return err
 		}
 		err = ioutil.WriteFile(filepath.Join(anchorDir, vr.Digest),
-			ar, 0664)
+			ar, g.fileModeOr(0664))
 		if err != nil {
 			return err
 		}
@@ -921,7 +1614,12 @@ func (g *gitBackEnd) afterAnchorVerify(vrs []v1.VerifyDigest) error {
 		}
 
 		// git commit anchor confirmation
-		commitMsg := markerAnchorConfirmation + " " + vr.Digest + "\n\n" + txLine
+		commitMsg := anchorMessage{
+			confirmation:   true,
+			merkle:         vr.Digest,
+			transaction:    vr.ChainInformation.Transaction,
+			chainTimestamp: vr.ChainInformation.ChainTimestamp,
+		}.String() + "\n\n" + txLine
 		err = g.gitCommit(g.vetted, commitMsg)
 		if err != nil {
 			return err
@@ -931,6 +1629,9 @@ func (g *gitBackEnd) afterAnchorVerify(vrs []v1.VerifyDigest) error {
 		if g.test {
 			g.testAnchors[vr.Digest] = true
 		}
+
+		// Notify hook
+		g.notifyAnchorConfirmed(vr, mr)
 	}
 	if len(vrs) != 0 {
 		// git checkout master unvetted
@@ -950,7 +1651,17 @@ func (g *gitBackEnd) afterAnchorVerify(vrs []v1.VerifyDigest) error {
 }
 
 // anchorAllReposCronJob is the cron job that anchors all repos at a preset time.
+// While anchoring is paused the anchor is deferred until ResumeAnchoring.
 func (g *gitBackEnd) anchorAllReposCronJob() {
+	g.anchorPauseMtx.Lock()
+	if g.anchorPaused {
+		g.anchorDeferred = true
+		g.anchorPauseMtx.Unlock()
+		log.Infof("Anchoring paused, deferring anchor")
+		return
+	}
+	g.anchorPauseMtx.Unlock()
+
 	err := g.anchorAllRepos()
 	if err != nil {
 		log.Errorf("%v", err)
@@ -958,13 +1669,18 @@ func (g *gitBackEnd) anchorAllReposCronJob() {
 }
 
 // verifyAnchor asks dcrtime if an anchor has been verified and returns a TX if
-// it has.
+// it has.  dcrtime replies are not signed, the reply is trusted because it was
+// received over TLS from the configured dcrtime host.  Operators that don't
+// want to trust every certificate authority with that pin the dcrtime
+// certificate, see util.NewPinnedDcrtimeClient.
 func (g *gitBackEnd) verifyAnchor(digest string) (*v1.VerifyDigest, error) {
 	var (
 		vr  *v1.VerifyReply
 		err error
 	)
 
+	g.testHook(testPointDcrtime)
+
 	// In test mode we fake success.
 	if g.test {
 		// Fake success
@@ -986,7 +1702,8 @@ func (g *gitBackEnd) verifyAnchor(digest string) (*v1.VerifyDigest, error) {
 		})
 	} else {
 		// Call dcrtime
-		vr, err = util.Verify(g.dcrtimeHost, []string{digest})
+		vr, err = util.VerifyWithClient(g.httpClient, g.dcrtimeHost,
+			[]string{digest})
 		if err != nil {
 			return nil, err
 		}
@@ -1012,15 +1729,20 @@ func (g *gitBackEnd) verifyAnchor(digest string) (*v1.VerifyDigest, error) {
 func (g *gitBackEnd) newRecord(token []byte, metadata []backend.MetadataStream, fa []file) (*backend.RecordMetadata, error) {
 	id := hex.EncodeToString(token)
 
-	// git checkout -b id
-	err := g.gitNewBranch(g.unvetted, id)
+	// A left over branch would make git fail opaquely
+	if g.gitBranchExists(g.unvetted, recordBranch(id)) {
+		return nil, g.staleBranchError(id)
+	}
+
+	// git checkout -b records/id
+	err := g.gitNewBranch(g.unvetted, recordBranch(id))
 	if err != nil {
 		return nil, err
 	}
 
 	// Process files.
 	path := filepath.Join(g.unvetted, id, defaultPayloadDir)
-	err = os.MkdirAll(path, 0774)
+	err = os.MkdirAll(path, g.dirModeOr(0774))
 	if err != nil {
 		return nil, err
 	}
@@ -1028,8 +1750,13 @@ func (g *gitBackEnd) newRecord(token []byte, metadata []backend.MetadataStream,
 	hashes := make([]*[sha256.Size]byte, 0, len(fa))
 	for i := range fa {
 		// Copy files into directory id/payload/filename.
-		filename := filepath.Join(path, fa[i].name)
-		err = ioutil.WriteFile(filename, fa[i].payload, 0664)
+		filename := payloadFilename(path, fa[i].name)
+		err = os.MkdirAll(filepath.Dir(filename), g.dirModeOr(0774))
+		if err != nil {
+			return nil, err
+		}
+		err = g.writeUnvettedPayload(filename, fa[i].payload,
+			fa[i].digest)
 		if err != nil {
 			return nil, err
 		}
@@ -1045,12 +1772,20 @@ func (g *gitBackEnd) newRecord(token []byte, metadata []backend.MetadataStream,
 
 	}
 
+	// Save the declared MIME types
+	mt := make(map[string]string)
+	declareMIMETypes(mt, fa)
+	err = g.writeMIMETypes(g.unvetted, id, mt)
+	if err != nil {
+		return nil, err
+	}
+
 	// Save all metadata streams
 	for i := range metadata {
 		filename := filepath.Join(g.unvetted, id, fmt.Sprintf("%02v%v",
 			metadata[i].ID, defaultMDFilenameSuffix))
 		err = ioutil.WriteFile(filename, []byte(metadata[i].Payload),
-			0664)
+			g.fileModeOr(0664))
 		if err != nil {
 			return nil, err
 		}
@@ -1062,7 +1797,7 @@ func (g *gitBackEnd) newRecord(token []byte, metadata []backend.MetadataStream,
 	}
 
 	// Save record metadata
-	brm, err := createMD(g.unvetted, id, backend.MDStatusUnvetted, 1,
+	brm, err := g.createMD(g.unvetted, id, backend.MDStatusUnvetted, 1,
 		hashes, token)
 	if err != nil {
 		return nil, err
@@ -1090,17 +1825,27 @@ func (g *gitBackEnd) newRecord(token []byte, metadata []backend.MetadataStream,
 //
 // New satisfies the backend interface.
 func (g *gitBackEnd) New(metadata []backend.MetadataStream, files []backend.File) (*backend.RecordMetadata, error) {
-	fa, err := verifyContent(metadata, files, []string{})
+	fa, err := verifyContent(metadata, files, []string{}, g.maxFileSize)
 	if err != nil {
 		return nil, err
 	}
-
-	// Create a censorship token.
-	token, err := util.Random(pd.TokenSize)
-	if err != nil {
+	var mdSize int64
+	for _, v := range metadata {
+		mdSize += int64(len(v.Payload))
+	}
+	err = g.checkMDLimits(len(metadata), mdSize)
+	if err != nil {
 		return nil, err
 	}
 
+	// Only valid requests count against the rate limit
+	if !g.allow() {
+		return nil, backend.ErrRateLimited
+	}
+
+	t := newOpTimer("New")
+	defer t.done()
+
 	// Lock filesystem
 	err = g.lock.Lock(LockDuration)
 	if err != nil {
@@ -1112,6 +1857,8 @@ func (g *gitBackEnd) New(metadata []backend.MetadataStream, files []backend.File
 			log.Errorf("Unlock error: %v", err)
 		}
 	}()
+	t.lockAcquired()
+	g.testHook(testPointLocked)
 	if g.shutdown {
 		return nil, backend.ErrShutdown
 	}
@@ -1128,6 +1875,34 @@ func (g *gitBackEnd) New(metadata []backend.MetadataStream, files []backend.File
 		return nil, err
 	}
 
+	// Create a censorship token.
+	var token []byte
+	if g.tokenNamespace != "" {
+		token = contentToken(g.tokenNamespace, fa)
+		brm, err := g.existingRecord(hex.EncodeToString(token))
+		if err != nil {
+			return nil, err
+		}
+		if brm != nil {
+			log.Debugf("New: content exists %x", token)
+			return brm, nil
+		}
+	} else {
+		token, err = g.newToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Don't let the review queue grow without bounds
+	if g.maxUnvetted != 0 {
+		n := len(g.pending)
+		if n >= g.maxUnvetted {
+			log.Debugf("New: %v records pending review", n)
+			return nil, backend.ErrTooManyUnvetted
+		}
+	}
+
 	var errReturn error
 	brm, err := g.newRecord(token, metadata, fa)
 	if err != nil {
@@ -1141,6 +1916,8 @@ func (g *gitBackEnd) New(metadata []backend.MetadataStream, files []backend.File
 
 		brm = nil
 		errReturn = err
+	} else {
+		g.setPending(hex.EncodeToString(token), brm.Status)
 	}
 
 	// git checkout master
@@ -1152,6 +1929,66 @@ func (g *gitBackEnd) New(metadata []backend.MetadataStream, files []backend.File
 	return brm, errReturn
 }
 
+// isPending returns true if a record with status is pending review.
+func isPending(status backend.MDStatusT) bool {
+	switch status {
+	case backend.MDStatusUnvetted, backend.MDStatusIterationUnvetted:
+		return true
+	}
+	return false
+}
+
+// pendingReview returns the metadata of all records that are pending review in
+// no particular order.  The record metadata is read straight from the record
+// branches.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) pendingReview() ([]backend.RecordMetadata, error) {
+	ids, err := g.recordBranches(g.unvetted)
+	if err != nil {
+		return nil, err
+	}
+	queue := make([]backend.RecordMetadata, 0, len(ids))
+	for _, id := range ids {
+		brm, err := g.loadUnvettedMD(id)
+		if err != nil {
+			return nil, err
+		}
+		if isPending(brm.Status) {
+			queue = append(queue, *brm)
+		}
+	}
+	return queue, nil
+}
+
+// loadPending initializes the set of records pending review that enforces
+// MaxUnvetted, see setPending.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) loadPending() error {
+	queue, err := g.pendingReview()
+	if err != nil {
+		return err
+	}
+	g.pending = make(map[string]struct{}, len(queue))
+	for _, v := range queue {
+		g.pending[hex.EncodeToString(v.Token)] = struct{}{}
+	}
+	return nil
+}
+
+// setPending records the status of record id once it has been committed so
+// that New does not have to scan all record branches to enforce MaxUnvetted.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) setPending(id string, status backend.MDStatusT) {
+	if isPending(status) {
+		g.pending[id] = struct{}{}
+		return
+	}
+	delete(g.pending, id)
+}
+
 // updateMetadata appends or overwrites in the unvetted repository.
 // Additionally it does the git bits when called.
 // Function must be called with the lock held.
@@ -1161,7 +1998,7 @@ func (g *gitBackEnd) updateMetadata(id string, mdAppend, mdOverwrite []backend.M
 		filename := filepath.Join(g.unvetted, id, fmt.Sprintf("%02v%v",
 			mdOverwrite[i].ID, defaultMDFilenameSuffix))
 		err := ioutil.WriteFile(filename, []byte(mdOverwrite[i].Payload),
-			0664)
+			g.fileModeOr(0664))
 		if err != nil {
 			return err
 		}
@@ -1177,7 +2014,7 @@ func (g *gitBackEnd) updateMetadata(id string, mdAppend, mdOverwrite []backend.M
 		filename := filepath.Join(g.unvetted, id, fmt.Sprintf("%02v%v",
 			mdAppend[i].ID, defaultMDFilenameSuffix))
 		f, err := os.OpenFile(filename,
-			os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+			os.O_RDWR|os.O_CREATE|os.O_APPEND, g.fileModeOr(0644))
 		if err != nil {
 			return err
 		}
@@ -1193,20 +2030,92 @@ func (g *gitBackEnd) updateMetadata(id string, mdAppend, mdOverwrite []backend.M
 			return err
 		}
 	}
+
+	return g.verifyMDLimits(id)
+}
+
+// allow consults the rate limiter, if any, once a record creation or update
+// request has been validated.
+func (g *gitBackEnd) allow() bool {
+	return g.rateLimiter == nil || g.rateLimiter.Allow()
+}
+
+// checkMDLimits returns a backend.ContentVerificationError if count metadata
+// streams with a total size of size bytes exceed the configured limits.
+func (g *gitBackEnd) checkMDLimits(count int, size int64) error {
+	if g.maxMDStreams != 0 && count > g.maxMDStreams {
+		return backend.ContentVerificationError{
+			ErrorCode: pd.ErrorStatusTooManyMDStreams,
+			ErrorContext: []string{
+				strconv.Itoa(count),
+			},
+		}
+	}
+	if g.maxMDSize != 0 && size > g.maxMDSize {
+		return backend.ContentVerificationError{
+			ErrorCode: pd.ErrorStatusMDTooLarge,
+			ErrorContext: []string{
+				strconv.FormatInt(size, 10),
+			},
+		}
+	}
 	return nil
 }
 
+// pluginMDStreams are the metadata streams that are written by the decred
+// plugin.  They grow with the vote and are therefore not counted against the
+// metadata limits, which only apply to the streams clients supply.
+var pluginMDStreams = map[uint64]struct{}{
+	decredplugin.MDStreamVotes:        {},
+	decredplugin.MDStreamVoteBits:     {},
+	decredplugin.MDStreamVoteSnapshot: {},
+	decredplugin.MDStreamVoteCancel:   {},
+	decredplugin.MDStreamVoteResults:  {},
+}
+
+// verifyMDLimits verifies that the metadata streams of record id in the
+// unvetted repo are within the configured limits.  Appended streams are
+// checked by their resulting size.  Plugin streams are not counted, see
+// pluginMDStreams.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) verifyMDLimits(id string) error {
+	if g.maxMDStreams == 0 && g.maxMDSize == 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(filepath.Join(g.unvetted, id))
+	if err != nil {
+		return err
+	}
+	var (
+		count int
+		size  int64
+	)
+	for _, v := range files {
+		if v.IsDir() {
+			continue
+		}
+		mdid, ok := mdStreamID(v.Name())
+		if !ok {
+			continue
+		}
+		if _, ok := pluginMDStreams[mdid]; ok {
+			continue
+		}
+		count++
+		size += v.Size()
+	}
+	return g.checkMDLimits(count, size)
+}
+
 func (g *gitBackEnd) checkoutRecordBranch(id string) (bool, error) {
 	// See if branch already exists
-	branches, err := g.gitBranches(g.unvetted)
+	ids, err := g.recordBranches(g.unvetted)
 	if err != nil {
 		return false, err
 	}
 	var found bool
-	for _, v := range branches {
-		if !util.IsDigest(v) {
-			continue
-		}
+	for _, v := range ids {
 		if v == id {
 			found = true
 			break
@@ -1215,9 +2124,10 @@ func (g *gitBackEnd) checkoutRecordBranch(id string) (bool, error) {
 
 	if found {
 		// Branch exists, modify branch
-		err := g.gitCheckout(g.unvetted, id)
+		err := g.gitCheckout(g.unvetted, recordBranch(id))
 		if err != nil {
-			return true, backend.ErrRecordNotFound
+			return true, fmt.Errorf("checkout record %v: %v", id,
+				err)
 		}
 	} else {
 		// Branch does not exist, create it if record exists
@@ -1231,8 +2141,8 @@ func (g *gitBackEnd) checkoutRecordBranch(id string) (bool, error) {
 			return false, fmt.Errorf("unvetted repo corrupt: %v "+
 				"is not a dir", fi.Name())
 		}
-		// git checkout -b id
-		err = g.gitNewBranch(g.unvetted, id)
+		// git checkout -b records/id
+		err = g.gitNewBranch(g.unvetted, recordBranch(id))
 		if err != nil {
 			return false, err
 		}
@@ -1244,7 +2154,9 @@ func (g *gitBackEnd) checkoutRecordBranch(id string) (bool, error) {
 // updateRecord takes various parameters to update a record.  Note that this
 // function must be wrapped by a function that delivers the call with the
 // unvetted repo sitting in master.  The idea is that if this function fails we
-// can simply unwind it by calling a git stash.
+// can simply unwind it by calling a git stash.  The update is journaled, see
+// beginUpdate, and the staged changes are verified against the requested ones
+// before they are committed.
 // Function must be called with the lock held.
 func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.MetadataStream, fa []file, filesDel []string) (*backend.RecordMetadata, error) {
 	// Checkout branch
@@ -1256,6 +2168,16 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 
 	// We now are sitting in branch id
 
+	// Journal the update until it is committed or unwound
+	head, err := g.gitRevParse(g.unvetted, recordBranch(id))
+	if err != nil {
+		return nil, err
+	}
+	err = g.beginUpdate(id, head)
+	if err != nil {
+		return nil, err
+	}
+
 	// Load MD
 	log.Tracef("updating %x", token)
 	brm, err := loadMD(g.unvetted, id)
@@ -1273,8 +2195,8 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 
 	// Verify all deletes before executing
 	for _, v := range filesDel {
-		fi, err := os.Stat(filepath.Join(g.unvetted, id,
-			defaultPayloadDir, v))
+		fi, err := os.Stat(payloadFilename(filepath.Join(g.unvetted,
+			id, defaultPayloadDir), v))
 		if err != nil {
 			if os.IsNotExist(err) {
 				return nil, backend.ContentVerificationError{
@@ -1292,8 +2214,13 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 	path := filepath.Join(g.unvetted, id, defaultPayloadDir)
 	for i := range fa {
 		// Copy files into directory id/payload/filename.
-		filename := filepath.Join(path, fa[i].name)
-		err = ioutil.WriteFile(filename, fa[i].payload, 0664)
+		filename := payloadFilename(path, fa[i].name)
+		err = os.MkdirAll(filepath.Dir(filename), g.dirModeOr(0774))
+		if err != nil {
+			return nil, err
+		}
+		err = g.writeUnvettedPayload(filename, fa[i].payload,
+			fa[i].digest)
 		if err != nil {
 			return nil, err
 		}
@@ -1307,13 +2234,27 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 
 	// Delete files
 	for _, v := range filesDel {
-		err = g.gitRm(g.unvetted, filepath.Join(id, defaultPayloadDir,
-			v))
+		err = g.gitRm(g.unvetted, payloadFilename(filepath.Join(id,
+			defaultPayloadDir), v))
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// Update the declared MIME types
+	mt, err := loadMIMETypes(g.unvetted, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range filesDel {
+		delete(mt, v)
+	}
+	declareMIMETypes(mt, fa)
+	err = g.writeMIMETypes(g.unvetted, id, mt)
+	if err != nil {
+		return nil, err
+	}
+
 	// Handle metadata
 	err = g.updateMetadata(id, mdAppend, mdOverwrite)
 	if err != nil {
@@ -1323,7 +2264,7 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 	// Find all hashes
 	hashes := make([]*[sha256.Size]byte, 0, len(fa))
 	ppath := filepath.Join(g.unvetted, id, defaultPayloadDir)
-	newRecordFiles, err := ioutil.ReadDir(ppath)
+	newRecordFiles, err := payloadFiles(ppath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, backend.ContentVerificationError{
@@ -1333,8 +2274,7 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 		return nil, err
 	}
 	for _, v := range newRecordFiles {
-		digest, err := util.DigestFileBytes(filepath.Join(ppath,
-			v.Name()))
+		digest, err := g.payloadDigest(payloadFilename(ppath, v))
 		if err != nil {
 			return nil, err
 		}
@@ -1343,18 +2283,40 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 		hashes = append(hashes, &d)
 	}
 
-	// If there are no changes DO NOT update the record and reply with no
-	// changes.
-	o, err := g.gitDiff(g.unvetted)
+	// Everything that is staged must have been asked for and nothing may
+	// be left unstaged.
+	want := make(map[string]struct{}, len(fa)+len(filesDel)+
+		len(mdAppend)+len(mdOverwrite)+2)
+	for _, v := range fa {
+		want[filepath.ToSlash(filepath.Join(id, defaultPayloadDir,
+			v.name))] = struct{}{}
+	}
+	for _, v := range filesDel {
+		want[filepath.ToSlash(filepath.Join(id, defaultPayloadDir,
+			v))] = struct{}{}
+	}
+	for _, v := range append(mdAppend, mdOverwrite...) {
+		want[id+"/"+fmt.Sprintf("%02v%v", v.ID,
+			defaultMDFilenameSuffix)] = struct{}{}
+	}
+	want[id+"/"+defaultMIMEFilename] = struct{}{}
+	staged, err := g.stagedChanges(id, want)
 	if err != nil {
 		return nil, err
 	}
-	if len(o) == 0 {
-		return nil, backend.ErrNoChanges
+
+	// If there are no changes DO NOT update the record and reply with the
+	// submitted files that turned out to be identical to the record.
+	if len(staged) == 0 {
+		e := backend.NoChangesError{Token: id}
+		for _, v := range fa {
+			e.Unchanged = append(e.Unchanged, v.name)
+		}
+		return nil, e
 	}
 
 	// Update record metadata
-	brmNew, err := createMD(g.unvetted, id,
+	brmNew, err := g.createMD(g.unvetted, id,
 		backend.MDStatusIterationUnvetted, brm.Version+1, hashes, token)
 	if err != nil {
 		return nil, err
@@ -1366,6 +2328,13 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 	if err != nil {
 		return nil, err
 	}
+	want[id+"/"+defaultRecordMetadataFilename] = struct{}{}
+
+	// Verify the working tree once more right before committing
+	_, err = g.stagedChanges(id, want)
+	if err != nil {
+		return nil, err
+	}
 
 	// git commit -m "message"
 	err = g.gitCommit(path, "Update record "+id)
@@ -1379,7 +2348,7 @@ func (g *gitBackEnd) updateRecord(token []byte, mdAppend, mdOverwrite []backend.
 func (g *gitBackEnd) UpdateUnvettedRecord(token []byte, mdAppend []backend.MetadataStream, mdOverwrite []backend.MetadataStream, filesAdd []backend.File, filesDel []string) (*backend.RecordMetadata, error) {
 	// Send in a single metadata array to verify there are no dups.
 	allMD := append(mdAppend, mdOverwrite...)
-	fa, err := verifyContent(allMD, filesAdd, filesDel)
+	fa, err := verifyContent(allMD, filesAdd, filesDel, g.maxFileSize)
 	if err != nil {
 		e, ok := err.(backend.ContentVerificationError)
 		if !ok {
@@ -1391,6 +2360,17 @@ func (g *gitBackEnd) UpdateUnvettedRecord(token []byte, mdAppend []backend.Metad
 		}
 	}
 
+	// Only valid requests count against the rate limit
+	if !g.allow() {
+		return nil, backend.ErrRateLimited
+	}
+
+	t := newOpTimer("UpdateUnvettedRecord")
+	defer t.done()
+
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
 	// Lock filesystem
 	err = g.lock.Lock(LockDuration)
 	if err != nil {
@@ -1402,10 +2382,19 @@ func (g *gitBackEnd) UpdateUnvettedRecord(token []byte, mdAppend []backend.Metad
 			log.Errorf("Unlock error: %v", err)
 		}
 	}()
+	t.lockAcquired()
+	g.testHook(testPointLocked)
 	if g.shutdown {
 		return nil, backend.ErrShutdown
 	}
 
+	// Clean up after a previous update of this record that died half way
+	id := hex.EncodeToString(token)
+	err = g.recoverUpdate(id)
+	if err != nil {
+		return nil, err
+	}
+
 	// git checkout master
 	err = g.gitCheckout(g.unvetted, "master")
 	if err != nil {
@@ -1422,10 +2411,7 @@ func (g *gitBackEnd) UpdateUnvettedRecord(token []byte, mdAppend []backend.Metad
 	// Do the work, if there is an error we must unwind git.
 	var errReturn error
 	brm, err := g.updateRecord(token, mdAppend, mdOverwrite, fa, filesDel)
-	if err == backend.ErrNoChanges {
-		brm = nil
-		errReturn = err
-	} else if err != nil {
+	if err != nil {
 		// git stash
 		err2 := g.gitStash(g.unvetted)
 		if err2 != nil {
@@ -1436,6 +2422,8 @@ func (g *gitBackEnd) UpdateUnvettedRecord(token []byte, mdAppend []backend.Metad
 
 		brm = nil
 		errReturn = err
+	} else {
+		g.setPending(id, brm.Status)
 	}
 
 	// git checkout master
@@ -1444,21 +2432,71 @@ func (g *gitBackEnd) UpdateUnvettedRecord(token []byte, mdAppend []backend.Metad
 		return nil, err
 	}
 
+	// The update is either committed or unwound
+	err = g.endUpdate(id)
+	if err != nil {
+		return nil, err
+	}
+
 	return brm, errReturn
 }
 
-// updateVettedMetadata updates metadata in the unvetted repo and pushes it
-// upstream followed by a rebase.  Record is not updated.
-// This function must be called with the lock held.
-func (g *gitBackEnd) updateVettedMetadata(id, idTmp string, mdAppend []backend.MetadataStream, mdOverwrite []backend.MetadataStream) error {
-	// Checkout temporary branch
+// updateVetted runs update for vetted record id on temporary branch idTmp,
+// see tmpBranch, and pushes it upstream followed by a rebase.  It goes through
+// the normal stages of updating unvetted, pushing PR, merge PR, pull remote.
+// If something goes wrong git is unwound and the temporary branch is dropped.
+//
+// This function must be called with the lock held and the unvetted repo
+// sitting in master.
+func (g *gitBackEnd) updateVetted(id string, update func() error) error {
+	// Do the work, if there is an error we must unwind git.
+	idTmp := tmpBranch(id)
+	var errReturn error
 	err := g.gitNewBranch(g.unvetted, idTmp)
+	if err == nil {
+		err = update()
+		if err == nil {
+			// create and rebase PR
+			err = g.rebasePR(idTmp)
+		}
+	}
+	if err != nil {
+		// git stash and drop potential tmp branch
+		err2 := g.gitStash(g.unvetted)
+		if err2 != nil {
+			// We are in trouble! Consider a panic.
+			log.Errorf("gitStash: %v", err2)
+			return err2
+		}
+
+		errReturn = err
+	}
+
+	// git checkout master
+	err = g.gitCheckout(g.unvetted, "master")
 	if err != nil {
 		return err
 	}
 
+	// If something went wrong drop branch
+	if errReturn != nil {
+		err2 := g.gitBranchDelete(g.unvetted, idTmp)
+		if err2 != nil {
+			// We are in trouble! Consider a panic.
+			log.Errorf("gitBranchDelete: %v", err2)
+			return err2
+		}
+	}
+
+	return errReturn
+}
+
+// updateVettedMetadata updates and commits the metadata of vetted record id
+// in the unvetted repo, see updateVetted.  Record is not updated.
+// This function must be called with the lock held.
+func (g *gitBackEnd) updateVettedMetadata(id string, mdAppend []backend.MetadataStream, mdOverwrite []backend.MetadataStream) error {
 	// Update metadata changes
-	err = g.updateMetadata(id, mdAppend, mdOverwrite)
+	err := g.updateMetadata(id, mdAppend, mdOverwrite)
 	if err != nil {
 		return err
 	}
@@ -1470,35 +2508,18 @@ func (g *gitBackEnd) updateVettedMetadata(id, idTmp string, mdAppend []backend.M
 	}
 
 	// Commit change
-	err = g.gitCommit(g.unvetted, "Update record metadata "+id)
-	if err != nil {
-		return err
-	}
-
-	// create and rebase PR
-	return g.rebasePR(idTmp)
+	return g.gitCommit(g.unvetted, "Update record metadata "+id)
 }
 
 // UpdateVettedMetadata updates metadata in vetted record.  It goes through the
 // normal stages of updating unvetted, pushing PR, merge PR, pull remote.
 // Record itself is not changed.
 func (g *gitBackEnd) UpdateVettedMetadata(token []byte, mdAppend []backend.MetadataStream, mdOverwrite []backend.MetadataStream) error {
-	// Send in a single metadata array to verify there are no dups.
-	allMD := append(mdAppend, mdOverwrite...)
-	_, err := verifyContent(allMD, []backend.File{}, []string{})
-	if err != nil {
-		e, ok := err.(backend.ContentVerificationError)
-		if !ok {
-			return err
-		}
-		// Allow ErrorStatusEmpty
-		if e.ErrorCode != pd.ErrorStatusEmpty {
-			return err
-		}
-	}
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
 
 	// Lock filesystem
-	err = g.lock.Lock(LockDuration)
+	err := g.lock.Lock(LockDuration)
 	if err != nil {
 		return err
 	}
@@ -1508,10 +2529,34 @@ func (g *gitBackEnd) UpdateVettedMetadata(token []byte, mdAppend []backend.Metad
 			log.Errorf("Unlock error: %v", err)
 		}
 	}()
+	g.testHook(testPointLocked)
 	if g.shutdown {
 		return backend.ErrShutdown
 	}
 
+	return g.updateVettedMetadataLocked(token, mdAppend, mdOverwrite)
+}
+
+// updateVettedMetadataLocked runs the portion of UpdateVettedMetadata that has
+// to be locked.  It allows plugin commands to check the record and update its
+// metadata without releasing the locks in between.
+//
+// This function must be called with the record lock and the lock held.
+func (g *gitBackEnd) updateVettedMetadataLocked(token []byte, mdAppend []backend.MetadataStream, mdOverwrite []backend.MetadataStream) error {
+	// Send in a single metadata array to verify there are no dups.
+	allMD := append(mdAppend, mdOverwrite...)
+	_, err := verifyContent(allMD, []backend.File{}, []string{}, 0)
+	if err != nil {
+		e, ok := err.(backend.ContentVerificationError)
+		if !ok {
+			return err
+		}
+		// Allow ErrorStatusEmpty
+		if e.ErrorCode != pd.ErrorStatusEmpty {
+			return err
+		}
+	}
+
 	// git checkout master
 	err = g.gitCheckout(g.unvetted, "master")
 	if err != nil {
@@ -1524,11 +2569,8 @@ func (g *gitBackEnd) UpdateVettedMetadata(token []byte, mdAppend []backend.Metad
 		return err
 	}
 
-	// Check if temporary branch exists (should never be the case)
-	id := hex.EncodeToString(token)
-	idTmp := id + "_tmp"
-
 	// Make sure vetted exists
+	id := hex.EncodeToString(token)
 	_, err = os.Stat(filepath.Join(g.unvetted, id))
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -1547,38 +2589,9 @@ func (g *gitBackEnd) UpdateVettedMetadata(token []byte, mdAppend []backend.Metad
 
 	log.Tracef("updating vetted metadata %x", token)
 
-	// Do the work, if there is an error we must unwind git.
-	var errReturn error
-	err = g.updateVettedMetadata(id, idTmp, mdAppend, mdOverwrite)
-	if err != nil {
-		// git stash and drop potential tmp branch
-		err2 := g.gitStash(g.unvetted)
-		if err2 != nil {
-			// We are in trouble! Consider a panic.
-			log.Errorf("gitStash: %v", err2)
-			return err2
-		}
-
-		errReturn = err
-	}
-
-	// git checkout master
-	err = g.gitCheckout(g.unvetted, "master")
-	if err != nil {
-		return err
-	}
-
-	// If something went wrong drop branch
-	if errReturn != nil {
-		err2 := g.gitBranchDelete(g.unvetted, idTmp)
-		if err2 != nil {
-			// We are in trouble! Consider a panic.
-			log.Errorf("gitBranchDelete: %v", err2)
-			return err2
-		}
-	}
-
-	return errReturn
+	return g.updateVetted(id, func() error {
+		return g.updateVettedMetadata(id, mdAppend, mdOverwrite)
+	})
 }
 
 // getRecordLock is the generic implementation of GetUnvetted/GetVetted.  It
@@ -1586,6 +2599,16 @@ func (g *gitBackEnd) UpdateVettedMetadata(token []byte, mdAppend []backend.Metad
 //
 // This function must be called WITHOUT the lock held.
 func (g *gitBackEnd) getRecordLock(token []byte, repo string, includeFiles bool) (*backend.Record, error) {
+	op := "GetUnvetted"
+	if repo == g.vetted {
+		op = "GetVetted"
+	}
+	t := newOpTimer(op)
+	defer t.done()
+
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
 	// Lock filesystem
 	err := g.lock.Lock(LockDuration)
 	if err != nil {
@@ -1597,6 +2620,8 @@ func (g *gitBackEnd) getRecordLock(token []byte, repo string, includeFiles bool)
 			log.Errorf("Unlock error: %v", err)
 		}
 	}()
+	t.lockAcquired()
+	g.testHook(testPointLocked)
 	if g.shutdown {
 		return nil, backend.ErrShutdown
 	}
@@ -1623,7 +2648,7 @@ func (g *gitBackEnd) _getRecord(id, repo string, includeFiles bool) (*backend.Re
 	var files []backend.File
 	if includeFiles {
 		// load files
-		files, err = loadRecord(repo, id)
+		files, err = g.loadRecord(repo, id)
 		if err != nil {
 			return nil, err
 		}
@@ -1636,6 +2661,35 @@ func (g *gitBackEnd) _getRecord(id, repo string, includeFiles bool) (*backend.Re
 	}, nil
 }
 
+// checkoutUnvetted checks out the branch of unvetted record id.  The caller
+// must switch back to master.
+//
+// This function must be called WITH the lock held.
+func (g *gitBackEnd) checkoutUnvetted(id string) error {
+	// Only a missing branch means the record does not exist.  A branch
+	// that can't be checked out indicates a corrupt repo and must not be
+	// reported as not found.
+	branch := recordBranch(id)
+	if !g.gitBranchExists(g.unvetted, branch) {
+		return backend.ErrRecordNotFound
+	}
+
+	// git checkout records/id
+	err := g.gitCheckout(g.unvetted, branch)
+	if err != nil {
+		return fmt.Errorf("checkout record %v: %v", id, err)
+	}
+	branchNow, err := g.gitBranchNow(g.unvetted)
+	if err != nil {
+		return fmt.Errorf("branch record %v: %v", id, err)
+	}
+	if branchNow != branch {
+		return fmt.Errorf("checkout record %v: on branch %v", id,
+			branchNow)
+	}
+	return nil
+}
+
 // getRecord is the generic implementation of GetUnvetted/GetVetted.  It
 // returns a record record from the provided repo.
 //
@@ -1643,14 +2697,9 @@ func (g *gitBackEnd) _getRecord(id, repo string, includeFiles bool) (*backend.Re
 func (g *gitBackEnd) getRecord(token []byte, repo string, includeFiles bool) (*backend.Record, error) {
 	id := hex.EncodeToString(token)
 	if repo == g.unvetted {
-		// git checkout id
-		err := g.gitCheckout(repo, id)
+		err := g.checkoutUnvetted(id)
 		if err != nil {
-			return nil, backend.ErrRecordNotFound
-		}
-		branchNow, err := g.gitBranchNow(repo)
-		if err != nil || branchNow != id {
-			return nil, backend.ErrRecordNotFound
+			return nil, err
 		}
 	}
 	defer func() {
@@ -1666,13 +2715,30 @@ func (g *gitBackEnd) getRecord(token []byte, repo string, includeFiles bool) (*b
 
 // fsck performs a git fsck and additionally it validates the git tree against
 // dcrtime.  This is an expensive operation and should not be run during
-// runtime.
+// runtime.  Every commit is verified as part of the anchor whose commit
+// message lists it, commits that were deferred by MinAnchorAge belong to a
+// later anchor than the one they sit below.  Commits covered by the checkpoint
+// of a previous run are skipped, the checkpoint only advances over a
+// contiguous range of anchors, starting at the oldest, whose commits all
+// verified.
 //
-// This function must be called WITH holding the lock.
+// This function must be called WITHOUT holding the lock.  The lock is only
+// taken while recording anchor confirmations.
 func (g *gitBackEnd) fsck(path string) error {
+	// Unless a full fsck was requested only the commits anchored after the
+	// checkpoint are verified.
+	var checkpoint string
+	if !g.fullFsck {
+		var err error
+		checkpoint, err = g.readFsckCheckpoint(path)
+		if err != nil {
+			return err
+		}
+	}
+
 	// obtain all commit digests and verify them.  We don't store anchor
 	// confirmations so we have to skip those.
-	out, err := g.git(path, "log", "--pretty=oneline")
+	out, err := g.gitLog(path)
 	if err != nil {
 		return err
 	}
@@ -1680,50 +2746,99 @@ func (g *gitBackEnd) fsck(path string) error {
 		return fmt.Errorf("invalid git output")
 	}
 
-	var seenAnchor bool
-	// gitDigests is an index of all git digests to verify with dcrtime
-	gitDigests := make(map[string]struct{})
+	// gitDigests is an index of all git digests to verify with dcrtime.
+	// It points to the anchor, in anchors, that covers the digest.
+	gitDigests := make(map[string]int)
+	// anchored maps the commit digests listed in the anchors that are
+	// verified to the anchor, in anchors, whose commit body lists them.
+	// Commits that were deferred by MinAnchorAge sit below an anchor that
+	// does not list them, they belong to a later anchor.
+	anchored := make(map[string]int)
+	// anchors lists the merkle roots of all seen anchors, newest first
+	var anchors []string
 	// confirmedAnchors keeps track of anchors that were timestamped with dcrtime but not verified,
 	// since periodicAnchorChecker only checks recent unconfirmed anchors and ignores older ones
 	confirmedAnchors := make(map[string]struct{})
 	var unconfirmedAnchors []string
-	for _, v := range out {
-		if regexAnchorConfirmation.MatchString(v) {
+	var resumed bool
+	for line := 0; line < len(out); {
+		commit, n, err := extractCommit(out[line:])
+		if err != nil {
+			return err
+		}
+		line += n
+
+		am, err := parseAnchorMessage(commit.Message[0])
+		if err != nil {
+			return err
+		}
+		if am != nil && am.confirmation {
 			// Store confirmed anchor merkle roots to look up later
-			merkleRoot := regexAnchorConfirmation.FindStringSubmatch(v)[1]
-			confirmedAnchors[merkleRoot] = struct{}{}
+			confirmedAnchors[am.merkle] = struct{}{}
 			continue
-		} else if regexAnchor.MatchString(v) {
-			// We now have seen an Anchor commit. The following digests are now precious.
-			seenAnchor = true
+		} else if am != nil {
+			if am.merkle == checkpoint {
+				// Anchors from here on were verified before.
+				// Keep going until the commits listed in the
+				// newer anchors have been seen.
+				log.Infof("fsck: resuming from checkpoint %v",
+					checkpoint)
+				resumed = true
+			}
+			if resumed {
+				if len(anchored) == 0 {
+					break
+				}
+				continue
+			}
+			digests, messages, err := parseAnchorCommit(commit)
+			if err != nil {
+				return err
+			}
+			for k, d := range digests {
+				// Anchor commits and record merkle roots are
+				// not verified
+				if isRecordMerkleMessage(messages[k]) {
+					continue
+				}
+				lam, err := parseAnchorMessage(messages[k])
+				if err != nil {
+					return err
+				}
+				if lam != nil {
+					continue
+				}
+				anchored[hex.EncodeToString(d)] = len(anchors)
+			}
+			anchors = append(anchors, am.merkle)
 			// We should have seen its confirmation already, since we're parsing top to bottom
 			// If we didn't, save the anchor key to verify with dcrtime later
-			merkleRoot := regexAnchor.FindStringSubmatch(v)[1]
-			_, confirmed := confirmedAnchors[merkleRoot]
+			_, confirmed := confirmedAnchors[am.merkle]
 			if !confirmed {
-				unconfirmedAnchors = append(unconfirmedAnchors, merkleRoot)
+				unconfirmedAnchors = append(unconfirmedAnchors, am.merkle)
 			}
 			continue
 		}
-		if !seenAnchor {
-			// We have not seen an Anchor yet so this digest is not
-			// precious.
-			continue
-		}
-		// git output is digest followed by one liner commit message
-		s := strings.SplitN(v, " ", 2)
-		if len(s) != 2 {
-			log.Infof("%v", spew.Sdump(s))
-			return fmt.Errorf("unexpected split: %v", v)
-		}
-		ds, err := extendSHA1FromString(s[0])
+		ds, err := extendSHA1FromString(commit.Hash)
 		if err != nil {
-			return fmt.Errorf("not a digest: %v", v)
+			return fmt.Errorf("not a digest: %v", commit.Hash)
+		}
+		a, ok := anchored[ds]
+		if !ok {
+			// Not anchored yet, this digest is not precious.
+			continue
 		}
 		if _, ok := gitDigests[ds]; ok {
 			return fmt.Errorf("duplicate git digest: %v", ds)
 		}
-		gitDigests[ds] = struct{}{}
+		gitDigests[ds] = a
+		delete(anchored, ds)
+		if resumed && len(anchored) == 0 {
+			break
+		}
+	}
+	if len(anchored) != 0 {
+		return fmt.Errorf("%v anchored commits not found", len(anchored))
 	}
 
 	if len(gitDigests) == 0 {
@@ -1755,27 +2870,85 @@ func (g *gitBackEnd) fsck(path string) error {
 	for d := range gitDigests {
 		digests = append(digests, d)
 	}
-	vr, err := util.Verify(g.dcrtimeHost, digests)
+	vr, err := util.VerifyWithClient(g.httpClient, g.dcrtimeHost, digests)
 	if err != nil {
 		return err
 	}
 
 	// Verify all results
-	var fail bool
+	failed := make(map[int]struct{}) // Anchors with failed digests
+	verified := make(map[string]struct{}, len(vr.Digests))
 	for _, v := range vr.Digests {
 		if v.Result != v1.ResultOK {
-			fail = true
+			failed[gitDigests[v.Digest]] = struct{}{}
 			log.Errorf("dcrtime error: %v %v %v", v.Digest,
 				v.Result, v1.Result[v.Result])
+			continue
+		}
+		verified[v.Digest] = struct{}{}
+	}
+	for d, a := range gitDigests {
+		if _, ok := verified[d]; !ok {
+			failed[a] = struct{}{}
+		}
+	}
+
+	// Advance the checkpoint over the contiguous range of anchors, starting
+	// at the oldest, whose digests all verified.
+	var next string
+	for k := len(anchors) - 1; k >= 0; k-- {
+		if _, ok := failed[k]; ok {
+			break
 		}
+		next = anchors[k]
 	}
-	if fail {
+	if next != "" {
+		err = g.writeFsckCheckpoint(path, next)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(failed) != 0 {
 		return fmt.Errorf("dcrtime fsck failed")
 	}
 
 	return nil
 }
 
+// fsckCheckpointFilename returns the filename of the fsck checkpoint of the
+// repo at path.  Checkpoints are kept outside of the repositories.
+func (g *gitBackEnd) fsckCheckpointFilename(path string) string {
+	return filepath.Join(g.root, defaultFsckCheckpointPrefix+
+		filepath.Base(path))
+}
+
+// readFsckCheckpoint returns the merkle root of the newest anchor of the repo
+// at path that was fully verified by fsck.  It returns an empty string if
+// there is no checkpoint.
+func (g *gitBackEnd) readFsckCheckpoint(path string) (string, error) {
+	b, err := ioutil.ReadFile(g.fsckCheckpointFilename(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	checkpoint := strings.TrimSpace(string(b))
+	if !util.IsDigest(checkpoint) {
+		return "", fmt.Errorf("invalid fsck checkpoint: %q", checkpoint)
+	}
+	return checkpoint, nil
+}
+
+// writeFsckCheckpoint records merkle as the fsck checkpoint of the repo at
+// path.
+func (g *gitBackEnd) writeFsckCheckpoint(path, merkle string) error {
+	log.Infof("fsck: checkpoint %v", merkle)
+	return ioutil.WriteFile(g.fsckCheckpointFilename(path),
+		[]byte(merkle+"\n"), g.fileModeOr(0664))
+}
+
 // GetUnvetted checks out branch token and returns the content of
 // unvetted/token directory.
 //
@@ -1791,17 +2964,235 @@ func (g *gitBackEnd) GetVetted(token []byte) (*backend.Record, error) {
 	return g.getRecordLock(token, g.vetted, true)
 }
 
+// GetVettedIfChanged returns the vetted record identified by token unless the
+// digest of its latest commit, as reported by RecordDigests, equals
+// knownDigest.  In that case the record is not loaded and (nil, false, nil) is
+// returned so that callers can cheaply reply not modified.  An empty
+// knownDigest always loads the record.
+//
+// GetVettedIfChanged satisfies the backend interface.
+func (g *gitBackEnd) GetVettedIfChanged(token []byte, knownDigest string) (*backend.Record, bool, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, false, backend.ErrShutdown
+	}
+
+	if knownDigest != "" {
+		commit, err := g.lastVettedCommit(hex.EncodeToString(token))
+		if err != nil {
+			return nil, false, err
+		}
+		if commit == knownDigest {
+			return nil, false, nil
+		}
+	}
+
+	r, err := g.getRecord(token, g.vetted, true)
+	if err != nil {
+		return nil, false, err
+	}
+	return r, true, nil
+}
+
+// GetVettedBatch returns the vetted records identified by tokens in the same
+// order.  The lock is taken once for the entire batch.  Records that do not
+// exist are returned as nil instead of failing the batch.  File payloads are
+// only loaded if includeFiles is set.
+//
+// GetVettedBatch satisfies the backend interface.
+func (g *gitBackEnd) GetVettedBatch(tokens [][]byte, includeFiles bool) ([]*backend.Record, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	records := make([]*backend.Record, len(tokens))
+	for k, token := range tokens {
+		r, err := g._getRecord(hex.EncodeToString(token), g.vetted,
+			includeFiles)
+		if err == backend.ErrRecordNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		records[k] = r
+	}
+
+	return records, nil
+}
+
+// GetRecordMetadataStreams returns the metadata streams of the record
+// identified by token.  Neither the record metadata nor the file payloads are
+// loaded.
+//
+// GetRecordMetadataStreams satisfies the backend interface.
+func (g *gitBackEnd) GetRecordMetadataStreams(token []byte, vetted bool) ([]backend.MetadataStream, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Unvetted metadata streams are read straight from the record branch
+	// so the record lock suffices.
+	if !vetted {
+		if g.isShutdown() {
+			return nil, backend.ErrShutdown
+		}
+		return g.loadUnvettedMDStreams(hex.EncodeToString(token))
+	}
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	mds, err := loadMDStreams(g.vetted, hex.EncodeToString(token))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = backend.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return mds, nil
+}
+
+// verifyMerkle recomputes the merkle root of the payload of path/id and
+// verifies that it matches the record metadata.  Blobs, compressed and
+// encrypted payloads are read in full so that their content is verified as
+// well.  It returns a backend.RecordCorruptError on mismatch.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) verifyMerkle(path, id string, brm *backend.RecordMetadata) error {
+	ppath := filepath.Join(path, id, defaultPayloadDir)
+	files, err := payloadFiles(ppath)
+	if err != nil {
+		return err
+	}
+	hashes := make([]*[sha256.Size]byte, 0, len(files))
+	for _, v := range files {
+		b, err := ioutil.ReadFile(payloadFilename(ppath, v))
+		if err != nil {
+			return err
+		}
+		if digest, ok := parseBlobPointer(b); ok {
+			pt, err := g.readPurged(digest)
+			if err != nil {
+				return err
+			}
+			if pt != nil {
+				// Content was purged, trust the pointer
+				pd, err := hex.DecodeString(digest)
+				if err != nil {
+					return err
+				}
+				var d [sha256.Size]byte
+				copy(d[:], pd)
+				hashes = append(hashes, &d)
+				continue
+			}
+			b, err = ioutil.ReadFile(g.blobFilename(digest))
+			if err != nil {
+				return err
+			}
+		} else if _, gz, ok := parseGzipPointer(b); ok {
+			b, err = gunzip(gz)
+			if err != nil {
+				return err
+			}
+		} else if digest, sealed, ok := parseEncPointer(b); ok {
+			b, err = decryptPayload(g.encryptionKey, digest, sealed)
+			if err != nil {
+				return err
+			}
+		}
+		var d [sha256.Size]byte
+		copy(d[:], util.Digest(b))
+		hashes = append(hashes, &d)
+	}
+
+	var actual [sha256.Size]byte
+	if len(hashes) != 0 {
+		actual = *merkle.Root(hashes)
+	}
+	if actual != brm.Merkle {
+		return backend.RecordCorruptError{
+			Token:  id,
+			Merkle: hex.EncodeToString(brm.Merkle[:]),
+			Actual: hex.EncodeToString(actual[:]),
+		}
+	}
+
+	return nil
+}
+
+// checkUnvettedStatus returns the error that setting the status of the checked
+// out unvetted record id with record metadata brm to status would produce, nil
+// if the transition is allowed.  It does not modify the record.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) checkUnvettedStatus(id string, brm *backend.RecordMetadata, status backend.MDStatusT) error {
+	// We only allow a transition from unvetted to vetted or censored
+	switch {
+	case (brm.Status == backend.MDStatusUnvetted ||
+		brm.Status == backend.MDStatusIterationUnvetted) &&
+		status == backend.MDStatusVetted:
+		// Refuse to publish files that do not match the MD
+		return g.verifyMerkle(g.unvetted, id, brm)
+	case brm.Status == backend.MDStatusUnvetted &&
+		status == backend.MDStatusCensored:
+		return nil
+	}
+	return backend.StateTransitionError{
+		From: brm.Status,
+		To:   status,
+	}
+}
+
 // setUnvettedStatus takes various parameters to update a record metadata and
 // status.  Note that this function must be wrapped by a function that delivers
 // the call with the unvetted repo sitting in master.  The idea is that if this
-// function fails we can simply unwind it by calling a git stash.
+// function fails we can simply unwind it by calling a git stash.  If publish is
+// false a vetted record is committed on its branch but not pushed upstream,
+// the caller is responsible for calling rebasePR.
 // Function must be called with the lock held.
-func (g *gitBackEnd) setUnvettedStatus(token []byte, status backend.MDStatusT, mdAppend, mdOverwrite []backend.MetadataStream) (*backend.Record, error) {
-	// git checkout id
+func (g *gitBackEnd) setUnvettedStatus(token []byte, status backend.MDStatusT, mdAppend, mdOverwrite []backend.MetadataStream, publish bool) (*backend.Record, error) {
+	// git checkout records/id
 	id := hex.EncodeToString(token)
-	err := g.gitCheckout(g.unvetted, id)
+	err := g.checkoutUnvetted(id)
 	if err != nil {
-		return nil, backend.ErrRecordNotFound
+		return nil, err
 	}
 
 	// Load record
@@ -1809,6 +3200,10 @@ func (g *gitBackEnd) setUnvettedStatus(token []byte, status backend.MDStatusT, m
 	if err != nil {
 		return nil, err
 	}
+	err = g.checkUnvettedStatus(id, &record.RecordMetadata, status)
+	if err != nil {
+		return nil, err
+	}
 
 	// We only allow a transition from unvetted to vetted or censored
 	switch {
@@ -1818,11 +3213,50 @@ func (g *gitBackEnd) setUnvettedStatus(token []byte, status backend.MDStatusT, m
 
 		// unvetted -> vetted
 
+		// Vetted content is public
+		err = g.decryptRecord(g.unvetted, id)
+		if err != nil {
+			return nil, err
+		}
+
 		// Update MD first
 		record.RecordMetadata.Status = backend.MDStatusVetted
 		record.RecordMetadata.Version += 1
 		record.RecordMetadata.Timestamp = time.Now().Unix()
-		err = updateMD(g.unvetted, id, &record.RecordMetadata)
+		err = g.updateMD(g.unvetted, id, &record.RecordMetadata)
+		if err != nil {
+			return nil, err
+		}
+
+		// Handle metadata
+		err = g.updateMetadata(id, mdAppend, mdOverwrite)
+		if err != nil {
+			return nil, err
+		}
+
+		// Commit brm
+		err = g.commitMD(g.unvetted, id, "published")
+		if err != nil {
+			return nil, err
+		}
+
+		if !publish {
+			break
+		}
+
+		// Create and rebase PR
+		err = g.rebasePR(recordBranch(id))
+		if err != nil {
+			return nil, err
+		}
+
+	case record.RecordMetadata.Status == backend.MDStatusUnvetted &&
+		status == backend.MDStatusCensored:
+		// unvetted -> censored
+		record.RecordMetadata.Status = backend.MDStatusCensored
+		record.RecordMetadata.Version += 1
+		record.RecordMetadata.Timestamp = time.Now().Unix()
+		err = g.updateMD(g.unvetted, id, &record.RecordMetadata)
 		if err != nil {
 			return nil, err
 		}
@@ -1832,56 +3266,441 @@ func (g *gitBackEnd) setUnvettedStatus(token []byte, status backend.MDStatusT, m
 		if err != nil {
 			return nil, err
 		}
-
-		// Commit brm
-		err = g.commitMD(g.unvetted, id, "published")
+
+		// Commit brm
+		err = g.commitMD(g.unvetted, id, "censored")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, backend.StateTransitionError{
+			From: record.RecordMetadata.Status,
+			To:   status,
+		}
+	}
+
+	return record, nil
+}
+
+// SetUnvettedStatus tries to update the status for an unvetted record. It
+// returns the updated record if successful but without the Files compnonet.
+//
+// SetUnvettedStatus satisfies the backend interface.
+func (g *gitBackEnd) SetUnvettedStatus(token []byte, status backend.MDStatusT, mdAppend, mdOverwrite []backend.MetadataStream) (*backend.Record, error) {
+	t := newOpTimer("SetUnvettedStatus")
+	defer t.done()
+
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	t.lockAcquired()
+	g.testHook(testPointLocked)
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	// Clean up after an update of this record that died half way
+	err = g.recoverUpdate(hex.EncodeToString(token))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Tracef("setting status %v (%v) -> %x", status,
+		backend.MDStatus[status], token)
+	var errReturn error
+	record, err := g.setUnvettedStatus(token, status, mdAppend, mdOverwrite,
+		true)
+	if err != nil {
+		// git stash
+		err2 := g.gitStash(g.unvetted)
+		if err2 != nil {
+			// We are in trouble!  Consider a panic.
+			log.Errorf("gitStash: %v", err2)
+			return nil, err2
+		}
+		errReturn = err
+	} else {
+		g.setPending(hex.EncodeToString(token), record.RecordMetadata.Status)
+	}
+
+	// git checkout master
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		return nil, err
+	}
+
+	if errReturn != nil {
+		return nil, errReturn
+	}
+
+	return record, nil
+}
+
+// CanSetUnvettedStatus returns nil if SetUnvettedStatus would set the status
+// of the unvetted record identified by token to status, or the error it would
+// return otherwise.  An interrupted update is recovered first, see
+// recoverUpdate.  The record is checked out to verify its files but nothing
+// is committed.
+//
+// CanSetUnvettedStatus satisfies the backend interface.
+func (g *gitBackEnd) CanSetUnvettedStatus(token []byte, status backend.MDStatusT) error {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return backend.ErrShutdown
+	}
+
+	// Clean up after an update of this record that died half way, just
+	// like SetUnvettedStatus does, so that the verdict matches
+	id := hex.EncodeToString(token)
+	err = g.recoverUpdate(id)
+	if err != nil {
+		return err
+	}
+
+	// git checkout records/id
+	err = g.checkoutUnvetted(id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// git checkout master
+		err := g.gitCheckout(g.unvetted, "master")
+		if err != nil {
+			log.Errorf("could not switch to master: %v", err)
+		}
+	}()
+
+	record, err := g._getRecord(id, g.unvetted, false)
+	if err != nil {
+		return err
+	}
+	return g.checkUnvettedStatus(id, &record.RecordMetadata, status)
+}
+
+// Inventory returns an inventory of vetted and unvetted records.  If
+// includeFiles is set the content is also returned.  Censored unvetted
+// records are skipped unless includeCensored is set, their status is read
+// from the branch without loading the rest of the record.
+func (g *gitBackEnd) Inventory(vettedCount, branchCount uint, includeFiles, includeCensored bool) ([]backend.Record, []backend.Record, error) {
+	t := newOpTimer("Inventory")
+	defer t.done()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	t.lockAcquired()
+	g.testHook(testPointLocked)
+	if g.shutdown {
+		return nil, nil, backend.ErrShutdown
+	}
+
+	vetted, branches, err := g.inventoryIDs(includeCensored)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pr := make([]backend.Record, 0, len(vetted))
+	for _, id := range vetted {
+		ids, err := hex.DecodeString(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		prv, err := g.getRecord(ids, g.vetted, includeFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		pr = append(pr, *prv)
+	}
+
+	br := make([]backend.Record, 0, len(branches))
+	for _, id := range branches {
+		ids, err := hex.DecodeString(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		pru, err := g.getRecord(ids, g.unvetted, includeFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		br = append(br, *pru)
+	}
+
+	return pr, br, nil
+}
+
+// loadUnvettedMD loads the RecordMetadata of unvetted record id straight from
+// its branch, without checking it out.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) loadUnvettedMD(id string) (*backend.RecordMetadata, error) {
+	out, err := g.gitShow(g.unvetted, recordBranch(id),
+		id+"/"+defaultRecordMetadataFilename)
+	if err != nil {
+		return nil, err
+	}
+	var brm backend.RecordMetadata
+	err = json.Unmarshal([]byte(strings.Join(out, "\n")), &brm)
+	if err != nil {
+		return nil, err
+	}
+	return &brm, nil
+}
+
+// loadUnvettedMDStreams loads the metadata streams of an unvetted record
+// straight from its branch without checking it out.  It only reads from the
+// git object store and therefore does not require the global lock.  Since the
+// branch may be deleted or renamed under the lock while it is read, a git
+// error on a branch that is gone is reported as backend.ErrRecordNotFound.
+func (g *gitBackEnd) loadUnvettedMDStreams(id string) ([]backend.MetadataStream, error) {
+	branch := recordBranch(id)
+	notFound := func(err error) error {
+		if !g.gitBranchExists(g.unvetted, branch) {
+			return backend.ErrRecordNotFound
+		}
+		return err
+	}
+	if !g.gitBranchExists(g.unvetted, branch) {
+		return nil, backend.ErrRecordNotFound
+	}
+	files, err := g.git(g.unvetted, "ls-tree", "--name-only", branch,
+		id+"/")
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	ms := make([]backend.MetadataStream, 0, len(files))
+	for _, v := range files {
+		// Skip irrelevant files
+		filename := strings.TrimPrefix(strings.TrimSpace(v), id+"/")
+		if !strings.HasSuffix(filename, defaultMDFilenameSuffix) {
+			continue
+		}
+
+		// Fish out metadata stream ID from filename
+		ids := strings.TrimSuffix(filename, defaultMDFilenameSuffix)
+		mdid, err := strconv.ParseUint(ids, 10, 64)
+		if err != nil {
+			log.Warnf("loadUnvettedMDStreams: skipping invalid "+
+				"metadata filename %v/%v: %v", id, filename, err)
+			continue
+		}
+
+		// Load metadata stream
+		md, err := g.gitRaw(g.unvetted, "show",
+			branch+":"+id+"/"+filename)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		ms = append(ms, backend.MetadataStream{
+			ID:      mdid,
+			Payload: string(md),
+		})
+	}
+
+	return ms, nil
+}
+
+// IsCensored returns true if the record identified by token was censored.
+// Only the record metadata is read.  It returns backend.ErrRecordNotFound if
+// the token is unknown.
+//
+// IsCensored satisfies the backend interface.
+func (g *gitBackEnd) IsCensored(token []byte) (bool, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return false, backend.ErrShutdown
+	}
+
+	// Vetted records can not be censored but they do exist.
+	id := hex.EncodeToString(token)
+	brm, err := loadMD(g.vetted, id)
+	if err == nil {
+		return brm.Status == backend.MDStatusCensored, nil
+	}
+	if err != backend.ErrRecordNotFound {
+		return false, err
+	}
+
+	// Censored records remain on their unvetted branch.
+	ids, err := g.recordBranches(g.unvetted)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range ids {
+		if v != id {
+			continue
+		}
+		brm, err := g.loadUnvettedMD(id)
+		if err != nil {
+			return false, err
+		}
+		return brm.Status == backend.MDStatusCensored, nil
+	}
+
+	return false, backend.ErrRecordNotFound
+}
+
+// StatusCounts returns the number of vetted and unvetted records per status.
+// Only the record metadata is read, unvetted records are read straight from
+// their branch so no checkouts are required.
+//
+// StatusCounts satisfies the backend interface.
+func (g *gitBackEnd) StatusCounts() (map[backend.MDStatusT]int, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	counts := make(map[backend.MDStatusT]int)
+
+	// Walk vetted
+	files, err := ioutil.ReadDir(g.vetted)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range files {
+		id := v.Name()
+		if !util.IsDigest(id) {
+			continue
+		}
+		brm, err := loadMD(g.vetted, id)
 		if err != nil {
 			return nil, err
 		}
+		counts[brm.Status]++
+	}
 
-		// Create and rebase PR
-		err = g.rebasePR(id)
+	// Walk branches on unvetted
+	ids, err := g.recordBranches(g.unvetted)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		brm, err := g.loadUnvettedMD(id)
 		if err != nil {
 			return nil, err
 		}
+		counts[brm.Status]++
+	}
 
-	case record.RecordMetadata.Status == backend.MDStatusUnvetted &&
-		status == backend.MDStatusCensored:
-		// unvetted -> censored
-		record.RecordMetadata.Status = backend.MDStatusCensored
-		record.RecordMetadata.Version += 1
-		record.RecordMetadata.Timestamp = time.Now().Unix()
-		err = updateMD(g.unvetted, id, &record.RecordMetadata)
-		if err != nil {
-			return nil, err
-		}
+	return counts, nil
+}
 
-		// Handle metadata
-		err = g.updateMetadata(id, mdAppend, mdOverwrite)
+// AuditMIMETypes returns the files of all vetted records whose MIME type is
+// not allowed by the current MIME policy in token order.  Files are reported
+// by the MIME type they were declared with, see loadRecord.  Nothing is
+// modified, this lets operators find the records that would fail a stricter
+// policy before enforcing it.
+//
+// AuditMIMETypes satisfies the backend interface.
+func (g *gitBackEnd) AuditMIMETypes() ([]backend.MIMEViolation, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
 		if err != nil {
-			return nil, err
+			log.Errorf("Unlock error: %v", err)
 		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
 
-		// Commit brm
-		err = g.commitMD(g.unvetted, id, "censored")
+	// Walk vetted
+	files, err := ioutil.ReadDir(g.vetted)
+	if err != nil {
+		return nil, err
+	}
+	var mv []backend.MIMEViolation
+	for _, v := range files {
+		id := v.Name()
+		if !util.IsDigest(id) {
+			continue
+		}
+		bf, err := g.loadRecord(g.vetted, id)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("load record %v: %v", id, err)
 		}
-	default:
-		return nil, backend.StateTransitionError{
-			From: record.RecordMetadata.Status,
-			To:   status,
+		for _, f := range bf {
+			if mime.MimeValid(f.MIME) {
+				continue
+			}
+			mv = append(mv, backend.MIMEViolation{
+				Token: id,
+				File:  f.Name,
+				MIME:  f.MIME,
+			})
 		}
 	}
 
-	return record, nil
+	return mv, nil
 }
 
-// SetUnvettedStatus tries to update the status for an unvetted record. It
-// returns the updated record if successful but without the Files compnonet.
+// ReviewQueue returns the metadata of all records that are pending review,
+// MDStatusUnvetted and MDStatusIterationUnvetted, sorted by submission time,
+// oldest first.  Records pending review live on their unvetted branch which is
+// read without checkouts.
 //
-// SetUnvettedStatus satisfies the backend interface.
-func (g *gitBackEnd) SetUnvettedStatus(token []byte, status backend.MDStatusT, mdAppend, mdOverwrite []backend.MetadataStream) (*backend.Record, error) {
+// ReviewQueue satisfies the backend interface.
+func (g *gitBackEnd) ReviewQueue() ([]backend.RecordMetadata, error) {
 	// Lock filesystem
 	err := g.lock.Lock(LockDuration)
 	if err != nil {
@@ -1897,41 +3716,32 @@ func (g *gitBackEnd) SetUnvettedStatus(token []byte, status backend.MDStatusT, m
 		return nil, backend.ErrShutdown
 	}
 
-	log.Tracef("setting status %v (%v) -> %x", status,
-		backend.MDStatus[status], token)
-	var errReturn error
-	record, err := g.setUnvettedStatus(token, status, mdAppend, mdOverwrite)
-	if err != nil {
-		// git stash
-		err2 := g.gitStash(g.unvetted)
-		if err2 != nil {
-			// We are in trouble!  Consider a panic.
-			log.Errorf("gitStash: %v", err2)
-			return nil, err2
-		}
-		errReturn = err
-	}
-
-	// git checkout master
-	err = g.gitCheckout(g.unvetted, "master")
+	queue, err := g.pendingReview()
 	if err != nil {
 		return nil, err
 	}
 
-	if errReturn != nil {
-		return nil, errReturn
-	}
+	// Ties are broken by token so that the order is stable
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].Timestamp != queue[j].Timestamp {
+			return queue[i].Timestamp < queue[j].Timestamp
+		}
+		return bytes.Compare(queue[i].Token, queue[j].Token) < 0
+	})
 
-	return record, nil
+	return queue, nil
 }
 
-// Inventory returns an inventory of vetted and unvetted records.  If
-// includeFiles is set the content is also returned.
-func (g *gitBackEnd) Inventory(vettedCount, branchCount uint, includeFiles bool) ([]backend.Record, []backend.Record, error) {
+// RecordDigests returns the latest commit digest of every vetted record keyed
+// by token.  The digests are obtained from a single git log walk so that
+// consumers can cheaply detect which records changed.
+//
+// RecordDigests satisfies the backend interface.
+func (g *gitBackEnd) RecordDigests() (map[string]string, error) {
 	// Lock filesystem
 	err := g.lock.Lock(LockDuration)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	defer func() {
 		err := g.lock.Unlock()
@@ -1940,58 +3750,135 @@ func (g *gitBackEnd) Inventory(vettedCount, branchCount uint, includeFiles bool)
 		}
 	}()
 	if g.shutdown {
-		return nil, nil, backend.ErrShutdown
+		return nil, backend.ErrShutdown
 	}
 
-	// Walk vetted, we can simply take the vetted directory and sort the
-	// entries by time.
-	files, err := ioutil.ReadDir(g.vetted)
+	// Walk the log from newest to oldest commit.  The first commit that
+	// touches a record is its latest.
+	out, err := g.git(g.vetted, "log", "--format=commit %H", "--name-only")
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
-	// Strip non record directories
-	pr := make([]backend.Record, 0, len(files))
-	for _, v := range files {
-		id := v.Name()
+	digests := make(map[string]string)
+	var commit string
+	for _, line := range out {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "commit ") {
+			commit = strings.TrimPrefix(line, "commit ")
+			continue
+		}
+		id := strings.SplitN(line, "/", 2)[0]
 		if !util.IsDigest(id) {
 			continue
 		}
-
-		ids, err := hex.DecodeString(id)
-		if err != nil {
-			return nil, nil, err
+		if _, ok := digests[id]; !ok {
+			digests[id] = commit
 		}
-		prv, err := g.getRecord(ids, g.vetted, includeFiles)
+	}
+
+	return digests, nil
+}
+
+// ModifiedSince returns the record metadata of every vetted record that was
+// committed to at or after the provided unix timestamp, sorted by token.
+// Payloads are not loaded.
+//
+// ModifiedSince satisfies the backend interface.
+func (g *gitBackEnd) ModifiedSince(since int64) ([]backend.RecordMetadata, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
 		if err != nil {
-			return nil, nil, err
+			log.Errorf("Unlock error: %v", err)
 		}
-		pr = append(pr, *prv)
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
 	}
 
-	// Walk Branches on unvetted
-	branches, err := g.gitBranches(g.unvetted)
+	// git log --since=@0 is not parsed as a timestamp so only filter on
+	// actual times
+	args := []string{"log", "--format=", "--name-only"}
+	if since > 0 {
+		args = append(args, "--since=@"+strconv.FormatInt(since, 10))
+	}
+	out, err := g.git(g.vetted, args...)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	br := make([]backend.Record, 0, len(branches))
-	for _, id := range branches {
+	seen := make(map[string]struct{})
+	ids := make([]string, 0, len(out))
+	for _, line := range out {
+		id := strings.SplitN(strings.TrimSpace(line), "/", 2)[0]
 		if !util.IsDigest(id) {
 			continue
 		}
-
-		ids, err := hex.DecodeString(id)
-		if err != nil {
-			return nil, nil, err
+		if _, ok := seen[id]; ok {
+			continue
 		}
-		pru, err := g.getRecord(ids, g.unvetted, includeFiles)
-		if err != nil {
-			return nil, nil, err
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	brms := make([]backend.RecordMetadata, 0, len(ids))
+	for _, id := range ids {
+		brm, err := loadMD(g.vetted, id)
+		if err == backend.ErrRecordNotFound {
+			// Record was removed since
+			continue
+		} else if err != nil {
+			return nil, err
 		}
-		br = append(br, *pru)
+		brms = append(brms, *brm)
 	}
 
-	return pr, br, nil
+	return brms, nil
+}
+
+// PingDcrtime verifies that the configured dcrtime host is reachable and
+// speaks the expected API version.
+func (g *gitBackEnd) PingDcrtime() error {
+	if g.test {
+		return nil
+	}
+
+	err := util.StatusWithClient(g.httpClient, g.dcrtimeHost)
+	if err != nil {
+		return fmt.Errorf("dcrtime %v: %v", g.dcrtimeHost, err)
+	}
+
+	return nil
+}
+
+// Status returns the health status of the backend.
+//
+// Status satisfies the backend interface.
+func (g *gitBackEnd) Status() (*backend.Status, error) {
+	if g.isShutdown() {
+		return nil, backend.ErrShutdown
+	}
+
+	g.anchorHealthMtx.Lock()
+	s := backend.Status{
+		DcrtimeHost:  g.dcrtimeHost,
+		Anchor:       g.anchorHealth,
+		AnchorPaused: g.isAnchoringPaused(),
+	}
+	g.anchorHealthMtx.Unlock()
+	err := g.PingDcrtime()
+	if err != nil {
+		s.DcrtimeError = err.Error()
+	}
+
+	return &s, nil
 }
 
 // GetPlugins returns a list of currently supported plugins and their settings.
@@ -2018,10 +3905,28 @@ func (g *gitBackEnd) Plugin(command, payload string) (string, string, error) {
 	case decredplugin.CmdBestBlock:
 		payload, err := g.pluginBestBlock()
 		return decredplugin.CmdBestBlock, payload, err
+	case decredplugin.CmdVoteDetails:
+		payload, err := g.pluginVoteDetails(payload)
+		return decredplugin.CmdVoteDetails, payload, err
+	case decredplugin.CmdCancelVote:
+		payload, err := g.pluginCancelVote(payload)
+		return decredplugin.CmdCancelVote, payload, err
+	case decredplugin.CmdVoteResults:
+		payload, err := g.pluginVoteResults(payload)
+		return decredplugin.CmdVoteResults, payload, err
 	}
 	return "", "", fmt.Errorf("invalid payload command") // XXX this needs to become a type error
 }
 
+// isShutdown returns true once the backend is shut down.  Functions that hold
+// the lock read shutdown directly, functions that don't must use isShutdown.
+func (g *gitBackEnd) isShutdown() bool {
+	g.shutdownMtx.Lock()
+	defer g.shutdownMtx.Unlock()
+
+	return g.shutdown
+}
+
 // Close shuts down the backend.  It obtains the lock and sets the shutdown
 // boolean to true.  All interface functions MUST return with errShutdown if
 // the backend is shutting down.
@@ -2040,8 +3945,95 @@ func (g *gitBackEnd) Close() {
 		}
 	}()
 
+	g.shutdownMtx.Lock()
 	g.shutdown = true
+	g.shutdownMtx.Unlock()
 	close(g.exit)
+
+	if g.db != nil {
+		err = g.db.Close()
+		if err != nil {
+			log.Errorf("Close labels: %v", err)
+		}
+	}
+	if g.mdIndex != nil {
+		err = g.mdIndex.Close()
+		if err != nil {
+			log.Errorf("Close metadata index: %v", err)
+		}
+	}
+}
+
+// validateVettedLayout verifies that the master branch of an existing vetted
+// repository uses the politeia layout.  Only the .gitignore, the anchor audit
+// trail, the record manifest, the anchors directory and record directories
+// are allowed at the top level.  Every record directory must contain a record
+// metadata file and may only contain metadata streams and a payload
+// directory, the files of which may be grouped in one of the payloadDirs.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) validateVettedLayout() error {
+	if !g.gitBranchExists(g.vetted, "master") {
+		return fmt.Errorf("incompatible vetted repository %v: no master "+
+			"branch", g.vetted)
+	}
+	out, err := g.git(g.vetted, "ls-tree", "-r", "-z", "--name-only",
+		"master")
+	if err != nil {
+		return err
+	}
+
+	records := make(map[string]bool)
+	for _, filename := range strings.Split(strings.Join(out, "\n"), "\x00") {
+		if filename == "" {
+			continue
+		}
+		parts := strings.Split(filename, "/")
+		var valid bool
+		switch {
+		case len(parts) == 1:
+			valid = parts[0] == ".gitignore" ||
+				parts[0] == defaultAuditTrailFile ||
+				parts[0] == defaultManifestFile
+		case parts[0] == defaultAnchorsDirectory:
+			valid = len(parts) == 2
+		case util.IsDigest(parts[0]):
+			if _, ok := records[parts[0]]; !ok {
+				records[parts[0]] = false
+			}
+			switch {
+			case len(parts) == 2 &&
+				parts[1] == defaultRecordMetadataFilename:
+				records[parts[0]] = true
+				valid = true
+			case len(parts) == 2 && parts[1] == defaultMIMEFilename:
+				valid = true
+			case len(parts) == 2 &&
+				strings.HasSuffix(parts[1], defaultMDFilenameSuffix):
+				_, err := strconv.ParseUint(strings.TrimSuffix(parts[1],
+					defaultMDFilenameSuffix), 10, 64)
+				valid = err == nil
+			case len(parts) == 3 && parts[1] == defaultPayloadDir:
+				valid = true
+			case len(parts) == 4 && parts[1] == defaultPayloadDir &&
+				payloadDirs[parts[2]]:
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("incompatible vetted repository %v: "+
+				"unexpected file %v", g.vetted, filename)
+		}
+	}
+	for id, hasMD := range records {
+		if !hasMD {
+			return fmt.Errorf("incompatible vetted repository %v: "+
+				"record %v has no %v", g.vetted, id,
+				defaultRecordMetadataFilename)
+		}
+	}
+
+	return nil
 }
 
 // newLocked runs the portion of new that has to be locked.
@@ -2064,22 +4056,27 @@ func (g *gitBackEnd) newLocked() error {
 		}
 	}()
 
-	// Ensure git works
-	version, err := g.gitVersion()
+	// Ensure git works and is recent enough
+	version, err := g.gitCheckVersion(g.minGitVersion)
 	if err != nil {
 		return err
 	}
 
 	log.Infof("Git version: %v", version)
 
-	// Init vetted git repo
-	err = g.gitInitRepo(g.vetted, defaultRepoConfig)
-	if err != nil {
-		return err
+	// Adopt an existing vetted git repo, otherwise init it
+	_, err = os.Stat(filepath.Join(g.vetted, ".git"))
+	switch {
+	case err == nil:
+		log.Infof("Using existing vetted repository: %v", g.vetted)
+		err = g.gitInitialCommit(g.vetted)
+		if err != nil {
+			return err
+		}
+		err = g.validateVettedLayout()
+	case os.IsNotExist(err):
+		err = g.gitInitRepo(g.vetted, defaultRepoConfig)
 	}
-
-	// Clone vetted repo into unvetted
-	err = g.gitClone(g.vetted, g.unvetted, defaultRepoConfig)
 	if err != nil {
 		return err
 	}
@@ -2090,27 +4087,61 @@ func (g *gitBackEnd) newLocked() error {
 	if err != nil {
 		return err
 	}
+	if g.verifyObjects {
+		log.Infof("Verifying git objects of vetted repository")
+		err = g.gitVerifyObjects(g.vetted)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Clone vetted repo into unvetted
+	err = g.gitClone(g.vetted, g.unvetted, defaultRepoConfig)
+	if err != nil {
+		return err
+	}
+
+	// Namespace record branches of repos that predate records/
+	for _, repo := range []string{g.vetted, g.unvetted} {
+		err = g.migrateBranches(repo)
+		if err != nil {
+			return err
+		}
+	}
+
 	log.Infof("Running git fsck on unvetted repository")
 	_, err = g.gitFsck(g.unvetted)
-	return err
+	if err != nil {
+		return err
+	}
+	if g.verifyObjects {
+		log.Infof("Verifying git objects of unvetted repository")
+		err = g.gitVerifyObjects(g.unvetted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.loadPending()
 }
 
-// rebasePR pushes branch id into upstream (vetted repo) and rebases it onto
-// master followed by replaying the rebase into origin (unvetted repo).
+// rebasePR pushes branch into upstream (vetted repo) and rebases it onto
+// master followed by replaying the rebase into origin (unvetted repo).  branch
+// is a record branch as returned by recordBranch or tmpBranch.
 // This function must be called with the lock held.
-func (g *gitBackEnd) rebasePR(id string) error {
+func (g *gitBackEnd) rebasePR(branch string) error {
 	// on unvetted repo:
 	//     git checkout master
 	//     git pull --ff--only --rebase
-	//     git checkout id
+	//     git checkout branch
 	//     git rebase master
-	//     git push --set-upstream origin id
+	//     git push --set-upstream origin branch
 	// on vetted repo:
-	//     git rebase id
-	//     git branch -D id
+	//     git rebase branch
+	//     git branch -D branch
 	// on unvetted repo:
 	//     git checkout master
-	//     git branch -D id
+	//     git branch -D branch
 	//     git pull --ff-only
 
 	//
@@ -2128,10 +4159,10 @@ func (g *gitBackEnd) rebasePR(id string) error {
 		return err
 	}
 
-	// git checkout id
-	err = g.gitCheckout(g.unvetted, id)
+	// git checkout branch
+	err = g.gitCheckout(g.unvetted, branch)
 	if err != nil {
-		return backend.ErrRecordNotFound
+		return fmt.Errorf("checkout %v: %v", branch, err)
 	}
 
 	// git rebase master
@@ -2140,8 +4171,8 @@ func (g *gitBackEnd) rebasePR(id string) error {
 		return err
 	}
 
-	// git push --set-upstream origin id
-	err = g.gitPush(g.unvetted, "origin", id, true)
+	// git push --set-upstream origin branch
+	err = g.gitPush(g.unvetted, "origin", branch, true)
 	if err != nil {
 		return err
 	}
@@ -2150,14 +4181,20 @@ func (g *gitBackEnd) rebasePR(id string) error {
 	// VETTED REPO REPLAY BRANCH
 	//
 
-	// git rebase id
-	err = g.gitRebase(g.vetted, id)
+	// git rebase branch
+	err = g.gitRebase(g.vetted, branch)
 	if err != nil {
+		// The rebase has been aborted, drop the pushed branch so
+		// that the next attempt starts from a clean slate.
+		err2 := g.gitBranchDelete(g.vetted, branch)
+		if err2 != nil {
+			log.Errorf("gitBranchDelete %v: %v", branch, err2)
+		}
 		return err
 	}
 
-	// git branch -D id
-	err = g.gitBranchDelete(g.vetted, id)
+	// git branch -D branch
+	err = g.gitBranchDelete(g.vetted, branch)
 	if err != nil {
 		return err
 	}
@@ -2178,17 +4215,59 @@ func (g *gitBackEnd) rebasePR(id string) error {
 		return err
 	}
 
-	// git branch -D id
-	return g.gitBranchDelete(g.unvetted, id)
+	// git branch -D branch
+	return g.gitBranchDelete(g.unvetted, branch)
 }
 
-// New returns a gitBackEnd context.  It verifies that git is installed.
-func New(anp *chaincfg.Params, root string, dcrtimeHost string, gitPath string, id *identity.FullIdentity, gitTrace bool) (*gitBackEnd, error) {
+// New returns a gitBackEnd context.  It verifies that git is installed.  opts
+// may be nil in which case all options use their defaults.
+func New(anp *chaincfg.Params, root string, dcrtimeHost string, gitPath string, id *identity.FullIdentity, gitTrace bool, opts *Options) (*gitBackEnd, error) {
 	// Default to system git
 	if gitPath == "" {
 		gitPath = "git"
 	}
 
+	// Default options
+	if opts == nil {
+		opts = &Options{}
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = util.NewDcrtimeClient(util.DefaultDcrtimeTimeout,
+			nil)
+	}
+	anchorPoll := opts.AnchorPollInterval
+	if anchorPoll == 0 {
+		anchorPoll = defaultAnchorPollInterval
+	}
+	if anchorPoll < minAnchorPollInterval {
+		return nil, fmt.Errorf("anchor poll interval %v is below the "+
+			"minimum of %v", anchorPoll, minAnchorPollInterval)
+	}
+	if opts.MinAnchorAge < 0 {
+		return nil, fmt.Errorf("invalid minimum anchor age %v",
+			opts.MinAnchorAge)
+	}
+	if opts.MaxUnvetted < 0 {
+		return nil, fmt.Errorf("invalid maximum unvetted records %v",
+			opts.MaxUnvetted)
+	}
+	minGitVersion := opts.MinGitVersion
+	if minGitVersion == "" {
+		minGitVersion = defaultMinGitVersion
+	}
+	if _, err := parseGitVersion(minGitVersion); err != nil {
+		return nil, err
+	}
+	indexedStreams := opts.IndexedMDStreams
+	if indexedStreams == nil {
+		indexedStreams = defaultIndexedMDStreams
+	}
+	indexedMD := make(map[uint64]struct{}, len(indexedStreams))
+	for _, v := range indexedStreams {
+		indexedMD[v] = struct{}{}
+	}
+
 	g := &gitBackEnd{
 		activeNetParams: anp,
 		root:            root,
@@ -2196,18 +4275,47 @@ func New(anp *chaincfg.Params, root string, dcrtimeHost string, gitPath string,
 		unvetted:        filepath.Join(root, defaultUnvettedPath),
 		vetted:          filepath.Join(root, defaultVettedPath),
 		gitPath:         gitPath,
+		gitTimeout:      opts.GitTimeout,
+		minGitVersion:   minGitVersion,
+		tokenNamespace:  opts.TokenNamespace,
+		anchorPoll:      anchorPoll,
+		minAnchorAge:    opts.MinAnchorAge,
+		fileMode:        opts.FileMode,
+		dirMode:         opts.DirMode,
 		dcrtimeHost:     dcrtimeHost,
+		httpClient:      httpClient,
+		blobThreshold:   opts.BlobThreshold,
+		compress:        opts.CompressPayloads,
+		maxMDStreams:    opts.MaxMDStreams,
+		maxMDSize:       opts.MaxMDSize,
+		maxFileSize:     opts.MaxFileSize,
+		maxUnvetted:     opts.MaxUnvetted,
+		fullFsck:        opts.FullFsck,
+		verifyObjects:   opts.VerifyObjects,
+		onAnchor:        opts.OnAnchor,
+		rateLimiter:     opts.RateLimiter,
+		bestBlockSource: opts.BestBlockSource,
+		encryptionKey:   opts.EncryptionKey,
+		identity:        id,
 		gitTrace:        gitTrace,
+		test:            opts.test,
 		exit:            make(chan struct{}),
 		checkAnchor:     make(chan struct{}),
 		testAnchors:     make(map[string]bool),
 		plugins:         []backend.Plugin{getDecredPlugin(anp.Name != "mainnet")},
+		indexedMD:       indexedMD,
+
+		decredPluginSettings:  make(map[string]string),
+		decredPluginVoteCache: make(map[string]*decredplugin.Vote),
+	}
+	for _, v := range g.plugins[0].Settings {
+		g.setDecredPluginSetting(v.Key, v.Value)
 	}
 	idJSON, err := id.Marshal()
 	if err != nil {
 		return nil, err
 	}
-	setDecredPluginSetting(decredPluginIdentity, string(idJSON))
+	g.setDecredPluginSetting(decredPluginIdentity, string(idJSON))
 
 	err = g.newLocked()
 	if err != nil {
@@ -2232,13 +4340,38 @@ func New(anp *chaincfg.Params, root string, dcrtimeHost string, gitPath string,
 
 	// Message user
 	log.Infof("Timestamp host: %v", g.dcrtimeHost)
+	err = g.PingDcrtime()
+	if err != nil {
+		// Log error but continue, anchoring will retry
+		log.Errorf("%v", err)
+	}
+
+	switch {
+	case opts.SkipStartupFsck:
+		log.Infof("Skipping dcrtime fsck on vetted repository")
+	case opts.AsyncStartupFsck:
+		go g.startupFsck()
+	default:
+		g.startupFsck()
+	}
+
+	return g, nil
+}
+
+// startupFsck runs the dcrtime fsck on the vetted repository and logs the
+// outcome.  It does not hold the lock while talking to dcrtime and may
+// therefore be run on its own go routine.
+func (g *gitBackEnd) startupFsck() {
+	if g.isShutdown() {
+		return
+	}
 
 	log.Infof("Running dcrtime fsck on vetted repository")
-	err = g.fsck(g.vetted)
+	err := g.fsck(g.vetted)
 	if err != nil {
 		// Log error but continue
 		log.Errorf("fsck: dcrtime %v", err)
+		return
 	}
-
-	return g, nil
+	log.Infof("Dcrtime fsck on vetted repository complete")
 }
@@ -10,22 +10,87 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/btcsuite/btclog"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/decred/dcrd/chaincfg"
+	dcrtime "github.com/decred/dcrtime/api/v1"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/decredplugin"
+	pd "github.com/decred/politeia/politeiad/api/v1"
+	"github.com/decred/politeia/politeiad/api/v1/identity"
 	"github.com/decred/politeia/politeiad/backend"
 	"github.com/decred/politeia/util"
 )
 
+// newTestBackEnd returns a backend in test mode that lives in a temporary
+// directory.  The returned function removes the directory.
+func newTestBackEnd(t *testing.T, opts *Options) (*gitBackEnd, func()) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+
+	dir, err := ioutil.TempDir("", "politeia.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), opts)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	g.test = true
+
+	return g, func() { os.RemoveAll(dir) }
+}
+
+// newTestFile returns a record file holding payload.
+func newTestFile(name string, payload []byte) backend.File {
+	return backend.File{
+		Name:    name,
+		MIME:    http.DetectContentType(payload),
+		Digest:  hex.EncodeToString(util.Digest(payload)),
+		Payload: base64.StdEncoding.EncodeToString(payload),
+	}
+}
+
+// newTestRecord creates an unvetted record with a single metadata stream and
+// the provided files.
+func newTestRecord(t *testing.T, g *gitBackEnd, files ...backend.File) *backend.RecordMetadata {
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+// vetTestRecord makes the unvetted record identified by token public.
+func vetTestRecord(t *testing.T, g *gitBackEnd, token []byte) *backend.Record {
+	emptyMD := []backend.MetadataStream{}
+	r, err := g.SetUnvettedStatus(token, backend.MDStatusVetted, emptyMD,
+		emptyMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
 func validateMD(got, want *backend.RecordMetadata) error {
 	if got.Version != want.Version+1 ||
 		got.Status != backend.MDStatusVetted ||
@@ -53,7 +118,7 @@ func TestExtendUnextend(t *testing.T) {
 	}
 }
 
-func TestAnchorWithCommits(t *testing.T) {
+func TestLoadMDStreamsSkipsInvalid(t *testing.T) {
 	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
 	UseLogger(log)
 
@@ -63,13 +128,48 @@ func TestAnchorWithCommits(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 
-	// Initialize stuff we need
-	g, err := New(&chaincfg.TestNet2Params, dir, "", "", nil,
-		testing.Verbose())
+	// Mix valid and junk metadata filenames
+	id := "record"
+	err = os.MkdirAll(filepath.Join(dir, id), 0774)
 	if err != nil {
 		t.Fatal(err)
 	}
-	g.test = true
+	files := map[string]string{
+		"0" + defaultMDFilenameSuffix:       "zero",
+		"12" + defaultMDFilenameSuffix:      "twelve",
+		"junk" + defaultMDFilenameSuffix:    "junk",
+		"-1" + defaultMDFilenameSuffix:      "negative",
+		defaultMDFilenameSuffix:             "empty",
+		defaultRecordMetadataFilename:       "{}",
+		"3" + defaultMDFilenameSuffix + "x": "not metadata",
+	}
+	for k, v := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, id, k), []byte(v),
+			0664)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mds, err := loadMDStreams(dir, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []backend.MetadataStream{
+		{ID: 0, Payload: "zero"},
+		{ID: 12, Payload: "twelve"},
+	}
+	if !reflect.DeepEqual(mds, want) {
+		t.Fatalf("unexpected metadata streams got %v, wanted %v",
+			spew.Sdump(mds), spew.Sdump(want))
+	}
+}
+
+func TestAnchorWithCommits(t *testing.T) {
+	// Initialize stuff we need
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	var err error
 
 	// Create 5 unvetted records
 	propCount := 5
@@ -120,7 +220,7 @@ func TestAnchorWithCommits(t *testing.T) {
 	for _, branch := range branches {
 		for _, v := range rm {
 			s := strings.Trim(branch, " \n")
-			if s == hex.EncodeToString(v.Token) {
+			if s == recordBranch(hex.EncodeToString(v.Token)) {
 				found++
 				break
 			}
@@ -376,5 +476,4312 @@ func TestAnchorWithCommits(t *testing.T) {
 	}
 }
 
+func TestGetRecordMetadataStreams(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Create two records, vet record 1
+	rm := make([]*backend.RecordMetadata, 2)
+	for i := range rm {
+		payload := []byte(fmt.Sprintf("record %v", i))
+		rm[i] = newTestRecord(t, g, newTestFile("file", payload))
+	}
+	vetTestRecord(t, g, rm[1].Token)
+
+	for k, v := range rm {
+		vetted := k == 1
+		get := g.GetUnvetted
+		if vetted {
+			get = g.GetVetted
+		}
+		r, err := get(v.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mds, err := g.GetRecordMetadataStreams(v.Token, vetted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(mds, r.Metadata) {
+			t.Fatalf("unexpected metadata got %v, wanted %v",
+				spew.Sdump(mds), spew.Sdump(r.Metadata))
+		}
+	}
+
+	// The branch of a vetted record is gone
+	_, err := g.GetRecordMetadataStreams(rm[1].Token, false)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestIsCensored(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Create two records, censor record 1
+	rm := make([]*backend.RecordMetadata, 2)
+	for i := range rm {
+		payload := []byte(fmt.Sprintf("record %v", i))
+		rm[i] = newTestRecord(t, g, newTestFile("file", payload))
+	}
+	emptyMD := []backend.MetadataStream{}
+	_, err := g.SetUnvettedStatus(rm[1].Token, backend.MDStatusCensored,
+		emptyMD, emptyMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range rm {
+		censored, err := g.IsCensored(v.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if censored != (k == 1) {
+			t.Fatalf("unexpected censored %v: %v", k, censored)
+		}
+	}
+	_, err = g.IsCensored([]byte{0xde, 0xad})
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+// newReviewedTestRecords creates three records, vets record 0 and censors
+// record 1.
+func newReviewedTestRecords(t *testing.T, g *gitBackEnd) []*backend.RecordMetadata {
+	rm := make([]*backend.RecordMetadata, 3)
+	for i := range rm {
+		payload := []byte(fmt.Sprintf("record %v", i))
+		rm[i] = newTestRecord(t, g, newTestFile("file", payload))
+	}
+	vetTestRecord(t, g, rm[0].Token)
+	emptyMD := []backend.MetadataStream{}
+	_, err := g.SetUnvettedStatus(rm[1].Token, backend.MDStatusCensored,
+		emptyMD, emptyMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rm
+}
+
+func TestStatusCounts(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	newReviewedTestRecords(t, g)
+
+	counts, err := g.StatusCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[backend.MDStatusVetted] != 1 ||
+		counts[backend.MDStatusCensored] != 1 ||
+		counts[backend.MDStatusUnvetted] != 1 {
+		t.Fatalf("unexpected status counts: %v", counts)
+	}
+}
+
+func TestReviewQueue(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	newReviewedTestRecords(t, g)
+
+	// Another record pending review
+	newTestRecord(t, g, newTestFile("file", []byte("record 3")))
+
+	queue, err := g.ReviewQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 2 {
+		t.Fatalf("unexpected review queue length %v", len(queue))
+	}
+	for k, v := range queue {
+		if v.Status != backend.MDStatusUnvetted {
+			t.Fatalf("unexpected review queue status %v", v.Status)
+		}
+		if k > 0 && v.Timestamp < queue[k-1].Timestamp {
+			t.Fatalf("review queue not sorted: %v", spew.Sdump(queue))
+		}
+	}
+}
+
+func TestInventoryCensored(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	newReviewedTestRecords(t, g)
+
+	// Censored branches are only part of the inventory on request
+	_, inv, err := g.Inventory(0, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv) != 1 {
+		t.Fatalf("unexpected branches %v", len(inv))
+	}
+	for _, v := range inv {
+		if v.RecordMetadata.Status == backend.MDStatusCensored {
+			t.Fatalf("censored branch in inventory")
+		}
+	}
+	_, inv, err = g.Inventory(0, 0, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv) != 2 {
+		t.Fatalf("unexpected branches %v", len(inv))
+	}
+}
+
+func TestInventoryJSON(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	rm := newReviewedTestRecords(t, g)
+
+	// The streamed inventory holds the same records, vetted first
+	for _, opts := range []backend.InventoryOptions{
+		{IncludeFiles: true, IncludeCensored: true},
+		{},
+	} {
+		vetted, branches, err := g.Inventory(0, 0, opts.IncludeFiles,
+			opts.IncludeCensored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = g.InventoryJSON(&buf, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var streamed []backend.Record
+		err = json.Unmarshal(buf.Bytes(), &streamed)
+		if err != nil {
+			t.Fatalf("invalid inventory JSON: %v", err)
+		}
+		expected, err := json.Marshal(append(vetted, branches...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(streamed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Fatalf("unexpected inventory JSON %+v: %s", opts,
+				buf.Bytes())
+		}
+	}
+
+	// A branch that was vetted after it was listed is streamed as vetted
+	r, err := g.lockedInventoryRecord(hex.EncodeToString(rm[0].Token),
+		g.unvetted, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RecordMetadata.Status != backend.MDStatusVetted {
+		t.Fatalf("unexpected status %v", r.RecordMetadata.Status)
+	}
+}
+
+func TestRecordDigests(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Create two records, vet record 1
+	rm := make([]*backend.RecordMetadata, 2)
+	for i := range rm {
+		payload := []byte(fmt.Sprintf("record %v", i))
+		rm[i] = newTestRecord(t, g, newTestFile("file", payload))
+	}
+	vetTestRecord(t, g, rm[1].Token)
+
+	digests, err := g.RecordDigests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := hex.EncodeToString(rm[1].Token)
+	out, err := g.git(g.vetted, "log", "-1", "--format=%H", "--", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 1 || digests[id] != out[0] {
+		t.Fatalf("unexpected record digests: %v", digests)
+	}
+}
+
+func TestRecordAnchorStatus(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Create two records, vet record 1
+	rm := make([]*backend.RecordMetadata, 2)
+	for i := range rm {
+		payload := []byte(fmt.Sprintf("record %v", i))
+		rm[i] = newTestRecord(t, g, newTestFile("file", payload))
+	}
+	vetTestRecord(t, g, rm[1].Token)
+
+	expect := func(want backend.AnchorStatus) {
+		t.Helper()
+		as, err := g.RecordAnchorStatus(rm[1].Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if as != want {
+			t.Fatalf("unexpected anchor status %v",
+				backend.AnchorStatuses[as])
+		}
+	}
+
+	// Not anchored, unconfirmed anchor and confirmed anchor
+	expect(backend.AnchorStatusNotAnchored)
+	err := g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(backend.AnchorStatusPending)
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(backend.AnchorStatusConfirmed)
+
+	// Unvetted records are not anchored
+	_, err = g.RecordAnchorStatus(rm[0].Token)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestAnchorHook(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	anchors := make(chan backend.AnchorInfo, 16)
+	g.onAnchor = func(ai backend.AnchorInfo) {
+		anchors <- ai
+	}
+
+	// Vet and anchor a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	err := g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unconfirmed, err := g.readUnconfirmedAnchorRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unconfirmed.Merkles) != 1 {
+		t.Fatalf("invalid merkles len %v", len(unconfirmed.Merkles))
+	}
+	var mr [sha256.Size]byte
+	copy(mr[:], unconfirmed.Merkles[0])
+	anchor, err := g.readAnchorRecord(mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hook is called for the anchor and for its confirmation
+	ai := <-anchors
+	if ai.Merkle != hex.EncodeToString(mr[:]) || ai.Confirmed ||
+		len(ai.Digests) != len(anchor.Digests) {
+		t.Fatalf("unexpected anchor hook %v", spew.Sdump(ai))
+	}
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ai = <-anchors
+	if ai.Merkle != hex.EncodeToString(mr[:]) || !ai.Confirmed ||
+		len(ai.Digests) != len(anchor.Digests) {
+		t.Fatalf("unexpected anchor hook %v", spew.Sdump(ai))
+	}
+}
+
+func TestProveAnchored(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Create two records, vet and anchor record 1
+	rm := make([]*backend.RecordMetadata, 2)
+	for i := range rm {
+		payload := []byte(fmt.Sprintf("record %v", i))
+		rm[i] = newTestRecord(t, g, newTestFile("file", payload))
+	}
+	vetTestRecord(t, g, rm[1].Token)
+	err := g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unconfirmed, err := g.readUnconfirmedAnchorRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unconfirmed.Merkles) != 1 {
+		t.Fatalf("invalid merkles len %v", len(unconfirmed.Merkles))
+	}
+	var mr [sha256.Size]byte
+	copy(mr[:], unconfirmed.Merkles[0])
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The commit digest is in the anchor
+	proof, err := g.ProveAnchored(rm[1].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Merkle != hex.EncodeToString(mr[:]) ||
+		proof.Transaction != expectedTestTX {
+		t.Fatalf("unexpected proof %v", spew.Sdump(proof))
+	}
+	root, err := merkle.VerifyAuthPath(&proof.AnchorBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *root != mr {
+		t.Fatalf("invalid proof root got %x wanted %x", *root, mr)
+	}
+	leaf, ok := util.ConvertDigest(proof.Digest)
+	if !ok {
+		t.Fatalf("invalid proof digest %v", proof.Digest)
+	}
+	found := 0
+	for _, h := range proof.AnchorBranch.Hashes {
+		if h == leaf {
+			found++
+		}
+	}
+	if found != 1 {
+		t.Fatalf("proof digest not in branch")
+	}
+
+	// The record merkle root is in the anchor as well
+	if proof.RecordMerkle != hex.EncodeToString(rm[1].Merkle[:]) {
+		t.Fatalf("unexpected record merkle %v", proof.RecordMerkle)
+	}
+	root, err = merkle.VerifyAuthPath(&proof.RecordBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *root != mr {
+		t.Fatalf("invalid record branch root got %x wanted %x", *root,
+			mr)
+	}
+
+	// The test dcrtime does not provide a dcrtime branch
+	err = VerifyAnchorProof(proof)
+	if err == nil || !strings.Contains(err.Error(), "dcrtime") {
+		t.Fatalf("expected dcrtime branch error, got %v", err)
+	}
+
+	// Unvetted records are not anchored
+	_, err = g.ProveAnchored(rm[0].Token)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
 func TestDcrtimeFsck(t *testing.T) {
 }
+
+func TestRepairRecord(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	var err error
+
+	// Create two records with multiple files
+	rm := make([]*backend.RecordMetadata, 2)
+	for i := range rm {
+		var files []backend.File
+		for _, name := range []string{"b", "a"} {
+			payload := fmt.Sprintf("record %v file %v", i, name)
+			files = append(files, backend.File{
+				Name: name,
+				MIME: http.DetectContentType([]byte(payload)),
+				Digest: hex.EncodeToString(util.Digest(
+					[]byte(payload))),
+				Payload: base64.StdEncoding.EncodeToString(
+					[]byte(payload)),
+			})
+		}
+		rm[i], err = g.New([]backend.MetadataStream{{
+			ID:      0,
+			Payload: "this is metadata",
+		}}, files)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Vet record 1
+	emptyMD := []backend.MetadataStream{}
+	vetted, err := g.SetUnvettedStatus(rm[1].Token, backend.MDStatusVetted,
+		emptyMD, emptyMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Intact records must not be repaired
+	for _, v := range rm {
+		err = g.RepairRecord(v.Token)
+		if err != backend.ErrRecordNotCorrupt {
+			t.Fatalf("expected ErrRecordNotCorrupt, got %v", err)
+		}
+	}
+
+	// corrupt writes junk record metadata to repo path and commits it.
+	corrupt := func(path, id string) {
+		filename := filepath.Join(path, id,
+			defaultRecordMetadataFilename)
+		err := ioutil.WriteFile(filename, []byte("{junk"), 0664)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.gitAdd(path, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.gitCommit(path, "Corrupt "+id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Corrupt and repair unvetted record 0
+	id := hex.EncodeToString(rm[0].Token)
+	err = g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt(g.unvetted, id)
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.GetUnvetted(rm[0].Token)
+	if err == nil {
+		t.Fatalf("expected corrupt record")
+	}
+	err = g.RepairRecord(rm[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := g.GetUnvetted(rm[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rebuilt record metadata, timestamp included, is the original
+	got := r.RecordMetadata
+	if !reflect.DeepEqual(&got, rm[0]) {
+		t.Fatalf("unexpected rm got %v, wanted %v", spew.Sdump(got),
+			spew.Sdump(rm[0]))
+	}
+
+	// Corrupt and repair vetted record 1
+	id = hex.EncodeToString(rm[1].Token)
+	corrupt(g.vetted, id)
+	err = g.RepairRecord(rm[1].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = g.GetVetted(rm[1].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = r.RecordMetadata
+	if !reflect.DeepEqual(got, vetted.RecordMetadata) {
+		t.Fatalf("unexpected rm got %v, wanted %v", spew.Sdump(got),
+			spew.Sdump(vetted.RecordMetadata))
+	}
+}
+
+func TestRecalculateMerkle(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Create two records, vet record 1
+	rm := make([]*backend.RecordMetadata, 2)
+	for i := range rm {
+		payload := []byte(fmt.Sprintf("record %v", i))
+		rm[i] = newTestRecord(t, g, newTestFile("file", payload))
+	}
+	vetTestRecord(t, g, rm[1].Token)
+
+	// Correct merkle roots are left alone
+	head, err := g.gitLastDigest(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range rm {
+		brm, err := g.RecalculateMerkle(v.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if brm.Merkle != v.Merkle {
+			t.Fatalf("unexpected merkle %x", brm.Merkle)
+		}
+	}
+	head2, err := g.gitLastDigest(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(head, head2) {
+		t.Fatalf("no-op committed")
+	}
+
+	// stale commits record metadata with a bogus merkle root.
+	stale := func(path, id string) {
+		brm, err := loadMD(path, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		brm.Merkle[0] ^= 0xff
+		err = g.updateMD(path, id, brm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.gitAdd(path, filepath.Join(path, id,
+			defaultRecordMetadataFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.gitCommit(path, "Stale merkle "+id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unvetted record 0
+	id := hex.EncodeToString(rm[0].Token)
+	err = g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale(g.unvetted, id)
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	brm, err := g.RecalculateMerkle(rm[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brm.Merkle != rm[0].Merkle || brm.Version != rm[0].Version {
+		t.Fatalf("unexpected rm %v", spew.Sdump(brm))
+	}
+	err = g.CanSetUnvettedStatus(rm[0].Token, backend.MDStatusVetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Vetted record 1
+	id = hex.EncodeToString(rm[1].Token)
+	stale(g.vetted, id)
+	_, err = g.RecalculateMerkle(rm[1].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brm, err = loadMD(g.vetted, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brm.Merkle != rm[1].Merkle {
+		t.Fatalf("vetted merkle not fixed %x", brm.Merkle)
+	}
+	out, err := g.git(g.vetted, "log", "-n", "1", "--pretty=format:%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || !strings.HasPrefix(out[0], "Recalculate merkle "+id) {
+		t.Fatalf("unexpected commit %v", out)
+	}
+
+	// Unknown record
+	_, err = g.RecalculateMerkle([]byte{0xde, 0xad})
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestBlobStore(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{BlobThreshold: 32})
+	defer cleanup()
+	dir := g.root
+
+	// A small file, a large file and a small file that looks like a blob
+	// pointer.
+	payloads := map[string]string{
+		"small":   "small",
+		"large":   strings.Repeat("large ", 10),
+		"pointer": string(blobPointer(make([]byte, sha256.Size))),
+	}
+	var (
+		files  []backend.File
+		hashes []*[sha256.Size]byte
+	)
+	for _, name := range []string{"large", "pointer", "small"} {
+		payload := []byte(payloads[name])
+		var d [sha256.Size]byte
+		copy(d[:], util.Digest(payload))
+		hashes = append(hashes, &d)
+		files = append(files, backend.File{
+			Name:    name,
+			MIME:    http.DetectContentType(payload),
+			Digest:  hex.EncodeToString(d[:]),
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		})
+	}
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm.Merkle != *merkle.Root(append([]*[sha256.Size]byte{},
+		hashes...)) {
+		t.Fatalf("unexpected merkle root")
+	}
+
+	// Only the small file is stored in git
+	id := hex.EncodeToString(rm.Token)
+	err = g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, payload := range payloads {
+		b, err := ioutil.ReadFile(filepath.Join(g.unvetted, id,
+			defaultPayloadDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, isPointer := parseBlobPointer(b)
+		if isPointer != (name != "small") {
+			t.Fatalf("%v: unexpected pointer %v", name, isPointer)
+		}
+		if !isPointer && string(b) != payload {
+			t.Fatalf("%v: unexpected payload %q", name, b)
+		}
+	}
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pointers are resolved transparently
+	r, err := g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Files, files) {
+		t.Fatalf("unexpected files got %v, wanted %v",
+			spew.Sdump(r.Files), spew.Sdump(files))
+	}
+
+	// Digests are taken from the pointers without reading the blobs
+	err = os.RemoveAll(filepath.Join(dir, defaultBlobDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, name := range []string{"large", "pointer", "small"} {
+		d, err := g.payloadDigest(filepath.Join(g.unvetted, id,
+			defaultPayloadDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, hashes[k][:]) {
+			t.Fatalf("%v: unexpected digest %x", name, d)
+		}
+	}
+}
+
+func TestPurgeFile(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{BlobThreshold: 32})
+	defer cleanup()
+
+	payloads := map[string]string{
+		"large": strings.Repeat("large ", 10),
+		"small": "small",
+	}
+	var files []backend.File
+	for _, name := range []string{"large", "small"} {
+		payload := []byte(payloads[name])
+		files = append(files, backend.File{
+			Name:    name,
+			MIME:    http.DetectContentType(payload),
+			Digest:  hex.EncodeToString(util.Digest(payload)),
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		})
+	}
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vetTestRecord(t, g, rm.Token)
+
+	// Unknown files and payloads committed to git can not be purged
+	err = g.PurgeFile(rm.Token, "nope")
+	if err != backend.ErrFileNotFound {
+		t.Fatalf("expected ErrFileNotFound, got %v", err)
+	}
+	err = g.PurgeFile(rm.Token, "small")
+	if err != backend.ErrFileNotPurgeable {
+		t.Fatalf("expected ErrFileNotPurgeable, got %v", err)
+	}
+
+	// Purge twice, the second time is a no-op
+	for i := 0; i < 2; i++ {
+		err = g.PurgeFile(rm.Token, "large")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = os.Stat(g.blobFilename(files[0].Digest))
+	if !os.IsNotExist(err) {
+		t.Fatalf("blob not deleted: %v", err)
+	}
+
+	// The file is still listed with its digest but without content and the
+	// record still verifies
+	r, err := g.GetVetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged := files[0]
+	purged.Payload = ""
+	expected := []backend.File{purged, files[1]}
+	if !reflect.DeepEqual(r.Files, expected) {
+		t.Fatalf("unexpected files got %v, wanted %v",
+			spew.Sdump(r.Files), spew.Sdump(expected))
+	}
+	err = g.verifyMerkle(g.vetted, hex.EncodeToString(rm.Token),
+		&r.RecordMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Resubmitting the content does not restore the blob
+	_, err = g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, files[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(g.blobFilename(files[0].Digest))
+	if !os.IsNotExist(err) {
+		t.Fatalf("blob restored: %v", err)
+	}
+}
+
+func TestOpenRecordFile(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{
+		BlobThreshold:    2048,
+		CompressPayloads: true,
+	})
+	defer cleanup()
+
+	// A blob, a compressed and a plain payload, one of them in a payload
+	// directory
+	payloads := map[string]string{
+		"blob":         strings.Repeat("blob ", 1000),
+		"compressed":   strings.Repeat("compressed ", 100),
+		"plain":        "plain",
+		"images/plain": "plain image",
+	}
+	var files []backend.File
+	for _, name := range []string{"blob", "compressed", "plain",
+		"images/plain"} {
+		payload := []byte(payloads[name])
+		files = append(files, backend.File{
+			Name:    name,
+			MIME:    http.DetectContentType(payload),
+			Digest:  hex.EncodeToString(util.Digest(payload)),
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		})
+	}
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(vetted bool) {
+		for _, f := range files {
+			r, fi, err := g.OpenRecordFile(rm.Token, f.Name, vetted)
+			if err != nil {
+				t.Fatalf("%v: %v", f.Name, err)
+			}
+			b, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatalf("%v: %v", f.Name, err)
+			}
+			if string(b) != payloads[f.Name] {
+				t.Fatalf("%v: unexpected payload", f.Name)
+			}
+			expected := backend.FileInfo{
+				Name:   f.Name,
+				MIME:   f.MIME,
+				Digest: f.Digest,
+				Size:   int64(len(payloads[f.Name])),
+			}
+			if *fi != expected {
+				t.Fatalf("%v: got %v, want %v", f.Name,
+					spew.Sdump(*fi), spew.Sdump(expected))
+			}
+		}
+	}
+
+	// Unvetted
+	verify(false)
+	_, _, err = g.OpenRecordFile(rm.Token, "blob", true)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	// Vetted
+	vetTestRecord(t, g, rm.Token)
+	verify(true)
+	_, _, err = g.OpenRecordFile(rm.Token, "nope", true)
+	if err != backend.ErrFileNotFound {
+		t.Fatalf("expected ErrFileNotFound, got %v", err)
+	}
+
+	// A corrupt blob is detected once it is read
+	err = ioutil.WriteFile(g.blobFilename(files[0].Digest),
+		[]byte("corrupt"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := g.OpenRecordFile(rm.Token, "blob", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	if err == nil {
+		t.Fatalf("expected corrupt blob")
+	}
+}
+
+func TestCompressPayloads(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{CompressPayloads: true})
+	defer cleanup()
+
+	// A small file, a large text file, a large image that does not
+	// compress and a file that looks like a gzip pointer.
+	image, err := util.Random(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image = append([]byte("\x89PNG\r\n\x1a\n"), image...)
+	payloads := map[string]string{
+		"small":   "small",
+		"text":    strings.Repeat("this is a proposal ", 100),
+		"image":   string(image),
+		"pointer": gzipPointerPrefix + strings.Repeat("0", 64) + "\nx",
+	}
+	names := []string{"image", "pointer", "small", "text"}
+	var (
+		files  []backend.File
+		hashes []*[sha256.Size]byte
+	)
+	for _, name := range names {
+		payload := []byte(payloads[name])
+		var d [sha256.Size]byte
+		copy(d[:], util.Digest(payload))
+		hashes = append(hashes, &d)
+		files = append(files, backend.File{
+			Name:    name,
+			MIME:    http.DetectContentType(payload),
+			Digest:  hex.EncodeToString(d[:]),
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		})
+	}
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm.Merkle != *merkle.Root(append([]*[sha256.Size]byte{},
+		hashes...)) {
+		t.Fatalf("unexpected merkle root")
+	}
+
+	// Only the text file is compressed and the lookalike is a blob
+	id := hex.EncodeToString(rm.Token)
+	err = g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, name := range names {
+		filename := filepath.Join(g.unvetted, id, defaultPayloadDir,
+			name)
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, isGzip := parseGzipPointer(b)
+		_, isBlob := parseBlobPointer(b)
+		switch {
+		case isGzip != (name == "text"):
+			t.Fatalf("%v: unexpected gzip pointer %v", name, isGzip)
+		case isBlob != (name == "pointer"):
+			t.Fatalf("%v: unexpected blob pointer %v", name, isBlob)
+		case !isGzip && !isBlob && string(b) != payloads[name]:
+			t.Fatalf("%v: unexpected payload %q", name, b)
+		case isGzip && len(b) >= len(payloads[name]):
+			t.Fatalf("%v: not compressed %v", name, len(b))
+		}
+		d, err := g.payloadDigest(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, hashes[k][:]) {
+			t.Fatalf("%v: unexpected digest %x", name, d)
+		}
+	}
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Payloads are decompressed transparently and survive vetting
+	r, err := g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Files, files) {
+		t.Fatalf("unexpected files got %v, wanted %v",
+			spew.Sdump(r.Files), spew.Sdump(files))
+	}
+	vetTestRecord(t, g, rm.Token)
+	r, err = g.GetVetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Files, files) {
+		t.Fatalf("unexpected files got %v, wanted %v",
+			spew.Sdump(r.Files), spew.Sdump(files))
+	}
+}
+
+func TestEncryptUnvetted(t *testing.T) {
+	var key [EncryptionKeySize]byte
+	k, err := util.Random(EncryptionKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(key[:], k)
+	g, cleanup := newTestBackEnd(t, &Options{
+		EncryptionKey: &key,
+		BlobThreshold: 8,
+	})
+	defer cleanup()
+
+	// A regular file and a file that looks like an encrypted pointer
+	payloads := map[string]string{
+		"file": "this is a secret draft",
+		"pointer": encPointerPrefix + strings.Repeat("0", 64) + "\n" +
+			strings.Repeat("x", encNonceSize+32),
+	}
+	names := []string{"file", "pointer"}
+	var (
+		files  []backend.File
+		hashes []*[sha256.Size]byte
+	)
+	for _, name := range names {
+		payload := []byte(payloads[name])
+		var d [sha256.Size]byte
+		copy(d[:], util.Digest(payload))
+		hashes = append(hashes, &d)
+		files = append(files, backend.File{
+			Name:    name,
+			MIME:    http.DetectContentType(payload),
+			Digest:  hex.EncodeToString(d[:]),
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		})
+	}
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm.Merkle != *merkle.Root(append([]*[sha256.Size]byte{},
+		hashes...)) {
+		t.Fatalf("unexpected merkle root")
+	}
+
+	// checkFiles verifies whether the payloads in path are encrypted.
+	id := hex.EncodeToString(rm.Token)
+	checkFiles := func(path string, encrypted bool) {
+		t.Helper()
+		for k, name := range names {
+			filename := filepath.Join(path, id, defaultPayloadDir,
+				name)
+			b, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, isEnc := parseEncPointer(b)
+			if isEnc != encrypted {
+				t.Fatalf("%v: unexpected encrypted pointer %v",
+					name, isEnc)
+			}
+			if encrypted && bytes.Contains(b, []byte("secret")) {
+				t.Fatalf("%v: plaintext payload %q", name, b)
+			}
+			p, err := g.readPayload(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(p) != payloads[name] {
+				t.Fatalf("%v: unexpected payload %q", name, p)
+			}
+			d, err := g.payloadDigest(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(d, hashes[k][:]) {
+				t.Fatalf("%v: unexpected digest %x", name, d)
+			}
+		}
+	}
+
+	// Nothing is stored in plaintext, not even in the blob store
+	err = g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(g.unvetted, true)
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(g.root, defaultBlobDir))
+	if !os.IsNotExist(err) {
+		t.Fatalf("unexpected blob store %v", err)
+	}
+
+	// Payloads are decrypted transparently
+	r, err := g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Files, files) {
+		t.Fatalf("unexpected files got %v, wanted %v",
+			spew.Sdump(r.Files), spew.Sdump(files))
+	}
+
+	// Vetted payloads are public
+	vetTestRecord(t, g, rm.Token)
+	r, err = g.GetVetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Files, files) {
+		t.Fatalf("unexpected files got %v, wanted %v",
+			spew.Sdump(r.Files), spew.Sdump(files))
+	}
+	checkFiles(g.vetted, false)
+
+	// Encrypted payloads can't be read without the key
+	sealed, err := encryptPayload(&key, []byte("x"),
+		util.Digest([]byte("x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, b, ok := parseEncPointer(sealed)
+	if !ok {
+		t.Fatalf("not an encrypted pointer")
+	}
+	_, err = decryptPayload(nil, digest, b)
+	if err == nil {
+		t.Fatalf("expected missing key error")
+	}
+	var wrongKey [EncryptionKeySize]byte
+	_, err = decryptPayload(&wrongKey, digest, b)
+	if err == nil {
+		t.Fatalf("expected decrypt error")
+	}
+}
+
+func TestConcurrentNew(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Create records concurrently
+	count := 8
+	type result struct {
+		rm  *backend.RecordMetadata
+		err error
+	}
+	c := make(chan result, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			payload := fmt.Sprintf("record %v", i)
+			rm, err := g.New([]backend.MetadataStream{{
+				ID:      0,
+				Payload: "this is metadata",
+			}}, []backend.File{{
+				Name: "file",
+				MIME: http.DetectContentType([]byte(payload)),
+				Digest: hex.EncodeToString(util.Digest(
+					[]byte(payload))),
+				Payload: base64.StdEncoding.EncodeToString(
+					[]byte(payload)),
+			}})
+			c <- result{rm: rm, err: err}
+		}(i)
+	}
+
+	// All records must be created with unique tokens
+	tokens := make(map[string]struct{}, count)
+	for i := 0; i < count; i++ {
+		r := <-c
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		tokens[hex.EncodeToString(r.rm.Token)] = struct{}{}
+	}
+	if len(tokens) != count {
+		t.Fatalf("token collision: got %v wanted %v", len(tokens),
+			count)
+	}
+	for token := range tokens {
+		if !g.gitBranchExists(g.unvetted, recordBranch(token)) {
+			t.Fatalf("missing branch %v", token)
+		}
+	}
+
+	// A token in use must not be handed out again
+	for token := range tokens {
+		inUse, err := g.tokenInUse(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !inUse {
+			t.Fatalf("token not in use %v", token)
+		}
+	}
+}
+
+func TestAdoptVettedRepo(t *testing.T) {
+	// Create a vetted repo with one record
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	dir := g.root
+	payload := "this is a file"
+	rm := newTestRecord(t, g, newTestFile("file", []byte(payload)))
+	vetTestRecord(t, g, rm.Token)
+	g.Close()
+
+	// Adopt it into a fresh root
+	root := filepath.Join(dir, "adopt")
+	_, err := g.git("", "clone", g.vetted, filepath.Join(root, "vetted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{SkipStartupFsck: true}
+	ga, err := New(&chaincfg.TestNet2Params, root, "", "", nil,
+		testing.Verbose(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ga.test = true
+	r, err := ga.GetVetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RecordMetadata.Status != backend.MDStatusVetted {
+		t.Fatalf("unexpected status %v", r.RecordMetadata.Status)
+	}
+	ga.Close()
+
+	// Files in payload subdirectories are part of the layout
+	gd, cleanupd := newTestBackEnd(t, nil)
+	defer cleanupd()
+	rm = newTestRecord(t, gd, newTestFile("images/pic", []byte(payload)))
+	vetTestRecord(t, gd, rm.Token)
+	err = gd.validateVettedLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gd.Close()
+	gd, err = New(&chaincfg.TestNet2Params, gd.root, "", "", nil,
+		testing.Verbose(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gd.Close()
+
+	// A stray file makes the layout incompatible
+	root = filepath.Join(dir, "stray")
+	vetted := filepath.Join(root, "vetted")
+	_, err = g.git("", "clone", g.vetted, vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(vetted, "stray"), []byte("x"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitAdd(vetted, "stray")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitCommit(vetted, "Add stray file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(&chaincfg.TestNet2Params, root, "", "", nil,
+		testing.Verbose(), opts)
+	if err == nil || !strings.Contains(err.Error(), "unexpected file stray") {
+		t.Fatalf("expected layout error, got %v", err)
+	}
+}
+
+func TestFileModes(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{
+		BlobThreshold: 8,
+		FileMode:      0600,
+		DirMode:       0700,
+	})
+	defer cleanup()
+
+	payload := []byte("this file is stored as a blob")
+	digest := util.Digest(payload)
+	_, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, []backend.File{{
+		Name:    "file",
+		MIME:    http.DetectContentType(payload),
+		Digest:  hex.EncodeToString(digest),
+		Payload: base64.StdEncoding.EncodeToString(payload),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// git does not retain modes on checkout so verify the blob store
+	blob := g.blobFilename(hex.EncodeToString(digest))
+	for filename, want := range map[string]os.FileMode{
+		filepath.Dir(blob): 0700,
+		blob:               0600,
+	} {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Fatalf("unexpected mode %v: got %v wanted %v",
+				filename, fi.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestAnchorPollInterval(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+
+	dir, err := ioutil.TempDir("", "politeia.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), &Options{AnchorPollInterval: time.Second})
+	if err == nil {
+		t.Fatal("expected poll interval to be rejected")
+	}
+
+	g, err := New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.anchorPoll != defaultAnchorPollInterval {
+		t.Fatalf("unexpected default poll interval %v", g.anchorPoll)
+	}
+	g.Close()
+
+	g, err = New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), &Options{AnchorPollInterval: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if g.anchorPoll != 30*time.Second {
+		t.Fatalf("unexpected poll interval %v", g.anchorPoll)
+	}
+}
+
+func TestVetCorruptRecord(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+
+	// Tamper with the file behind the backend's back
+	id := hex.EncodeToString(rm.Token)
+	err := g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(g.unvetted, id, defaultPayloadDir, "file")
+	err = ioutil.WriteFile(filename, []byte("tampered"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitAdd(g.unvetted, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitCommit(g.unvetted, "Tamper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Vetting must be refused
+	emptyMD := []backend.MetadataStream{}
+	_, err = g.SetUnvettedStatus(rm.Token, backend.MDStatusVetted,
+		emptyMD, emptyMD)
+	e, ok := err.(backend.RecordCorruptError)
+	if !ok {
+		t.Fatalf("expected RecordCorruptError, got %v", err)
+	}
+	if e.Merkle != hex.EncodeToString(rm.Merkle[:]) {
+		t.Fatalf("unexpected merkle %v", e.Merkle)
+	}
+	r, err := g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RecordMetadata.Status != backend.MDStatusUnvetted {
+		t.Fatalf("unexpected status %v", r.RecordMetadata.Status)
+	}
+}
+
+func TestGetUnvettedCheckoutFailure(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+
+	// Unknown records are not found
+	_, err := g.GetUnvetted(make([]byte, len(rm.Token)))
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	// An untracked file on master prevents checking out the branch
+	id := hex.EncodeToString(rm.Token)
+	err = os.MkdirAll(filepath.Join(g.unvetted, id), 0774)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(g.unvetted, id, defaultRecordMetadataFilename)
+	err = ioutil.WriteFile(filename, []byte("in the way"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.GetUnvetted(rm.Token)
+	if err == nil || err == backend.ErrRecordNotFound {
+		t.Fatalf("expected checkout error, got %v", err)
+	}
+	err = g.CanSetUnvettedStatus(rm.Token, backend.MDStatusVetted)
+	if err == nil || err == backend.ErrRecordNotFound {
+		t.Fatalf("expected checkout error, got %v", err)
+	}
+	_, err = g.SetUnvettedStatus(rm.Token, backend.MDStatusVetted, nil,
+		nil)
+	if err == nil || err == backend.ErrRecordNotFound {
+		t.Fatalf("expected checkout error, got %v", err)
+	}
+
+	// Once the tree is clean the record is readable again
+	err = os.RemoveAll(filepath.Join(g.unvetted, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReissueToken(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	rms := make([]*backend.RecordMetadata, 0, 2)
+	for i := 0; i < 2; i++ {
+		rm, err := g.New([]backend.MetadataStream{{
+			ID:      0,
+			Payload: "this is metadata " + strconv.Itoa(i),
+		}}, []backend.File{newTestFile("file", payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rms = append(rms, rm)
+	}
+
+	// Reissue the first record
+	err := g.SetLabels(rms[0].Token, []string{"spam-suspect"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := g.ReissueToken(rms[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(newToken, rms[0].Token) {
+		t.Fatalf("token was not changed")
+	}
+	_, err = g.GetUnvetted(rms[0].Token)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+	r, err := g.GetUnvetted(newToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.RecordMetadata.Token, newToken) {
+		t.Fatalf("unexpected token %x", r.RecordMetadata.Token)
+	}
+	if r.RecordMetadata.Merkle != rms[0].Merkle {
+		t.Fatalf("unexpected merkle %x", r.RecordMetadata.Merkle)
+	}
+	if len(r.Metadata) != 1 || r.Metadata[0].Payload != "this is metadata 0" {
+		t.Fatalf("unexpected metadata %v", r.Metadata)
+	}
+	if len(r.Files) != 1 || r.Files[0].Name != "file" {
+		t.Fatalf("unexpected files %v", r.Files)
+	}
+	labels, err := g.GetLabels(newToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labels, []string{"spam-suspect"}) {
+		t.Fatalf("labels were not moved: %v", labels)
+	}
+
+	// The old token is tombstoned and never handed out again
+	id := hex.EncodeToString(rms[0].Token)
+	b, err := ioutil.ReadFile(g.reissuedFilename(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(b)) != hex.EncodeToString(newToken) {
+		t.Fatalf("unexpected tombstone %q", b)
+	}
+	inUse, err := g.tokenInUse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inUse {
+		t.Fatalf("reissued token not in use")
+	}
+	_, err = g.ReissueToken(rms[0].Token)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	// The reissued record can be vetted
+	vetTestRecord(t, g, newToken)
+
+	// Vetted records can't be reissued
+	_, err = g.ReissueToken(newToken)
+	if err != backend.ErrRecordVetted {
+		t.Fatalf("expected ErrRecordVetted, got %v", err)
+	}
+
+	// Other records are left alone
+	r, err = g.GetUnvetted(rms[1].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.RecordMetadata.Token, rms[1].Token) {
+		t.Fatalf("unexpected token %x", r.RecordMetadata.Token)
+	}
+}
+
+func TestValidFilename(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"file", true},
+		{"images/logo.png", true},
+		{"docs/readme", true},
+		{"", false},
+		{"images", false},
+		{"images/", false},
+		{"other/file", false},
+		{"images/sub/file", false},
+		{"images/..", false},
+		{"../file", false},
+		{"/file", false},
+		{"./file", false},
+	}
+	for _, test := range tests {
+		if validFilename(test.name) != test.valid {
+			t.Errorf("%q: expected valid %v", test.name, test.valid)
+		}
+	}
+}
+
+func TestPayloadDirs(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	newFile := func(name, content string) backend.File {
+		return newTestFile(name, []byte(content))
+	}
+	fileNames := func(files []backend.File) []string {
+		names := make([]string, 0, len(files))
+		for _, v := range files {
+			names = append(names, v.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	md := []backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}
+
+	// Traversal is rejected
+	_, err := g.New(md, []backend.File{newFile("../file", "escape")})
+	e, ok := err.(backend.ContentVerificationError)
+	if !ok || e.ErrorCode != pd.ErrorStatusInvalidFilename {
+		t.Fatalf("expected ErrorStatusInvalidFilename, got %v", err)
+	}
+
+	rm, err := g.New(md, []backend.File{
+		newFile("file", "this is a file"),
+		newFile("images/pic", "this is a picture"),
+		newFile("docs/readme", "this is a doc"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"docs/readme", "file", "images/pic"}
+	if !reflect.DeepEqual(fileNames(r.Files), expected) {
+		t.Fatalf("unexpected files %v", fileNames(r.Files))
+	}
+
+	// The merkle root covers the files in subdirectories
+	vetTestRecord(t, g, rm.Token)
+	r, err = g.GetVetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fileNames(r.Files), expected) {
+		t.Fatalf("unexpected files %v", fileNames(r.Files))
+	}
+	for _, v := range r.Files {
+		if v.Name != "images/pic" {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(v.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "this is a picture" {
+			t.Fatalf("unexpected payload %q", b)
+		}
+	}
+}
+
+func TestPruneUnconfirmedAnchors(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Vet and anchor a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	err := g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ua, err := g.readUnconfirmedAnchorRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ua.Merkles) != 1 {
+		t.Fatalf("invalid merkles len %v", len(ua.Merkles))
+	}
+	merkle := hex.EncodeToString(ua.Merkles[0])
+
+	// Nothing to prune yet
+	pruned, err := g.PruneUnconfirmedAnchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 0 {
+		t.Fatalf("unexpected pruned anchors %v", pruned)
+	}
+
+	// Record the confirmation out of band
+	err = os.MkdirAll(filepath.Join(g.vetted, defaultAnchorsDirectory),
+		0774)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(defaultAnchorsDirectory, merkle)
+	err = ioutil.WriteFile(filepath.Join(g.vetted, filename),
+		[]byte(`{"transaction":"`+expectedTestTX+`"}`), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitAdd(g.vetted, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitCommit(g.vetted, "Out of band confirmation")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err = g.PruneUnconfirmedAnchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0] != merkle {
+		t.Fatalf("unexpected pruned anchors %v", pruned)
+	}
+
+	// The checker must not confirm the anchor again
+	last, err := g.gitLastDigest(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastAfter, err := g.gitLastDigest(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(last, lastAfter) {
+		t.Fatalf("anchor confirmed twice")
+	}
+}
+
+func TestAnchorHealth(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// No attempt yet
+	s, err := g.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Anchor != (backend.AnchorHealth{}) {
+		t.Fatalf("unexpected anchor health %v", spew.Sdump(s.Anchor))
+	}
+
+	// Vet and anchor a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	err = g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	la, err := g.readLastAnchorRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = g.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Anchor.LastAttempt == 0 || s.Anchor.LastSuccess == 0 ||
+		s.Anchor.LastMerkle != hex.EncodeToString(la.Merkle) ||
+		s.Anchor.LastSkip != "" {
+		t.Fatalf("unexpected anchor health %v", spew.Sdump(s.Anchor))
+	}
+	success := s.Anchor
+
+	// Nothing to anchor keeps the last success
+	err = g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = g.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Anchor.LastSkip == "" ||
+		s.Anchor.LastSuccess != success.LastSuccess ||
+		s.Anchor.LastMerkle != success.LastMerkle {
+		t.Fatalf("unexpected anchor health %v", spew.Sdump(s.Anchor))
+	}
+
+	// Confirmation latency is reported and recorded in the audit trail
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = g.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Anchor.Confirmed != 1 || s.Anchor.LastConfirmDelay < 0 ||
+		s.Anchor.MaxConfirmDelay != s.Anchor.LastConfirmDelay {
+		t.Fatalf("unexpected anchor health %v", spew.Sdump(s.Anchor))
+	}
+	audit, err := ioutil.ReadFile(filepath.Join(g.vetted,
+		defaultAuditTrailFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	delayLine := strings.TrimSpace(auditTrailDelayLine(success.LastMerkle,
+		s.Anchor.LastConfirmDelay))
+	if !strings.Contains(string(audit), delayLine) {
+		t.Fatalf("delay not in audit trail: %s", audit)
+	}
+}
+
+func TestAnchorCommits(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Vet and anchor a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	err := g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	la, err := g.readLastAnchorRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	merkle := hex.EncodeToString(la.Merkle)
+
+	// The record commit must be listed with its message
+	digest, err := g.lastVettedDigest(hex.EncodeToString(rm.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acs, err := g.AnchorCommits(merkle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, ac := range acs {
+		if isRecordMerkleMessage(ac.Message) {
+			t.Fatalf("record merkle listed as commit: %v", ac.Message)
+		}
+		if ac.Digest != digest {
+			continue
+		}
+		found = true
+		out, err := g.git(g.vetted, "log", "-1", "--format=%s",
+			digest[:40])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ac.Message != out[0] {
+			t.Fatalf("unexpected message %q wanted %q",
+				ac.Message, out[0])
+		}
+	}
+	if !found {
+		t.Fatalf("record commit %v not in anchor %v", digest, merkle)
+	}
+
+	// Unknown and invalid merkle roots
+	_, err = g.AnchorCommits(hex.EncodeToString(util.Digest(payload)))
+	if err != backend.ErrAnchorNotFound {
+		t.Fatalf("expected ErrAnchorNotFound, got %v", err)
+	}
+	_, err = g.AnchorCommits("invalid")
+	if err == nil {
+		t.Fatalf("expected invalid merkle root error")
+	}
+}
+
+func TestMinAnchorAge(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+
+	dir, err := ioutil.TempDir("", "politeia.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), &Options{MinAnchorAge: -time.Hour})
+	if err == nil {
+		t.Fatal("expected negative anchor age to be rejected")
+	}
+
+	g, err := New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), &Options{MinAnchorAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	g.test = true
+
+	newVetted := func(content string) string {
+		payload := []byte(content)
+		rm := newTestRecord(t, g, newTestFile("file", payload))
+		vetTestRecord(t, g, rm.Token)
+		digest, err := g.lastVettedDigest(hex.EncodeToString(rm.Token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return digest
+	}
+	anchored := func(merkle []byte, digest string) bool {
+		acs, err := g.AnchorCommits(hex.EncodeToString(merkle))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ac := range acs {
+			if ac.Digest == digest {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Vet a record in the past and one now, only the old one is anchored
+	past := time.Now().Add(-2 * time.Hour).Unix()
+	os.Setenv("GIT_COMMITTER_DATE", fmt.Sprintf("%v +0000", past))
+	old := newVetted("this is an old file")
+	os.Unsetenv("GIT_COMMITTER_DATE")
+	young := newVetted("this is a young file")
+	_, err = g.dropAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	la, err := g.readLastAnchorRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !anchored(la.Merkle, old) {
+		t.Fatalf("old record not in anchor %x", la.Merkle)
+	}
+	if anchored(la.Merkle, young) {
+		t.Fatalf("young record in anchor %x", la.Merkle)
+	}
+
+	// The deferred record sits below the anchor and is still too young
+	_, err = g.dropAnchor()
+	if err != errNothingToDo {
+		t.Fatalf("expected errNothingToDo, got %v", err)
+	}
+
+	// Fake dcrtime, fsck must only verify the commits an anchor lists
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		var v dcrtime.Verify
+		err := json.NewDecoder(r.Body).Decode(&v)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		requested = append(requested, v.Digests...)
+		vr := dcrtime.VerifyReply{ID: v.ID}
+		for _, d := range v.Digests {
+			vr.Digests = append(vr.Digests, dcrtime.VerifyDigest{
+				Digest: d,
+				Result: dcrtime.ResultOK,
+			})
+		}
+		util.RespondWithJSON(w, http.StatusOK, vr)
+	}))
+	defer ts.Close()
+	g.dcrtimeHost = ts.URL
+	g.httpClient = ts.Client()
+	expectFsck := func(merkle []byte) {
+		t.Helper()
+		requested = nil
+		err := g.fsck(g.vetted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acs, err := g.AnchorCommits(hex.EncodeToString(merkle))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for _, ac := range acs {
+			am, err := parseAnchorMessage(ac.Message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if am == nil {
+				want = append(want, ac.Digest)
+			}
+		}
+		sort.Strings(want)
+		sort.Strings(requested)
+		if !reflect.DeepEqual(requested, want) {
+			t.Fatalf("unexpected digests got %v, wanted %v",
+				requested, want)
+		}
+		checkpoint, err := g.readFsckCheckpoint(g.vetted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint != hex.EncodeToString(merkle) {
+			t.Fatalf("unexpected checkpoint %v", checkpoint)
+		}
+	}
+
+	// The deferred record is not verified against the anchor it sits
+	// below
+	expectFsck(la.Merkle)
+
+	// Without a minimum age the deferred record is anchored on its own
+	g.minAnchorAge = 0
+	_, err = g.dropAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	la2, err := g.readLastAnchorRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !anchored(la2.Merkle, young) {
+		t.Fatalf("deferred record not in anchor %x", la2.Merkle)
+	}
+	if anchored(la2.Merkle, old) {
+		t.Fatalf("record anchored twice")
+	}
+
+	// The deferred record is verified against the anchor that lists it
+	// although it sits below the checkpoint
+	expectFsck(la2.Merkle)
+}
+
+func TestResyncAnchorConfirmations(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Vet, anchor and confirm a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	err := g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ua, err := g.readUnconfirmedAnchorRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ua.Merkles) != 1 {
+		t.Fatalf("invalid merkles len %v", len(ua.Merkles))
+	}
+	merkle := hex.EncodeToString(ua.Merkles[0])
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resync runs ResyncAnchorConfirmations and returns whether a commit
+	// was made.  dcrtime keeps knowing about the anchor.
+	resync := func() bool {
+		t.Helper()
+		g.testAnchors[merkle] = false
+		last, err := g.gitLastDigest(g.vetted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.ResyncAnchorConfirmations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lastAfter, err := g.gitLastDigest(g.vetted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return !bytes.Equal(last, lastAfter)
+	}
+	// verify checks that the confirmation is completely recorded.
+	verify := func() {
+		t.Helper()
+		ua, err := g.readUnconfirmedAnchorRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ua.Merkles) != 0 {
+			t.Fatalf("unexpected unconfirmed anchors %v",
+				len(ua.Merkles))
+		}
+		ci, err := g.readAnchorChainInformation(merkle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ci == nil || ci.Transaction != expectedTestTX {
+			t.Fatalf("unexpected chain information %v", ci)
+		}
+		audit, err := ioutil.ReadFile(filepath.Join(g.vetted,
+			defaultAuditTrailFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		txLine := merkle + " anchored in TX " + expectedTestTX
+		if n := strings.Count(string(audit), txLine); n != 1 {
+			t.Fatalf("unexpected TX line count %v", n)
+		}
+	}
+
+	// Nothing to do
+	if resync() {
+		t.Fatalf("complete confirmation was resynced")
+	}
+	verify()
+
+	// Lose the confirmation commit as if we crashed before committing
+	for _, repo := range []string{g.vetted, g.unvetted} {
+		_, err = g.git(repo, "reset", "--hard", "HEAD~1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !resync() {
+		t.Fatalf("missing confirmation was not resynced")
+	}
+	verify()
+
+	// Lose the audit trail TX line only
+	audit, err := ioutil.ReadFile(filepath.Join(g.vetted,
+		defaultAuditTrailFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(audit), "\n")
+	audit = []byte(strings.Join(lines[:len(lines)-3], ""))
+	err = ioutil.WriteFile(filepath.Join(g.vetted, defaultAuditTrailFile),
+		audit, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitAdd(g.vetted, defaultAuditTrailFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.gitCommit(g.vetted, "Truncate audit trail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resync() {
+		t.Fatalf("missing audit trail line was not resynced")
+	}
+	verify()
+
+	// Idempotent
+	if resync() {
+		t.Fatalf("complete confirmation was resynced")
+	}
+}
+
+func TestAnchorExternal(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	_, err := g.AnchorExternal([]string{"not a digest"})
+	if err == nil {
+		t.Fatalf("expected invalid digest error")
+	}
+
+	last, err := g.gitLastDigest(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests := []string{
+		hex.EncodeToString(util.Digest([]byte("external 1"))),
+		hex.EncodeToString(util.Digest([]byte("external 2"))),
+	}
+	mr, err := g.AnchorExternal(digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.AnchorExternal(digests)
+	if err == nil {
+		t.Fatalf("expected duplicate anchor error")
+	}
+
+	// Repositories must not be touched
+	lastAfter, err := g.gitLastDigest(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(last, lastAfter) {
+		t.Fatalf("external anchor was committed")
+	}
+
+	ea, err := g.readExternalAnchor(mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ea.Digests, digests) ||
+		ea.ChainInformation != nil {
+		t.Fatalf("unexpected external anchor %v", spew.Sdump(ea))
+	}
+
+	ai, err := g.GetExternalAnchor(mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ai.Merkle != mr || ai.Confirmed {
+		t.Fatalf("unexpected anchor info %v", spew.Sdump(ai))
+	}
+	_, err = g.ProveExternal(mr, digests[0])
+	if err != backend.ErrAnchorNotFound {
+		t.Fatalf("expected ErrAnchorNotFound, got %v", err)
+	}
+
+	// Confirm
+	err = g.externalAnchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ea, err = g.readExternalAnchor(mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ea.ChainInformation == nil ||
+		ea.ChainInformation.Transaction != expectedTestTX {
+		t.Fatalf("external anchor not confirmed %v", spew.Sdump(ea))
+	}
+	merkles, err := g.unconfirmedExternalAnchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merkles) != 0 {
+		t.Fatalf("unexpected unconfirmed external anchors %v", merkles)
+	}
+
+	ai, err = g.GetExternalAnchor(mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ai.Confirmed || ai.Transaction != expectedTestTX ||
+		!reflect.DeepEqual(ai.Digests, digests) {
+		t.Fatalf("unexpected anchor info %v", spew.Sdump(ai))
+	}
+	_, err = g.GetExternalAnchor(hex.EncodeToString(
+		util.Digest([]byte("nope"))))
+	if err != backend.ErrAnchorNotFound {
+		t.Fatalf("expected ErrAnchorNotFound, got %v", err)
+	}
+
+	// Prove and verify
+	_, err = g.ProveExternal(mr, hex.EncodeToString(
+		util.Digest([]byte("nope"))))
+	if err == nil {
+		t.Fatalf("expected digest not in anchor error")
+	}
+	proof, err := g.ProveExternal(mr, digests[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Digest != digests[1] || proof.Merkle != mr {
+		t.Fatalf("unexpected proof %v", spew.Sdump(proof))
+	}
+
+	// The fake dcrtime carries no merkle path, put the anchor in a
+	// dcrtime merkle root with another digest
+	anchor, ok := util.ConvertDigest(mr)
+	if !ok {
+		t.Fatalf("invalid anchor merkle %v", mr)
+	}
+	var other [sha256.Size]byte
+	copy(other[:], util.Digest([]byte("other")))
+	leaves := []*[sha256.Size]byte{&anchor, &other}
+	root := merkle.Root(append([]*[sha256.Size]byte{}, leaves...))
+	proof.MerkleRoot = hex.EncodeToString(root[:])
+	proof.DcrtimeBranch = *merkle.AuthPath(leaves, &anchor)
+	err = VerifyAnchorProof(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof.Digest = digests[0]
+	err = VerifyAnchorProof(proof)
+	if err == nil {
+		t.Fatalf("expected proof of wrong digest to fail")
+	}
+}
+
+func TestGetVettedBatch(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	var err error
+
+	payload := []byte("this is a file")
+	rms := make([]*backend.RecordMetadata, 0, 3)
+	for i := 0; i < 3; i++ {
+		rm, err := g.New([]backend.MetadataStream{{
+			ID:      0,
+			Payload: "this is metadata " + strconv.Itoa(i),
+		}}, []backend.File{newTestFile("file", payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rms = append(rms, rm)
+	}
+
+	// Vet the first and last record
+	emptyMD := []backend.MetadataStream{}
+	for _, k := range []int{0, 2} {
+		_, err = g.SetUnvettedStatus(rms[k].Token,
+			backend.MDStatusVetted, emptyMD, emptyMD)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unvetted and unknown records are not found
+	tokens := [][]byte{rms[2].Token, rms[1].Token, rms[0].Token,
+		make([]byte, len(rms[0].Token))}
+	records, err := g.GetVettedBatch(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(tokens) {
+		t.Fatalf("unexpected records %v", len(records))
+	}
+	for k, v := range []int{2, -1, 0, -1} {
+		if v == -1 {
+			if records[k] != nil {
+				t.Fatalf("%v: expected nil record", k)
+			}
+			continue
+		}
+		if records[k] == nil {
+			t.Fatalf("%v: record not found", k)
+		}
+		if !bytes.Equal(records[k].RecordMetadata.Token,
+			rms[v].Token) {
+			t.Fatalf("%v: unexpected token", k)
+		}
+		if len(records[k].Files) != 0 {
+			t.Fatalf("%v: unexpected files", k)
+		}
+		if len(records[k].Metadata) != 1 ||
+			records[k].Metadata[0].Payload !=
+				"this is metadata "+strconv.Itoa(v) {
+			t.Fatalf("%v: unexpected metadata %v", k,
+				records[k].Metadata)
+		}
+	}
+
+	// Files are loaded on request
+	records, err = g.GetVettedBatch(tokens[:1], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records[0].Files) != 1 ||
+		records[0].Files[0].Digest != hex.EncodeToString(util.Digest(payload)) {
+		t.Fatalf("unexpected files %v", records[0].Files)
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	rms := make([]*backend.RecordMetadata, 0, 3)
+	for i := 0; i < 3; i++ {
+		rm, err := g.New([]backend.MetadataStream{{
+			ID:      0,
+			Payload: "this is metadata " + strconv.Itoa(i),
+		}}, []backend.File{newTestFile("file", payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rms = append(rms, rm)
+
+		// Leave the last record unvetted
+		if i == 2 {
+			continue
+		}
+		vetTestRecord(t, g, rm.Token)
+	}
+	tokens := func(brms []backend.RecordMetadata) []string {
+		s := make([]string, 0, len(brms))
+		for _, v := range brms {
+			s = append(s, hex.EncodeToString(v.Token))
+		}
+		return s
+	}
+
+	// All vetted records
+	brms, err := g.ModifiedSince(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{hex.EncodeToString(rms[0].Token),
+		hex.EncodeToString(rms[1].Token)}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(tokens(brms), expected) {
+		t.Fatalf("unexpected records %v", tokens(brms))
+	}
+
+	// Commit timestamps have a resolution of one second
+	time.Sleep(1100 * time.Millisecond)
+	since := time.Now().Unix()
+	brms, err = g.ModifiedSince(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(brms) != 0 {
+		t.Fatalf("unexpected records %v", tokens(brms))
+	}
+
+	// Only the updated record
+	err = g.UpdateVettedMetadata(rms[1].Token, nil, []backend.MetadataStream{
+		{ID: 1, Payload: "update"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	brms, err = g.ModifiedSince(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{hex.EncodeToString(rms[1].Token)}
+	if !reflect.DeepEqual(tokens(brms), expected) {
+		t.Fatalf("unexpected records %v", tokens(brms))
+	}
+	if brms[0].Status != backend.MDStatusVetted {
+		t.Fatalf("unexpected status %v", brms[0].Status)
+	}
+}
+
+func TestModifiedSinceNoLowerBound(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	rm := newTestRecord(t, g, newTestFile("file", []byte("this is a file")))
+	vetTestRecord(t, g, rm.Token)
+
+	// git log does not parse --since=@0 as a timestamp, bounds that are
+	// not positive must not filter at all
+	for _, since := range []int64{0, -1} {
+		brms, err := g.ModifiedSince(since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(brms) != 1 || !bytes.Equal(brms[0].Token, rm.Token) {
+			t.Fatalf("unexpected records since %v: %v", since,
+				spew.Sdump(brms))
+		}
+	}
+}
+
+func TestMDLimits(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{MaxMDStreams: 2, MaxMDSize: 16})
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	files := []backend.File{newTestFile("file", payload)}
+	expectError := func(err error, code pd.ErrorStatusT) {
+		t.Helper()
+		e, ok := err.(backend.ContentVerificationError)
+		if !ok || e.ErrorCode != code {
+			t.Fatalf("expected %v, got %v", pd.ErrorStatus[code], err)
+		}
+	}
+
+	// New
+	_, err := g.New([]backend.MetadataStream{
+		{ID: 0, Payload: "a"},
+		{ID: 1, Payload: "b"},
+		{ID: 2, Payload: "c"},
+	}, files)
+	expectError(err, pd.ErrorStatusTooManyMDStreams)
+	_, err = g.New([]backend.MetadataStream{
+		{ID: 0, Payload: strings.Repeat("a", 17)},
+	}, files)
+	expectError(err, pd.ErrorStatusMDTooLarge)
+	rm, err := g.New([]backend.MetadataStream{
+		{ID: 0, Payload: "a"},
+	}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Appends are checked by their resulting size
+	_, err = g.UpdateUnvettedRecord(rm.Token, []backend.MetadataStream{
+		{ID: 0, Payload: strings.Repeat("a", 16)},
+	}, nil, nil, nil)
+	expectError(err, pd.ErrorStatusMDTooLarge)
+	_, err = g.UpdateUnvettedRecord(rm.Token, nil, []backend.MetadataStream{
+		{ID: 1, Payload: "b"},
+		{ID: 2, Payload: "c"},
+	}, nil, nil)
+	expectError(err, pd.ErrorStatusTooManyMDStreams)
+
+	// Failed updates left the record untouched
+	vetTestRecord(t, g, rm.Token)
+	mds, err := g.GetRecordMetadataStreams(rm.Token, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mds) != 1 || mds[0].Payload != "a" {
+		t.Fatalf("unexpected metadata streams %v", mds)
+	}
+
+	err = g.UpdateVettedMetadata(rm.Token, nil, []backend.MetadataStream{
+		{ID: 1, Payload: "b"},
+		{ID: 2, Payload: "c"},
+	})
+	expectError(err, pd.ErrorStatusTooManyMDStreams)
+	err = g.UpdateVettedMetadata(rm.Token, nil, []backend.MetadataStream{
+		{ID: 1, Payload: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plugin streams are not counted
+	err = g.UpdateVettedMetadata(rm.Token, nil, []backend.MetadataStream{
+		{ID: decredplugin.MDStreamVotes, Payload: strings.Repeat("v", 32)},
+		{ID: decredplugin.MDStreamVoteBits, Payload: "bits"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	// Allow a single record and never refill
+	g, cleanup := newTestBackEnd(t, &Options{
+		RateLimiter: util.NewTokenBucket(0, 1),
+	})
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	md := []backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}
+	files := []backend.File{newTestFile("file", payload)}
+
+	// Invalid requests must not use up the token
+	_, err := g.New(md, nil)
+	if err == nil || err == backend.ErrRateLimited {
+		t.Fatalf("expected content error, got %v", err)
+	}
+
+	rm, err := g.New(md, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.New(md, files)
+	if err != backend.ErrRateLimited {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	_, err = g.UpdateUnvettedRecord(rm.Token, md, nil, nil, nil)
+	if err != backend.ErrRateLimited {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+
+	// Rate limited calls must not leave anything behind
+	_, err = g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branches, err := g.git(g.unvetted, "branch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 2 {
+		t.Fatalf("unexpected branches %v", branches)
+	}
+}
+
+func TestMaxUnvetted(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+
+	dir, err := ioutil.TempDir("", "politeia.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), &Options{MaxUnvetted: -1})
+	if err == nil {
+		t.Fatal("expected negative maximum to be rejected")
+	}
+
+	g, err := New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), &Options{MaxUnvetted: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.test = true
+
+	newRecord := func(content string) (*backend.RecordMetadata, error) {
+		payload := []byte(content)
+		return g.New([]backend.MetadataStream{{
+			ID:      0,
+			Payload: "this is metadata",
+		}}, []backend.File{newTestFile("file", payload)})
+	}
+
+	// The second record pending review is refused
+	rm, err := newRecord("this is a file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newRecord("this is another file")
+	if err != backend.ErrTooManyUnvetted {
+		t.Fatalf("expected ErrTooManyUnvetted, got %v", err)
+	}
+
+	// Reviewing the first record makes room
+	vetTestRecord(t, g, rm.Token)
+	_, err = newRecord("this is another file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Close()
+
+	// Records pending review are counted on startup
+	g, err = New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), &Options{MaxUnvetted: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	g.test = true
+	if len(g.pending) != 1 {
+		t.Fatalf("unexpected pending records: %v", g.pending)
+	}
+	_, err = newRecord("this is yet another file")
+	if err != backend.ErrTooManyUnvetted {
+		t.Fatalf("expected ErrTooManyUnvetted, got %v", err)
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Nothing anchored yet
+	at, err := g.AuditTrail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at.Close()
+	if len(b) != 0 {
+		t.Fatalf("unexpected audit trail %q", b)
+	}
+
+	// Vet and anchor a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	err = g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join(g.vetted,
+		defaultAuditTrailFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Appends after opening are not returned
+	at, err = g.AuditTrail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer at.Close()
+	err = g.appendAuditTrail(g.vetted, 0, [sha256.Size]byte{},
+		[]string{"appended"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) == 0 || !bytes.Equal(b, want) {
+		t.Fatalf("unexpected audit trail got %q, wanted %q", b, want)
+	}
+}
+
+func TestFsckCheckpoint(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{SkipStartupFsck: true})
+	defer cleanup()
+
+	// Fake dcrtime, digests in fail do not verify
+	var (
+		requested []string
+		fail      = make(map[string]bool)
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		var v dcrtime.Verify
+		err := json.NewDecoder(r.Body).Decode(&v)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		requested = append(requested, v.Digests...)
+		vr := dcrtime.VerifyReply{ID: v.ID}
+		for _, d := range v.Digests {
+			vd := dcrtime.VerifyDigest{
+				Digest: d,
+				Result: dcrtime.ResultOK,
+			}
+			if fail[d] {
+				vd.Result = dcrtime.ResultDoesntExistError
+			}
+			// Anchor every digest in a tree of its own
+			leaf, ok := util.ConvertDigest(d)
+			if !ok {
+				t.Errorf("invalid digest %v", d)
+				return
+			}
+			leaves := []*[sha256.Size]byte{&leaf}
+			vd.ChainInformation.MerklePath = *merkle.AuthPath(leaves,
+				&leaf)
+			root := merkle.Root(leaves)
+			vd.ChainInformation.MerkleRoot = hex.EncodeToString(root[:])
+			vr.Digests = append(vr.Digests, vd)
+		}
+		util.RespondWithJSON(w, http.StatusOK, vr)
+	}))
+	defer ts.Close()
+	g.dcrtimeHost = ts.URL
+	g.httpClient = ts.Client()
+
+	// vetAndAnchor vets a new record, anchors the vetted repo and
+	// confirms the anchor.  It returns the anchored commits and the anchor
+	// merkle root.
+	vetAndAnchor := func() ([]string, string) {
+		t.Helper()
+		payload, err := util.Random(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = []byte(hex.EncodeToString(payload))
+		rm := newTestRecord(t, g, newTestFile("file", payload))
+		vetTestRecord(t, g, rm.Token)
+		err = g.anchorAllRepos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.anchorChecker()
+		if err != nil {
+			t.Fatal(err)
+		}
+		la, err := g.readLastAnchorRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var key [sha256.Size]byte
+		copy(key[:], la.Merkle)
+		anchor, err := g.readAnchorRecord(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// fsck skips anchor commits
+		var digests []string
+		for k, d := range anchor.Digests {
+			am, err := parseAnchorMessage(anchor.Messages[k])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if am != nil || isRecordMerkleMessage(anchor.Messages[k]) {
+				continue
+			}
+			digests = append(digests, hex.EncodeToString(d))
+		}
+		return digests, hex.EncodeToString(la.Merkle)
+	}
+	expectCheckpoint := func(want string) {
+		t.Helper()
+		got, err := g.readFsckCheckpoint(g.vetted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("unexpected checkpoint got %v, wanted %v", got,
+				want)
+		}
+	}
+	expectRequested := func(want ...[]string) {
+		t.Helper()
+		var all []string
+		for _, v := range want {
+			all = append(all, v...)
+		}
+		sort.Strings(all)
+		sort.Strings(requested)
+		if !reflect.DeepEqual(requested, all) {
+			t.Fatalf("unexpected digests got %v, wanted %v",
+				requested, all)
+		}
+		requested = nil
+	}
+
+	// The first run verifies everything
+	d1, _ := vetAndAnchor()
+	d2, m2 := vetAndAnchor()
+	err := g.fsck(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRequested(d1, d2)
+	expectCheckpoint(m2)
+
+	// Nothing anchored since the checkpoint
+	err = g.fsck(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRequested()
+
+	// Only the new anchor is verified
+	d3, m3 := vetAndAnchor()
+	err = g.fsck(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRequested(d3)
+	expectCheckpoint(m3)
+
+	// The checkpoint only advances over contiguous verified anchors
+	d4, _ := vetAndAnchor()
+	d5, m5 := vetAndAnchor()
+	d6, _ := vetAndAnchor()
+	fail[d4[0]] = true
+	err = g.fsck(g.vetted)
+	if err == nil {
+		t.Fatalf("expected fsck failure")
+	}
+	expectRequested(d4, d5, d6)
+	expectCheckpoint(m3)
+	delete(fail, d4[0])
+	fail[d6[0]] = true
+	err = g.fsck(g.vetted)
+	if err == nil {
+		t.Fatalf("expected fsck failure")
+	}
+	expectRequested(d4, d5, d6)
+	expectCheckpoint(m5)
+
+	// A full fsck ignores the checkpoint
+	delete(fail, d6[0])
+	g.fullFsck = true
+	err = g.fsck(g.vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRequested(d1, d2, d3, d4, d5, d6)
+}
+
+func TestSearch(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Unvetted budget, censored notes and vetted budget
+	rm := []*backend.RecordMetadata{
+		newTestRecord(t, g, newTestFile("budget.txt", []byte("a"))),
+		newTestRecord(t, g, newTestFile("notes.txt", []byte("b"))),
+		newTestRecord(t, g, newTestFile("budget.txt", []byte("c"))),
+	}
+	emptyMD := []backend.MetadataStream{}
+	_, err := g.SetUnvettedStatus(rm[1].Token, backend.MDStatusCensored,
+		emptyMD, emptyMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vetTestRecord(t, g, rm[2].Token)
+
+	// Records are returned in token order
+	unvetted := []int{0, 1}
+	if bytes.Compare(rm[0].Token, rm[1].Token) > 0 {
+		unvetted = []int{1, 0}
+	}
+	future := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name  string
+		query backend.SearchQuery
+		want  []int // Indexes into rm
+	}{
+		{"vetted", backend.SearchQuery{}, []int{2}},
+		{"unvetted", backend.SearchQuery{Unvetted: true}, unvetted},
+		{"filename", backend.SearchQuery{
+			Unvetted: true,
+			Filename: "BUDGET",
+		}, []int{0}},
+		{"vetted filename", backend.SearchQuery{
+			Filename: "notes",
+		}, nil},
+		{"status", backend.SearchQuery{
+			Unvetted: true,
+			Status:   []backend.MDStatusT{backend.MDStatusCensored},
+		}, []int{1}},
+		{"from", backend.SearchQuery{
+			Unvetted: true,
+			From:     future,
+		}, nil},
+		{"files", backend.SearchQuery{
+			Unvetted:     true,
+			Filename:     "notes",
+			IncludeFiles: true,
+		}, []int{1}},
+		{"paged", backend.SearchQuery{
+			Unvetted: true,
+			Offset:   1,
+			Limit:    1,
+		}, unvetted[1:]},
+	}
+	for _, test := range tests {
+		records, err := g.Search(test.query)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if len(records) != len(test.want) {
+			t.Fatalf("%v: got %v records, wanted %v", test.name,
+				len(records), len(test.want))
+		}
+		for k, v := range records {
+			want := rm[test.want[k]]
+			if !bytes.Equal(v.RecordMetadata.Token, want.Token) {
+				t.Fatalf("%v: unexpected record %v", test.name,
+					spew.Sdump(v.RecordMetadata))
+			}
+			if len(v.Metadata) != 1 {
+				t.Fatalf("%v: unexpected metadata %v", test.name,
+					spew.Sdump(v.Metadata))
+			}
+			if test.query.IncludeFiles != (len(v.Files) == 1) {
+				t.Fatalf("%v: unexpected files %v", test.name,
+					spew.Sdump(v.Files))
+			}
+		}
+
+		// Searching never leaves unvetted off master
+		branch, err := g.gitBranchNow(g.unvetted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if branch != "master" {
+			t.Fatalf("%v: unexpected branch %v", test.name, branch)
+		}
+	}
+
+	// A record branch that can't be read is an error, not a miss
+	_, err = g.git(g.unvetted, "branch", recordBranch(strings.Repeat("ab",
+		32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.Search(backend.SearchQuery{Unvetted: true})
+	if err == nil || err == backend.ErrRecordNotFound {
+		t.Fatalf("expected git error, got %v", err)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	defer g.Close()
+
+	var rm []*backend.RecordMetadata
+	for i := 0; i < 2; i++ {
+		payload := []byte(fmt.Sprintf("record %v", i))
+		r := newTestRecord(t, g, newTestFile("file", payload))
+		rm = append(rm, r)
+	}
+
+	// Unknown records and invalid labels are rejected
+	err := g.SetLabels([]byte{1, 2, 3}, []string{"spam-suspect"})
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+	err = g.SetLabels(rm[0].Token, []string{" "})
+	if err != backend.ErrInvalidLabel {
+		t.Fatalf("expected ErrInvalidLabel, got %v", err)
+	}
+
+	// Labels are normalized
+	err = g.SetLabels(rm[0].Token, []string{"spam-suspect", " featured",
+		"spam-suspect"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, err := g.GetLabels(rm[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labels, []string{"featured", "spam-suspect"}) {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	labels, err = g.GetLabels(rm[1].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 0 {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+
+	// Search by label
+	records, err := g.Search(backend.SearchQuery{
+		Unvetted: true,
+		Label:    "featured",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 ||
+		!bytes.Equal(records[0].RecordMetadata.Token, rm[0].Token) {
+		t.Fatalf("unexpected search result %v", spew.Sdump(records))
+	}
+
+	// Labels survive vetting and do not change the record
+	vetTestRecord(t, g, rm[0].Token)
+	records, err = g.Search(backend.SearchQuery{Label: "spam-suspect"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].RecordMetadata.Merkle != rm[0].Merkle {
+		t.Fatalf("unexpected search result %v", spew.Sdump(records))
+	}
+
+	// Clear
+	err = g.SetLabels(rm[0].Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels, err = g.GetLabels(rm[0].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 0 {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{BlobThreshold: 8})
+	defer cleanup()
+
+	report, err := g.SelfTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%v", spew.Sdump(report))
+	if len(report.Steps) == 0 || report.Steps[0].Name != "create" ||
+		report.Steps[0].Error != "" {
+		t.Fatalf("create failed: %v", spew.Sdump(report))
+	}
+	last := report.Steps[len(report.Steps)-1]
+	if report.Passed != (last.Error == "") {
+		t.Fatalf("inconsistent report: %v", spew.Sdump(report))
+	}
+	if !report.Passed {
+		t.Fatalf("self test failed: %v", spew.Sdump(report))
+	}
+
+	// The throwaway root is removed and the backend is untouched
+	_, err = os.Stat(report.Root)
+	if !os.IsNotExist(err) {
+		t.Fatalf("self test root not removed: %v", err)
+	}
+	_, vetted, err := g.Inventory(0, 0, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vetted) != 0 {
+		t.Fatalf("unexpected records %v", len(vetted))
+	}
+}
+
+func TestCanSetUnvettedStatus(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	branch := recordBranch(hex.EncodeToString(rm.Token))
+	head, err := g.git(g.unvetted, "rev-parse", branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Allowed transitions
+	for _, status := range []backend.MDStatusT{backend.MDStatusVetted,
+		backend.MDStatusCensored} {
+		err = g.CanSetUnvettedStatus(rm.Token, status)
+		if err != nil {
+			t.Fatalf("%v: %v", backend.MDStatus[status], err)
+		}
+	}
+
+	// Refused transition and unknown record
+	err = g.CanSetUnvettedStatus(rm.Token, backend.MDStatusLocked)
+	if _, ok := err.(backend.StateTransitionError); !ok {
+		t.Fatalf("expected StateTransitionError, got %v", err)
+	}
+	err = g.CanSetUnvettedStatus([]byte{0xde, 0xad}, backend.MDStatusVetted)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	// Nothing was written and unvetted is on master
+	head2, err := g.git(g.unvetted, "rev-parse", branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head2[0] != head[0] {
+		t.Fatalf("branch moved from %v to %v", head[0], head2[0])
+	}
+	current, err := g.gitBranchNow(g.unvetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != "master" {
+		t.Fatalf("unexpected branch %v", current)
+	}
+
+	// The check agrees with the real call once the record is vetted
+	emptyMD := []backend.MetadataStream{}
+	vetTestRecord(t, g, rm.Token)
+	err = g.CanSetUnvettedStatus(rm.Token, backend.MDStatusCensored)
+	_, err2 := g.SetUnvettedStatus(rm.Token, backend.MDStatusCensored,
+		emptyMD, emptyMD)
+	if err == nil || err != err2 {
+		t.Fatalf("check %v, real call %v", err, err2)
+	}
+}
+
+func TestStaleBranch(t *testing.T) {
+	newBackend := func() *gitBackEnd {
+		g, _ := newTestBackEnd(t, &Options{TokenNamespace: "stale"})
+		return g
+	}
+	payload := []byte("this is a file")
+	md := []backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}
+	files := []backend.File{newTestFile("file", payload)}
+
+	// Learn the token of the content from another instance
+	g2 := newBackend()
+	defer os.RemoveAll(g2.root)
+	rm, err := g2.New(md, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := hex.EncodeToString(rm.Token)
+
+	// Leave a branch without a record behind, as a crash would
+	g := newBackend()
+	defer os.RemoveAll(g.root)
+	_, err = g.git(g.unvetted, "branch", recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.New(md, files)
+	e, ok := err.(backend.StaleBranchError)
+	if !ok {
+		t.Fatalf("expected StaleBranchError, got %v", err)
+	}
+	if e.Token != id || e.Branch != recordBranch(id) || e.Head == "" {
+		t.Fatalf("unexpected error %v", spew.Sdump(e))
+	}
+
+	// The branch is left for the operator and unvetted is on master
+	if !g.gitBranchExists(g.unvetted, recordBranch(id)) {
+		t.Fatalf("stale branch removed")
+	}
+	branch, err := g.gitBranchNow(g.unvetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch != "master" {
+		t.Fatalf("unexpected branch %v", branch)
+	}
+}
+
+func TestDeterministicTokens(t *testing.T) {
+	newBackend := func(namespace string) *gitBackEnd {
+		g, _ := newTestBackEnd(t, &Options{TokenNamespace: namespace})
+		return g
+	}
+	newFile := func(name, content string) backend.File {
+		return newTestFile(name, []byte(content))
+	}
+	md := []backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}
+	files := []backend.File{newFile("a", "a"), newFile("b", "b")}
+
+	g := newBackend("mirror")
+	defer os.RemoveAll(g.root)
+	rm, err := g.New(md, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same content in any order maps to the existing record
+	rm2, err := g.New(md, []backend.File{files[1], files[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rm, rm2) {
+		t.Fatalf("got %v, want %v", spew.Sdump(rm2), spew.Sdump(rm))
+	}
+	branches, err := g.gitBranches(g.unvetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 2 {
+		t.Fatalf("unexpected branches %v", branches)
+	}
+
+	// Also once vetted
+	vetTestRecord(t, g, rm.Token)
+	rm2, err = g.New(md, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rm2.Token, rm.Token) ||
+		rm2.Status != backend.MDStatusVetted {
+		t.Fatalf("unexpected record %v", spew.Sdump(rm2))
+	}
+
+	// Different content gets a different token
+	rm2, err = g.New(md, files[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rm2.Token, rm.Token) {
+		t.Fatalf("unexpected token reuse")
+	}
+
+	// Another instance derives the same token in the same namespace only
+	g2 := newBackend("mirror")
+	defer os.RemoveAll(g2.root)
+	rm2, err = g2.New(md, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rm2.Token, rm.Token) {
+		t.Fatalf("token not deterministic")
+	}
+	g3 := newBackend("other")
+	defer os.RemoveAll(g3.root)
+	rm2, err = g3.New(md, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rm2.Token, rm.Token) {
+		t.Fatalf("token not namespaced")
+	}
+}
+
+func TestRecordAtAnchor(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, &Options{BlobThreshold: 16})
+	defer cleanup()
+
+	// Vet a record and anchor it
+	newVetted := func(content string) []byte {
+		payload := []byte(content)
+		rm := newTestRecord(t, g, newTestFile("file", payload))
+		vetTestRecord(t, g, rm.Token)
+		return rm.Token
+	}
+	anchor := func() string {
+		err := g.anchorAllRepos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g.anchorHealth.LastMerkle
+	}
+	token1 := newVetted("small")
+	merkle1 := anchor()
+	token2 := newVetted(strings.Repeat("large ", 10))
+	merkle2 := anchor()
+
+	// Records are reconstructed as anchored, including blobs
+	for _, v := range []struct {
+		token  []byte
+		merkle string
+	}{
+		{token1, merkle1},
+		{token1, merkle2},
+		{token2, merkle2},
+	} {
+		r, err := g.RecordAtAnchor(v.token, v.merkle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := g.GetVetted(v.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r, expected) {
+			t.Fatalf("got %v, want %v", spew.Sdump(r),
+				spew.Sdump(expected))
+		}
+	}
+
+	// A record that was vetted after the anchor did not exist yet
+	_, err := g.RecordAtAnchor(token2, merkle1)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+	_, err = g.RecordAtAnchor(token1, hex.EncodeToString(make([]byte, 32)))
+	if err != backend.ErrAnchorNotFound {
+		t.Fatalf("expected ErrAnchorNotFound, got %v", err)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	newFile := func(payload []byte) backend.File {
+		return backend.File{
+			Name:    "file",
+			MIME:    http.DetectContentType(payload),
+			Digest:  hex.EncodeToString(util.Digest(payload)),
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		}
+	}
+	expectError := func(err error, code pd.ErrorStatusT) {
+		t.Helper()
+		cve, ok := err.(backend.ContentVerificationError)
+		if !ok || cve.ErrorCode != code {
+			t.Fatalf("expected %v, got %v", pd.ErrorStatus[code], err)
+		}
+	}
+
+	for _, size := range []int{1, 2, 3, 15, 16, 17} {
+		payload := []byte(strings.Repeat("a", size))
+		_, err := verifyContent(nil, []backend.File{newFile(payload)},
+			nil, 16)
+		if size <= 16 && err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if size > 16 {
+			expectError(err, pd.ErrorStatusFileTooLarge)
+		}
+	}
+
+	// Oversized payloads are rejected before they are decoded
+	f := newFile([]byte("a"))
+	f.Payload = strings.Repeat("!", 1<<20)
+	_, err := verifyContent(nil, []backend.File{f}, nil, 16)
+	expectError(err, pd.ErrorStatusFileTooLarge)
+	_, err = verifyContent(nil, []backend.File{f}, nil, 0)
+	expectError(err, pd.ErrorStatusInvalidBase64)
+}
+
+func TestMigrateBranches(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	dir := g.root
+	payload := "this is a file"
+	rm := newTestRecord(t, g, newTestFile("file", []byte(payload)))
+	id := hex.EncodeToString(rm.Token)
+
+	// Recreate the pre namespace layout: a bare token branch and a
+	// leftover temporary branch
+	err := g.gitBranchRename(g.unvetted, recordBranch(id), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.git(g.unvetted, "branch", id+tmpBranchSuffix, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.GetUnvetted(rm.Token)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+	g.Close()
+
+	g, err = New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	g.test = true
+
+	branches, err := g.gitBranches(g.unvetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(branches)
+	expected := []string{"master", recordBranch(id), tmpBranch(id)}
+	if !reflect.DeepEqual(branches, expected) {
+		t.Fatalf("got %v, want %v", branches, expected)
+	}
+	_, err = g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := g.recordBranches(g.unvetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{id}) {
+		t.Fatalf("unexpected record branches %v", ids)
+	}
+}
+
+func TestSetUnvettedStatusBatch(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	var err error
+	defer g.Close()
+
+	rm := make([]*backend.RecordMetadata, 3)
+	for k := range rm {
+		payload := fmt.Sprintf("this is file %v", k)
+		rm[k] = newTestRecord(t, g, newTestFile("file", []byte(payload)))
+	}
+
+	changes := []backend.StatusChange{
+		{Token: rm[0].Token, Status: backend.MDStatusVetted},
+		{Token: rm[1].Token, Status: backend.MDStatusCensored},
+		{Token: rm[2].Token, Status: backend.MDStatusVetted},
+		{Token: []byte{0xde, 0xad}, Status: backend.MDStatusVetted},
+		{Token: rm[0].Token, Status: backend.MDStatusVetted},
+	}
+	records, err := g.SetUnvettedStatusBatch(changes)
+	sbe, ok := err.(backend.StatusBatchError)
+	if !ok {
+		t.Fatalf("expected StatusBatchError, got %v", err)
+	}
+	for k, v := range sbe.Errors {
+		if (v != nil) != (k >= 3) {
+			t.Fatalf("unexpected error %v: %v", k, v)
+		}
+		if (records[k] == nil) != (k >= 3) {
+			t.Fatalf("unexpected record %v: %v", k, records[k])
+		}
+	}
+	if sbe.Errors[3] != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", sbe.Errors[3])
+	}
+
+	// Vetted records are published, the censored one stays unvetted
+	for _, k := range []int{0, 2} {
+		r, err := g.GetVetted(rm[k].Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.RecordMetadata.Status != backend.MDStatusVetted {
+			t.Fatalf("unexpected status %v", r.RecordMetadata.Status)
+		}
+	}
+	censored, err := g.IsCensored(rm[1].Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !censored {
+		t.Fatalf("expected censored record")
+	}
+	ids, err := g.recordBranches(g.unvetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{hex.EncodeToString(rm[1].Token)}) {
+		t.Fatalf("unexpected record branches %v", ids)
+	}
+
+	// An invalid transition only fails its own status change
+	records, err = g.SetUnvettedStatusBatch([]backend.StatusChange{
+		{Token: rm[1].Token, Status: backend.MDStatusVetted},
+	})
+	sbe, ok = err.(backend.StatusBatchError)
+	if !ok {
+		t.Fatalf("expected StatusBatchError, got %v", err)
+	}
+	if _, ok := sbe.Errors[0].(backend.StateTransitionError); !ok {
+		t.Fatalf("expected StateTransitionError, got %v", sbe.Errors[0])
+	}
+	if records[0] != nil {
+		t.Fatalf("unexpected record %v", records[0])
+	}
+}
+
+func TestPauseAnchoring(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	defer g.Close()
+
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+
+	// A scheduled anchor is deferred while paused
+	err := g.PauseAnchoring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.anchorAllReposCronJob()
+	s, err := g.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.AnchorPaused {
+		t.Fatalf("expected anchoring to be paused")
+	}
+	if s.Anchor.LastAttempt != 0 {
+		t.Fatalf("unexpected anchor attempt %v", spew.Sdump(s.Anchor))
+	}
+
+	// Resuming drops the deferred anchor
+	err = g.ResumeAnchoring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		s, err = g.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Anchor.LastSuccess != 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("deferred anchor not dropped %v",
+				spew.Sdump(s.Anchor))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if s.AnchorPaused {
+		t.Fatalf("expected anchoring to be resumed")
+	}
+}
+
+func TestVerifyContentTextMIME(t *testing.T) {
+	newFile := func(name, mimeType, payload string) backend.File {
+		return backend.File{
+			Name:    name,
+			MIME:    mimeType,
+			Digest:  hex.EncodeToString(util.Digest([]byte(payload))),
+			Payload: base64.StdEncoding.EncodeToString([]byte(payload)),
+		}
+	}
+	plain := "text/plain; charset=utf-8"
+	tests := []struct {
+		name     string
+		mimeType string
+		payload  string
+		valid    bool
+	}{
+		{"a.json", "application/json", `{"a": 1}`, true},
+		{"a.json", plain, `{"a": 1}`, true},
+		{"a.json", "application/json", "not json", false},
+		{"a.txt", "application/json", `{"a": 1}`, false},
+		{"a.yml", "application/x-yaml", "a: 1\n", true},
+		{"a.md", "text/markdown", "# Title\n", true},
+		{"a.MD", "text/markdown", "# Title\n", true},
+		{"a.md", "text/markdown", "\x89PNG\x0d\x0a\x1a\x0a", false},
+	}
+	for _, test := range tests {
+		_, err := verifyContent(nil, []backend.File{newFile(test.name,
+			test.mimeType, test.payload)}, nil, 0)
+		if test.valid && err != nil {
+			t.Fatalf("%v %v: %v", test.name, test.mimeType, err)
+		}
+		if !test.valid {
+			cve, ok := err.(backend.ContentVerificationError)
+			if !ok || cve.ErrorCode != pd.ErrorStatusInvalidMIMEType {
+				t.Fatalf("%v %v: expected invalid MIME, got %v",
+					test.name, test.mimeType, err)
+			}
+		}
+	}
+}
+
+func TestDeclaredMIMETypes(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	newFile := func(name, mimeType, payload string) backend.File {
+		return backend.File{
+			Name:    name,
+			MIME:    mimeType,
+			Digest:  hex.EncodeToString(util.Digest([]byte(payload))),
+			Payload: base64.StdEncoding.EncodeToString([]byte(payload)),
+		}
+	}
+	plain := "text/plain; charset=utf-8"
+	verify := func(files []backend.File, want map[string]string) {
+		t.Helper()
+		got := make(map[string]string, len(files))
+		for _, v := range files {
+			got[v.Name] = v.MIME
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	// JSON declared as plain text and as JSON
+	rm := newTestRecord(t, g, newFile("a.json", plain, `{"a": 1}`),
+		newFile("b.json", "application/json", `{"b": 1}`))
+	r, err := g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(r.Files, map[string]string{
+		"a.json": plain,
+		"b.json": "application/json",
+	})
+
+	// Redeclared files replace their declared type, deleted files drop it
+	_, err = g.UpdateUnvettedRecord(rm.Token, nil, nil,
+		[]backend.File{newFile("a.json", "application/json",
+			`{"a": 1}`)}, []string{"b.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(r.Files, map[string]string{"a.json": "application/json"})
+
+	// Declared types are published with the record
+	vetTestRecord(t, g, rm.Token)
+	r, err = g.GetVetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(r.Files, map[string]string{"a.json": "application/json"})
+	rc, fi, err := g.OpenRecordFile(rm.Token, "a.json", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if fi.MIME != "application/json" {
+		t.Fatalf("unexpected MIME %v", fi.MIME)
+	}
+	err = g.validateVettedLayout()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditMIMETypes(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Vet a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+
+	mv, err := g.AuditMIMETypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mv) != 0 {
+		t.Fatalf("unexpected violations %v", spew.Sdump(mv))
+	}
+
+	// Simulate a file that was accepted under an older policy
+	token := hex.EncodeToString(rm.Token)
+	filename := filepath.Join(g.vetted, token, defaultPayloadDir, "file")
+	err = ioutil.WriteFile(filename, []byte("GIF89a this is a gif"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mv, err = g.AuditMIMETypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mv) != 1 || mv[0].Token != token || mv[0].File != "file" ||
+		mv[0].MIME != "image/gif" {
+		t.Fatalf("unexpected violations %v", spew.Sdump(mv))
+	}
+}
+
+func TestReindex(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	var err error
+	defer g.Close()
+
+	rm := make([]*backend.RecordMetadata, 2)
+	for k := range rm {
+		payload := fmt.Sprintf("this is file %v", k)
+		rm[k] = newTestRecord(t, g, newTestFile("file", []byte(payload)))
+		err = g.SetLabels(rm[k].Token, []string{"label"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	vetTestRecord(t, g, rm[0].Token)
+	g.decredPluginVoteCache["stale"] = &decredplugin.Vote{}
+
+	// Drop the unvetted record behind the backend's back
+	err = g.gitBranchDelete(g.unvetted,
+		recordBranch(hex.EncodeToString(rm[1].Token)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = g.Reindex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.decredPluginVoteCache) != 0 {
+		t.Fatalf("unexpected vote cache %v", g.decredPluginVoteCache)
+	}
+	labels, err := g.readLabels(hex.EncodeToString(rm[0].Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labels, []string{"label"}) {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	labels, err = g.readLabels(hex.EncodeToString(rm[1].Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 0 {
+		t.Fatalf("unexpected labels of dropped record %v", labels)
+	}
+}
+
+func TestGetVettedIfChanged(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	defer g.Close()
+
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	id := hex.EncodeToString(rm.Token)
+
+	// Unvetted records are not found
+	_, _, err := g.GetVettedIfChanged(rm.Token, "")
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	vetTestRecord(t, g, rm.Token)
+	digests, err := g.RecordDigests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := digests[id]
+
+	// The current digest short-circuits, anything else loads the record
+	r, changed, err := g.GetVettedIfChanged(rm.Token, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil || changed {
+		t.Fatalf("expected unchanged record")
+	}
+	for _, digest := range []string{"", strings.Repeat("0", 40)} {
+		r, changed, err = g.GetVettedIfChanged(rm.Token, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r == nil || !changed || len(r.Files) != 1 {
+			t.Fatalf("%q: expected record", digest)
+		}
+	}
+
+	// A metadata update invalidates the known digest
+	err = g.UpdateVettedMetadata(rm.Token, nil, []backend.MetadataStream{{
+		ID:      1,
+		Payload: "this is new metadata",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, changed, err = g.GetVettedIfChanged(rm.Token, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || !changed {
+		t.Fatalf("expected changed record")
+	}
+}
+
+func TestSignedAnchors(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+
+	dir, err := ioutil.TempDir("", "politeia.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	id, err := identity.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(&chaincfg.TestNet2Params, dir, "", "", id,
+		testing.Verbose(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	g.test = true
+
+	// Vet, anchor and confirm a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	err = g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The anchor commit is attributable to the server identity
+	out, err := g.git(g.vetted, "log", "-1", "--format=%B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyAnchorMessage(id.Public, strings.Join(out, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := g.ProveAnchored(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := identity.SignatureFromString(proof.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.Public.VerifyMessage([]byte(proof.Merkle), *signature) {
+		t.Fatalf("invalid proof signature")
+	}
+}
+
+func TestListAnchors(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Nothing anchored yet
+	anchors, err := g.ListAnchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != 0 {
+		t.Fatalf("unexpected anchors %v", len(anchors))
+	}
+
+	// Vet and anchor two records, confirming only the first anchor
+	merkles := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		payload := []byte(fmt.Sprintf("this is file %v", i))
+		rm := newTestRecord(t, g, newTestFile("file", payload))
+		vetTestRecord(t, g, rm.Token)
+		err = g.anchorAllRepos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		la, err := g.readLastAnchorRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		merkles = append(merkles, hex.EncodeToString(la.Merkle))
+		if i == 0 {
+			err = g.anchorChecker()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	anchors, err = g.ListAnchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != 2 {
+		t.Fatalf("unexpected anchors %v", len(anchors))
+	}
+	if anchors[0].Merkle != merkles[1] || anchors[1].Merkle != merkles[0] {
+		t.Fatalf("anchors not newest first")
+	}
+	if anchors[0].Confirmed {
+		t.Fatalf("unexpected confirmed anchor %v", anchors[0].Merkle)
+	}
+	if !anchors[1].Confirmed || anchors[1].Transaction == "" {
+		t.Fatalf("anchor not confirmed %v", anchors[1].Merkle)
+	}
+	for _, v := range anchors {
+		if len(v.Digests) == 0 {
+			t.Fatalf("anchor without digests %v", v.Merkle)
+		}
+	}
+}
+
+func TestUpdateRecordRecovery(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	newFile := func(name, content string) backend.File {
+		return newTestFile(name, []byte(content))
+	}
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, []backend.File{newFile("file", "this is a file")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := hex.EncodeToString(rm.Token)
+
+	// Updates are committed
+	brm, err := g.UpdateUnvettedRecord(rm.Token, nil, nil,
+		[]backend.File{newFile("file2", "this is another file")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brm.Version != rm.Version+1 {
+		t.Fatalf("unexpected version %v", brm.Version)
+	}
+
+	// Redundant updates are not
+	_, err = g.UpdateUnvettedRecord(rm.Token, nil, nil,
+		[]backend.File{newFile("file2", "this is another file")}, nil)
+	nce, ok := err.(backend.NoChangesError)
+	if !ok {
+		t.Fatalf("expected NoChangesError, got %v", err)
+	}
+	if nce.Token != id || len(nce.Unchanged) != 1 ||
+		nce.Unchanged[0] != "file2" {
+		t.Fatalf("unexpected no changes error %v", spew.Sdump(nce))
+	}
+	if g.gitHasChanges(g.unvetted) {
+		t.Fatalf("working tree not clean")
+	}
+
+	// Leftovers in the working tree are not committed
+	err = g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stray := filepath.Join(g.unvetted, id, defaultPayloadDir, "stray")
+	err = ioutil.WriteFile(stray, []byte("partial"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.stagedChanges(id, map[string]struct{}{})
+	if err == nil {
+		t.Fatalf("expected unstaged change error")
+	}
+	err = g.gitAdd(g.unvetted, stray)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.stagedChanges(id, map[string]struct{}{})
+	if err == nil {
+		t.Fatalf("expected unexpected change error")
+	}
+
+	// Simulate a crash half way through an update
+	head, err := g.gitRevParse(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.beginUpdate(id, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(g.unvetted, id, defaultPayloadDir, "file2")
+	err = ioutil.WriteFile(partial, []byte("this is a partial"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next operation on the record cleans up
+	brm, err = g.UpdateUnvettedRecord(rm.Token, nil, nil,
+		[]backend.File{newFile("file3", "this is a third file")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brm.Version != rm.Version+2 {
+		t.Fatalf("unexpected version %v", brm.Version)
+	}
+	_, err = os.Stat(g.updateJournalFilename(id))
+	if !os.IsNotExist(err) {
+		t.Fatalf("update journal not dropped: %v", err)
+	}
+	record, err := g.GetUnvetted(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Files) != 3 {
+		t.Fatalf("unexpected files %v", len(record.Files))
+	}
+	for _, v := range record.Files {
+		if v.Name == "stray" {
+			t.Fatalf("partial write committed")
+		}
+		if v.Name != "file2" {
+			continue
+		}
+		p, err := base64.StdEncoding.DecodeString(v.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != "this is another file" {
+			t.Fatalf("partial write committed: %q", p)
+		}
+	}
+
+	// A status check cleans up as well instead of tripping over the
+	// partial write
+	err = g.gitCheckout(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err = g.gitRevParse(g.unvetted, recordBranch(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.beginUpdate(id, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial = filepath.Join(g.unvetted, id, defaultPayloadDir, "file4")
+	err = ioutil.WriteFile(partial, []byte("this is a partial"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.CanSetUnvettedStatus(rm.Token, backend.MDStatusVetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(g.updateJournalFilename(id))
+	if !os.IsNotExist(err) {
+		t.Fatalf("update journal not dropped: %v", err)
+	}
+}
+
+func TestFindByMetadata(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Two vetted records and one unvetted record with an indexed and a
+	// plain stream
+	indexed := uint64(decredplugin.MDStreamVoteBits)
+	tokens := make([]string, 0, 3)
+	for i, status := range []string{"yes", "no", "yes"} {
+		payload := []byte(fmt.Sprintf("this is file %v", i))
+		rm, err := g.New([]backend.MetadataStream{{
+			ID:      indexed,
+			Payload: status,
+		}, {
+			ID:      2,
+			Payload: status,
+		}}, []backend.File{newTestFile("file", payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, hex.EncodeToString(rm.Token))
+		if i == 2 {
+			continue
+		}
+		vetTestRecord(t, g, rm.Token)
+	}
+
+	isYes := func(payload string) bool {
+		return payload == "yes"
+	}
+	find := func(streamID uint64) []string {
+		t.Helper()
+		records, err := g.FindByMetadata(streamID, isYes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0, len(records))
+		for _, v := range records {
+			ids = append(ids, hex.EncodeToString(v.RecordMetadata.Token))
+		}
+		return ids
+	}
+	for _, streamID := range []uint64{indexed, 2} {
+		ids := find(streamID)
+		if len(ids) != 2 || ids[0] != tokens[0] || ids[1] != tokens[2] {
+			t.Fatalf("unexpected matches for stream %v: %v", streamID,
+				ids)
+		}
+	}
+
+	// Vetted metadata changes are picked up by the index
+	token, err := hex.DecodeString(tokens[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.UpdateVettedMetadata(token, nil, []backend.MetadataStream{{
+		ID:      indexed,
+		Payload: "yes",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := find(indexed)
+	if len(ids) != 3 {
+		t.Fatalf("unexpected matches after update: %v", ids)
+	}
+
+	// A dropped index is rebuilt
+	err = g.Reindex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = find(indexed)
+	if len(ids) != 3 {
+		t.Fatalf("unexpected matches after reindex: %v", ids)
+	}
+
+	// Records without the stream never match
+	ids = find(7)
+	if len(ids) != 0 {
+		t.Fatalf("unexpected matches for missing stream: %v", ids)
+	}
+}
+
+func TestReconcileDB(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// A vetted record with an indexed stream and labels
+	indexed := uint64(decredplugin.MDStreamVoteBits)
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      indexed,
+		Payload: "yes",
+	}}, []backend.File{newTestFile("file", []byte("this is a file"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vetTestRecord(t, g, rm.Token)
+	err = g.SetLabels(rm.Token, []string{"featured"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := g.ReconcileDB(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rr.OrphanLabels) != 0 || len(rr.StaleMDIndex) != 0 ||
+		len(rr.MissingMDIndex) != 0 {
+		t.Fatalf("unexpected discrepancies %v", spew.Sdump(rr))
+	}
+
+	// Corrupt both databases behind the backend's back
+	id := hex.EncodeToString(rm.Token)
+	orphan := hex.EncodeToString(util.Digest([]byte("orphan")))
+	err = g.db.Put([]byte(orphan), []byte(`["spam-suspect"]`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.mdIndex.Put(mdIndexKey(indexed, id), []byte("no"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.mdIndex.Put(mdIndexKey(indexed, orphan), []byte("yes"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, repair := range []bool{false, true} {
+		rr, err = g.ReconcileDB(repair)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rr.OrphanLabels, []string{orphan}) ||
+			len(rr.StaleMDIndex) != 2 || len(rr.MissingMDIndex) != 0 ||
+			rr.Repaired != repair {
+			t.Fatalf("unexpected report %v", spew.Sdump(rr))
+		}
+	}
+
+	rr, err = g.ReconcileDB(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rr.OrphanLabels) != 0 || len(rr.StaleMDIndex) != 0 ||
+		len(rr.MissingMDIndex) != 0 {
+		t.Fatalf("discrepancies after repair %v", spew.Sdump(rr))
+	}
+	labels, err := g.GetLabels(rm.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labels, []string{"featured"}) {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	b, err := g.mdIndex.Get(mdIndexKey(indexed, id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "yes" {
+		t.Fatalf("index not repaired: %v", string(b))
+	}
+}
+
+func TestFileProof(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Vet a record with three files
+	files := make([]backend.File, 0, 3)
+	for i := 0; i < 3; i++ {
+		payload := []byte(fmt.Sprintf("this is file %v", i))
+		files = append(files, backend.File{
+			Name:    fmt.Sprintf("file%v", i),
+			MIME:    http.DetectContentType(payload),
+			Digest:  hex.EncodeToString(util.Digest(payload)),
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		})
+	}
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      0,
+		Payload: "this is metadata",
+	}}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vetTestRecord(t, g, rm.Token)
+
+	// Not anchored yet
+	_, err = g.FileProof(rm.Token, "file1")
+	if err != backend.ErrAnchorNotFound {
+		t.Fatalf("expected ErrAnchorNotFound, got %v", err)
+	}
+
+	err = g.anchorAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.anchorChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := g.FileProof(rm.Token, "file1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Digest != files[1].Digest {
+		t.Fatalf("unexpected digest %v wanted %v", proof.Digest,
+			files[1].Digest)
+	}
+	if proof.RecordMerkle != hex.EncodeToString(rm.Merkle[:]) {
+		t.Fatalf("unexpected record merkle %v", proof.RecordMerkle)
+	}
+
+	// File digest to record merkle root
+	root, err := merkle.VerifyAuthPath(&proof.FileBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(root[:]) != proof.RecordMerkle {
+		t.Fatalf("invalid file branch root %x", root[:])
+	}
+	leaf, ok := util.ConvertDigest(proof.Digest)
+	if !ok {
+		t.Fatalf("invalid proof digest %v", proof.Digest)
+	}
+	var found bool
+	for _, h := range proof.FileBranch.Hashes {
+		if h == leaf {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("file digest not in branch")
+	}
+
+	// Record commit to anchor
+	root, err = merkle.VerifyAuthPath(&proof.Anchor.AnchorBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(root[:]) != proof.Anchor.Merkle ||
+		proof.Anchor.Transaction != expectedTestTX {
+		t.Fatalf("invalid anchor proof %v", spew.Sdump(proof.Anchor))
+	}
+
+	// Record merkle root to anchor
+	if proof.Anchor.RecordMerkle != proof.RecordMerkle {
+		t.Fatalf("anchor record merkle %v, expected %v",
+			proof.Anchor.RecordMerkle, proof.RecordMerkle)
+	}
+	err = verifyBranch(proof.Anchor.RecordBranch, proof.RecordMerkle,
+		proof.Anchor.Merkle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = g.FileProof(rm.Token, "nope")
+	if err != backend.ErrFileNotFound {
+		t.Fatalf("expected ErrFileNotFound, got %v", err)
+	}
+}
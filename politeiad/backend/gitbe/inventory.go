@@ -0,0 +1,179 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+// inventoryIDs returns the ids of the vetted records and of the unvetted
+// record branches that make up the inventory.  Censored unvetted records are
+// skipped unless includeCensored is set, their status is read from the branch
+// without loading the rest of the record.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) inventoryIDs(includeCensored bool) ([]string, []string, error) {
+	// Walk vetted, we can simply take the vetted directory and sort the
+	// entries by time.
+	files, err := ioutil.ReadDir(g.vetted)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Strip non record directories
+	vetted := make([]string, 0, len(files))
+	for _, v := range files {
+		if !util.IsDigest(v.Name()) {
+			continue
+		}
+		vetted = append(vetted, v.Name())
+	}
+
+	// Walk Branches on unvetted
+	branches, err := g.recordBranches(g.unvetted)
+	if err != nil {
+		return nil, nil, err
+	}
+	unvetted := make([]string, 0, len(branches))
+	for _, id := range branches {
+		if !includeCensored {
+			brm, err := g.loadUnvettedMD(id)
+			if err != nil {
+				return nil, nil, err
+			}
+			if brm.Status == backend.MDStatusCensored {
+				continue
+			}
+		}
+		unvetted = append(unvetted, id)
+	}
+
+	return vetted, unvetted, nil
+}
+
+// lockedInventoryIDs returns the inventory ids with the lock held.
+func (g *gitBackEnd) lockedInventoryIDs(includeCensored bool) ([]string, []string, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, nil, backend.ErrShutdown
+	}
+
+	return g.inventoryIDs(includeCensored)
+}
+
+// lockedInventoryRecord loads record id from repo with the record and
+// filesystem locks held.  An unvetted record whose branch is gone is looked up
+// in the vetted repo since it may have been vetted after the ids were listed.
+func (g *gitBackEnd) lockedInventoryRecord(id, repo string, includeFiles bool) (*backend.Record, error) {
+	token, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err = g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	r, err := g.getRecord(token, repo, includeFiles)
+	if err == backend.ErrRecordNotFound && repo == g.unvetted {
+		return g.getRecord(token, g.vetted, includeFiles)
+	}
+	return r, err
+}
+
+// InventoryJSON writes the same records as Inventory to w as a single JSON
+// array, vetted records first.  Records are encoded and written one at a
+// time so that neither the records nor their JSON encoding have to be held in
+// memory at once.  The lock is only held while the ids are listed and while a
+// single record is loaded, a slow writer does not block the backend.  An
+// unvetted record that is vetted while the inventory is written is written in
+// its vetted form, one that is removed is skipped.  If an error is returned
+// the array written so far is incomplete.
+//
+// InventoryJSON satisfies the backend interface.
+func (g *gitBackEnd) InventoryJSON(w io.Writer, opts backend.InventoryOptions) error {
+	t := newOpTimer("InventoryJSON")
+	defer t.done()
+
+	vetted, branches, err := g.lockedInventoryIDs(opts.IncludeCensored)
+	if err != nil {
+		return err
+	}
+	t.lockAcquired()
+
+	_, err = io.WriteString(w, "[")
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(w)
+	first := true
+	write := func(r *backend.Record) error {
+		if !first {
+			_, err := io.WriteString(w, ",")
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+		return e.Encode(*r)
+	}
+
+	for _, id := range vetted {
+		r, err := g.lockedInventoryRecord(id, g.vetted, opts.IncludeFiles)
+		if err != nil {
+			return err
+		}
+		err = write(r)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range branches {
+		r, err := g.lockedInventoryRecord(id, g.unvetted,
+			opts.IncludeFiles)
+		if err == backend.ErrRecordNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = write(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// updateJournalFilename returns the filename of the update journal of id.
+func (g *gitBackEnd) updateJournalFilename(id string) string {
+	return filepath.Join(g.root, defaultUpdateJournalDirectory, id)
+}
+
+// beginUpdate journals that the record branch of id, currently at commit
+// head, is about to be updated in the working tree.  The journal is dropped by
+// endUpdate once the update has been committed or unwound.  A journal that is
+// still around means politeiad died half way and recoverUpdate has to clean up
+// the working tree.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) beginUpdate(id, head string) error {
+	err := os.MkdirAll(filepath.Join(g.root, defaultUpdateJournalDirectory),
+		g.dirModeOr(0774))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(g.updateJournalFilename(id), []byte(head+"\n"),
+		g.fileModeOr(0664))
+}
+
+// endUpdate drops the update journal of id.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) endUpdate(id string) error {
+	err := os.Remove(g.updateJournalFilename(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// recoverUpdate cleans up after an update of id that was interrupted between
+// writing the working tree and committing, see beginUpdate.  Partial writes
+// and stale index locks are discarded so that the unvetted repo is back at the
+// last commit.  It is a noop if no update of id was in flight.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) recoverUpdate(id string) error {
+	b, err := ioutil.ReadFile(g.updateJournalFilename(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	head := strings.TrimSpace(string(b))
+
+	log.Warnf("Recovering interrupted update of %v", id)
+
+	// A crash during git add or git commit leaves the index locked.
+	err = os.Remove(filepath.Join(g.unvetted, ".git", "index.lock"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// git reset --hard
+	err = g.gitResetHard(g.unvetted)
+	if err != nil {
+		return err
+	}
+
+	// git clean -f -d
+	err = g.gitClean(g.unvetted)
+	if err != nil {
+		return err
+	}
+
+	tip, err := g.gitRevParse(g.unvetted, recordBranch(id))
+	if err != nil {
+		return err
+	}
+	if tip == head {
+		log.Infof("Rolled back interrupted update of %v", id)
+	} else {
+		log.Infof("Interrupted update of %v had been committed", id)
+	}
+
+	return g.endUpdate(id)
+}
+
+// stagedChanges returns the files of record id that are staged for commit.
+// It fails if the working tree holds changes that are not staged or staged
+// changes to files outside of want, i.e. anything an update did not intend to
+// change.  All filenames are relative to the unvetted repo.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) stagedChanges(id string, want map[string]struct{}) ([]string, error) {
+	out, err := g.gitStatus(g.unvetted, id)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := make([]string, 0, len(want))
+	fields := bytes.Split(out, []byte{0})
+	for i := 0; i < len(fields); i++ {
+		entry := string(fields[i])
+		if len(entry) < 4 {
+			continue
+		}
+		x, y, name := entry[0], entry[1], entry[3:]
+		names := []string{name}
+		if x == 'R' || x == 'C' {
+			// Renames and copies are followed by the source
+			i++
+			if i < len(fields) {
+				names = append(names, string(fields[i]))
+			}
+		}
+		if y != ' ' {
+			return nil, fmt.Errorf("unstaged change to %v", name)
+		}
+		for _, v := range names {
+			if _, ok := want[v]; !ok {
+				return nil, fmt.Errorf("unexpected change "+
+					"to %v", v)
+			}
+			staged = append(staged, v)
+		}
+	}
+
+	return staged, nil
+}
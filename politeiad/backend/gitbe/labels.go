@@ -0,0 +1,229 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// defaultLabelsDirectory is the directory, relative to the root, of the
+	// leveldb database that stores record labels.
+	defaultLabelsDirectory = "labels"
+
+	// maxLabelLength is the maximum length of a single label.
+	maxLabelLength = 64
+)
+
+// Record labels
+//
+// Labels are operational annotations, e.g. "spam-suspect", that are kept in
+// leveldb outside of git.  They are not part of the record, do not affect its
+// merkle root, are never anchored and are lost if the database is lost.  They
+// must therefore never be used as evidence of anything.  Labels are keyed by
+// token so they survive status changes.
+
+// labelsDB returns the labels database, opening it on first use.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) labelsDB() (*leveldb.DB, error) {
+	if g.db != nil {
+		return g.db, nil
+	}
+	db, err := leveldb.OpenFile(filepath.Join(g.root,
+		defaultLabelsDirectory), nil)
+	if err != nil {
+		return nil, err
+	}
+	g.db = db
+	return db, nil
+}
+
+// readLabels returns the labels of record id.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) readLabels(id string) ([]string, error) {
+	db, err := g.labelsDB()
+	if err != nil {
+		return nil, err
+	}
+	b, err := db.Get([]byte(id), nil)
+	if err == leveldb.ErrNotFound {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var labels []string
+	err = json.Unmarshal(b, &labels)
+	if err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// moveLabels moves the labels of record id to record newID.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) moveLabels(id, newID string) error {
+	db, err := g.labelsDB()
+	if err != nil {
+		return err
+	}
+	b, err := db.Get([]byte(id), nil)
+	if err == leveldb.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(newID), b)
+	batch.Delete([]byte(id))
+	return db.Write(batch, nil)
+}
+
+// normalizeLabels trims, deduplicates and sorts labels.
+func normalizeLabels(labels []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(labels))
+	nl := make([]string, 0, len(labels))
+	for _, v := range labels {
+		v = strings.TrimSpace(v)
+		if v == "" || len(v) > maxLabelLength {
+			return nil, backend.ErrInvalidLabel
+		}
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		nl = append(nl, v)
+	}
+	sort.Strings(nl)
+	return nl, nil
+}
+
+// recordExists returns true if id is a vetted or an unvetted record.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) recordExists(id string) (bool, error) {
+	_, err := os.Stat(filepath.Join(g.vetted, id))
+	if err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	return g.gitBranchExists(g.unvetted, recordBranch(id)), nil
+}
+
+// SetLabels replaces the labels of the record identified by token.  An empty
+// list removes all labels.  See "Record labels" for why labels are purely
+// operational.
+//
+// SetLabels satisfies the backend interface.
+func (g *gitBackEnd) SetLabels(token []byte, labels []string) error {
+	nl, err := normalizeLabels(labels)
+	if err != nil {
+		return err
+	}
+
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err = g.lock.Lock(LockDuration)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return backend.ErrShutdown
+	}
+
+	id := hex.EncodeToString(token)
+	ok, err := g.recordExists(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return backend.ErrRecordNotFound
+	}
+
+	db, err := g.labelsDB()
+	if err != nil {
+		return err
+	}
+	if len(nl) == 0 {
+		return db.Delete([]byte(id), nil)
+	}
+	b, err := json.Marshal(nl)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(id), b, nil)
+}
+
+// GetLabels returns the sorted labels of the record identified by token.
+//
+// GetLabels satisfies the backend interface.
+func (g *gitBackEnd) GetLabels(token []byte) ([]string, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	id := hex.EncodeToString(token)
+	ok, err := g.recordExists(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, backend.ErrRecordNotFound
+	}
+
+	return g.readLabels(id)
+}
+
+// matchLabel returns true if record id carries the query label.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) matchLabel(q backend.SearchQuery, id string) (bool, error) {
+	if q.Label == "" {
+		return true, nil
+	}
+	labels, err := g.readLabels(id)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range labels {
+		if v == q.Label {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"sort"
+	"sync"
+)
+
+// Lock ordering
+//
+// There are two lock layers:
+//
+//	1. Record locks, one per token, see recordLocks.
+//	2. The global filesystem lock, g.lock.
+//
+// All unvetted branches share a single working tree and the vetted repo is
+// shared by all records, therefore every operation that checks out a branch
+// or touches either repo holds the global lock.  Record locks do not make
+// those operations concurrent.  They keep multi step operations on a single
+// record, e.g. plugin commands and InventoryJSON, consistent while the global
+// lock is released between steps.  The only reads that run concurrently with
+// operations on other records are those that go straight to the git object
+// store, e.g. loadUnvettedMDStreams, which only require the record lock.
+//
+// Operations on a single record take the record lock of that token first and
+// then, if required, the global lock.  Operations on several records, e.g.
+// SetUnvettedStatusBatch, take the record locks of all tokens, see
+// lockRecords, and then the global lock.  Repo wide operations, e.g.
+// anchoring, Inventory and Close, only take the global lock.  In order to
+// prevent deadlocks the following rules must be observed:
+//
+//	- Never acquire a record lock while holding the global lock.
+//	- Acquire multiple record locks in token order.
+
+// recordLock is a reference counted mutex for a single record.
+type recordLock struct {
+	sync.Mutex
+	refs int // Number of holders and waiters
+}
+
+// recordLocks hands out a mutex per record token.  Mutexes are discarded once
+// nobody holds or waits on them.  The zero value is ready for use.
+type recordLocks struct {
+	sync.Mutex
+	locks map[string]*recordLock // [token]lock
+}
+
+// lock locks the record identified by id.
+func (r *recordLocks) lock(id string) {
+	r.Lock()
+	if r.locks == nil {
+		r.locks = make(map[string]*recordLock)
+	}
+	l, ok := r.locks[id]
+	if !ok {
+		l = &recordLock{}
+		r.locks[id] = l
+	}
+	l.refs++
+	r.Unlock()
+
+	l.Lock()
+}
+
+// unlock unlocks the record identified by id.  It panics if the record is not
+// locked.
+func (r *recordLocks) unlock(id string) {
+	r.Lock()
+	defer r.Unlock()
+
+	l, ok := r.locks[id]
+	if !ok {
+		panic("unlock of unlocked record " + id)
+	}
+	l.refs--
+	if l.refs == 0 {
+		delete(r.locks, id)
+	}
+	l.Unlock()
+}
+
+// lockRecord locks the record identified by token and returns the function
+// that unlocks it.  It must be called before taking the global lock.
+func (g *gitBackEnd) lockRecord(token []byte) func() {
+	id := hex.EncodeToString(token)
+	g.recordLocks.lock(id)
+	return func() { g.recordLocks.unlock(id) }
+}
+
+// lockRecords locks the records identified by tokens in token order and
+// returns the function that unlocks them.  Duplicate tokens are locked once.
+// It must be called before taking the global lock.
+func (g *gitBackEnd) lockRecords(tokens [][]byte) func() {
+	seen := make(map[string]struct{}, len(tokens))
+	ids := make([]string, 0, len(tokens))
+	for _, v := range tokens {
+		id := hex.EncodeToString(v)
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, v := range ids {
+		g.recordLocks.lock(v)
+	}
+	return func() {
+		for i := len(ids) - 1; i >= 0; i-- {
+			g.recordLocks.unlock(ids[i])
+		}
+	}
+}
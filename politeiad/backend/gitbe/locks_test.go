@@ -0,0 +1,126 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+func TestRecordLocks(t *testing.T) {
+	var r recordLocks
+
+	// Different records do not block each other
+	r.lock("a")
+	r.lock("b")
+
+	// Same record blocks until unlocked
+	locked := make(chan struct{})
+	go func() {
+		r.lock("a")
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("record locked twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	r.unlock("a")
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("record not unlocked")
+	}
+
+	// Unused locks are discarded
+	r.unlock("a")
+	r.unlock("b")
+	if len(r.locks) != 0 {
+		t.Fatalf("unexpected locks: %v", r.locks)
+	}
+}
+
+func TestLockRecords(t *testing.T) {
+	var g gitBackEnd
+
+	// Duplicates are locked once and everything is released
+	unlock := g.lockRecords([][]byte{{0x02}, {0x01}, {0x02}})
+	if len(g.recordLocks.locks) != 2 {
+		t.Fatalf("unexpected locks: %v", g.recordLocks.locks)
+	}
+
+	// A single record lock waits for the batch
+	locked := make(chan struct{})
+	go func() {
+		defer g.lockRecord([]byte{0x01})()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("record locked twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("records not unlocked")
+	}
+}
+
+func TestUnvettedMDStreamsWithoutGlobalLock(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	payload := "this is a file"
+	md := "this is metadata\r\nwith a trailing newline\n"
+	rm, err := g.New([]backend.MetadataStream{{
+		ID:      2,
+		Payload: md,
+	}}, []backend.File{newTestFile("file", []byte(payload))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the global lock while reading the metadata streams
+	err = g.lock.Lock(LockDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		mds []backend.MetadataStream
+		err error
+	}
+	c := make(chan result)
+	go func() {
+		mds, err := g.GetRecordMetadataStreams(rm.Token, false)
+		c <- result{mds: mds, err: err}
+	}()
+	var r result
+	select {
+	case r = <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("read blocked on the global lock")
+	}
+	err = g.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if len(r.mds) != 1 || r.mds[0].ID != 2 || r.mds[0].Payload != md {
+		t.Fatalf("unexpected metadata streams: %v", r.mds)
+	}
+
+	_, err = g.GetRecordMetadataStreams([]byte{0xde, 0xad}, false)
+	if err != backend.ErrRecordNotFound {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+// readManifest reads the record manifest from the vetted repo.  It returns
+// backend.ErrManifestNotFound if no manifest was written yet.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) readManifest() (*backend.Manifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(g.vetted, defaultManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, backend.ErrManifestNotFound
+		}
+		return nil, err
+	}
+
+	var m backend.Manifest
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// readAnchoredManifest reads the record manifest as of the newest commit of
+// the vetted repo that is covered by an anchor.  Manifests committed since,
+// e.g. because they were deferred by MinAnchorAge, are left out until they are
+// anchored.  It returns backend.ErrManifestNotFound if no manifest was
+// anchored yet.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) readAnchoredManifest() (*backend.Manifest, error) {
+	la, err := g.readLastAnchorRecord()
+	if err != nil {
+		return nil, err
+	}
+	if len(la.Covered) == 0 {
+		return nil, backend.ErrManifestNotFound
+	}
+
+	// git show covered:manifest.json
+	covered := hex.EncodeToString(unextendSHA256(la.Covered))
+	out, err := g.gitShow(g.vetted, covered, defaultManifestFile)
+	if err != nil {
+		// The manifest did not exist yet
+		return nil, backend.ErrManifestNotFound
+	}
+
+	var m backend.Manifest
+	err = json.Unmarshal([]byte(strings.Join(out, "\n")), &m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// manifestRecords returns every vetted record and its merkle root sorted by
+// token.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) manifestRecords() ([]backend.ManifestRecord, error) {
+	files, err := ioutil.ReadDir(g.vetted)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]backend.ManifestRecord, 0, len(files))
+	for _, v := range files {
+		id := v.Name()
+		if !util.IsDigest(id) {
+			continue
+		}
+		brm, err := loadMD(g.vetted, id)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, backend.ManifestRecord{
+			Token:  id,
+			Merkle: hex.EncodeToString(brm.Merkle[:]),
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Token < records[j].Token
+	})
+
+	return records, nil
+}
+
+// updateManifest commits a new record manifest to the vetted repo if the set
+// of records or any of their merkle roots changed since the last one.  The
+// first manifest is written once there is a vetted record.  The vetted repo
+// must be on master.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) updateManifest() error {
+	records, err := g.manifestRecords()
+	if err != nil {
+		return err
+	}
+
+	old, err := g.readManifest()
+	switch {
+	case err == backend.ErrManifestNotFound:
+		if len(records) == 0 {
+			return nil
+		}
+	case err != nil:
+		return err
+	case reflect.DeepEqual(old.Records, records):
+		return nil
+	}
+
+	b, err := json.MarshalIndent(backend.Manifest{
+		Timestamp: time.Now().Unix(),
+		Records:   records,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(g.vetted, defaultManifestFile),
+		append(b, '\n'), g.fileModeOr(0664))
+	if err != nil {
+		return err
+	}
+	err = g.gitAdd(g.vetted, defaultManifestFile)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Updating manifest: %v records", len(records))
+
+	return g.gitCommit(g.vetted, "Update manifest")
+}
+
+// Manifest returns the record manifest as of the last anchor.  It lists every
+// vetted record and its merkle root, comparing it to an older anchored
+// manifest reveals records that were removed.  A manifest that was deferred by
+// MinAnchorAge is returned once a later anchor covers it.  It returns
+// backend.ErrManifestNotFound if nothing was anchored since there are vetted
+// records.
+//
+// Manifest satisfies the backend interface.
+func (g *gitBackEnd) Manifest() (*backend.Manifest, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	return g.readAnchoredManifest()
+}
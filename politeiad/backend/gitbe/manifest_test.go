@@ -0,0 +1,125 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/davecgh/go-spew/spew"
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+func TestManifest(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+	dir := g.root
+
+	_, err := g.Manifest()
+	if err != backend.ErrManifestNotFound {
+		t.Fatalf("expected ErrManifestNotFound, got %v", err)
+	}
+
+	// Vet and anchor a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	_, err = g.dropAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := g.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Records) != 1 ||
+		m.Records[0].Token != hex.EncodeToString(rm.Token) ||
+		m.Records[0].Merkle != hex.EncodeToString(rm.Merkle[:]) {
+		t.Fatalf("unexpected manifest %v", spew.Sdump(m))
+	}
+
+	// The manifest commit is anchored along with the record
+	out, err := g.git(g.vetted, "log", "-n", "1", "--pretty=format:%H",
+		"--", defaultManifestFile)
+	if err != nil || len(out) != 1 {
+		t.Fatalf("manifest commit not found: %v", err)
+	}
+	_, err = g.AnchorForCommit(out[0])
+	if err != nil {
+		t.Fatalf("manifest not anchored: %v", err)
+	}
+
+	// An unchanged manifest is not committed again
+	_, err = g.dropAnchor()
+	if err != errNothingToDo {
+		t.Fatalf("expected errNothingToDo, got %v", err)
+	}
+
+	// A vetted repo with a manifest can be adopted
+	g.Close()
+	g, err = New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Close()
+}
+
+func TestManifestMinAnchorAge(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+
+	dir, err := ioutil.TempDir("", "politeia.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, err := New(&chaincfg.TestNet2Params, dir, "", "", nil,
+		testing.Verbose(), &Options{MinAnchorAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	g.test = true
+
+	// Vet an old record, its manifest commit is too young to be anchored
+	past := time.Now().Add(-2 * time.Hour).Unix()
+	os.Setenv("GIT_COMMITTER_DATE", fmt.Sprintf("%v +0000", past))
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	os.Unsetenv("GIT_COMMITTER_DATE")
+	_, err = g.dropAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.Manifest()
+	if err != backend.ErrManifestNotFound {
+		t.Fatalf("expected ErrManifestNotFound, got %v", err)
+	}
+
+	// The deferred manifest is returned once it is anchored
+	g.minAnchorAge = 0
+	_, err = g.dropAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := g.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Records) != 1 ||
+		m.Records[0].Token != hex.EncodeToString(rm.Token) {
+		t.Fatalf("unexpected manifest %v", spew.Sdump(m))
+	}
+}
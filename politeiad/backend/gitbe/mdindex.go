@@ -0,0 +1,376 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/decred/politeia/decredplugin"
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+	"github.com/syndtr/goleveldb/leveldb"
+	ldbutil "github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// defaultMDIndexDirectory is the directory, relative to the root, of
+	// the leveldb database that indexes vetted metadata streams.
+	defaultMDIndexDirectory = "mdindex"
+
+	// mdIndexTipKey is the key of the vetted commit the index reflects.
+	mdIndexTipKey = "tip"
+)
+
+// defaultIndexedMDStreams are the metadata streams that are indexed unless
+// Options.IndexedMDStreams says otherwise.
+var defaultIndexedMDStreams = []uint64{
+	decredplugin.MDStreamVotes,
+	decredplugin.MDStreamVoteBits,
+	decredplugin.MDStreamVoteSnapshot,
+}
+
+// Metadata index
+//
+// The metadata index is a leveldb database, outside of git, that holds the
+// payload of the indexed metadata streams of every vetted record.  It is keyed
+// by stream ID and token and remembers the vetted commit it reflects.  The
+// index is brought up to date on use by looking at the records that changed
+// since that commit, it can therefore be deleted at any time and is rebuilt
+// from the vetted repo.  Unvetted records are few and are always scanned.
+
+// mdIndexKey returns the index key of stream streamID of record id.
+func mdIndexKey(streamID uint64, id string) []byte {
+	return []byte(fmt.Sprintf("md/%v/%v", streamID, id))
+}
+
+// mdIndexDB returns the metadata index database, opening it on first use.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) mdIndexDB() (*leveldb.DB, error) {
+	if g.mdIndex != nil {
+		return g.mdIndex, nil
+	}
+	db, err := leveldb.OpenFile(filepath.Join(g.root,
+		defaultMDIndexDirectory), nil)
+	if err != nil {
+		return nil, err
+	}
+	g.mdIndex = db
+	return db, nil
+}
+
+// mdStreamID returns the stream ID of a metadata stream filename.
+func mdStreamID(filename string) (uint64, bool) {
+	if !strings.HasSuffix(filename, defaultMDFilenameSuffix) {
+		return 0, false
+	}
+	mdid, err := strconv.ParseUint(strings.TrimSuffix(filename,
+		defaultMDFilenameSuffix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return mdid, true
+}
+
+// loadMDStream loads metadata stream streamID of path/id.  It returns false if
+// the record does not have the stream.
+//
+// This function must be called with the lock held.
+func loadMDStream(path, id string, streamID uint64) (string, bool, error) {
+	dir := filepath.Join(path, id)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", false, err
+	}
+	for _, v := range files {
+		mdid, ok := mdStreamID(v.Name())
+		if !ok || mdid != streamID {
+			continue
+		}
+		md, err := ioutil.ReadFile(filepath.Join(dir, v.Name()))
+		if err != nil {
+			return "", false, err
+		}
+		return string(md), true, nil
+	}
+	return "", false, nil
+}
+
+// loadUnvettedMDStream loads metadata stream streamID of unvetted record id
+// straight from its branch.  It returns false if the record does not have the
+// stream.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) loadUnvettedMDStream(id string, streamID uint64) (string, bool, error) {
+	branch := recordBranch(id)
+	files, err := g.git(g.unvetted, "ls-tree", "--name-only", branch,
+		id+"/")
+	if err != nil {
+		return "", false, err
+	}
+	for _, v := range files {
+		filename := strings.TrimPrefix(strings.TrimSpace(v), id+"/")
+		mdid, ok := mdStreamID(filename)
+		if !ok || mdid != streamID {
+			continue
+		}
+		md, err := g.gitRaw(g.unvetted, "show",
+			branch+":"+id+"/"+filename)
+		if err != nil {
+			return "", false, err
+		}
+		return string(md), true, nil
+	}
+	return "", false, nil
+}
+
+// vettedIDs returns the ids of all vetted records.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) vettedIDs() ([]string, error) {
+	files, err := ioutil.ReadDir(g.vetted)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(files))
+	for _, v := range files {
+		if util.IsDigest(v.Name()) {
+			ids = append(ids, v.Name())
+		}
+	}
+	return ids, nil
+}
+
+// changedVettedIDs returns the ids of the vetted records that changed between
+// commit from and the current vetted commit.  It returns false if from is not
+// known, e.g. after the vetted repo was restored.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) changedVettedIDs(from string) ([]string, bool) {
+	out, err := g.git(g.vetted, "diff", "--name-only", from, "HEAD")
+	if err != nil {
+		return nil, false
+	}
+	seen := make(map[string]struct{})
+	ids := make([]string, 0, len(out))
+	for _, v := range out {
+		id := strings.SplitN(strings.TrimSpace(v), "/", 2)[0]
+		if !util.IsDigest(id) {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// syncMDIndex brings the metadata index up to date with the vetted repo.
+// Only the records that changed since the last sync are reloaded.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) syncMDIndex() (*leveldb.DB, error) {
+	db, err := g.mdIndexDB()
+	if err != nil {
+		return nil, err
+	}
+	tip, err := g.gitRevParse(g.vetted, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	from, err := db.Get([]byte(mdIndexTipKey), nil)
+	switch {
+	case err == leveldb.ErrNotFound:
+	case err != nil:
+		return nil, err
+	case string(from) == tip:
+		return db, nil
+	default:
+		var ok bool
+		ids, ok = g.changedVettedIDs(string(from))
+		if !ok {
+			ids = nil
+		}
+	}
+
+	batch := new(leveldb.Batch)
+	if ids == nil {
+		// Rebuild from scratch
+		log.Infof("Building metadata index")
+		iter := db.NewIterator(nil, nil)
+		for iter.Next() {
+			batch.Delete(append([]byte{}, iter.Key()...))
+		}
+		iter.Release()
+		err = iter.Error()
+		if err != nil {
+			return nil, err
+		}
+		ids, err = g.vettedIDs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range ids {
+		for streamID := range g.indexedMD {
+			md, ok, err := loadMDStream(g.vetted, id, streamID)
+			if os.IsNotExist(err) {
+				// Record is gone
+				ok, err = false, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				batch.Put(mdIndexKey(streamID, id), []byte(md))
+			} else {
+				batch.Delete(mdIndexKey(streamID, id))
+			}
+		}
+	}
+	batch.Put([]byte(mdIndexTipKey), []byte(tip))
+
+	err = db.Write(batch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// dropMDIndex forgets the indexed commit so that the metadata index is rebuilt
+// on next use.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) dropMDIndex() error {
+	db, err := g.mdIndexDB()
+	if err != nil {
+		return err
+	}
+	return db.Delete([]byte(mdIndexTipKey), nil)
+}
+
+// findVettedByMetadata returns the ids of the vetted records whose metadata
+// stream streamID satisfies predicate.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) findVettedByMetadata(streamID uint64, predicate func(string) bool) ([]string, error) {
+	var ids []string
+	if _, ok := g.indexedMD[streamID]; ok {
+		db, err := g.syncMDIndex()
+		if err != nil {
+			return nil, err
+		}
+		prefix := mdIndexKey(streamID, "")
+		iter := db.NewIterator(ldbutil.BytesPrefix(prefix), nil)
+		for iter.Next() {
+			if predicate(string(iter.Value())) {
+				ids = append(ids,
+					string(iter.Key()[len(prefix):]))
+			}
+		}
+		iter.Release()
+		return ids, iter.Error()
+	}
+
+	vetted, err := g.vettedIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range vetted {
+		md, ok, err := loadMDStream(g.vetted, id, streamID)
+		if err != nil {
+			return nil, err
+		}
+		if ok && predicate(md) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// FindByMetadata returns the records whose metadata stream streamID satisfies
+// predicate, vetted records first, in token order.  Records that do not have
+// the stream never match.  Only the stream is loaded to evaluate predicate and
+// indexed streams of vetted records are served from the metadata index.  File
+// payloads are not returned.  Predicate is called with the lock held and must
+// not call into the backend.
+//
+// FindByMetadata satisfies the backend interface.
+func (g *gitBackEnd) FindByMetadata(streamID uint64, predicate func(payload string) bool) ([]backend.Record, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	records := make([]backend.Record, 0)
+
+	// Vetted
+	ids, err := g.findVettedByMetadata(streamID, predicate)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		r, err := g._getRecord(id, g.vetted, false)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *r)
+	}
+
+	// Unvetted
+	ids, err = g.recordBranches(g.unvetted)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// git checkout master
+		err := g.gitCheckout(g.unvetted, "master")
+		if err != nil {
+			log.Errorf("could not switch to master: %v", err)
+		}
+	}()
+	for _, id := range ids {
+		md, ok, err := g.loadUnvettedMDStream(id, streamID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !predicate(md) {
+			continue
+		}
+
+		// git checkout records/id
+		err = g.checkoutUnvetted(id)
+		if err != nil {
+			return nil, err
+		}
+		r, err := g._getRecord(id, g.unvetted, false)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *r)
+	}
+
+	return records, nil
+}
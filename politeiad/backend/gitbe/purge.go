@@ -0,0 +1,194 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+// Purging file content
+//
+// Rewriting git history to strip a payload would change the digest of every
+// commit that follows it and therefore invalidate every anchor that was
+// dropped since, which defeats the purpose of anchoring.  Instead only
+// payloads that live in the blob store can be purged.  Git merely holds a
+// pointer, that carries the payload digest, to those so the blob is deleted
+// and a redaction tombstone that is indexed by the digest is left in its
+// place.  Commits, anchors and record merkle roots remain valid since they
+// only cover the pointer and digests.  The purged content can no longer be
+// verified against its digest by anyone that did not keep a copy.  Payloads
+// that are committed to git can not be purged, operators that need to be
+// able to take down content should set a blob threshold.
+
+// purgeTombstone is the redaction placeholder of a purged blob.
+type purgeTombstone struct {
+	Digest    string `json:"digest"`    // Payload digest
+	MIME      string `json:"mime"`      // MIME type of the purged payload
+	Timestamp int64  `json:"timestamp"` // Purge time
+}
+
+// purgedFilename returns the filename of the tombstone of the blob digest.
+func (g *gitBackEnd) purgedFilename(digest string) string {
+	return filepath.Join(g.root, defaultPurgedDirectory, digest)
+}
+
+// readPurged returns the tombstone of the blob digest or nil if the blob was
+// not purged.
+func (g *gitBackEnd) readPurged(digest string) (*purgeTombstone, error) {
+	b, err := ioutil.ReadFile(g.purgedFilename(digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pt purgeTombstone
+	err = json.Unmarshal(b, &pt)
+	if err != nil {
+		return nil, err
+	}
+	return &pt, nil
+}
+
+// purgedPayload returns the tombstone of the payload stored in filename or nil
+// if the payload was not purged.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) purgedPayload(filename string) (*purgeTombstone, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	digest, ok := parseBlobPointer(b)
+	if !ok {
+		return nil, nil
+	}
+	return g.readPurged(digest)
+}
+
+// PurgeFile irrevocably deletes the content of filename of the record
+// identified by token, e.g. for a legal takedown.  See "Purging file content"
+// for the consequences.  The record keeps listing the file, with its digest
+// and an empty payload.  Since the blob store is content addressed the
+// content is purged for every record that references it.  It returns
+// backend.ErrFileNotPurgeable if the payload is committed to git.
+//
+// PurgeFile satisfies the backend interface.
+func (g *gitBackEnd) PurgeFile(token []byte, filename string) error {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return backend.ErrShutdown
+	}
+
+	if !validFilename(filename) {
+		return backend.ErrFileNotFound
+	}
+
+	// Find the record, vetted records live in master
+	id := hex.EncodeToString(token)
+	path := g.vetted
+	_, err = os.Stat(filepath.Join(g.vetted, id))
+	switch {
+	case err == nil:
+		err = g.gitCheckout(g.vetted, "master")
+	case os.IsNotExist(err):
+		path = g.unvetted
+		if !g.gitBranchExists(g.unvetted, recordBranch(id)) {
+			return backend.ErrRecordNotFound
+		}
+		err = g.gitCheckout(g.unvetted, recordBranch(id))
+		defer func() {
+			err := g.gitCheckout(g.unvetted, "master")
+			if err != nil {
+				log.Errorf("PurgeFile checkout master: %v", err)
+			}
+		}()
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(payloadFilename(filepath.Join(path, id,
+		defaultPayloadDir), filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return backend.ErrFileNotFound
+		}
+		return err
+	}
+	digest, ok := parseBlobPointer(b)
+	if !ok {
+		return backend.ErrFileNotPurgeable
+	}
+	pt, err := g.readPurged(digest)
+	if err != nil {
+		return err
+	}
+	if pt != nil {
+		// Already purged
+		return nil
+	}
+
+	// Write the tombstone before deleting the blob so that the payload is
+	// never reported as corrupt.
+	blob, err := ioutil.ReadFile(g.blobFilename(digest))
+	if err != nil {
+		return err
+	}
+	mt, err := loadMIMETypes(path, id)
+	if err != nil {
+		return err
+	}
+	mimeType, ok := mt[filename]
+	if !ok {
+		mimeType = http.DetectContentType(blob)
+	}
+	tb, err := json.Marshal(purgeTombstone{
+		Digest:    digest,
+		MIME:      mimeType,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Join(g.root, defaultPurgedDirectory),
+		g.dirModeOr(0774))
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(g.purgedFilename(digest), tb, g.fileModeOr(0664))
+	if err != nil {
+		return err
+	}
+	err = os.Remove(g.blobFilename(digest))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Purged file %v %v: %v", id, filename, digest)
+
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"os"
+	"sort"
+
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+	"github.com/syndtr/goleveldb/leveldb"
+	ldbutil "github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// reconcileLabels reports, and if repair is set deletes, the labels of tokens
+// that are neither vetted nor unvetted records.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) reconcileLabels(rr *backend.ReconcileReport, repair bool) error {
+	db, err := g.labelsDB()
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		id := string(iter.Key())
+		ok := util.IsDigest(id)
+		if ok {
+			ok, err = g.recordExists(id)
+			if err != nil {
+				iter.Release()
+				return err
+			}
+		}
+		if ok {
+			continue
+		}
+		rr.OrphanLabels = append(rr.OrphanLabels, id)
+		batch.Delete([]byte(id))
+	}
+	iter.Release()
+	err = iter.Error()
+	if err != nil {
+		return err
+	}
+
+	if !repair || batch.Len() == 0 {
+		return nil
+	}
+	return db.Write(batch, nil)
+}
+
+// reconcileMDIndex compares every entry of the metadata index to the vetted
+// repo and reports, and if repair is set fixes, the differences.  The index is
+// synced first so that records that merely changed since the last sync are not
+// reported.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) reconcileMDIndex(rr *backend.ReconcileReport, repair bool) error {
+	db, err := g.syncMDIndex()
+	if err != nil {
+		return err
+	}
+
+	// Everything the index should hold according to the vetted repo
+	ids, err := g.vettedIDs()
+	if err != nil {
+		return err
+	}
+	expected := make(map[string]string)
+	for _, id := range ids {
+		for streamID := range g.indexedMD {
+			md, ok, err := loadMDStream(g.vetted, id, streamID)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if ok {
+				expected[string(mdIndexKey(streamID, id))] = md
+			}
+		}
+	}
+
+	batch := new(leveldb.Batch)
+	iter := db.NewIterator(ldbutil.BytesPrefix([]byte("md/")), nil)
+	for iter.Next() {
+		key := string(iter.Key())
+		md, ok := expected[key]
+		if ok {
+			delete(expected, key)
+			if md == string(iter.Value()) {
+				continue
+			}
+			batch.Put([]byte(key), []byte(md))
+		} else {
+			batch.Delete([]byte(key))
+		}
+		rr.StaleMDIndex = append(rr.StaleMDIndex, key)
+	}
+	iter.Release()
+	err = iter.Error()
+	if err != nil {
+		return err
+	}
+	for key, md := range expected {
+		rr.MissingMDIndex = append(rr.MissingMDIndex, key)
+		batch.Put([]byte(key), []byte(md))
+	}
+
+	if !repair || batch.Len() == 0 {
+		return nil
+	}
+	return db.Write(batch, nil)
+}
+
+// ReconcileDB compares the labels database and the metadata index to the
+// repositories, which are authoritative.  Labels of tokens that are no record
+// and index entries that do not match the vetted repo are reported and, if
+// repair is set, deleted or rewritten.  Run it after an unclean shutdown or
+// after restoring the repositories from a backup.
+//
+// ReconcileDB satisfies the backend interface.
+func (g *gitBackEnd) ReconcileDB(repair bool) (*backend.ReconcileReport, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	var rr backend.ReconcileReport
+	err = g.reconcileLabels(&rr, repair)
+	if err != nil {
+		return nil, err
+	}
+	err = g.reconcileMDIndex(&rr, repair)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rr.MissingMDIndex)
+	rr.Repaired = repair
+
+	n := len(rr.OrphanLabels) + len(rr.StaleMDIndex) +
+		len(rr.MissingMDIndex)
+	if n != 0 {
+		log.Infof("ReconcileDB: %v discrepancies, repaired %v", n,
+			repair)
+	}
+
+	return &rr, nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/decred/politeia/decredplugin"
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+// Reindex rescans both repos and rebuilds everything that is derived from them
+// after out of band changes, e.g. restoring a backup or importing records.
+// The unvetted repo is synced to the vetted repo, the metadata of every record
+// must load, the decred plugin vote cache and the metadata index are dropped
+// and the labels of records that no longer exist are pruned, see
+// reconcileLabels.  The set of records pending review is rebuilt.  Status
+// counts and tokens in use are always derived from the repos on demand and
+// need no rebuilding.
+//
+// Reindex satisfies the backend interface.
+func (g *gitBackEnd) Reindex() error {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return backend.ErrShutdown
+	}
+
+	log.Infof("Reindexing %v", g.root)
+
+	// git checkout master
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		return err
+	}
+
+	// git pull --ff-only --rebase
+	err = g.gitPull(g.unvetted, true)
+	if err != nil {
+		return err
+	}
+
+	// Rescan vetted
+	counts := make(map[backend.MDStatusT]int)
+	files, err := ioutil.ReadDir(g.vetted)
+	if err != nil {
+		return err
+	}
+	for _, v := range files {
+		id := v.Name()
+		if !util.IsDigest(id) {
+			continue
+		}
+		brm, err := loadMD(g.vetted, id)
+		if err != nil {
+			return fmt.Errorf("reindex vetted %v: %v", id, err)
+		}
+		counts[brm.Status]++
+	}
+
+	// Rescan unvetted
+	ids, err := g.recordBranches(g.unvetted)
+	if err != nil {
+		return err
+	}
+	pending := make(map[string]struct{})
+	for _, id := range ids {
+		brm, err := g.loadUnvettedMD(id)
+		if err != nil {
+			return fmt.Errorf("reindex unvetted %v: %v", id, err)
+		}
+		counts[brm.Status]++
+		if isPending(brm.Status) {
+			pending[id] = struct{}{}
+		}
+	}
+	g.pending = pending
+
+	// Vote bits are reloaded from the vetted repo on demand
+	g.decredPluginVoteCache = make(map[string]*decredplugin.Vote)
+
+	var rr backend.ReconcileReport
+	err = g.reconcileLabels(&rr, true)
+	if err != nil {
+		return err
+	}
+
+	// The metadata index is rebuilt on next use
+	err = g.dropMDIndex()
+	if err != nil {
+		return err
+	}
+
+	for status, count := range counts {
+		log.Infof("Reindex: %v %v records", count, backend.MDStatus[status])
+	}
+	log.Infof("Reindex: dropped labels of %v records",
+		len(rr.OrphanLabels))
+
+	return nil
+}
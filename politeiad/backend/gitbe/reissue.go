@@ -0,0 +1,188 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+// reissuedFilename returns the filename of the tombstone of id.
+func (g *gitBackEnd) reissuedFilename(id string) string {
+	return filepath.Join(g.root, defaultReissuedDirectory, id)
+}
+
+// writeReissued records the tombstone that maps id to newID.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) writeReissued(id, newID string) error {
+	err := os.MkdirAll(filepath.Join(g.root, defaultReissuedDirectory),
+		g.dirModeOr(0774))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(g.reissuedFilename(id), []byte(newID+"\n"),
+		g.fileModeOr(0664))
+}
+
+// reissueToken moves the unvetted record id onto branch newID and renames its
+// directory accordingly.  Note that this function must be wrapped by a
+// function that delivers the call with the unvetted repo sitting in master.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) reissueToken(id, newID string, newToken []byte) error {
+	// git checkout records/id
+	err := g.checkoutUnvetted(id)
+	if err != nil {
+		return err
+	}
+
+	brm, err := loadMD(g.unvetted, id)
+	if err != nil {
+		return err
+	}
+	if brm.Status == backend.MDStatusVetted {
+		return backend.ErrRecordVetted
+	}
+
+	// git checkout -b records/newID
+	err = g.gitNewBranch(g.unvetted, recordBranch(newID))
+	if err != nil {
+		return err
+	}
+
+	// git mv id newID
+	_, err = g.git(g.unvetted, "mv", id, newID)
+	if err != nil {
+		return err
+	}
+
+	brm.Token = newToken
+	brm.Timestamp = time.Now().Unix()
+	err = g.updateMD(g.unvetted, newID, brm)
+	if err != nil {
+		return err
+	}
+
+	// git add newID/recordmetadata.json
+	err = g.gitAdd(g.unvetted, filepath.Join(g.unvetted, newID,
+		defaultRecordMetadataFilename))
+	if err != nil {
+		return err
+	}
+
+	// git commit -m "message"
+	return g.gitCommit(g.unvetted, "Reissue record "+id+" as "+newID)
+}
+
+// ReissueToken moves an unvetted record to a freshly generated token and
+// returns it.  The history of the record is retained on the new branch and a
+// tombstone that maps the old token to the new one is kept outside of the
+// repositories, the old token is never handed out again.  Labels move to the
+// new token.  The metadata index only holds vetted records so there is nothing
+// to move there.  Vetted records can't be reissued since their token is part of
+// the public record.
+//
+// ReissueToken satisfies the backend interface.
+func (g *gitBackEnd) ReissueToken(token []byte) ([]byte, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	id := hex.EncodeToString(token)
+	_, err = os.Stat(filepath.Join(g.vetted, id))
+	if err == nil {
+		return nil, backend.ErrRecordVetted
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	newToken, err := g.newToken()
+	if err != nil {
+		return nil, err
+	}
+	newID := hex.EncodeToString(newToken)
+
+	// Do the work, if there is an error we must unwind git.
+	var errReturn error
+	err = g.reissueToken(id, newID, newToken)
+	if err == nil {
+		err = g.moveLabels(id, newID)
+	}
+	if err == nil {
+		err = g.writeReissued(id, newID)
+		if err != nil {
+			// Labels follow the record
+			err2 := g.moveLabels(newID, id)
+			if err2 != nil {
+				log.Errorf("moveLabels: %v", err2)
+			}
+		}
+	}
+	if err != nil {
+		// git stash
+		err2 := g.gitStash(g.unvetted)
+		if err2 != nil {
+			// We are in trouble! Consider a panic.
+			log.Errorf("gitStash: %v", err2)
+			return nil, err2
+		}
+
+		errReturn = err
+	}
+
+	// git checkout master
+	err = g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the branch that is no longer in use
+	drop := id
+	if errReturn != nil {
+		drop = newID
+		if !g.gitBranchExists(g.unvetted, recordBranch(newID)) {
+			return nil, errReturn
+		}
+	}
+	err = g.gitBranchDelete(g.unvetted, recordBranch(drop))
+	if err != nil {
+		// We are in trouble! Consider a panic.
+		log.Errorf("gitBranchDelete: %v", err)
+		return nil, err
+	}
+	if errReturn != nil {
+		return nil, errReturn
+	}
+
+	if _, ok := g.pending[id]; ok {
+		delete(g.pending, id)
+		g.pending[newID] = struct{}{}
+	}
+
+	log.Infof("Reissued record %v as %v", id, newID)
+
+	return newToken, nil
+}
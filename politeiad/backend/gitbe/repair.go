@@ -0,0 +1,402 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+// lastValidMD walks the history of the record metadata of path/id and returns
+// the most recent version that decodes.  It returns nil if there is no such
+// version.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) lastValidMD(path, id string) (*backend.RecordMetadata, error) {
+	filename := id + "/" + defaultRecordMetadataFilename
+	commits, err := g.git(path, "log", "--format=%H", "--", filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, commit := range commits {
+		out, err := g.gitShow(path, commit, filename)
+		if err != nil {
+			continue
+		}
+		var brm backend.RecordMetadata
+		err = json.Unmarshal([]byte(strings.Join(out, "\n")), &brm)
+		if err != nil {
+			continue
+		}
+		return &brm, nil
+	}
+	return nil, nil
+}
+
+// payloadMerkle returns the merkle root of the payload of path/id that is
+// currently on disk.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) payloadMerkle(path, id string) (*[sha256.Size]byte, error) {
+	// Find all hashes
+	ppath := filepath.Join(path, id, defaultPayloadDir)
+	files, err := payloadFiles(ppath)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]*[sha256.Size]byte, 0, len(files))
+	for _, v := range files {
+		digest, err := g.payloadDigest(payloadFilename(ppath, v))
+		if err != nil {
+			return nil, err
+		}
+		var d [sha256.Size]byte
+		copy(d[:], digest)
+		hashes = append(hashes, &d)
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("record has no files: %v", id)
+	}
+
+	return merkle.Root(hashes), nil
+}
+
+// rebuildMD reconstructs the RecordMetadata of path/id from the payload that
+// is currently on disk and the record history.  Version, status and timestamp
+// are taken from the last valid record metadata in history, status falls back
+// to the provided one and timestamp to now if there is none.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) rebuildMD(path, id string, token []byte, status backend.MDStatusT) (*backend.RecordMetadata, error) {
+	root, err := g.payloadMerkle(path, id)
+	if err != nil {
+		return nil, err
+	}
+
+	brm := backend.RecordMetadata{
+		Version:   1,
+		Status:    status,
+		Merkle:    *root,
+		Timestamp: time.Now().Unix(),
+		Token:     token,
+	}
+
+	prev, err := g.lastValidMD(path, id)
+	if err != nil {
+		return nil, err
+	}
+	if prev == nil {
+		log.Warnf("rebuildMD: no valid record metadata in history %v",
+			id)
+		return &brm, nil
+	}
+	brm.Version = prev.Version
+	brm.Status = prev.Status
+	brm.Timestamp = prev.Timestamp
+
+	return &brm, nil
+}
+
+// repairMD rebuilds and commits the record metadata of path/id.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) repairMD(path, id string, token []byte, status backend.MDStatusT) error {
+	brm, err := g.rebuildMD(path, id, token, status)
+	if err != nil {
+		return err
+	}
+	err = g.updateMD(path, id, brm)
+	if err != nil {
+		return err
+	}
+
+	// git add id/recordmetadata.json
+	err = g.gitAdd(path, filepath.Join(path, id,
+		defaultRecordMetadataFilename))
+	if err != nil {
+		return err
+	}
+
+	// git commit -m "message"
+	return g.gitCommit(path, "Repair record metadata "+id)
+}
+
+// repairVetted repairs a vetted record.  It goes through the normal stages of
+// updating unvetted, pushing PR, merge PR, pull remote.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) repairVetted(id string, token []byte) error {
+	// git checkout master
+	err := g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		return err
+	}
+
+	// git pull --ff-only --rebase
+	err = g.gitPull(g.unvetted, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = loadMD(g.unvetted, id)
+	if err == nil {
+		return backend.ErrRecordNotCorrupt
+	}
+	log.Infof("Repairing vetted record %v: %v", id, err)
+
+	return g.updateVetted(id, func() error {
+		return g.repairMD(g.unvetted, id, token, backend.MDStatusVetted)
+	})
+}
+
+// repairUnvetted repairs an unvetted record on its branch.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) repairUnvetted(id string, token []byte) error {
+	// git checkout records/id
+	err := g.checkoutUnvetted(id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// git checkout master
+		err := g.gitCheckout(g.unvetted, "master")
+		if err != nil {
+			log.Errorf("could not switch to master: %v", err)
+		}
+	}()
+
+	_, err = loadMD(g.unvetted, id)
+	if err == nil {
+		return backend.ErrRecordNotCorrupt
+	}
+	log.Infof("Repairing unvetted record %v: %v", id, err)
+
+	err = g.repairMD(g.unvetted, id, token, backend.MDStatusUnvetted)
+	if err != nil {
+		// git stash
+		err2 := g.gitStash(g.unvetted)
+		if err2 != nil {
+			// We are in trouble! Consider a panic.
+			log.Errorf("gitStash: %v", err2)
+			return err2
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RepairRecord rebuilds the record metadata of a record whose
+// recordmetadata.json is missing or can not be decoded.  The merkle root is
+// recalculated from the payload, version and status are recovered from
+// history on a best effort basis.  It returns backend.ErrRecordNotCorrupt if
+// the record metadata is intact.
+//
+// RepairRecord satisfies the backend interface.
+func (g *gitBackEnd) RepairRecord(token []byte) error {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return backend.ErrShutdown
+	}
+
+	id := hex.EncodeToString(token)
+	_, err = os.Stat(filepath.Join(g.vetted, id))
+	if err == nil {
+		return g.repairVetted(id, token)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return g.repairUnvetted(id, token)
+}
+
+// staleMerkle loads the record metadata of path/id and compares its merkle
+// root to the merkle root of the payload, see payloadMerkle.  It returns the
+// record metadata and, if the merkle root is stale, the merkle root of the
+// payload.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) staleMerkle(path, id string) (*backend.RecordMetadata, *[sha256.Size]byte, error) {
+	brm, err := loadMD(path, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := g.payloadMerkle(path, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *root == brm.Merkle {
+		return brm, nil, nil
+	}
+	return brm, root, nil
+}
+
+// fixMerkle replaces the stale merkle root in the record metadata brm of
+// path/id with root and commits it.  The commit message names the old and the
+// new merkle root so that the correction ends up in the anchor audit trail.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) fixMerkle(path, id string, brm *backend.RecordMetadata, root *[sha256.Size]byte) error {
+	stale := hex.EncodeToString(brm.Merkle[:])
+	brm.Merkle = *root
+	err := g.updateMD(path, id, brm)
+	if err != nil {
+		return err
+	}
+
+	// git add id/recordmetadata.json
+	err = g.gitAdd(path, filepath.Join(path, id,
+		defaultRecordMetadataFilename))
+	if err != nil {
+		return err
+	}
+
+	// git commit -m "message"
+	return g.gitCommit(path, "Recalculate merkle "+id+" "+stale+" "+
+		hex.EncodeToString(root[:]))
+}
+
+// recalculateVetted fixes the merkle root of a vetted record.  It goes
+// through the normal stages of updating unvetted, pushing PR, merge PR, pull
+// remote.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) recalculateVetted(id string) (*backend.RecordMetadata, error) {
+	// git checkout master
+	err := g.gitCheckout(g.unvetted, "master")
+	if err != nil {
+		return nil, err
+	}
+
+	// git pull --ff-only --rebase
+	err = g.gitPull(g.unvetted, true)
+	if err != nil {
+		return nil, err
+	}
+
+	brm, root, err := g.staleMerkle(g.unvetted, id)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return brm, nil
+	}
+	log.Infof("Recalculating merkle of vetted record %v: %x -> %x", id,
+		brm.Merkle, *root)
+
+	err = g.updateVetted(id, func() error {
+		return g.fixMerkle(g.unvetted, id, brm, root)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return brm, nil
+}
+
+// recalculateUnvetted fixes the merkle root of an unvetted record on its
+// branch.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) recalculateUnvetted(id string) (*backend.RecordMetadata, error) {
+	// git checkout records/id
+	err := g.checkoutUnvetted(id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// git checkout master
+		err := g.gitCheckout(g.unvetted, "master")
+		if err != nil {
+			log.Errorf("could not switch to master: %v", err)
+		}
+	}()
+
+	brm, root, err := g.staleMerkle(g.unvetted, id)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return brm, nil
+	}
+	log.Infof("Recalculating merkle of unvetted record %v: %x -> %x", id,
+		brm.Merkle, *root)
+
+	err = g.fixMerkle(g.unvetted, id, brm, root)
+	if err != nil {
+		// git stash
+		err2 := g.gitStash(g.unvetted)
+		if err2 != nil {
+			// We are in trouble! Consider a panic.
+			log.Errorf("gitStash: %v", err2)
+			return nil, err2
+		}
+		return nil, err
+	}
+
+	return brm, nil
+}
+
+// RecalculateMerkle recomputes the merkle root of a record from its current
+// payload and, if the merkle root in the record metadata is stale, commits
+// the corrected record metadata.  Version, status and timestamp are left
+// alone.  The commit message carries the old and the new merkle root, which
+// lands in the anchor audit trail with the next anchor.  It is a no-op for
+// records whose merkle root is correct.  Records whose record metadata can't
+// be decoded are fixed with RepairRecord instead.
+//
+// RecalculateMerkle satisfies the backend interface.
+func (g *gitBackEnd) RecalculateMerkle(token []byte) (*backend.RecordMetadata, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	id := hex.EncodeToString(token)
+	_, err = os.Stat(filepath.Join(g.vetted, id))
+	if err == nil {
+		return g.recalculateVetted(id)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return g.recalculateUnvetted(id)
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+// matchMD returns true if the provided RecordMetadata satisfies the status and
+// timestamp criteria of the query.
+func matchMD(q backend.SearchQuery, brm *backend.RecordMetadata) bool {
+	if len(q.Status) != 0 {
+		var found bool
+		for _, v := range q.Status {
+			if brm.Status == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.From != 0 && brm.Timestamp < q.From {
+		return false
+	}
+	if q.To != 0 && brm.Timestamp > q.To {
+		return false
+	}
+	return true
+}
+
+// matchFilename returns true if any of the provided payload filenames
+// contains the query filename substring.
+func matchFilename(q backend.SearchQuery, files []string) bool {
+	if q.Filename == "" {
+		return true
+	}
+	needle := strings.ToLower(q.Filename)
+	for _, v := range files {
+		if strings.Contains(strings.ToLower(v), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// vettedFilenames returns the payload filenames of vetted record id.  Only the
+// directory is read, file payloads are not touched.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) vettedFilenames(id string) ([]string, error) {
+	files, err := payloadFiles(filepath.Join(g.vetted, id,
+		defaultPayloadDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// unvettedFilenames returns the payload filenames of unvetted record id
+// straight from its branch.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) unvettedFilenames(id string) ([]string, error) {
+	prefix := id + "/" + defaultPayloadDir + "/"
+	out, err := g.git(g.unvetted, "ls-tree", "-r", "--name-only",
+		recordBranch(id), prefix)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(out))
+	for _, v := range out {
+		files = append(files, strings.TrimPrefix(strings.TrimSpace(v),
+			prefix))
+	}
+	return files, nil
+}
+
+// searchRecord loads record id if it matches the query.  It returns nil if
+// the record does not match.  Unvetted records are matched straight from
+// their branch, the branch is only checked out to load the file payloads of
+// a matching record.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) searchRecord(q backend.SearchQuery, id string) (*backend.Record, error) {
+	ok, err := g.matchLabel(q, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	var brm *backend.RecordMetadata
+	if q.Unvetted {
+		brm, err = g.loadUnvettedMD(id)
+	} else {
+		brm, err = loadMD(g.vetted, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !matchMD(q, brm) {
+		return nil, nil
+	}
+
+	if q.Filename != "" {
+		var files []string
+		if q.Unvetted {
+			files, err = g.unvettedFilenames(id)
+		} else {
+			files, err = g.vettedFilenames(id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !matchFilename(q, files) {
+			return nil, nil
+		}
+	}
+
+	if !q.Unvetted {
+		return g._getRecord(id, g.vetted, q.IncludeFiles)
+	}
+	if q.IncludeFiles {
+		token, err := hex.DecodeString(id)
+		if err != nil {
+			return nil, err
+		}
+		return g.getRecord(token, g.unvetted, true)
+	}
+	mds, err := g.loadUnvettedMDStreams(id)
+	if err != nil {
+		return nil, err
+	}
+	return &backend.Record{
+		RecordMetadata: *brm,
+		Metadata:       mds,
+	}, nil
+}
+
+// Search returns all records that match the provided query.  Matching is done
+// on the record metadata and the payload directory listing so file payloads
+// are only loaded for matching records and only if requested.  Unvetted
+// records are read from their branches without checking them out.  Results
+// are returned in token order and paged by the query Offset and Limit.
+//
+// Search satisfies the backend interface.
+func (g *gitBackEnd) Search(q backend.SearchQuery) ([]backend.Record, error) {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, backend.ErrShutdown
+	}
+
+	// Collect candidate ids
+	var ids []string
+	if q.Unvetted {
+		ids, err = g.recordBranches(g.unvetted)
+	} else {
+		ids, err = g.vettedIDs()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		skipped uint
+		records []backend.Record
+	)
+	for _, id := range ids {
+		if !util.IsDigest(id) {
+			continue
+		}
+		r, err := g.searchRecord(q, id)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			continue
+		}
+
+		// Page results
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		records = append(records, *r)
+		if q.Limit != 0 && uint(len(records)) >= q.Limit {
+			break
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/decred/politeia/util"
+)
+
+// selfTestFile returns a throwaway record file.
+func selfTestFile(name, content string) backend.File {
+	payload := []byte(content)
+	return backend.File{
+		Name:    name,
+		MIME:    http.DetectContentType(payload),
+		Digest:  hex.EncodeToString(util.Digest(payload)),
+		Payload: base64.StdEncoding.EncodeToString(payload),
+	}
+}
+
+// selfTestSteps runs the self test steps against the throwaway backend st and
+// stops at the first failure.
+func selfTestSteps(st *gitBackEnd, report *backend.SelfTestReport) {
+	var token []byte
+	steps := []struct {
+		name string
+		f    func() error
+	}{
+		{"create", func() error {
+			rm, err := st.New([]backend.MetadataStream{{
+				ID:      0,
+				Payload: "politeia self test",
+			}}, []backend.File{selfTestFile("selftest", "created")})
+			if err != nil {
+				return err
+			}
+			token = rm.Token
+			return nil
+		}},
+		{"update", func() error {
+			_, err := st.UpdateUnvettedRecord(token, nil, nil,
+				[]backend.File{selfTestFile("selftest", "updated")},
+				nil)
+			return err
+		}},
+		{"vet", func() error {
+			emptyMD := []backend.MetadataStream{}
+			_, err := st.SetUnvettedStatus(token,
+				backend.MDStatusVetted, emptyMD, emptyMD)
+			return err
+		}},
+		{"anchor", func() error {
+			return st.anchorAllRepos()
+		}},
+		{"confirm", func() error {
+			err := st.anchorChecker()
+			if err != nil {
+				return err
+			}
+			as, err := st.RecordAnchorStatus(token)
+			if err != nil {
+				return err
+			}
+			if as != backend.AnchorStatusConfirmed {
+				return fmt.Errorf("anchor not confirmed: %v",
+					backend.AnchorStatuses[as])
+			}
+			return nil
+		}},
+		{"verify", func() error {
+			_, err := st.ProveAnchored(token)
+			return err
+		}},
+	}
+	for _, v := range steps {
+		start := time.Now()
+		err := v.f()
+		step := backend.SelfTestStep{
+			Name:     v.name,
+			Duration: time.Since(start),
+		}
+		if err != nil {
+			step.Error = err.Error()
+		}
+		report.Steps = append(report.Steps, step)
+		if err != nil {
+			return
+		}
+	}
+	report.Passed = true
+}
+
+// SelfTest exercises the record pipeline, create, update, vet, anchor and
+// anchor confirmation, on a throwaway backend that uses the same options as g
+// in an isolated temporary root inside the root of g.  Anchors are dropped in
+// test mode so dcrtime is not contacted.  The temporary root is removed
+// afterwards.  Step failures are reported in the returned report, an error
+// is only returned if the self test could not run.
+//
+// SelfTest satisfies the backend interface.
+func (g *gitBackEnd) SelfTest() (*backend.SelfTestReport, error) {
+	if g.isShutdown() {
+		return nil, backend.ErrShutdown
+	}
+
+	root, err := ioutil.TempDir(g.root, "selftest")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := os.RemoveAll(root)
+		if err != nil {
+			log.Errorf("SelfTest remove %v: %v", root, err)
+		}
+	}()
+
+	st, err := New(g.activeNetParams, root, "", g.gitPath, g.identity,
+		g.gitTrace,
+		&Options{
+			HTTPClient:         g.httpClient,
+			SkipStartupFsck:    true,
+			BlobThreshold:      g.blobThreshold,
+			CompressPayloads:   g.compress,
+			MaxMDStreams:       g.maxMDStreams,
+			MaxMDSize:          g.maxMDSize,
+			GitTimeout:         g.gitTimeout,
+			FileMode:           g.fileMode,
+			DirMode:            g.dirMode,
+			EncryptionKey:      g.encryptionKey,
+			AnchorPollInterval: g.anchorPoll,
+			MinGitVersion:      g.minGitVersion,
+			test:               true,
+		})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		st.cron.Stop()
+		st.Close()
+	}()
+
+	report := backend.SelfTestReport{
+		Root: root,
+	}
+	selfTestSteps(st, &report)
+
+	log.Infof("Self test passed: %v", report.Passed)
+
+	return &report, nil
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+// snapshotDirs are the directories, relative to the root, that hold record
+// state outside of git and are part of a snapshot.  Their files are never
+// modified in place and are linked, see linkOrCopy.
+var snapshotDirs = []string{
+	defaultBlobDir,
+	defaultPurgedDirectory,
+	defaultReissuedDirectory,
+}
+
+// snapshotCopyDirs are the directories, relative to the root, that hold state
+// outside of git whose files are modified in place and are therefore copied.
+// The labels database is closed while it is copied.
+var snapshotCopyDirs = []string{
+	defaultLabelsDirectory,
+	defaultExternalAnchorDir,
+}
+
+// snapshotBundle returns the filename of the bundle of repo in a snapshot.
+func snapshotBundle(dir, repo string) string {
+	return filepath.Join(dir, repo+".bundle")
+}
+
+// linkOrCopy hard links src to dst and falls back to copying the file if src
+// and dst are on different filesystems.  The files it is used for are never
+// modified in place, only created and removed, so a link is as good as a copy.
+func linkOrCopy(src, dst string) error {
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile copies src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// snapshotDir copies the directory name, relative to the root, into dir.  Files
+// are linked if link is set, see linkOrCopy.  Directories that don't exist are
+// skipped.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) snapshotDir(dir, name string, link bool) error {
+	src := filepath.Join(g.root, name)
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == src && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(g.root, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(dst, 0700)
+		}
+		if link {
+			return linkOrCopy(path, dst)
+		}
+		return copyFile(path, dst)
+	})
+}
+
+// Snapshot creates a consistent point in time copy of the backend in dir,
+// which must not exist.  Both repos are written as git bundles with all their
+// refs, which includes the unvetted record branches, and the state that is
+// kept outside of git, the blob store, the purge and reissue tombstones, the
+// record labels and the external anchors, is linked or copied alongside.  The
+// snapshot is built next to dir and only renamed to dir once it is complete.
+// It is restored by cloning the bundles into a new root and moving the
+// remaining directories next to them.
+//
+// The lock is only held while the snapshot is taken.  Snapshot refuses to run
+// while a repo is in the middle of a rebase.
+//
+// Snapshot satisfies the backend interface.
+func (g *gitBackEnd) Snapshot(dir string) error {
+	t := newOpTimer("Snapshot")
+	defer t.done()
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(dir)
+	if err == nil {
+		return fmt.Errorf("snapshot %v already exists", dir)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	tmp := dir + ".partial"
+	err = os.MkdirAll(tmp, 0700)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = g.writeSnapshot(tmp, t)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Snapshot: %v", dir)
+
+	return os.Rename(tmp, dir)
+}
+
+// writeSnapshot writes the snapshot into dir under the lock.
+func (g *gitBackEnd) writeSnapshot(dir string, t *opTimer) error {
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	t.lockAcquired()
+	g.testHook(testPointLocked)
+	if g.shutdown {
+		return backend.ErrShutdown
+	}
+
+	for _, path := range []string{g.vetted, g.unvetted} {
+		if g.gitRebaseInProgress(path) {
+			return fmt.Errorf("rebase in progress: %v", path)
+		}
+	}
+	for _, path := range []string{g.vetted, g.unvetted} {
+		_, err = g.git(path, "bundle", "create",
+			snapshotBundle(dir, filepath.Base(path)), "--all")
+		if err != nil {
+			return fmt.Errorf("bundle %v: %v", path, err)
+		}
+	}
+	for _, name := range snapshotDirs {
+		err = g.snapshotDir(dir, name, true)
+		if err != nil {
+			return fmt.Errorf("snapshot %v: %v", name, err)
+		}
+	}
+
+	// Close the labels database so that no compaction runs while it is
+	// copied, it is reopened on first use
+	if g.db != nil {
+		err = g.db.Close()
+		g.db = nil
+		if err != nil {
+			return fmt.Errorf("close labels: %v", err)
+		}
+	}
+	for _, name := range snapshotCopyDirs {
+		err = g.snapshotDir(dir, name, false)
+		if err != nil {
+			return fmt.Errorf("snapshot %v: %v", name, err)
+		}
+	}
+
+	return nil
+}
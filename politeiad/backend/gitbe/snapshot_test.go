@@ -0,0 +1,112 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btclog"
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/politeia/politeiad/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestSnapshot(t *testing.T) {
+	log := btclog.NewBackend(&testWriter{t}).Logger("TEST")
+	UseLogger(log)
+
+	dir, err := ioutil.TempDir("", "politeia.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, err := New(&chaincfg.TestNet2Params, filepath.Join(dir, "root"), "",
+		"", nil, testing.Verbose(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.test = true
+
+	newRecord := func(content string) string {
+		payload := []byte(content)
+		rm := newTestRecord(t, g, newTestFile("file", payload))
+		return hex.EncodeToString(rm.Token)
+	}
+
+	// One vetted and one unvetted record
+	vetted := newRecord("this is a vetted file")
+	token, err := hex.DecodeString(vetted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyMD := []backend.MetadataStream{}
+	_, err = g.SetUnvettedStatus(token, backend.MDStatusVetted, emptyMD,
+		emptyMD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unvetted := newRecord("this is an unvetted file")
+	err = g.SetLabels(token, []string{"featured"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := filepath.Join(dir, "snapshot")
+	err = g.Snapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.Snapshot(snapshot)
+	if err == nil {
+		t.Fatal("expected existing snapshot to be refused")
+	}
+	_, err = os.Stat(snapshot + ".partial")
+	if !os.IsNotExist(err) {
+		t.Fatalf("partial snapshot left behind: %v", err)
+	}
+
+	// Labels are copied and remain usable afterwards
+	db, err := leveldb.OpenFile(filepath.Join(snapshot,
+		defaultLabelsDirectory), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Get([]byte(vetted), nil)
+	db.Close()
+	if err != nil {
+		t.Fatalf("labels not in snapshot: %v", err)
+	}
+	labels, err := g.GetLabels(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 || labels[0] != "featured" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+
+	// Restore both repos from their bundles
+	restore := filepath.Join(dir, "restore")
+	for _, repo := range []string{defaultVettedPath, defaultUnvettedPath} {
+		_, err = g.git("", "clone", snapshotBundle(snapshot, repo),
+			filepath.Join(restore, repo))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = loadMD(filepath.Join(restore, defaultVettedPath), vetted)
+	if err != nil {
+		t.Fatalf("vetted record not restored: %v", err)
+	}
+	_, err = g.git(filepath.Join(restore, defaultUnvettedPath), "rev-parse",
+		"--verify", "origin/"+recordBranch(unvetted))
+	if err != nil {
+		t.Fatalf("unvetted record not restored: %v", err)
+	}
+}
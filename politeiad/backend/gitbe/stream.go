@@ -0,0 +1,245 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+// blobReader streams a blob and verifies it against its digest once it has
+// been read completely.
+type blobReader struct {
+	f      *os.File
+	h      hash.Hash
+	digest string
+}
+
+// Read satisfies the io.Reader interface.  It returns an error instead of
+// io.EOF if the blob does not match its digest.
+func (r *blobReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.h.Sum(nil)) != r.digest {
+		return n, fmt.Errorf("blob corrupt: %v", r.digest)
+	}
+	return n, err
+}
+
+// Close satisfies the io.Closer interface.
+func (r *blobReader) Close() error {
+	return r.f.Close()
+}
+
+// detectContentType returns the MIME type of the file f without moving its
+// offset.
+func detectContentType(f *os.File) (string, error) {
+	b := make([]byte, 512)
+	n, err := f.ReadAt(b, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(b[:n]), nil
+}
+
+// isPointer returns true if the start of a payload file looks like a blob,
+// gzip or encrypted pointer.
+func isPointer(head []byte) bool {
+	for _, prefix := range []string{blobPointerPrefix, gzipPointerPrefix,
+		encPointerPrefix} {
+		if bytes.HasPrefix(head, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// openPayload opens the record payload stored in filename for streaming.  name
+// is the record file name relative to the payload directory, see
+// validFilename.  The MIME type is detected, the caller replaces it with the
+// declared one, see loadMIMETypes.
+//
+// Payloads that are committed to git as is and blobs are streamed from disk,
+// compressed and encrypted payloads are small enough to live in git and are
+// decoded in memory.  The open file remains readable after the lock is
+// released even if git replaces it.
+//
+// This function must be called with the lock held.
+func (g *gitBackEnd) openPayload(name, filename string) (io.ReadCloser, *backend.FileInfo, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, backend.ErrFileNotFound
+		}
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	// Blob and gzip pointers have the longest prefix
+	head := make([]byte, len(blobPointerPrefix))
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, nil, err
+	}
+
+	info := backend.FileInfo{
+		Name: name,
+	}
+	if !isPointer(head[:n]) {
+		// Plain payload, hash it and rewind
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		if err == nil {
+			info.MIME, err = detectContentType(f)
+		}
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		info.Digest = hex.EncodeToString(h.Sum(nil))
+		info.Size = fi.Size()
+		return f, &info, nil
+	}
+
+	// Pointers are small, resolve them
+	b, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	var payload []byte
+	if digest, ok := parseBlobPointer(b); ok {
+		pt, err := g.readPurged(digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pt != nil {
+			// Content was purged, see PurgeFile
+			info.MIME = pt.MIME
+			info.Digest = pt.Digest
+			return ioutil.NopCloser(bytes.NewReader(nil)), &info, nil
+		}
+
+		bf, err := os.Open(g.blobFilename(digest))
+		if err != nil {
+			return nil, nil, err
+		}
+		bfi, err := bf.Stat()
+		if err == nil {
+			info.MIME, err = detectContentType(bf)
+		}
+		if err != nil {
+			bf.Close()
+			return nil, nil, err
+		}
+		info.Digest = digest
+		info.Size = bfi.Size()
+		return &blobReader{
+			f:      bf,
+			h:      sha256.New(),
+			digest: digest,
+		}, &info, nil
+	} else if digest, gz, ok := parseGzipPointer(b); ok {
+		payload, err = decompressPayload(digest, gz)
+	} else if digest, sealed, ok := parseEncPointer(b); ok {
+		payload, err = decryptPayload(g.encryptionKey, digest, sealed)
+	} else {
+		// Plain payload that merely starts like a pointer
+		payload = b
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := sha256.Sum256(payload)
+	info.MIME = http.DetectContentType(payload)
+	info.Digest = hex.EncodeToString(h[:])
+	info.Size = int64(len(payload))
+	return ioutil.NopCloser(bytes.NewReader(payload)), &info, nil
+}
+
+// OpenRecordFile opens filename of the record identified by token for
+// streaming so that large files don't have to be loaded into memory.  The
+// caller must close the returned reader.  Blobs are verified against their
+// digest as they are read, a mismatch is returned instead of io.EOF.
+//
+// OpenRecordFile satisfies the backend interface.
+func (g *gitBackEnd) OpenRecordFile(token []byte, filename string, vetted bool) (io.ReadCloser, *backend.FileInfo, error) {
+	// Lock record before the filesystem, see locks.go
+	defer g.lockRecord(token)()
+
+	// Lock filesystem
+	err := g.lock.Lock(LockDuration)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		err := g.lock.Unlock()
+		if err != nil {
+			log.Errorf("Unlock error: %v", err)
+		}
+	}()
+	if g.shutdown {
+		return nil, nil, backend.ErrShutdown
+	}
+
+	id := hex.EncodeToString(token)
+	repo := g.vetted
+	if vetted {
+		_, err = os.Stat(filepath.Join(g.vetted, id))
+		if os.IsNotExist(err) {
+			return nil, nil, backend.ErrRecordNotFound
+		} else if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		repo = g.unvetted
+		err = g.checkoutUnvetted(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			// git checkout master
+			err := g.gitCheckout(g.unvetted, "master")
+			if err != nil {
+				log.Errorf("could not switch to master: %v", err)
+			}
+		}()
+	}
+
+	if !validFilename(filename) {
+		return nil, nil, backend.ErrFileNotFound
+	}
+	mt, err := loadMIMETypes(repo, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, fi, err := g.openPayload(filename, payloadFilename(filepath.Join(repo,
+		id, defaultPayloadDir), filename))
+	if err != nil {
+		return nil, nil, err
+	}
+	if v, ok := mt[filename]; ok {
+		fi.MIME = v
+	}
+	return r, fi, nil
+}
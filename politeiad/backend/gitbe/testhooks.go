@@ -0,0 +1,34 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+// testPoint identifies a point in a backend operation where a unit test may
+// pause the backend, see testHook.
+type testPoint string
+
+const (
+	// testPointLocked is reached right after an operation acquired the
+	// filesystem lock.
+	testPointLocked testPoint = "locked"
+
+	// testPointCommit is reached right before a git commit.
+	testPointCommit testPoint = "commit"
+
+	// testPointDcrtime is reached right before dcrtime is called to
+	// anchor or verify digests.
+	testPointDcrtime testPoint = "dcrtime"
+)
+
+// testHook calls the test hook, if any, at point p.  The hook runs on the
+// goroutine of the operation and with the locks of the operation held, a hook
+// that blocks pauses the operation at p.  This makes races such as an anchor
+// during a record update deterministic in unit tests.  It is a no-op outside
+// of tests.
+func (g *gitBackEnd) testHook(p testPoint) {
+	if !g.test || g.testHookFunc == nil {
+		return
+	}
+	g.testHookFunc(p)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/politeia/politeiad/backend"
+)
+
+func TestAnchorDuringUpdate(t *testing.T) {
+	g, cleanup := newTestBackEnd(t, nil)
+	defer cleanup()
+
+	// Vet a record
+	payload := []byte("this is a file")
+	rm := newTestRecord(t, g, newTestFile("file", payload))
+	vetTestRecord(t, g, rm.Token)
+	token := hex.EncodeToString(rm.Token)
+
+	// Pause the anchor right before it calls dcrtime
+	var once sync.Once
+	paused := make(chan struct{})
+	release := make(chan struct{})
+	g.testHookFunc = func(p testPoint) {
+		if p != testPointDcrtime {
+			return
+		}
+		once.Do(func() {
+			close(paused)
+			<-release
+		})
+	}
+	anchorErr := make(chan error)
+	go func() {
+		_, err := g.dropAnchor()
+		anchorErr <- err
+	}()
+	<-paused
+
+	// The update has to wait for the anchor to finish
+	updateErr := make(chan error)
+	go func() {
+		updateErr <- g.UpdateVettedMetadata(rm.Token, nil,
+			[]backend.MetadataStream{{
+				ID:      1,
+				Payload: "this is more metadata",
+			}})
+	}()
+	select {
+	case err := <-updateErr:
+		t.Fatalf("update did not wait for the anchor: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-anchorErr; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-updateErr; err != nil {
+		t.Fatal(err)
+	}
+
+	// The update is not part of the anchor that was in flight
+	digest, err := g.lastVettedDigest(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.AnchorForCommit(digest)
+	if err != backend.ErrAnchorNotFound {
+		t.Fatalf("expected ErrAnchorNotFound, got %v", err)
+	}
+
+	// But it is part of the next one
+	_, err = g.dropAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.AnchorForCommit(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gitbe
+
+import (
+	"time"
+)
+
+// opTimer measures the wall clock duration of a backend operation and the
+// part of it that was spent waiting for the record and filesystem locks.  It
+// tells lock contention apart from slow git work at debug level without the
+// need for a metrics interface.
+type opTimer struct {
+	op     string    // Operation name
+	start  time.Time // Operation start
+	locked time.Time // Locks acquired, zero if never acquired
+}
+
+// newOpTimer starts timing operation op.
+func newOpTimer(op string) *opTimer {
+	return &opTimer{
+		op:    op,
+		start: time.Now(),
+	}
+}
+
+// lockAcquired marks the time all locks of the operation were acquired.
+func (t *opTimer) lockAcquired() {
+	t.locked = time.Now()
+}
+
+// done logs the duration of the operation.  It is meant to be deferred before
+// any lock is taken so that the release of the locks is included.
+func (t *opTimer) done() {
+	now := time.Now()
+	if t.locked.IsZero() {
+		log.Debugf("%v: %v, lock not acquired", t.op, now.Sub(t.start))
+		return
+	}
+	log.Debugf("%v: %v, lock wait %v, work %v", t.op, now.Sub(t.start),
+		t.locked.Sub(t.start), now.Sub(t.locked))
+}
@@ -6,7 +6,9 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"os"
@@ -15,6 +17,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	flags "github.com/btcsuite/go-flags"
 	"github.com/decred/dcrd/dcrutil"
@@ -71,8 +74,30 @@ type config struct {
 	RPCPass     string `long:"rpcpass" description:"RPC password for privileged commands"`
 	DcrtimeHost string `long:"dcrtimehost" description:"Dcrtime ip:port"`
 	DcrtimeCert string `long:"dcrtimecert" description:"File containing the https certificate file for dcrtimehost"`
+	DcrtimePin  string `long:"dcrtimepin" description:"Hex encoded SHA256 fingerprint of the dcrtimehost certificate, other certificates are refused"`
 	Identity    string `long:"identity" description:"File containing the politeiad identity file"`
 	GitTrace    bool   `long:"gittrace" description:"Enable git tracing in logs"`
+
+	BlobThreshold    int64         `long:"blobthreshold" description:"Store files larger than this many bytes outside of git, 0 disables"`
+	SkipStartupFsck  bool          `long:"skipstartupfsck" description:"Do not run the dcrtime fsck of the vetted repository on startup"`
+	AsyncStartupFsck bool          `long:"asyncstartupfsck" description:"Run the startup dcrtime fsck in the background"`
+	FullFsck         bool          `long:"fullfsck" description:"Ignore the fsck checkpoint and verify the entire vetted repository"`
+	VerifyObjects    bool          `long:"verifyobjects" description:"Inflate and hash every git object of both repositories on startup"`
+	GitTimeout       time.Duration `long:"gittimeout" description:"Maximum duration of a single git command (default 3m)"`
+	MinGitVersion    string        `long:"mingitversion" description:"Oldest accepted git version (default 2.6.0)"`
+	AnchorInterval   time.Duration `long:"anchorinterval" description:"How often unconfirmed anchors are checked with dcrtime, at least 10s (default 5m)"`
+	MinAnchorAge     time.Duration `long:"minanchorage" description:"Minimum age of a commit before it is anchored, younger commits wait for the next anchor (default 0, anchor all)"`
+	CompressPayloads bool          `long:"compresspayloads" description:"Gzip compress file payloads that are committed to git"`
+	MaxMDStreams     int           `long:"maxmdstreams" description:"Maximum number of metadata streams per record, 0 disables"`
+	MaxFileSize      int64         `long:"maxfilesize" description:"Maximum size in bytes of a single record file, 0 disables"`
+	MaxMDSize        int64         `long:"maxmdsize" description:"Maximum total size in bytes of the metadata streams of a record, 0 disables"`
+	MaxUnvetted      int           `long:"maxunvetted" description:"Maximum number of records pending review, new records are refused beyond it, 0 disables"`
+	RecordRate       float64       `long:"recordrate" description:"Average number of records that may be created or updated per second, 0 disables"`
+	RecordBurst      int           `long:"recordburst" description:"Number of records that may be created or updated at once when recordrate is set (default 1)"`
+	UnvettedKey      string        `long:"unvettedkey" description:"File containing the hex encoded 32 byte key that encrypts unvetted payloads at rest"`
+	TokenNamespace   string        `long:"tokennamespace" description:"Derive record tokens from this namespace and the file digests, identical content maps to the existing record"`
+	SelfTest         bool          `long:"selftest" description:"Create, update, vet and anchor a throwaway record in a temporary root, report the result and exit"`
+	Reindex          bool          `long:"reindex" description:"Rescan the repositories and rebuild derived state before serving, e.g. after restoring a backup"`
 }
 
 // serviceOptions defines the configuration options for the daemon as a service
@@ -483,11 +508,26 @@ func loadConfig() (*config, []string, error) {
 		cfg.DcrtimeCert = path
 	}
 
+	if cfg.DcrtimePin != "" {
+		pin, err := hex.DecodeString(cfg.DcrtimePin)
+		if err != nil || len(pin) != sha256.Size {
+			str := "%s: dcrtimepin is not a hex encoded SHA256 " +
+				"fingerprint"
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			return nil, nil, err
+		}
+	}
+
 	if cfg.Identity == "" {
 		cfg.Identity = defaultIdentityFile
 	}
 	cfg.Identity = cleanAndExpandPath(cfg.Identity)
 
+	if cfg.UnvettedKey != "" {
+		cfg.UnvettedKey = cleanAndExpandPath(cfg.UnvettedKey)
+	}
+
 	// Set random username and password when not specified
 	if cfg.RPCUser == "" {
 		name, err := util.Random(32)
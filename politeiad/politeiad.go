@@ -17,6 +17,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strings"
 	"syscall"
 	"time"
 
@@ -244,6 +245,18 @@ func (p *politeia) newRecord(w http.ResponseWriter, r *http.Request) {
 	rm, err := p.backend.New(convertFrontendMetadataStream(t.Metadata),
 		convertFrontendFiles(t.Files))
 	if err != nil {
+		if err == backend.ErrRateLimited {
+			log.Errorf("%v New record rate limited", remoteAddr(r))
+			p.respondWithUserError(w, v1.ErrorStatusRateLimited, nil)
+			return
+		}
+		if err == backend.ErrTooManyUnvetted {
+			log.Errorf("%v New record too many unvetted",
+				remoteAddr(r))
+			p.respondWithUserError(w, v1.ErrorStatusTooManyUnvetted,
+				nil)
+			return
+		}
 		// Check for content error.
 		if contentErr, ok := err.(backend.ContentVerificationError); ok {
 			log.Errorf("%v New record content error: %v",
@@ -315,10 +328,17 @@ func (p *politeia) updateUnvetted(w http.ResponseWriter, r *http.Request) {
 		convertFrontendMetadataStream(t.MDOverwrite),
 		convertFrontendFiles(t.FilesAdd), t.FilesDel)
 	if err != nil {
-		if err == backend.ErrNoChanges {
+		if nce, ok := err.(backend.NoChangesError); ok {
 			log.Errorf("%v update record no changes: %x",
 				remoteAddr(r), token)
-			p.respondWithUserError(w, v1.ErrorStatusNoChanges, nil)
+			p.respondWithUserError(w, v1.ErrorStatusNoChanges,
+				nce.Unchanged)
+			return
+		}
+		if err == backend.ErrRateLimited {
+			log.Errorf("%v update record rate limited: %x",
+				remoteAddr(r), token)
+			p.respondWithUserError(w, v1.ErrorStatusRateLimited, nil)
 			return
 		}
 		// Check for content error.
@@ -491,6 +511,92 @@ func (p *politeia) getVetted(w http.ResponseWriter, r *http.Request) {
 	util.RespondWithJSON(w, http.StatusOK, reply)
 }
 
+// auditTrail streams the anchor audit trail of the vetted repository.  The
+// reply is plain text, not JSON, since the trail may be very large.
+func (p *politeia) auditTrail(w http.ResponseWriter, r *http.Request) {
+	at, err := p.backend.AuditTrail()
+	if err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Audit trail error code %v: %v", remoteAddr(r),
+			errorCode, err)
+		p.respondWithServerError(w, errorCode)
+		return
+	}
+	defer at.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, at)
+	if err != nil {
+		log.Errorf("%v Audit trail copy: %v", remoteAddr(r), err)
+	}
+}
+
+// proveAnchored replies with a proof that the latest version of a vetted
+// record is anchored.  The proof is omitted if the record does not exist or
+// is not anchored and confirmed yet.
+func (p *politeia) proveAnchored(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var t v1.ProveAnchored
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&t); err != nil {
+		p.respondWithUserError(w, v1.ErrorStatusInvalidRequestPayload, nil)
+		return
+	}
+
+	challenge, err := hex.DecodeString(t.Challenge)
+	if err != nil || len(challenge) != v1.ChallengeSize {
+		p.respondWithUserError(w, v1.ErrorStatusInvalidChallenge, nil)
+		return
+	}
+	response := p.identity.SignMessage(challenge)
+
+	reply := v1.ProveAnchoredReply{
+		Response: hex.EncodeToString(response[:]),
+	}
+
+	// Validate token
+	token, err := util.ConvertStringToken(t.Token)
+	if err != nil {
+		p.respondWithUserError(w, v1.ErrorStatusInvalidRequestPayload, nil)
+		return
+	}
+
+	proof, err := p.backend.ProveAnchored(token)
+	switch {
+	case err == backend.ErrRecordNotFound || err == backend.ErrAnchorNotFound:
+		log.Infof("Prove anchored %v: token %v not anchored: %v",
+			remoteAddr(r), t.Token, err)
+	case err != nil:
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Prove anchored error code %v: %v",
+			remoteAddr(r), errorCode, err)
+
+		p.respondWithServerError(w, errorCode)
+		return
+	default:
+		reply.Proof = &v1.AnchorProof{
+			Digest:         proof.Digest,
+			AnchorBranch:   proof.AnchorBranch,
+			RecordMerkle:   proof.RecordMerkle,
+			RecordBranch:   proof.RecordBranch,
+			Merkle:         proof.Merkle,
+			DcrtimeBranch:  proof.DcrtimeBranch,
+			MerkleRoot:     proof.MerkleRoot,
+			Transaction:    proof.Transaction,
+			ChainTimestamp: proof.ChainTimestamp,
+			Signature:      proof.Signature,
+		}
+		log.Infof("Prove anchored %v: token %v", remoteAddr(r),
+			t.Token)
+	}
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
 func (p *politeia) inventory(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -514,7 +620,7 @@ func (p *politeia) inventory(w http.ResponseWriter, r *http.Request) {
 
 	// Ask backend for inventory
 	prs, brs, err := p.backend.Inventory(i.VettedCount, i.BranchesCount,
-		i.IncludeFiles)
+		i.IncludeFiles, i.IncludeCensored)
 	if err != nil {
 		// Generic internal error.
 		errorCode := time.Now().Unix()
@@ -603,6 +709,13 @@ func (p *politeia) setUnvettedStatus(w http.ResponseWriter, r *http.Request) {
 			p.respondWithUserError(w, v1.ErrorStatusInvalidRecordStatusTransition, nil)
 			return
 		}
+		if contentErr, ok := err.(backend.ContentVerificationError); ok {
+			log.Errorf("%v %v Set unvetted status content error: %v",
+				remoteAddr(r), t.Token, contentErr)
+			p.respondWithUserError(w, contentErr.ErrorCode,
+				contentErr.ErrorContext)
+			return
+		}
 		// Generic internal error.
 		errorCode := time.Now().Unix()
 		log.Errorf("%v Set unvetted status error code %v: %v",
@@ -868,8 +981,8 @@ func _main() error {
 
 	// Load certs, if there.  If they aren't there assume OS is used to
 	// resolve cert validity.
+	var certPool *x509.CertPool
 	if len(loadedCfg.DcrtimeCert) != 0 {
-		var certPool *x509.CertPool
 		if !fileExists(loadedCfg.DcrtimeCert) {
 			return fmt.Errorf("unable to find dcrtime cert %v",
 				loadedCfg.DcrtimeCert)
@@ -885,15 +998,100 @@ func _main() error {
 		}
 	}
 
+	// dcrtime replies are not signed, optionally pin the dcrtime
+	// certificate.  The pin was validated with the config.
+	var dcrtimePin []byte
+	if loadedCfg.DcrtimePin != "" {
+		dcrtimePin, err = hex.DecodeString(loadedCfg.DcrtimePin)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Load the unvetted payload key, if there.
+	var encryptionKey *[gitbe.EncryptionKeySize]byte
+	if loadedCfg.UnvettedKey != "" {
+		k, err := ioutil.ReadFile(loadedCfg.UnvettedKey)
+		if err != nil {
+			return fmt.Errorf("unable to read unvetted key %v: %v",
+				loadedCfg.UnvettedKey, err)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(k)))
+		if err != nil || len(key) != gitbe.EncryptionKeySize {
+			return fmt.Errorf("invalid unvetted key %v",
+				loadedCfg.UnvettedKey)
+		}
+		encryptionKey = new([gitbe.EncryptionKeySize]byte)
+		copy(encryptionKey[:], key)
+	}
+
 	// Setup backend.
+	var rateLimiter backend.RateLimiter
+	if loadedCfg.RecordRate > 0 {
+		burst := loadedCfg.RecordBurst
+		if burst < 1 {
+			burst = 1
+		}
+		rateLimiter = util.NewTokenBucket(loadedCfg.RecordRate, burst)
+	}
 	gitbe.UseLogger(gitbeLog)
 	b, err := gitbe.New(activeNetParams.Params, loadedCfg.DataDir,
-		loadedCfg.DcrtimeHost, "", p.identity, loadedCfg.GitTrace)
+		loadedCfg.DcrtimeHost, "", p.identity, loadedCfg.GitTrace,
+		&gitbe.Options{
+			HTTPClient: util.NewPinnedDcrtimeClient(
+				util.DefaultDcrtimeTimeout, certPool, dcrtimePin),
+			BlobThreshold:      loadedCfg.BlobThreshold,
+			SkipStartupFsck:    loadedCfg.SkipStartupFsck,
+			AsyncStartupFsck:   loadedCfg.AsyncStartupFsck,
+			FullFsck:           loadedCfg.FullFsck,
+			VerifyObjects:      loadedCfg.VerifyObjects,
+			GitTimeout:         loadedCfg.GitTimeout,
+			MinGitVersion:      loadedCfg.MinGitVersion,
+			TokenNamespace:     loadedCfg.TokenNamespace,
+			AnchorPollInterval: loadedCfg.AnchorInterval,
+			MinAnchorAge:       loadedCfg.MinAnchorAge,
+			CompressPayloads:   loadedCfg.CompressPayloads,
+			MaxMDStreams:       loadedCfg.MaxMDStreams,
+			MaxMDSize:          loadedCfg.MaxMDSize,
+			MaxFileSize:        loadedCfg.MaxFileSize,
+			MaxUnvetted:        loadedCfg.MaxUnvetted,
+			RateLimiter:        rateLimiter,
+			EncryptionKey:      encryptionKey,
+		})
 	if err != nil {
 		return err
 	}
 	p.backend = b
 
+	// Validate the deployment and exit
+	if loadedCfg.SelfTest {
+		defer p.backend.Close()
+		report, err := p.backend.SelfTest()
+		if err != nil {
+			return err
+		}
+		for _, v := range report.Steps {
+			if v.Error != "" {
+				log.Errorf("Self test %v: %v", v.Name, v.Error)
+				continue
+			}
+			log.Infof("Self test %v: ok (%v)", v.Name, v.Duration)
+		}
+		if !report.Passed {
+			return fmt.Errorf("self test failed")
+		}
+		return nil
+	}
+
+	// Recover consistency after out of band repo changes
+	if loadedCfg.Reindex {
+		err = p.backend.Reindex()
+		if err != nil {
+			p.backend.Close()
+			return fmt.Errorf("reindex: %v", err)
+		}
+	}
+
 	// Setup mux
 	p.router = mux.NewRouter()
 
@@ -908,6 +1106,10 @@ func _main() error {
 		permissionPublic)
 	p.addRoute(http.MethodPost, v1.GetVettedRoute, p.getVetted,
 		permissionPublic)
+	p.addRoute(http.MethodGet, v1.AuditTrailRoute, p.auditTrail,
+		permissionPublic)
+	p.addRoute(http.MethodPost, v1.ProveAnchoredRoute, p.proveAnchored,
+		permissionPublic)
 
 	// Routes that require auth
 	p.addRoute(http.MethodPost, v1.InventoryRoute, p.inventory,
@@ -17,6 +17,7 @@ import (
 
 	flags "github.com/btcsuite/go-flags"
 	"github.com/decred/dcrd/dcrutil"
+	"github.com/decred/politeia/politeiawww/api/v1"
 	"github.com/decred/politeia/util"
 )
 
@@ -32,8 +33,11 @@ const (
 	defaultMainnetPort = "49374"
 	defaultTestnetPort = "59374"
 
-	defaultWalletMainnetPort = "19110"
-	defaultWalletTestnetPort = "19111"
+	defaultWalletAddress = "127.0.0.1" // Only allow localhost for now
+
+	defaultMainnetPoliteiaWWW = "https://proposals.decred.org"
+	defaultTestnetPoliteiaWWW = "https://test-proposals.decred.org"
+	defaultSimnetPoliteiaWWW  = "https://127.0.0.1:4443"
 )
 
 var (
@@ -55,18 +59,27 @@ type config struct {
 	ShowVersion      bool     `short:"V" long:"version" description:"Display version information and exit"`
 	ConfigFile       string   `short:"C" long:"configfile" description:"Path to configuration file"`
 	LogDir           string   `long:"logdir" description:"Directory to log output."`
+	MainNet          bool     `long:"mainnet" description:"Use the main network (default)"`
 	TestNet          bool     `long:"testnet" description:"Use the test network"`
 	SimNet           bool     `long:"simnet" description:"Use the simulation test network"`
 	PoliteiaWWW      string   `long:"politeiawww" description:"Politeia WWW host"`
+	APIRoute         string   `long:"apiroute" description:"Politeia WWW API route prefix, e.g. /politeia/v1 behind a reverse proxy (default /v1)"`
 	Profile          string   `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	CPUProfile       string   `long:"cpuprofile" description:"Write CPU profile to the specified file"`
 	MemProfile       string   `long:"memprofile" description:"Write mem profile to the specified file"`
 	DebugLevel       string   `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
+	Debug            bool     `long:"debug" description:"Log at debug level, shortcut for --debuglevel=debug"`
+	Trace            bool     `long:"trace" description:"Log at trace level, including redacted request and response bodies, shortcut for --debuglevel=trace"`
 	Listeners        []string `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 49152, testnet: 59152)"`
 	Version          string
 	Identity         string `long:"identity" description:"File containing the politeiad identity file"`
+	WalletHost       string `long:"wallethost" description:"Wallet GRPC host (default localhost, port 9111 mainnet, 19111 testnet)"`
 	WalletCert       string `long:"walletgrpccert" description:"Wallet GRPC certificate"`
 	WalletPassphrase string `long:"walletpassphrase" description:"Wallet passphrase"`
+	Dcrdata          string `long:"dcrdata" description:"dcrdata host used by verify to look up anchor transactions, disabled if empty"`
+	Receipts         string `long:"receipts" description:"JSON file that vote adds the vote receipts to and verify checks them from"`
+	Yes              bool   `long:"yes" description:"Vote without asking for confirmation, e.g. when automated"`
+	OutputDir        string `long:"outputdir" description:"Directory that a redacted transcript of every politeiawww request and response is written to, disabled if empty"`
 }
 
 // serviceOptions defines the configuration options for the daemon as a service
@@ -345,6 +358,9 @@ func loadConfig() (*config, []string, error) {
 	// while we're at it
 	port := defaultMainnetPort
 	activeNetParams = &mainNetParams
+	if cfg.MainNet {
+		numNets++
+	}
 	if cfg.TestNet {
 		numNets++
 		activeNetParams = &testNet2Params
@@ -356,8 +372,8 @@ func loadConfig() (*config, []string, error) {
 		activeNetParams = &simNetParams
 	}
 	if numNets > 1 {
-		str := "%s: The testnet and simnet params can't be " +
-			"used together -- choose one of the three"
+		str := "%s: The mainnet, testnet and simnet params can't " +
+			"be used together -- choose one of the three"
 		err := fmt.Errorf(str, funcName)
 		fmt.Fprintln(os.Stderr, err)
 		fmt.Fprintln(os.Stderr, usageMessage)
@@ -379,6 +395,14 @@ func loadConfig() (*config, []string, error) {
 	// the logger variables may be used.
 	initLogRotator(filepath.Join(cfg.LogDir, defaultLogFilename))
 
+	// The level shortcuts override the debug level
+	switch {
+	case cfg.Trace:
+		cfg.DebugLevel = "trace"
+	case cfg.Debug:
+		cfg.DebugLevel = "debug"
+	}
+
 	// Parse, validate, and set debug log level(s).
 	if err := parseAndSetDebugLevels(cfg.DebugLevel); err != nil {
 		err := fmt.Errorf("%s: %v", funcName, err.Error())
@@ -412,9 +436,26 @@ func loadConfig() (*config, []string, error) {
 	// duplicate addresses.
 	cfg.Listeners = normalizeAddresses(cfg.Listeners, port)
 
-	if cfg.TestNet {
-	} else {
+	// Default politeiawww and wallet for the selected network
+	if cfg.PoliteiaWWW == "" {
+		switch activeNetParams {
+		case &testNet2Params:
+			cfg.PoliteiaWWW = defaultTestnetPoliteiaWWW
+		case &simNetParams:
+			cfg.PoliteiaWWW = defaultSimnetPoliteiaWWW
+		default:
+			cfg.PoliteiaWWW = defaultMainnetPoliteiaWWW
+		}
 	}
+	if cfg.APIRoute == "" {
+		cfg.APIRoute = v1.PoliteiaWWWAPIRoute
+	}
+	cfg.APIRoute = "/" + strings.Trim(cfg.APIRoute, "/")
+	if cfg.WalletHost == "" {
+		cfg.WalletHost = defaultWalletAddress
+	}
+	cfg.WalletHost = util.NormalizeAddress(cfg.WalletHost,
+		activeNetParams.WalletRPCServerPort)
 
 	if cfg.Identity == "" {
 		cfg.Identity = defaultIdentityFile
@@ -427,6 +468,16 @@ func loadConfig() (*config, []string, error) {
 	}
 	cfg.WalletCert = cleanAndExpandPath(cfg.WalletCert)
 
+	// Vote receipts
+	if cfg.Receipts != "" {
+		cfg.Receipts = cleanAndExpandPath(cfg.Receipts)
+	}
+
+	// Request transcripts
+	if cfg.OutputDir != "" {
+		cfg.OutputDir = cleanAndExpandPath(cfg.OutputDir)
+	}
+
 	// Warn about missing config file only after all other configuration is
 	// done.  This prevents the warning on help messages and invalid
 	// options.  Note this should go directly before the return.
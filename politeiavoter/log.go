@@ -5,9 +5,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/btcsuite/btclog"
 	"github.com/jrick/logrotate/rotator"
@@ -106,3 +108,55 @@ func (c logClosure) String() string {
 func newLogClosure(c func() string) logClosure {
 	return logClosure(c)
 }
+
+// redactedKeys are the JSON keys, or key substrings, whose values are never
+// logged.
+var redactedKeys = []string{"signature", "passphrase", "csrf"}
+
+// redact replaces the values of redactedKeys anywhere in v.
+func redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range t {
+			lk := strings.ToLower(k)
+			var found bool
+			for _, rk := range redactedKeys {
+				if strings.Contains(lk, rk) {
+					found = true
+					break
+				}
+			}
+			if found {
+				t[k] = "[redacted]"
+			} else {
+				t[k] = redact(vv)
+			}
+		}
+	case []interface{}:
+		for k, vv := range t {
+			t[k] = redact(vv)
+		}
+	}
+	return v
+}
+
+// redactedBody returns a log closure that prints a JSON request or response
+// body with signatures and secrets redacted.  Bodies that are not JSON are
+// only logged by size.
+func redactedBody(b []byte) logClosure {
+	return newLogClosure(func() string {
+		if len(b) == 0 {
+			return ""
+		}
+		var v interface{}
+		err := json.Unmarshal(b, &v)
+		if err != nil {
+			return fmt.Sprintf("<%v bytes>", len(b))
+		}
+		rb, err := json.Marshal(redact(v))
+		if err != nil {
+			return fmt.Sprintf("<%v bytes>", len(b))
+		}
+		return string(rb)
+	})
+}
@@ -1,23 +1,34 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrtime/merkle"
 	pb "github.com/decred/dcrwallet/rpc/walletrpc"
 	"github.com/decred/politeia/decredplugin"
+	pd "github.com/decred/politeia/politeiad/api/v1"
 	"github.com/decred/politeia/politeiad/api/v1/identity"
 	"github.com/decred/politeia/politeiawww/api/v1"
 	"github.com/decred/politeia/util"
@@ -32,6 +43,35 @@ var (
 	verify = false // Validate server TLS certificate
 )
 
+const (
+	// Exit codes
+	exitFailure        = 1 // Generic failure
+	exitVotesFailed    = 2 // Some votes failed
+	exitAllVotesFailed = 3 // All votes failed
+
+	// Range of politeiawww API versions this tool speaks
+	minAPIVersion = 1
+	maxAPIVersion = v1.PoliteiaWWWAPIVersion
+)
+
+// voteFailedError is returned by vote when some or all votes failed.
+type voteFailedError struct {
+	failed int // Number of failed votes
+	total  int // Number of votes cast
+}
+
+func (e voteFailedError) Error() string {
+	return fmt.Sprintf("%v of %v votes failed", e.failed, e.total)
+}
+
+// exitCode returns the exit code that reflects the number of failed votes.
+func (e voteFailedError) exitCode() int {
+	if e.failed == e.total {
+		return exitAllVotesFailed
+	}
+	return exitVotesFailed
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: politeiavoter [flags] <action> [arguments]\n")
 	fmt.Fprintf(os.Stderr, " flags:\n")
@@ -39,20 +79,55 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "\n actions:\n")
 	fmt.Fprintf(os.Stderr, "  inventory          - Retrieve active "+
 		"votes\n")
-	fmt.Fprintf(os.Stderr, "  vote               - Vote on a proposal\n")
+	fmt.Fprintf(os.Stderr, "  vote               - Vote on a proposal, "+
+		"the token may be abbreviated to a unique prefix\n")
+	fmt.Fprintf(os.Stderr, "  verify             - Verify that a "+
+		"proposal is anchored in the blockchain and, with --receipts, "+
+		"that its votes were received\n")
+	fmt.Fprintf(os.Stderr, "\n exit status:\n")
+	fmt.Fprintf(os.Stderr, "  %v - success\n", 0)
+	fmt.Fprintf(os.Stderr, "  %v - failure\n", exitFailure)
+	fmt.Fprintf(os.Stderr, "  %v - some votes failed\n", exitVotesFailed)
+	fmt.Fprintf(os.Stderr, "  %v - all votes failed\n", exitAllVotesFailed)
 	fmt.Fprintf(os.Stderr, "\n")
 }
 
 // ProvidePrivPassphrase is used to prompt for the private passphrase which
-// maybe required during upgrades.
-func ProvidePrivPassphrase() ([]byte, error) {
+// maybe required during upgrades.  The prompt is aborted when parent is
+// cancelled.
+func ProvidePrivPassphrase(parent context.Context) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := terminal.GetState(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	type readResult struct {
+		pass []byte
+		err  error
+	}
 	prompt := "Enter the private passphrase of your wallet: "
 	for {
 		fmt.Print(prompt)
-		pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-		if err != nil {
-			return nil, err
+		rc := make(chan readResult, 1)
+		go func() {
+			pass, err := terminal.ReadPassword(fd)
+			rc <- readResult{pass: pass, err: err}
+		}()
+		var r readResult
+		select {
+		case <-parent.Done():
+			// ReadPassword can't be interrupted, restore the terminal
+			// so that echo isn't left disabled.
+			terminal.Restore(fd, state)
+			fmt.Print("\n")
+			return nil, parent.Err()
+		case r = <-rc:
 		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		pass := r.pass
 		fmt.Print("\n")
 		pass = bytes.TrimSpace(pass)
 		if len(pass) == 0 {
@@ -63,12 +138,47 @@ func ProvidePrivPassphrase() ([]byte, error) {
 	}
 }
 
+// errVoteNotConfirmed is returned when the user does not confirm a vote.
+var errVoteNotConfirmed = errors.New("vote not confirmed")
+
+// confirmVote prints prompt and waits for the user to type yes.  Any other
+// answer returns errVoteNotConfirmed.
+func confirmVote(parent context.Context, prompt string) error {
+	type readResult struct {
+		line string
+		err  error
+	}
+	fmt.Print(prompt + " Type yes to continue: ")
+	rc := make(chan readResult, 1)
+	go func() {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		rc <- readResult{line: line, err: err}
+	}()
+	var r readResult
+	select {
+	case <-parent.Done():
+		fmt.Print("\n")
+		return parent.Err()
+	case r = <-rc:
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if strings.ToLower(strings.TrimSpace(r.line)) != "yes" {
+		return errVoteNotConfirmed
+	}
+	return nil
+}
+
 type ctx struct {
 	client *http.Client
 	cfg    *config
 	id     *identity.PublicIdentity
 	csrf   string
 
+	// Number of transcripts written to --outputdir
+	transcripts int
+
 	// wallet grpc
 	ctx    context.Context
 	creds  credentials.TransportCredentials
@@ -76,7 +186,7 @@ type ctx struct {
 	wallet pb.WalletServiceClient
 }
 
-func newClient(skipVerify bool, cfg *config) (*ctx, error) {
+func newClient(parent context.Context, skipVerify bool, cfg *config) (*ctx, error) {
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: skipVerify,
 	}
@@ -96,7 +206,7 @@ func newClient(skipVerify bool, cfg *config) (*ctx, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn, err := grpc.Dial("127.0.0.1:19111", grpc.WithTransportCredentials(creds))
+	conn, err := grpc.Dial(cfg.WalletHost, grpc.WithTransportCredentials(creds))
 	if err != nil {
 		return nil, err
 	}
@@ -104,7 +214,7 @@ func newClient(skipVerify bool, cfg *config) (*ctx, error) {
 
 	// return context
 	return &ctx{
-		ctx:    context.Background(),
+		ctx:    parent,
 		creds:  creds,
 		conn:   conn,
 		wallet: wallet,
@@ -123,15 +233,16 @@ func (c *ctx) getCSRF() (*v1.VersionReply, error) {
 
 	log.Debugf("Request: GET /")
 
-	log.Tracef("%v  ", string(requestBody))
+	log.Tracef("%v  ", redactedBody(requestBody))
 
 	req, err := http.NewRequest(http.MethodGet, c.cfg.PoliteiaWWW,
 		bytes.NewReader(requestBody))
 	if err != nil {
 		return nil, err
 	}
-	r, err := c.client.Do(req)
+	r, err := c.client.Do(req.WithContext(c.ctx))
 	if err != nil {
+		c.writeTranscript(http.MethodGet, "/", requestBody, 0, nil, err)
 		return nil, err
 	}
 	defer func() {
@@ -139,7 +250,9 @@ func (c *ctx) getCSRF() (*v1.VersionReply, error) {
 	}()
 
 	responseBody := util.ConvertBodyToByteArray(r.Body, false)
-	log.Tracef("Response: %v", string(responseBody))
+	log.Tracef("Response: %v", redactedBody(responseBody))
+	c.writeTranscript(http.MethodGet, "/", requestBody, r.StatusCode,
+		responseBody, nil)
 	if r.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("%v", r.StatusCode)
 	}
@@ -155,9 +268,9 @@ func (c *ctx) getCSRF() (*v1.VersionReply, error) {
 	return &v, nil
 }
 
-func firstContact(cfg *config) (*ctx, error) {
+func firstContact(parent context.Context, cfg *config) (*ctx, error) {
 	// Always hit / first for csrf token and obtain api version
-	c, err := newClient(true, cfg)
+	c, err := newClient(parent, true, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -168,16 +281,69 @@ func firstContact(cfg *config) (*ctx, error) {
 	log.Debugf("Version: %v", version.Version)
 	log.Debugf("Route  : %v", version.Route)
 	log.Debugf("Pubkey : %v", version.PubKey)
-	log.Debugf("CSRF   : %v", c.csrf)
+	log.Debugf("Network: %v", version.Network)
+
+	// Refuse to talk to a server we don't understand
+	err = verifyAPIVersion(version.Version)
+	if err != nil {
+		return nil, err
+	}
 
 	c.id, err = util.IdentityFromString(version.PubKey)
 	if err != nil {
 		return nil, err
 	}
 
+	// Refuse to vote on the wrong network
+	err = c.verifyNetwork(version.Network)
+	if err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
 
+// verifyAPIVersion ensures that the politeiawww API version is in the range
+// this tool was built for so that users upgrade the right component instead
+// of running into confusing errors later on.
+func verifyAPIVersion(version uint) error {
+	if version >= minAPIVersion && version <= maxAPIVersion {
+		return nil
+	}
+
+	supported := fmt.Sprintf("v%v", maxAPIVersion)
+	if minAPIVersion != maxAPIVersion {
+		supported = fmt.Sprintf("v%v-v%v", minAPIVersion, maxAPIVersion)
+	}
+	return fmt.Errorf("server API v%v is not supported by this "+
+		"politeiavoter (supports %v)", version, supported)
+}
+
+// verifyNetwork ensures that both politeiawww and the wallet run on the
+// selected network.  Servers that predate the network field in the version
+// reply are only checked through the wallet.
+func (c *ctx) verifyNetwork(wwwNetwork string) error {
+	switch wwwNetwork {
+	case "":
+		log.Warnf("%v does not report its network", c.cfg.PoliteiaWWW)
+	case activeNetParams.Name:
+	default:
+		return fmt.Errorf("%v runs on %v, expected %v", c.cfg.PoliteiaWWW,
+			wwwNetwork, activeNetParams.Name)
+	}
+
+	nr, err := c.wallet.Network(c.ctx, &pb.NetworkRequest{})
+	if err != nil {
+		return fmt.Errorf("wallet network: %v", err)
+	}
+	if wire.CurrencyNet(nr.ActiveNetwork) != activeNetParams.Net {
+		return fmt.Errorf("wallet runs on %v, expected %v",
+			wire.CurrencyNet(nr.ActiveNetwork), activeNetParams.Name)
+	}
+
+	return nil
+}
+
 func convertTicketHashes(h []string) ([][]byte, error) {
 	hashes := make([][]byte, 0, len(h))
 	for _, v := range h {
@@ -213,12 +379,10 @@ func (c *ctx) makeRequest(method, route string, b interface{}) ([]byte, error) {
 		}
 	}
 
-	fullRoute := c.cfg.PoliteiaWWW + v1.PoliteiaWWWAPIRoute + route +
-		queryParams
-	log.Debugf("Request: %v %v", method, v1.PoliteiaWWWAPIRoute+route+
-		queryParams)
+	fullRoute := c.cfg.PoliteiaWWW + c.cfg.APIRoute + route + queryParams
+	log.Debugf("Request: %v %v", method, c.cfg.APIRoute+route+queryParams)
 	if len(requestBody) != 0 {
-		log.Tracef("%v  ", string(requestBody))
+		log.Tracef("%v  ", redactedBody(requestBody))
 	}
 
 	req, err := http.NewRequest(method, fullRoute, bytes.NewReader(requestBody))
@@ -226,8 +390,10 @@ func (c *ctx) makeRequest(method, route string, b interface{}) ([]byte, error) {
 		return nil, err
 	}
 	req.Header.Add(v1.CsrfToken, c.csrf)
-	r, err := c.client.Do(req)
+	r, err := c.client.Do(req.WithContext(c.ctx))
 	if err != nil {
+		c.writeTranscript(method, c.cfg.APIRoute+route+queryParams,
+			requestBody, 0, nil, err)
 		return nil, err
 	}
 	defer func() {
@@ -235,7 +401,9 @@ func (c *ctx) makeRequest(method, route string, b interface{}) ([]byte, error) {
 	}()
 
 	responseBody := util.ConvertBodyToByteArray(r.Body, false)
-	log.Tracef("Response: %v %v", r.StatusCode, string(responseBody))
+	log.Tracef("Response: %v %v", r.StatusCode, redactedBody(responseBody))
+	c.writeTranscript(method, c.cfg.APIRoute+route+queryParams, requestBody,
+		r.StatusCode, responseBody, nil)
 	if r.StatusCode != http.StatusOK {
 		var ue v1.UserError
 		err = json.Unmarshal(responseBody, &ue)
@@ -267,6 +435,15 @@ func (c *ctx) _inventory() (*v1.ActiveVoteReply, error) {
 	return &ar, nil
 }
 
+// ticketRatio returns the percentage of the eligible tickets that are
+// controlled by the wallet.
+func ticketRatio(wallet, eligible int) float64 {
+	if eligible == 0 {
+		return 0
+	}
+	return float64(wallet) * 100 / float64(eligible)
+}
+
 func (c *ctx) inventory() error {
 	i, err := c._inventory()
 	if err != nil {
@@ -338,7 +515,10 @@ func (c *ctx) inventory() error {
 		fmt.Printf("  Start block     : %v\n", v.VoteDetails.StartBlockHeight)
 		fmt.Printf("  End block       : %v\n", v.VoteDetails.EndHeight)
 		fmt.Printf("  Mask            : %v\n", v.Vote.Mask)
-		fmt.Printf("  Eligible tickets: %v\n", len(ctres.TicketAddresses))
+		fmt.Printf("  Eligible tickets: %v\n", len(tix))
+		fmt.Printf("  Wallet tickets  : %v of %v (%.2f%%)\n",
+			len(ctres.TicketAddresses), len(tix),
+			ticketRatio(len(ctres.TicketAddresses), len(tix)))
 		for _, vo := range v.Vote.Options {
 			fmt.Printf("  Vote Option:\n")
 			fmt.Printf("    Id                   : %v\n", vo.Id)
@@ -354,7 +534,31 @@ func (c *ctx) inventory() error {
 	return nil
 }
 
-func (c *ctx) _vote(token, voteId string) ([]string, *v1.BallotReply, error) {
+// resolveToken returns the full token of the active vote that starts with the
+// provided prefix.  It errors if the prefix matches no vote or more than one.
+func resolveToken(i *v1.ActiveVoteReply, prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	var matches []string
+	for _, v := range i.Votes {
+		token := v.Proposal.CensorshipRecord.Token
+		if token == prefix {
+			return token, nil
+		}
+		if strings.HasPrefix(token, prefix) {
+			matches = append(matches, token)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("proposal not found: %v", prefix)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("ambiguous token prefix %v: %v", prefix,
+		strings.Join(matches, ", "))
+}
+
+func (c *ctx) _vote(token, voteId string) (*v1.Ballot, *v1.BallotReply, error) {
 	// XXX This is expensive but we need the snapshot of the votes. Later
 	// replace this with a locally saved file in order to prevent sending
 	// the same questions mutliple times.
@@ -363,9 +567,16 @@ func (c *ctx) _vote(token, voteId string) ([]string, *v1.BallotReply, error) {
 		return nil, nil, err
 	}
 
+	// Allow abbreviated tokens
+	token, err = resolveToken(i, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Find proposal
 	var (
 		prop    *v1.ProposalVoteTuple
+		option  decredplugin.VoteOption
 		voteBit string
 	)
 	for _, v := range i.Votes {
@@ -377,7 +588,16 @@ func (c *ctx) _vote(token, voteId string) ([]string, *v1.BallotReply, error) {
 		found := false
 		for _, vv := range v.Vote.Options {
 			if vv.Id == voteId {
+				// Make sure the option is allowed by the mask
+				// before signing anything.
+				if vv.Bits&v.Vote.Mask != vv.Bits {
+					return nil, nil, fmt.Errorf("vote bits "+
+						"not allowed by mask: bits 0x%x "+
+						"mask 0x%x", vv.Bits,
+						v.Vote.Mask)
+				}
 				found = true
+				option = vv
 				voteBit = strconv.FormatUint(vv.Bits, 16)
 				break
 			}
@@ -414,7 +634,20 @@ func (c *ctx) _vote(token, voteId string) ([]string, *v1.BallotReply, error) {
 		return nil, nil, fmt.Errorf("no eligible tickets found")
 	}
 
-	passphrase, err := ProvidePrivPassphrase()
+	// Votes can't be taken back, make sure the user means it before
+	// anything is signed.
+	fmt.Printf("Proposal : %v\n", prop.Proposal.Name)
+	fmt.Printf("Token    : %v\n", token)
+	fmt.Printf("Vote     : %v (%v)\n", option.Id, option.Description)
+	fmt.Printf("Tickets  : %v\n", len(ctres.TicketAddresses))
+	if !c.cfg.Yes {
+		err = confirmVote(c.ctx, "Cast this vote with all tickets?")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	passphrase, err := ProvidePrivPassphrase(c.ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -454,7 +687,6 @@ func (c *ctx) _vote(token, voteId string) ([]string, *v1.BallotReply, error) {
 	cv := v1.Ballot{
 		Votes: make([]decredplugin.CastVote, 0, len(ctres.TicketAddresses)),
 	}
-	tickets := make([]string, 0, len(ctres.TicketAddresses))
 	for k, v := range ctres.TicketAddresses {
 		h, err := chainhash.NewHash(v.Ticket)
 		if err != nil {
@@ -467,12 +699,18 @@ func (c *ctx) _vote(token, voteId string) ([]string, *v1.BallotReply, error) {
 			VoteBit:   voteBit,
 			Signature: signature,
 		})
-		tickets = append(tickets, h.String())
 	}
 
 	// Vote on the supplied proposal
 	responseBody, err := c.makeRequest("POST", v1.RouteCastVotes, &cv)
 	if err != nil {
+		if c.ctx.Err() != nil {
+			// The ballot may have reached the server, there is no
+			// way to tell without the receipts.
+			return nil, nil, fmt.Errorf("interrupted while casting "+
+				"votes, some votes may have been recorded: %v",
+				err)
+		}
 		return nil, nil, err
 	}
 
@@ -483,7 +721,138 @@ func (c *ctx) _vote(token, voteId string) ([]string, *v1.BallotReply, error) {
 			err)
 	}
 
-	return tickets, &vr, nil
+	return &cv, &vr, nil
+}
+
+// voteReceipts is the format of the receipts file, see --receipts.  It holds
+// every vote as it was cast together with the receipt of the server and the
+// politeiad identity that signed the receipts.
+type voteReceipts struct {
+	PublicKey string        `json:"publickey"` // politeiad public key
+	Receipts  []voteReceipt `json:"receipts"`  // Votes and their receipts
+}
+
+// voteReceipt is a cast vote and its receipt.
+type voteReceipt struct {
+	decredplugin.CastVote
+	Receipt decredplugin.CastVoteReply `json:"receipt"`
+}
+
+// verifyReceipt verifies that the receipt was signed by the server and that
+// it is for the vote that was cast.
+func (c *ctx) verifyReceipt(vote decredplugin.CastVote, receipt decredplugin.CastVoteReply) error {
+	if receipt.Error != "" {
+		return errors.New(receipt.Error)
+	}
+	if receipt.ClientSignature != vote.Signature {
+		return fmt.Errorf("receipt is for signature %v",
+			receipt.ClientSignature)
+	}
+	sig, err := identity.SignatureFromString(receipt.Signature)
+	if err != nil {
+		return err
+	}
+	if !c.id.VerifyMessage([]byte(receipt.ClientSignature), *sig) {
+		return fmt.Errorf("Could not verify receipt %v",
+			receipt.ClientSignature)
+	}
+	return nil
+}
+
+// writeReceipts adds the cast votes and their receipts to filename.  Receipts
+// of other proposals that are already in filename are kept and a receipt of a
+// ticket that voted on the same proposal before is replaced.
+func (c *ctx) writeReceipts(filename string, cv *v1.Ballot, br *v1.BallotReply) error {
+	if len(br.Receipts) != len(cv.Votes) {
+		return fmt.Errorf("got %v receipts for %v votes",
+			len(br.Receipts), len(cv.Votes))
+	}
+
+	vr := voteReceipts{
+		PublicKey: c.id.String(),
+	}
+	b, err := ioutil.ReadFile(filename)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		err = json.Unmarshal(b, &vr)
+		if err != nil {
+			return fmt.Errorf("Could not unmarshal receipts: %v",
+				err)
+		}
+		if vr.PublicKey != c.id.String() {
+			return fmt.Errorf("receipts were signed by %v, "+
+				"politeiad is %v", vr.PublicKey, c.id.String())
+		}
+	}
+
+	// Replace the receipts of tickets that voted again
+	voted := make(map[string]struct{}, len(cv.Votes))
+	for _, v := range cv.Votes {
+		voted[v.Token+v.Ticket] = struct{}{}
+	}
+	receipts := make([]voteReceipt, 0, len(vr.Receipts)+len(br.Receipts))
+	for _, v := range vr.Receipts {
+		if _, ok := voted[v.Token+v.Ticket]; ok {
+			continue
+		}
+		receipts = append(receipts, v)
+	}
+	for k, v := range br.Receipts {
+		receipts = append(receipts, voteReceipt{
+			CastVote: cv.Votes[k],
+			Receipt:  v,
+		})
+	}
+	vr.Receipts = receipts
+
+	b, err = json.MarshalIndent(vr, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0644)
+}
+
+// verifyReceipts verifies the receipts in filename of the votes on token.
+func (c *ctx) verifyReceipts(filename, token string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var vr voteReceipts
+	err = json.Unmarshal(b, &vr)
+	if err != nil {
+		return fmt.Errorf("Could not unmarshal receipts: %v", err)
+	}
+	if vr.PublicKey != c.id.String() {
+		return fmt.Errorf("receipts were signed by %v, politeiad is %v",
+			vr.PublicKey, c.id.String())
+	}
+
+	var verified, failed int
+	for _, v := range vr.Receipts {
+		if v.Token != token {
+			continue
+		}
+		err := c.verifyReceipt(v.CastVote, v.Receipt)
+		if err != nil {
+			fmt.Printf("Invalid receipt  : %v %v\n", v.Ticket, err)
+			failed++
+			continue
+		}
+		verified++
+	}
+	fmt.Printf("Receipts verified: %v\n", verified)
+	fmt.Printf("Receipts invalid : %v\n", failed)
+	if verified+failed == 0 {
+		return fmt.Errorf("no receipts for %v in %v", token, filename)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%v invalid receipts", failed)
+	}
+	return nil
 }
 
 func (c *ctx) vote(args []string) error {
@@ -491,36 +860,281 @@ func (c *ctx) vote(args []string) error {
 		return fmt.Errorf("vote: not enough arguments %v", args)
 	}
 
-	tickets, cv, err := c._vote(args[0], args[1])
+	ballot, cv, err := c._vote(args[0], args[1])
 	if err != nil {
 		return err
 	}
+	if len(cv.Receipts) != len(ballot.Votes) {
+		return fmt.Errorf("got %v receipts for %v votes",
+			len(cv.Receipts), len(ballot.Votes))
+	}
+
+	// Keep the receipts, including failed ones, before looking at them
+	if c.cfg.Receipts != "" {
+		err = c.writeReceipts(c.cfg.Receipts, ballot, cv)
+		if err != nil {
+			return fmt.Errorf("write receipts: %v", err)
+		}
+		fmt.Printf("Receipts written to %v\n", c.cfg.Receipts)
+	}
 
 	// Verify vote replies
 	failedReceipts := make([]decredplugin.CastVoteReply, 0,
 		len(cv.Receipts))
-	for _, v := range cv.Receipts {
-		if v.Error != "" {
-			failedReceipts = append(failedReceipts, v)
-			continue
-		}
-		sig, err := identity.SignatureFromString(v.Signature)
+	failedTickets := make([]string, 0, len(cv.Receipts))
+	for k, v := range cv.Receipts {
+		err := c.verifyReceipt(ballot.Votes[k], v)
 		if err != nil {
 			v.Error = err.Error()
 			failedReceipts = append(failedReceipts, v)
-			continue
-		}
-		if !c.id.VerifyMessage([]byte(v.ClientSignature), *sig) {
-			v.Error = "Could not verify receipt " + v.ClientSignature
-			failedReceipts = append(failedReceipts, v)
+			failedTickets = append(failedTickets,
+				ballot.Votes[k].Ticket)
 		}
-
 	}
 	fmt.Printf("Votes succeeded: %v\n", len(cv.Receipts)-
 		len(failedReceipts))
 	fmt.Printf("Votes failed   : %v\n", len(failedReceipts))
 	for k, v := range failedReceipts {
-		fmt.Printf("Failed vote    : %v %v\n", tickets[k], v.Error)
+		fmt.Printf("Failed vote    : %v %v\n", failedTickets[k], v.Error)
+	}
+
+	if len(failedReceipts) != 0 {
+		return voteFailedError{
+			failed: len(failedReceipts),
+			total:  len(cv.Receipts),
+		}
+	}
+
+	return nil
+}
+
+// proposalRoute returns the proposal route with the token path parameter
+// filled in.
+func proposalRoute(route, token string) string {
+	return strings.Replace(route, "{token:[A-z0-9]{64}}", token, 1)
+}
+
+// verifyCensorshipRecord verifies that the censorship record of the proposal
+// was signed by the server and that it matches the proposal files.
+func (c *ctx) verifyCensorshipRecord(p v1.ProposalRecord) error {
+	files := make([]pd.File, 0, len(p.Files))
+	for _, v := range p.Files {
+		files = append(files, pd.File{
+			Name:    v.Name,
+			MIME:    v.MIME,
+			Digest:  v.Digest,
+			Payload: v.Payload,
+		})
+	}
+	return pd.Verify(*c.id, pd.CensorshipRecord{
+		Token:     p.CensorshipRecord.Token,
+		Merkle:    p.CensorshipRecord.Merkle,
+		Signature: p.CensorshipRecord.Signature,
+	}, files)
+}
+
+// verifyBranch verifies that the merkle branch b contains leaf and that it
+// authenticates root.
+func verifyBranch(b merkle.Branch, leaf, root string) error {
+	found := false
+	for _, v := range b.Hashes {
+		if hex.EncodeToString(v[:]) == leaf {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%v not in merkle branch", leaf)
+	}
+
+	r, err := merkle.VerifyAuthPath(&b)
+	if err != nil {
+		return fmt.Errorf("invalid merkle branch: %v", err)
+	}
+	if hex.EncodeToString(r[:]) != root {
+		return fmt.Errorf("merkle branch root %x, expected %v", r[:],
+			root)
+	}
+
+	return nil
+}
+
+// recordMerkle returns the hex encoded merkle root of the payloads of files.
+func recordMerkle(files []v1.File) (string, error) {
+	if len(files) == 0 {
+		return "", fmt.Errorf("no files")
+	}
+	hashes := make([]*[sha256.Size]byte, 0, len(files))
+	for _, v := range files {
+		payload, err := base64.StdEncoding.DecodeString(v.Payload)
+		if err != nil {
+			return "", fmt.Errorf("invalid payload %v: %v", v.Name,
+				err)
+		}
+		d := sha256.Sum256(payload)
+		hashes = append(hashes, &d)
+	}
+	return hex.EncodeToString(merkle.Root(hashes)[:]), nil
+}
+
+// verifyAnchorProof verifies that the commit and the record merkle root, which
+// the caller computed from the proposal files, are included in the anchor and
+// that the anchor is included in the dcrtime merkle root that was committed in
+// a transaction.
+func verifyAnchorProof(p v1.AnchorProof, recordMerkle string) error {
+	err := verifyBranch(p.AnchorBranch, p.Digest, p.Merkle)
+	if err != nil {
+		return fmt.Errorf("anchor: %v", err)
+	}
+	if p.RecordMerkle == "" {
+		return fmt.Errorf("anchor does not carry the proposal merkle " +
+			"root")
+	}
+	if p.RecordMerkle != recordMerkle {
+		return fmt.Errorf("anchored merkle root %v, proposal files "+
+			"have %v", p.RecordMerkle, recordMerkle)
+	}
+	err = verifyBranch(p.RecordBranch, p.RecordMerkle, p.Merkle)
+	if err != nil {
+		return fmt.Errorf("record: %v", err)
+	}
+	err = verifyBranch(p.DcrtimeBranch, p.Merkle, p.MerkleRoot)
+	if err != nil {
+		return fmt.Errorf("dcrtime: %v", err)
+	}
+	if p.Transaction == "" {
+		return fmt.Errorf("anchor %v is not in a transaction", p.Merkle)
+	}
+	return nil
+}
+
+// dcrdataTx is the subset of a dcrdata transaction that is required to
+// verify an anchor.
+type dcrdataTx struct {
+	Block *struct {
+		BlockHeight int64 `json:"blockheight"`
+	} `json:"block"`
+	Vout []struct {
+		ScriptPubKey struct {
+			Asm string `json:"asm"`
+		} `json:"scriptPubKey"`
+	} `json:"vout"`
+}
+
+// verifyTransaction looks up the anchor transaction on dcrdata and returns
+// the height of the block that mined it.  It errors if the transaction does
+// not commit to merkleRoot or if it is not mined yet.
+func (c *ctx) verifyTransaction(tx, merkleRoot string) (int64, error) {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	req, err := http.NewRequest(http.MethodGet,
+		strings.TrimRight(c.cfg.Dcrdata, "/")+"/api/tx/"+tx, nil)
+	if err != nil {
+		return 0, err
+	}
+	r, err := client.Do(req.WithContext(c.ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+
+	responseBody := util.ConvertBodyToByteArray(r.Body, false)
+	log.Tracef("Response: %v %v", r.StatusCode, string(responseBody))
+	if r.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("dcrdata: %v", r.StatusCode)
+	}
+
+	var t dcrdataTx
+	err = json.Unmarshal(responseBody, &t)
+	if err != nil {
+		return 0, fmt.Errorf("Could not unmarshal dcrdata tx: %v", err)
+	}
+	found := false
+	for _, v := range t.Vout {
+		if strings.Contains(v.ScriptPubKey.Asm, merkleRoot) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("transaction %v does not commit to %v", tx,
+			merkleRoot)
+	}
+	if t.Block == nil {
+		return 0, fmt.Errorf("transaction %v is not mined", tx)
+	}
+
+	return t.Block.BlockHeight, nil
+}
+
+func (c *ctx) verifyAnchor(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("verify: not enough arguments %v", args)
+	}
+	token := strings.ToLower(args[0])
+	_, err := util.ConvertStringToken(token)
+	if err != nil {
+		return fmt.Errorf("verify: invalid token %v", args[0])
+	}
+
+	// Make sure the proposal is genuine
+	responseBody, err := c.makeRequest("GET",
+		proposalRoute(v1.RouteProposalDetails, token), nil)
+	if err != nil {
+		return err
+	}
+	var pdr v1.ProposalDetailsReply
+	err = json.Unmarshal(responseBody, &pdr)
+	if err != nil {
+		return fmt.Errorf("Could not unmarshal ProposalDetailsReply: %v",
+			err)
+	}
+	err = c.verifyCensorshipRecord(pdr.Proposal)
+	if err != nil {
+		return fmt.Errorf("invalid censorship record: %v", err)
+	}
+
+	// Verify the anchor proof
+	responseBody, err = c.makeRequest("GET",
+		proposalRoute(v1.RouteProposalAnchorProof, token), nil)
+	if err != nil {
+		return err
+	}
+	var par v1.ProposalAnchorProofReply
+	err = json.Unmarshal(responseBody, &par)
+	if err != nil {
+		return fmt.Errorf("Could not unmarshal "+
+			"ProposalAnchorProofReply: %v", err)
+	}
+	if par.Proof == nil {
+		return fmt.Errorf("proposal %v is not anchored yet", token)
+	}
+	mr, err := recordMerkle(pdr.Proposal.Files)
+	if err != nil {
+		return fmt.Errorf("invalid proposal files: %v", err)
+	}
+	err = verifyAnchorProof(*par.Proof, mr)
+	if err != nil {
+		return fmt.Errorf("invalid anchor proof: %v", err)
+	}
+
+	if c.cfg.Dcrdata == "" {
+		fmt.Printf("Anchored in TX %v at %v\n", par.Proof.Transaction,
+			time.Unix(par.Proof.ChainTimestamp, 0).UTC())
+	} else {
+		height, err := c.verifyTransaction(par.Proof.Transaction,
+			par.Proof.MerkleRoot)
+		if err != nil {
+			return fmt.Errorf("invalid anchor transaction: %v", err)
+		}
+		fmt.Printf("Anchored in TX %v at height %v\n",
+			par.Proof.Transaction, height)
+	}
+
+	// Verify the receipts of our own votes
+	if c.cfg.Receipts != "" {
+		return c.verifyReceipts(c.cfg.Receipts, token)
 	}
 
 	return nil
@@ -536,8 +1150,24 @@ func _main() error {
 		return fmt.Errorf("must provide action")
 	}
 
+	// Abort on SIGINT and SIGTERM.  A second signal terminates immediately
+	// since the handler is removed after the first one.
+	root, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Infof("Aborting with %v", sig)
+			signal.Stop(sigs)
+			cancel()
+		case <-root.Done():
+		}
+	}()
+
 	// Contact WWW
-	c, err := firstContact(cfg)
+	c, err := firstContact(root, cfg)
 	if err != nil {
 		return err
 	}
@@ -553,6 +1183,8 @@ func _main() error {
 				return c.inventory()
 			case "vote":
 				return c.vote(args[1:])
+			case "verify":
+				return c.verifyAnchor(args[1:])
 			default:
 				return fmt.Errorf("invalid action: %v", a)
 			}
@@ -566,6 +1198,9 @@ func main() {
 	err := _main()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		if e, ok := err.(voteFailedError); ok {
+			os.Exit(e.exitCode())
+		}
+		os.Exit(exitFailure)
 	}
 }
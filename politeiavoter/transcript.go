@@ -0,0 +1,83 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// transcript is a single politeiawww request and its response as written to
+// --outputdir.  Bodies are redacted the same way they are at trace level and
+// neither cookies nor the CSRF header are recorded.
+type transcript struct {
+	Timestamp  string      `json:"timestamp"`          // Time of the response
+	Method     string      `json:"method"`             // HTTP method
+	Route      string      `json:"route"`              // Route including query
+	Request    interface{} `json:"request,omitempty"`  // Redacted request body
+	StatusCode int         `json:"statuscode"`         // HTTP status, 0 on error
+	Response   interface{} `json:"response,omitempty"` // Redacted response body
+	Error      string      `json:"error,omitempty"`    // Transport error
+}
+
+// redactedJSON returns the redacted JSON body b.  Bodies that are not JSON
+// are only recorded by size.
+func redactedJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	var v interface{}
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return fmt.Sprintf("<%v bytes>", len(b))
+	}
+	return redact(v)
+}
+
+// writeTranscript writes the exchange with politeiawww to a new timestamped
+// file in --outputdir.  The files sort in request order.  Failing to write a
+// transcript is logged but does not fail the request.
+func (c *ctx) writeTranscript(method, route string, request []byte, status int, response []byte, rerr error) {
+	if c.cfg.OutputDir == "" {
+		return
+	}
+
+	now := time.Now()
+	c.transcripts++
+	t := transcript{
+		Timestamp:  now.Format(time.RFC3339Nano),
+		Method:     method,
+		Route:      route,
+		Request:    redactedJSON(request),
+		StatusCode: status,
+		Response:   redactedJSON(response),
+	}
+	if rerr != nil {
+		t.Error = rerr.Error()
+	}
+
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		log.Errorf("transcript: %v", err)
+		return
+	}
+	err = os.MkdirAll(c.cfg.OutputDir, 0700)
+	if err != nil {
+		log.Errorf("transcript: %v", err)
+		return
+	}
+	filename := filepath.Join(c.cfg.OutputDir, fmt.Sprintf("%v-%04d.json",
+		now.Format("20060102-150405.000000"), c.transcripts))
+	err = ioutil.WriteFile(filename, append(b, '\n'), 0600)
+	if err != nil {
+		log.Errorf("transcript: %v", err)
+		return
+	}
+	log.Debugf("Transcript: %v", filename)
+}
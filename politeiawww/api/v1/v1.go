@@ -3,6 +3,7 @@ package v1
 import (
 	"fmt"
 
+	"github.com/decred/dcrtime/merkle"
 	"github.com/decred/politeia/decredplugin"
 )
 
@@ -38,6 +39,7 @@ const (
 	RouteStartVote           = "/proposals/startvote"
 	RouteActiveVote          = "/proposals/activevote"
 	RouteCastVotes           = "/proposals/castvotes"
+	RouteProposalAnchorProof = "/proposals/{token:[A-z0-9]{64}}/anchorproof"
 
 	// VerificationTokenSize is the size of verification token in bytes
 	VerificationTokenSize = 32
@@ -263,6 +265,7 @@ type VersionReply struct {
 	Version uint   `json:"version"` // politeia WWW API version
 	Route   string `json:"route"`   // prefix to API calls
 	PubKey  string `json:"pubkey"`  // Server public key
+	Network string `json:"network"` // Network the server runs on
 }
 
 // NewUser is used to request that a new user be created within the db.
@@ -419,6 +422,35 @@ type ProposalDetailsReply struct {
 	Proposal ProposalRecord `json:"proposal"`
 }
 
+// ProposalAnchorProof is used to retrieve the anchor proof of a public
+// proposal.
+type ProposalAnchorProof struct {
+	Token string `json:"token"`
+}
+
+// AnchorProof proves that the latest version of a proposal is anchored in the
+// blockchain.  AnchorBranch must verify to Merkle and contain Digest,
+// DcrtimeBranch must verify to MerkleRoot and contain Merkle and MerkleRoot
+// must be committed in Transaction.  If set, RecordBranch must verify to Merkle
+// and contain RecordMerkle, which binds the proposal files to the anchor.
+type AnchorProof struct {
+	Digest         string        `json:"digest"`         // Extended commit digest of the proposal
+	AnchorBranch   merkle.Branch `json:"anchorbranch"`   // Digest inclusion proof in Merkle
+	RecordMerkle   string        `json:"recordmerkle"`   // Merkle root of all files in proposal, may be empty
+	RecordBranch   merkle.Branch `json:"recordbranch"`   // RecordMerkle inclusion proof in Merkle
+	Merkle         string        `json:"merkle"`         // Anchor merkle root
+	DcrtimeBranch  merkle.Branch `json:"dcrtimebranch"`  // Merkle inclusion proof in MerkleRoot
+	MerkleRoot     string        `json:"merkleroot"`     // dcrtime merkle root
+	Transaction    string        `json:"transaction"`    // dcrd transaction that holds MerkleRoot
+	ChainTimestamp int64         `json:"chaintimestamp"` // Timestamp of the block
+}
+
+// ProposalAnchorProofReply is used to reply to a proposal anchor proof
+// command.  Proof is nil if the proposal is not anchored and confirmed yet.
+type ProposalAnchorProofReply struct {
+	Proof *AnchorProof `json:"proof"`
+}
+
 // SetProposalStatus is used to publish or censor an unreviewed proposal.
 type SetProposalStatus struct {
 	Token          string      `json:"token"`
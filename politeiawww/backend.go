@@ -382,11 +382,13 @@ func (b *backend) remoteInventory() (*pd.InventoryReply, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Censored proposals remain visible to admins and their authors
 	inv := pd.Inventory{
-		Challenge:     hex.EncodeToString(challenge),
-		IncludeFiles:  false,
-		VettedCount:   0,
-		BranchesCount: 0,
+		Challenge:       hex.EncodeToString(challenge),
+		IncludeFiles:    false,
+		IncludeCensored: true,
+		VettedCount:     0,
+		BranchesCount:   0,
 	}
 
 	responseBody, err := b.makeRequest(http.MethodPost, pd.InventoryRoute, inv)
@@ -1539,6 +1541,65 @@ func (b *backend) ProcessProposalDetails(propDetails www.ProposalsDetails, user
 	return &reply, nil
 }
 
+// ProcessProposalAnchorProof asks politeiad for a proof that the latest
+// version of a public proposal is anchored.
+func (b *backend) ProcessProposalAnchorProof(pap www.ProposalAnchorProof) (*www.ProposalAnchorProofReply, error) {
+	var reply www.ProposalAnchorProofReply
+	challenge, err := util.Random(pd.ChallengeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only public proposals are anchored
+	b.RLock()
+	p, ok := b.inventory[pap.Token]
+	if !ok {
+		b.RUnlock()
+		return nil, www.UserError{
+			ErrorCode: www.ErrorStatusProposalNotFound,
+		}
+	}
+	status := convertPropFromInventoryRecord(p, b.userPubkeys).Status
+	b.RUnlock()
+	if status != www.PropStatusPublic {
+		return nil, www.UserError{
+			ErrorCode: www.ErrorStatusProposalNotFound,
+		}
+	}
+
+	if b.test {
+		return &reply, nil
+	}
+
+	responseBody, err := b.makeRequest(http.MethodPost,
+		pd.ProveAnchoredRoute, pd.ProveAnchored{
+			Token:     pap.Token,
+			Challenge: hex.EncodeToString(challenge),
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var pdReply pd.ProveAnchoredReply
+	err = json.Unmarshal(responseBody, &pdReply)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal "+
+			"ProveAnchoredReply: %v", err)
+	}
+
+	// Verify the challenge.
+	err = util.VerifyChallenge(b.cfg.Identity, challenge, pdReply.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	if pdReply.Proof != nil {
+		proof := convertAnchorProofFromPD(*pdReply.Proof)
+		reply.Proof = &proof
+	}
+	return &reply, nil
+}
+
 // ProcessComment processes a submitted comment.  It ensures the proposal and
 // the parent exists.  A parent ID of 0 indicates that it is a comment on the
 // proposal whereas non-zero indicates that it is a reply to a comment.
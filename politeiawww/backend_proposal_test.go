@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/decred/politeia/politeiad/api/v1/identity"
@@ -482,6 +483,35 @@ func TestPublishedProposal(t *testing.T) {
 	b.db.Close()
 }
 
+// Tests that unknown and unreviewed proposals have no anchor proof.
+func TestProposalAnchorProof(t *testing.T) {
+	b := createBackend(t)
+	u, id := createAndVerifyUser(t, b)
+	user, _ := b.db.UserGet(u.Email)
+	_, npr, err := createNewProposal(b, t, user, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pap := www.ProposalAnchorProof{
+		Token: npr.CensorshipRecord.Token,
+	}
+	_, err = b.ProcessProposalAnchorProof(pap)
+	ue, ok := err.(www.UserError)
+	if !ok || ue.ErrorCode != www.ErrorStatusProposalNotFound {
+		t.Fatalf("expected ErrorStatusProposalNotFound, got %v", err)
+	}
+
+	_, err = b.ProcessProposalAnchorProof(www.ProposalAnchorProof{
+		Token: strings.Repeat("0", 64),
+	})
+	ue, ok = err.(www.UserError)
+	if !ok || ue.ErrorCode != www.ErrorStatusProposalNotFound {
+		t.Fatalf("expected ErrorStatusProposalNotFound, got %v", err)
+	}
+
+	b.db.Close()
+}
+
 // Tests that the inventory is always sorted by timestamp.
 // XXX must be fixed by @sndurkin
 //func TestInventorySorted(t *testing.T) {
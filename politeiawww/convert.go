@@ -160,6 +160,20 @@ func convertPropFromPD(p pd.Record) www.ProposalRecord {
 	}
 }
 
+func convertAnchorProofFromPD(p pd.AnchorProof) www.AnchorProof {
+	return www.AnchorProof{
+		Digest:         p.Digest,
+		AnchorBranch:   p.AnchorBranch,
+		RecordMerkle:   p.RecordMerkle,
+		RecordBranch:   p.RecordBranch,
+		Merkle:         p.Merkle,
+		DcrtimeBranch:  p.DcrtimeBranch,
+		MerkleRoot:     p.MerkleRoot,
+		Transaction:    p.Transaction,
+		ChainTimestamp: p.ChainTimestamp,
+	}
+}
+
 func convertErrorStatusFromPD(s int) www.ErrorStatusT {
 	switch pd.ErrorStatusT(s) {
 	case pd.ErrorStatusInvalidFileDigest:
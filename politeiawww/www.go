@@ -229,6 +229,7 @@ func (p *politeiawww) handleVersion(w http.ResponseWriter, r *http.Request) {
 		Version: v1.PoliteiaWWWAPIVersion,
 		Route:   v1.PoliteiaWWWAPIRoute,
 		PubKey:  hex.EncodeToString(p.cfg.Identity.Key[:]),
+		Network: activeNetParams.Name,
 	})
 	if err != nil {
 		RespondWithError(w, r, 0, "handleVersion: Marshal %v", err)
@@ -599,6 +600,28 @@ func (p *politeiawww) handleProposalDetails(w http.ResponseWriter, r *http.Reque
 	util.RespondWithJSON(w, http.StatusOK, reply)
 }
 
+// handleProposalAnchorProof replies with the anchor proof of a public
+// proposal.
+func (p *politeiawww) handleProposalAnchorProof(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	// Add the path param to the struct.
+	log.Tracef("handleProposalAnchorProof")
+	pathParams := mux.Vars(r)
+	var pap v1.ProposalAnchorProof
+	pap.Token = pathParams["token"]
+
+	reply, err := p.backend.ProcessProposalAnchorProof(pap)
+	if err != nil {
+		RespondWithError(w, r, 0,
+			"handleProposalAnchorProof: ProcessProposalAnchorProof %v",
+			err)
+		return
+	}
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
 func (p *politeiawww) handlePolicy(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -991,6 +1014,8 @@ func _main() error {
 		permissionPublic, true)
 	p.addRoute(http.MethodGet, v1.RouteProposalDetails,
 		p.handleProposalDetails, permissionPublic, true)
+	p.addRoute(http.MethodGet, v1.RouteProposalAnchorProof,
+		p.handleProposalAnchorProof, permissionPublic, true)
 	p.addRoute(http.MethodGet, v1.RoutePolicy, p.handlePolicy,
 		permissionPublic, false)
 	p.addRoute(http.MethodGet, v1.RouteCommentsGet, p.handleCommentsGet,
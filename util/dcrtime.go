@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -15,9 +16,16 @@ import (
 	"github.com/decred/dcrtime/merkle"
 )
 
+const (
+	// DefaultDcrtimeTimeout is the overall timeout of a dcrtime request
+	// when using the default client.
+	DefaultDcrtimeTimeout = 2 * time.Minute
+)
+
 var (
 	skipVerify = false
 	httpClient = &http.Client{
+		Timeout: DefaultDcrtimeTimeout,
 		Transport: &http.Transport{
 			IdleConnTimeout: 60 * time.Second,
 			TLSClientConfig: &tls.Config{
@@ -27,6 +35,47 @@ var (
 	}
 )
 
+// NewDcrtimeClient returns an http client suitable for dcrtime calls.  If
+// certPool is not nil it is used instead of the OS pool to verify the dcrtime
+// certificate, this allows pinning the certificate of a private dcrtimed.
+func NewDcrtimeClient(timeout time.Duration, certPool *x509.CertPool) *http.Client {
+	return NewPinnedDcrtimeClient(timeout, certPool, nil)
+}
+
+// NewPinnedDcrtimeClient returns an http client like NewDcrtimeClient that, in
+// addition to the regular certificate verification, refuses dcrtime servers
+// whose certificate does not have the SHA256 fingerprint pin.  dcrtime replies
+// are not signed, TLS is all that authenticates them, so without a pin any
+// certificate authority trusted by certPool can vouch for a man in the middle
+// that forges anchor confirmations.  A nil pin disables pinning.
+func NewPinnedDcrtimeClient(timeout time.Duration, certPool *x509.CertPool, pin []byte) *http.Client {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+		RootCAs:            certPool,
+	}
+	if len(pin) != 0 {
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("dcrtime presented no certificate")
+			}
+			fp := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(fp[:], pin) {
+				return fmt.Errorf("dcrtime certificate %x does not "+
+					"match pin %x", fp, pin)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			IdleConnTimeout: 60 * time.Second,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
 // isTimestamp determines if a string is a valid SHA256 digest.
 func isDigest(digest string) bool {
 	return v1.RegexpSHA256.MatchString(digest)
@@ -61,9 +110,69 @@ func getError(r io.Reader) (string, error) {
 	return fmt.Sprintf("%v", rError), nil
 }
 
+// Status sends a Status request to the provided host in order to determine
+// if it is reachable and speaks the expected API version.  The caller is
+// responsible for assembling the host string based on what net to use.
+func Status(host string) error {
+	return StatusWithClient(httpClient, host)
+}
+
+// StatusWithClient is Status using the provided http client.
+func StatusWithClient(c *http.Client, host string) error {
+	s := v1.Status{
+		ID: "politeia",
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, host+v1.StatusRoute,
+		bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	switch r.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return fmt.Errorf("%v: incompatible dcrtime API version, "+
+			"expected %v", r.Status, v1.StatusRoute)
+	default:
+		e, err := getError(r.Body)
+		if err != nil {
+			return fmt.Errorf("%v", r.Status)
+		}
+		return fmt.Errorf("%v: %v", r.Status, e)
+	}
+
+	// Decode response.
+	var sr v1.StatusReply
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&sr); err != nil {
+		return fmt.Errorf("Could not decode StatusReply: %v", err)
+	}
+	if sr.ID != s.ID {
+		return fmt.Errorf("unexpected StatusReply id: got %v wanted %v",
+			sr.ID, s.ID)
+	}
+
+	return nil
+}
+
 // Timestamp sends a Timestamp request to the provided host.  The caller is
 // responsible for assembling the host string based on what net to use.
 func Timestamp(host string, digests []*[sha256.Size]byte) error {
+	return TimestampWithClient(httpClient, host, digests)
+}
+
+// TimestampWithClient is Timestamp using the provided http client.
+func TimestampWithClient(c *http.Client, host string, digests []*[sha256.Size]byte) error {
 	// batch uploads
 	ts := v1.Timestamp{
 		ID:      "politeia",
@@ -77,7 +186,7 @@ func Timestamp(host string, digests []*[sha256.Size]byte) error {
 		return err
 	}
 
-	r, err := httpClient.Post(host+v1.TimestampRoute, "application/json",
+	r, err := c.Post(host+v1.TimestampRoute, "application/json",
 		bytes.NewReader(b))
 	if err != nil {
 		return err
@@ -122,6 +231,11 @@ func Timestamp(host string, digests []*[sha256.Size]byte) error {
 // further processing.  This means that the caller can be assured that all
 // checks have been done and the data is readily usable.
 func Verify(host string, digests []string) (*v1.VerifyReply, error) {
+	return VerifyWithClient(httpClient, host, digests)
+}
+
+// VerifyWithClient is Verify using the provided http client.
+func VerifyWithClient(c *http.Client, host string, digests []string) (*v1.VerifyReply, error) {
 	ver := v1.Verify{
 		ID: "politeia",
 	}
@@ -141,7 +255,7 @@ func Verify(host string, digests []string) (*v1.VerifyReply, error) {
 		return nil, err
 	}
 
-	r, err := httpClient.Post(host+v1.VerifyRoute, "application/json",
+	r, err := c.Post(host+v1.VerifyRoute, "application/json",
 		bytes.NewReader(b))
 	if err != nil {
 		return nil, err
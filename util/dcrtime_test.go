@@ -2,12 +2,16 @@ package util
 
 import (
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/decred/dcrtime/merkle"
 )
@@ -96,3 +100,44 @@ func TestVerify(t *testing.T) {
 		t.Fatalf("unexpected merkle %v", hex.EncodeToString(root[:]))
 	}
 }
+
+func TestPinnedDcrtimeClient(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	cert := ts.TLS.Certificates[0].Certificate[0]
+	leaf, err := x509.ParseCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPool := x509.NewCertPool()
+	certPool.AddCert(leaf)
+	pin := sha256.Sum256(cert)
+
+	get := func(c *http.Client) error {
+		r, err := c.Get(ts.URL)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		return nil
+	}
+
+	// Unpinned and correctly pinned clients connect
+	err = get(NewDcrtimeClient(time.Minute, certPool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = get(NewPinnedDcrtimeClient(time.Minute, certPool, pin[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A trusted certificate with another fingerprint is refused
+	other := sha256.Sum256([]byte("other"))
+	err = get(NewPinnedDcrtimeClient(time.Minute, certPool, other[:]))
+	if err == nil {
+		t.Fatal("expected pin mismatch")
+	}
+}
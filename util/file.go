@@ -75,6 +75,12 @@ func LoadFile(filename string) (mimeType string, digest string, payload string,
 		return
 	}
 
+	return EncodeFile(b)
+}
+
+// EncodeFile returns the MIME type, the sha256 digest and the base64 encoded
+// payload of the provided file content.
+func EncodeFile(b []byte) (mimeType string, digest string, payload string, err error) {
 	// MIME
 	mimeType = http.DetectContentType(b)
 	if !mime.MimeValid(mimeType) {
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a token bucket rate limiter.  The bucket holds up to burst
+// tokens and is refilled at rate tokens per second.  Every allowed event takes
+// one token.  It is safe for concurrent use.
+type TokenBucket struct {
+	mtx    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time // Clock, replaced during UT
+}
+
+// NewTokenBucket returns a full token bucket that allows burst events at once
+// and rate events per second on average.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// Allow takes a token from the bucket.  It returns false if the bucket is
+// empty.
+func (tb *TokenBucket) Allow() bool {
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+
+	now := tb.now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+
+	return true
+}
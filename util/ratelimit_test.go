@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	tb := NewTokenBucket(0.5, 2)
+	tb.last = now
+	tb.now = func() time.Time { return now }
+
+	// Burst
+	for i := 0; i < 2; i++ {
+		if !tb.Allow() {
+			t.Fatalf("burst %v not allowed", i)
+		}
+	}
+	if tb.Allow() {
+		t.Fatalf("empty bucket allowed")
+	}
+
+	// One token is added every two seconds
+	now = now.Add(time.Second)
+	if tb.Allow() {
+		t.Fatalf("partial token allowed")
+	}
+	now = now.Add(time.Second)
+	if !tb.Allow() {
+		t.Fatalf("refilled token not allowed")
+	}
+	if tb.Allow() {
+		t.Fatalf("empty bucket allowed")
+	}
+
+	// The bucket does not fill beyond burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if !tb.Allow() {
+			t.Fatalf("burst %v not allowed", i)
+		}
+	}
+	if tb.Allow() {
+		t.Fatalf("bucket filled beyond burst")
+	}
+}